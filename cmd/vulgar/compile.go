@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/bytecode"
+)
+
+var flagCompileOutput string
+
+var compileCmd = &cobra.Command{
+	Use:   "compile <script.lua>",
+	Short: "Precompile a script to bytecode",
+	Long: `Compile a script to a .luac file that vulgar runs without parsing it:
+
+  vulgar compile workflow.lua -o workflow.luac
+  vulgar workflow.luac
+
+Compiled scripts keep their line numbers and permission header, so errors
+and sandbox rules work as for the source. They only run on vulgar builds
+with the same bytecode format; recompile after upgrading.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runCompile,
+}
+
+func init() {
+	compileCmd.Flags().StringVarP(&flagCompileOutput, "output", "o", "", "Output file (default: the script name with a .luac extension)")
+	rootCmd.AddCommand(compileCmd)
+}
+
+func runCompile(cmd *cobra.Command, args []string) {
+	path := args[0]
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if bytecode.IsChunk(source) {
+		fmt.Fprintf(os.Stderr, "Error: %s is already compiled\n", path)
+		os.Exit(1)
+	}
+
+	chunk, err := bytecode.Compile(source, path)
+	if err != nil {
+		printError("compilation failed", err)
+		os.Exit(1)
+	}
+
+	output := flagCompileOutput
+	if output == "" {
+		output = strings.TrimSuffix(path, filepath.Ext(path)) + bytecode.Extension
+	}
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf, chunk); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(output, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Compiled %s to %s\n", path, output)
+}
+
+// bytecodeCacheDir is where compiled project modules are cached, or empty
+// with --no-cache
+func bytecodeCacheDir() string {
+	if flagNoCache {
+		return ""
+	}
+	dir, err := bytecode.DefaultCacheDir()
+	if err != nil {
+		return ""
+	}
+	return dir
+}
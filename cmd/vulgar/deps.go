@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/project"
+)
+
+var depsCmd = &cobra.Command{
+	Use:   "deps",
+	Short: "Manage the Lua dependencies of a project",
+	Long: `Manage the Lua packages a project declares in vulgar.toml.
+
+Scripts below a vulgar.toml can require modules from the project's lib/
+directory and from its dependencies:
+
+  [dependencies]
+  slackfmt = { path = "../shared/slackfmt" }
+  inspect  = { git = "https://github.com/kikito/inspect.lua", rev = "a3b9b9e" }
+
+Run 'vulgar deps install' to vendor them into .vulgar/deps and record the
+exact commits in vulgar.lock.`,
+}
+
+var depsInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Vendor the dependencies from vulgar.toml and update vulgar.lock",
+	Args:  cobra.NoArgs,
+	Run:   runDepsInstall,
+}
+
+func init() {
+	depsCmd.AddCommand(depsInstallCmd)
+	rootCmd.AddCommand(depsCmd)
+}
+
+func runDepsInstall(cmd *cobra.Command, args []string) {
+	p, err := project.Find(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if p == nil {
+		fmt.Fprintf(os.Stderr, "Error: no %s found in this directory or its parents\n", project.ManifestFile)
+		os.Exit(1)
+	}
+
+	if len(p.Manifest.Dependencies) == 0 {
+		fmt.Printf("No dependencies declared in %s\n", project.ManifestFile)
+	} else {
+		fmt.Printf("Installing dependencies for %s\n", p.Root)
+	}
+	lock, err := p.Install(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Locked %d package(s) in %s\n", len(lock.Packages), project.LockFile)
+}
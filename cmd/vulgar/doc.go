@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/modules/docs"
+)
+
+var flagDocMeta string
+
+var docCmd = &cobra.Command{
+	Use:   "doc [module[.function]]",
+	Short: "Show the documentation of a module or function",
+	Long: `Show what a module exports and how to call its functions:
+
+  vulgar doc                                  List modules
+  vulgar doc integrations.gsheets             Functions and configuration
+  vulgar doc gsheets.batch_update             Parameters, returns, examples
+
+Modules may be named by their last component. --meta writes LuaLS
+definition files for the given modules, or all of them, so the Lua
+language server knows their functions:
+
+  vulgar doc --meta .vulgar/meta
+  # .luarc.json: {"workspace.library": [".vulgar/meta"]}`,
+	Run: runDoc,
+}
+
+func init() {
+	docCmd.Flags().StringVar(&flagDocMeta, "meta", "", "Write LuaLS ---@meta definition files to this directory")
+	rootCmd.AddCommand(docCmd)
+}
+
+func runDoc(cmd *cobra.Command, args []string) {
+	eng := engine.NewEngine(engine.Config{LogLevel: "ERROR"})
+	defer eng.Close()
+
+	if flagDocMeta != "" {
+		if err := writeMetaFiles(eng, flagDocMeta, args); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			eng.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch len(args) {
+	case 0:
+		listModuleDocs()
+	case 1:
+		ref, fn, err := docs.Query(eng.L, args[0])
+		if err == nil {
+			if fn != nil {
+				err = docs.WriteFunction(os.Stdout, ref, *fn)
+			} else {
+				err = docs.WriteModule(os.Stdout, ref)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			eng.Close()
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Error: doc takes one module or function")
+		eng.Close()
+		os.Exit(1)
+	}
+}
+
+// listModuleDocs prints the modules with their summaries
+func listModuleDocs() {
+	var names []string
+	for name := range modules.GetRegistry() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range names {
+		info, _ := modules.GetInfo(name)
+		fmt.Fprintf(tw, "%s\t%s\n", name, info.Summary)
+	}
+	tw.Flush()
+	fmt.Println("\nRun 'vulgar doc <module>' for a module's functions.")
+}
+
+// writeMetaFiles writes a LuaLS definition file per module to dir
+func writeMetaFiles(eng *engine.Engine, dir string, names []string) error {
+	if len(names) == 0 {
+		for name := range modules.GetRegistry() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	written := 0
+	for _, query := range names {
+		name, _, ok := docs.Resolve(query)
+		if !ok {
+			return fmt.Errorf("unknown module %q", query)
+		}
+		ref, err := docs.Describe(eng.L, name)
+		if err != nil {
+			// Modules such as those computing their contents have no
+			// fixed functions to describe
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", name, err)
+			continue
+		}
+		f, err := os.Create(filepath.Join(dir, name+".lua"))
+		if err != nil {
+			return err
+		}
+		err = docs.WriteMeta(f, ref)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		written++
+	}
+	fmt.Printf("Wrote %d definition file(s) to %s\n", written, dir)
+	return nil
+}
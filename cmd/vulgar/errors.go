@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zepzeper/vulgar/internal/cli"
+	"github.com/zepzeper/vulgar/internal/engine"
+)
+
+// Values of --error-format
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// jsonError is the --error-format=json form of errors that carry no report,
+// such as exceeded resource limits
+type jsonError struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// validateErrorFormat checks the --error-format flag
+func validateErrorFormat() error {
+	switch flagErrorFormat {
+	case errorFormatText, errorFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid --error-format %q (use text or json)", flagErrorFormat)
+}
+
+// printError writes an error to stderr. In text mode it is prefixed with
+// what failed and runtime errors are rendered with their source snippet and
+// traceback; in json mode it is written as a single JSON object per line.
+func printError(what string, err error) {
+	if flagErrorFormat == errorFormatJSON {
+		fmt.Fprintln(os.Stderr, errorJSON(err))
+		return
+	}
+
+	var runtimeErr *engine.RuntimeError
+	if errors.As(err, &runtimeErr) {
+		fmt.Fprintf(os.Stderr, "Error: %s: %s\n", what, runtimeErr.Render(stderrErrorStyle()))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", what, err)
+}
+
+// printCallbackError reports an error raised by a timer, event or watcher
+// callback. The script keeps running.
+func printCallbackError(err error) {
+	printError("callback failed", err)
+}
+
+func errorJSON(err error) string {
+	var value interface{} = jsonError{Kind: "error", Message: err.Error()}
+
+	var runtimeErr *engine.RuntimeError
+	var limitErr *engine.LimitError
+	switch {
+	case errors.As(err, &runtimeErr):
+		value = runtimeErr
+	case errors.As(err, &limitErr):
+		value = jsonError{Kind: "limit", Message: err.Error()}
+	}
+
+	data, marshalErr := json.Marshal(value)
+	if marshalErr != nil {
+		data, _ = json.Marshal(jsonError{Kind: "error", Message: err.Error()})
+	}
+	return string(data)
+}
+
+// stderrErrorStyle highlights error reports when stderr is a terminal
+func stderrErrorStyle() engine.ErrorStyle {
+	r := lipgloss.NewRenderer(os.Stderr)
+	message := r.NewStyle().Foreground(cli.ColorError).Bold(true)
+	current := r.NewStyle().Foreground(cli.ColorWarning).Bold(true)
+	muted := r.NewStyle().Foreground(cli.ColorMuted)
+	hint := r.NewStyle().Foreground(cli.ColorInfo)
+
+	return engine.ErrorStyle{
+		Message: renderWith(message),
+		Current: renderWith(current),
+		Muted:   renderWith(muted),
+		Hint:    renderWith(hint),
+	}
+}
+
+func renderWith(style lipgloss.Style) func(string) string {
+	return func(s string) string { return style.Render(s) }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/cli/tui/workflow"
+	"github.com/zepzeper/vulgar/internal/history"
+)
+
+var (
+	flagGraphFormat string
+	flagGraphStatus bool
+	flagGraphRun    string
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph <script>",
+	Short: "Print the stdlib.workflow graph of a script as a diagram",
+	Long: `Print the workflow a script builds as a Mermaid flowchart, a Graphviz
+DOT digraph or JSON, e.g. for runbooks and merge request descriptions:
+
+  vulgar graph deploy.lua > deploy.mmd
+  vulgar graph deploy.lua --format dot | dot -Tsvg > deploy.svg
+  vulgar graph deploy.lua --status
+
+The script runs to build the graph, as a dry run with every capability
+denied; workflow.run only records the workflow it is given, so no node
+runs. The graph is taken from that workflow, or from the global wf,
+workflow or w. Modules that cannot check each target, like
+integrations.stripe, cannot be loaded this way. --status adds the
+status and duration of every node from the last recorded run of the
+script, --run from the given run.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runGraph,
+}
+
+func init() {
+	graphCmd.Flags().StringVarP(&flagGraphFormat, "format", "f", "mermaid", "Output format (mermaid, dot, json)")
+	graphCmd.Flags().BoolVar(&flagGraphStatus, "status", false, "Show node status and durations from the last run")
+	graphCmd.Flags().StringVar(&flagGraphRun, "run", "", "Show node status and durations from this run")
+	rootCmd.AddCommand(graphCmd)
+}
+
+func runGraph(cmd *cobra.Command, args []string) {
+	switch flagGraphFormat {
+	case "mermaid", "dot", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (use mermaid, dot or json)\n", flagGraphFormat)
+		os.Exit(1)
+	}
+
+	script := args[0]
+	var runs []workflow.RunNode
+	if flagGraphStatus || flagGraphRun != "" {
+		var err error
+		if runs, err = recordedNodes(script, flagGraphRun); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	bridge := workflow.NewBridge()
+	defer bridge.Close()
+	bridge.SetGraphOnly()
+	// Keep the diagram on stdout clean of what the script prints
+	bridge.SetOutput(os.Stderr)
+	if err := bridge.Load(script); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		bridge.Close()
+		os.Exit(1)
+	}
+
+	text, err := bridge.Export(flagGraphFormat, runs != nil, runs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		bridge.Close()
+		os.Exit(1)
+	}
+	fmt.Print(text)
+}
+
+// recordedNodes returns the workflow nodes of run id, or of the last
+// recorded run of script when id is empty
+func recordedNodes(script, id string) ([]workflow.RunNode, error) {
+	store := openHistory()
+	defer store.Close()
+
+	if id == "" {
+		abs, err := filepath.Abs(script)
+		if err != nil {
+			return nil, err
+		}
+		runs, err := store.List(history.Filter{Script: abs})
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range runs {
+			if run.Script == abs {
+				id = run.ID
+				break
+			}
+		}
+		if id == "" {
+			return nil, fmt.Errorf("no recorded run of %s", displayPath(abs))
+		}
+	}
+
+	run, err := store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]workflow.RunNode, 0, len(run.Nodes))
+	for _, node := range run.Nodes {
+		nodes = append(nodes, workflow.RunNode{
+			Workflow: node.Workflow,
+			Name:     node.Name,
+			Status:   node.Status,
+			Duration: node.Duration,
+		})
+	}
+	return nodes, nil
+}
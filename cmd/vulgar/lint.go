@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/lint"
+)
+
+var flagLintFormat string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint [paths...]",
+	Short: "Check scripts for mistakes without running them",
+	Long: `Check scripts for mistakes that --check does not catch:
+
+  unknown-module     require() of a module that does not exist
+  unknown-function   use of a function a module does not export (http.gett)
+  ignored-error      discarded err of a function returning (result, err)
+  undefined-global   read of a global that is never assigned
+  unused-local       local variable or function that is never read
+
+Directories are searched for .lua files, skipping hidden directories. With
+no paths the current directory is checked. Exits with status 1 when there
+are findings. Silence one with a comment on its line:
+
+  local data = json.decode(body) -- vulgar:ignore ignored-error
+
+--format=sarif writes a SARIF log for code scanning in CI.`,
+	Run: runLint,
+}
+
+func init() {
+	lintCmd.Flags().StringVar(&flagLintFormat, "format", "text", "Output format (text, sarif)")
+	rootCmd.AddCommand(lintCmd)
+}
+
+func runLint(cmd *cobra.Command, args []string) {
+	if flagLintFormat != "text" && flagLintFormat != "sarif" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --format %q (use text or sarif)\n", flagLintFormat)
+		os.Exit(1)
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	files, err := luaFiles(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	eng := engine.NewEngine(engine.Config{LogLevel: "ERROR", PluginDirs: pluginDirs()})
+	defer eng.Close()
+	var plugins []string
+	for _, p := range eng.Plugins() {
+		plugins = append(plugins, p.Module())
+	}
+	linter := lint.New(eng.L, plugins)
+	linter.ModuleHint = engine.ModuleHint
+
+	var diags []lint.Diagnostic
+	for _, file := range files {
+		found, err := linter.LintFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		diags = append(diags, found...)
+	}
+
+	if flagLintFormat == "sarif" {
+		err = lint.WriteSARIF(os.Stdout, diags, Version)
+	} else {
+		err = lint.WriteText(os.Stdout, diags)
+		if err == nil {
+			fmt.Println(lintSummary(len(files), diags))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+}
+
+// luaFiles expands directories in paths to the .lua files below them
+func luaFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && filepath.Ext(p) == ".lua" {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func lintSummary(files int, diags []lint.Diagnostic) string {
+	if len(diags) == 0 {
+		return fmt.Sprintf("%d file(s) checked, no problems found", files)
+	}
+	errors := 0
+	for _, d := range diags {
+		if d.Severity == lint.SeverityError {
+			errors++
+		}
+	}
+	return fmt.Sprintf("%d file(s) checked, %d problem(s) (%d error(s), %d warning(s))",
+		files, len(diags), errors, len(diags)-errors)
+}
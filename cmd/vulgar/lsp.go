@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/lsp"
+)
+
+var lspCmd = &cobra.Command{
+	Use:   "lsp",
+	Short: "Run the language server for editors",
+	Long: `Run a language server speaking LSP over stdin and stdout. Editors get
+completion of module names in require() and of module functions, hover
+documentation and signature help from the modules' usage examples, and the
+findings of 'vulgar lint' as diagnostics.
+
+Configure your editor to start 'vulgar lsp' for Lua files.`,
+	Args: cobra.NoArgs,
+	Run:  runLSP,
+}
+
+func init() {
+	rootCmd.AddCommand(lspCmd)
+}
+
+func runLSP(cmd *cobra.Command, args []string) {
+	// stdout carries the protocol; keep anything else printed off it
+	out := os.Stdout
+	os.Stdout = os.Stderr
+
+	eng := engine.NewEngine(engine.Config{LogLevel: "ERROR", PluginDirs: pluginDirs()})
+	defer eng.Close()
+	var plugins []string
+	for _, p := range eng.Plugins() {
+		plugins = append(plugins, p.Module())
+	}
+	server := lsp.New(eng.L, plugins)
+	server.Linter.ModuleHint = engine.ModuleHint
+	server.Version = Version
+
+	if err := server.Serve(os.Stdin, out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		eng.Close()
+		os.Exit(1)
+	}
+}
@@ -14,6 +14,9 @@ import (
 	"github.com/zepzeper/vulgar/cmd/vulgar/ui"
 	"github.com/zepzeper/vulgar/internal/engine"
 	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/params"
+	"github.com/zepzeper/vulgar/internal/plugins"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 // Version information - set via ldflags at build time
@@ -33,8 +36,38 @@ var (
 	// Execution flags
 	flagEval    string
 	flagTimeout string
+	flagGrace   string
 	flagDryRun  bool
 
+	// Event loop flags
+	flagEventQueueSize int
+
+	// Bytecode cache flags
+	flagNoCache bool
+
+	// Run history flags
+	flagNoHistory bool
+	flagResume    string
+
+	// Error reporting flags
+	flagErrorFormat string
+
+	// Script parameter flags
+	flagParams []string
+
+	// Sandbox flags
+	flagAllow []string
+	flagDeny  []string
+
+	// Resource limit flags
+	flagMaxMemory       string
+	flagMaxCallDepth    int
+	flagMaxStack        int
+	flagMaxInstructions int64
+
+	// Plugin flags
+	flagPluginDirs []string
+
 	// Inspection flags
 	flagCheck       bool
 	flagListModules bool
@@ -67,7 +100,26 @@ func init() {
 
 	rootCmd.Flags().StringVarP(&flagEval, "eval", "e", "", "Execute Lua code directly instead of a file")
 	rootCmd.Flags().StringVarP(&flagTimeout, "timeout", "t", "", "Execution timeout (e.g., 30s, 5m, 1h)")
-	rootCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Parse and validate without executing side effects")
+	rootCmd.Flags().StringVar(&flagGrace, "shutdown-grace", "10s", "Time on_shutdown handlers get after SIGINT/SIGTERM")
+	rootCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Execute with side-effecting calls stubbed out and report what would have run")
+
+	rootCmd.Flags().IntVar(&flagEventQueueSize, "event-queue-size", 0, "Events buffered before overflow policies apply (default 100)")
+	rootCmd.Flags().BoolVar(&flagNoCache, "no-cache", false, "Parse project modules on every run instead of caching their bytecode")
+	rootCmd.Flags().BoolVar(&flagNoHistory, "no-history", false, "Do not record the run in the run history (see 'vulgar runs')")
+	rootCmd.Flags().StringVar(&flagResume, "resume", "", "Continue an interrupted run, skipping workflow nodes it checkpointed")
+	rootCmd.Flags().StringVar(&flagErrorFormat, "error-format", errorFormatText, "Error report format (text, json)")
+
+	rootCmd.Flags().StringArrayVarP(&flagParams, "param", "p", nil, "Set a script parameter as name=value (repeatable)")
+
+	rootCmd.Flags().StringArrayVar(&flagAllow, "allow", nil, "Grant a capability: net[=host], fs[=path], shell, process[=name], ssh[=host] (repeatable)")
+	rootCmd.Flags().StringArrayVar(&flagDeny, "deny", nil, "Revoke a capability, overriding any allow (repeatable)")
+
+	rootCmd.Flags().StringVar(&flagMaxMemory, "max-memory", "", "Stop the script when the heap exceeds this size (e.g., 256MB, 1GB)")
+	rootCmd.Flags().IntVar(&flagMaxCallDepth, "max-call-depth", 0, "Maximum nested Lua calls (default 256)")
+	rootCmd.Flags().IntVar(&flagMaxStack, "max-stack", 0, "Maximum Lua value stack size in slots (default 5120)")
+	rootCmd.Flags().Int64Var(&flagMaxInstructions, "max-instructions", 0, "Stop the script after this many Lua VM instructions")
+
+	rootCmd.Flags().StringArrayVar(&flagPluginDirs, "plugin-dir", nil, "Also load plugins from this directory (repeatable, searched first)")
 
 	rootCmd.Flags().BoolVarP(&flagCheck, "check", "c", false, "Check syntax only, do not execute")
 	rootCmd.Flags().BoolVar(&flagListModules, "list-modules", false, "List all available modules and exit")
@@ -75,6 +127,17 @@ func init() {
 	rootCmd.Flags().BoolVar(&flagProfile, "profile", false, "Enable CPU profiling (writes to vulgar.prof)")
 	rootCmd.Flags().BoolVar(&flagTrace, "trace", false, "Enable execution tracing (writes to vulgar.trace)")
 
+	defaultHelp := rootCmd.HelpFunc()
+	rootCmd.SetHelpFunc(func(cmd *cobra.Command, args []string) {
+		if cmd == rootCmd {
+			if script := findScriptArg(args); script != "" {
+				printScriptHelp(script)
+				return
+			}
+		}
+		defaultHelp(cmd, args)
+	})
+
 	rootCmd.SetVersionTemplate(fmt.Sprintf("vulgar %s (built %s, commit %s)\n", Version, BuildTime, GitCommit))
 
 	// Register discovery commands (init, gdrive, gsheets, etc.)
@@ -88,7 +151,7 @@ func runRoot(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if len(args) == 0 && flagEval == "" {
+	if len(args) == 0 && flagEval == "" && flagResume == "" {
 		cmd.Help()
 		return
 	}
@@ -136,12 +199,48 @@ func runRoot(cmd *cobra.Command, args []string) {
 		logLevel = "DEBUG"
 	}
 
+	policy, err := sandbox.NewPolicy(flagAllow, flagDeny)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid sandbox rule: %v\n", err)
+		os.Exit(1)
+	}
+
+	grace, err := time.ParseDuration(flagGrace)
+	if err != nil || grace <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid shutdown grace period %q\n", flagGrace)
+		os.Exit(1)
+	}
+
+	if flagEventQueueSize < 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid --event-queue-size: must be positive\n")
+		os.Exit(1)
+	}
+
+	if err := validateErrorFormat(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	limits, err := buildLimits()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	cfg := engine.Config{
-		LogLevel:  logLevel,
-		LogFormat: flagLogFormat,
-		DryRun:    flagDryRun,
-		Profile:   flagProfile,
-		Trace:     flagTrace,
+		LogLevel:      logLevel,
+		LogFormat:     flagLogFormat,
+		DryRun:        flagDryRun,
+		Profile:       flagProfile,
+		Trace:         flagTrace,
+		Sandbox:       policy,
+		ShutdownGrace: grace,
+		Limits:        limits,
+		PluginDirs:    pluginDirs(),
+
+		EventQueueSize:  flagEventQueueSize,
+		BytecodeCache:   bytecodeCacheDir(),
+		OnCallbackError: printCallbackError,
 	}
 
 	eng := engine.NewEngine(cfg)
@@ -161,9 +260,15 @@ func runRoot(cmd *cobra.Command, args []string) {
 	}
 	eng.SetContext(ctx)
 
+	stopSignals := handleShutdownSignals(eng, grace)
+	defer stopSignals()
+
 	// Execute based on mode
 	if flagEval != "" {
 		runEval(eng, flagEval)
+	} else if len(args) == 0 {
+		// --resume finds the script in the run history
+		runScript(eng, "", nil)
 	} else {
 		runScript(eng, args[0], args[1:])
 	}
@@ -171,16 +276,26 @@ func runRoot(cmd *cobra.Command, args []string) {
 
 func runEval(eng *engine.Engine, code string) {
 	if err := eng.Eval(code); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: eval failed: %v\n", err)
+		printError("eval failed", err)
 		os.Exit(1)
 	}
 }
 
 func runScript(eng *engine.Engine, scriptPath string, scriptArgs []string) {
+	named, err := params.ParseAssignments(flagParams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if flagResume != "" {
+		scriptPath, scriptArgs = resumeRun(eng, scriptPath, scriptArgs, named)
+	}
+	eng.SetInput(params.Input{Named: named, Positional: scriptArgs, Strict: true})
+
 	// Syntax check mode
 	if flagCheck {
 		if err := eng.Compile(scriptPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Syntax error: %v\n", err)
+			printError("check failed", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Syntax OK: %s\n", scriptPath)
@@ -190,11 +305,11 @@ func runScript(eng *engine.Engine, scriptPath string, scriptArgs []string) {
 	// Dry run mode
 	if flagDryRun {
 		fmt.Printf("Dry run: %s\n", scriptPath)
-		if err := eng.Compile(scriptPath); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+		err := eng.RunWorkflow(scriptPath)
+		printDryRunReport(eng.DryRunCalls())
+		if err != nil {
+			exitWorkflowError(err)
 		}
-		fmt.Println("Validation passed (no side effects executed)")
 		return
 	}
 
@@ -203,9 +318,11 @@ func runScript(eng *engine.Engine, scriptPath string, scriptArgs []string) {
 		fmt.Printf("Running workflow: %s\n", scriptPath)
 	}
 
-	if err := eng.RunWorkflow(scriptPath); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: workflow failed: %v\n", err)
-		os.Exit(1)
+	rec := beginHistory(eng, scriptPath, named, scriptArgs)
+	err = eng.RunWorkflow(scriptPath)
+	finishHistory(rec, err)
+	if err != nil {
+		exitWorkflowError(err)
 	}
 
 	if flagVerbose {
@@ -213,6 +330,71 @@ func runScript(eng *engine.Engine, scriptPath string, scriptArgs []string) {
 	}
 }
 
+// buildLimits converts the resource limit flags into engine limits
+func buildLimits() (engine.Limits, error) {
+	limits := engine.Limits{
+		MaxCallDepth:    flagMaxCallDepth,
+		MaxStackSlots:   flagMaxStack,
+		MaxInstructions: flagMaxInstructions,
+	}
+
+	if flagMaxMemory != "" {
+		size, err := engine.ParseByteSize(flagMaxMemory)
+		if err != nil {
+			return limits, fmt.Errorf("invalid --max-memory: %w", err)
+		}
+		limits.MaxMemory = size
+	}
+	if flagMaxCallDepth < 0 {
+		return limits, fmt.Errorf("invalid --max-call-depth: must be positive")
+	}
+	if flagMaxStack != 0 && flagMaxStack < 128 {
+		return limits, fmt.Errorf("invalid --max-stack: must be at least 128 slots")
+	}
+	if flagMaxInstructions < 0 {
+		return limits, fmt.Errorf("invalid --max-instructions: must be positive")
+	}
+
+	return limits, nil
+}
+
+// findScriptArg returns the first argument that names an existing Lua script
+func findScriptArg(args []string) string {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") || !strings.HasSuffix(arg, ".lua") {
+			continue
+		}
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			return arg
+		}
+	}
+	return ""
+}
+
+// printScriptHelp prints the parameters a script declares via params{...}
+func printScriptHelp(scriptPath string) {
+	specs, err := params.Extract(scriptPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	params.PrintHelp(os.Stdout, scriptPath, specs)
+}
+
+// printDryRunReport lists every side-effecting call intercepted during a dry run
+func printDryRunReport(calls []engine.DryRunCall) {
+	fmt.Println()
+	if len(calls) == 0 {
+		fmt.Println("No side-effecting calls would have been made")
+		return
+	}
+
+	fmt.Printf("Calls that would have been made (%d):\n", len(calls))
+	for i, call := range calls {
+		fmt.Printf("  %d. %s\n", i+1, call.String())
+	}
+}
+
 func listModules() {
 	registry := modules.GetRegistry()
 
@@ -263,4 +445,17 @@ func listModules() {
 			fmt.Printf("    require(\"%s\")\n", name)
 		}
 	}
+
+	found := plugins.Discover(pluginDirs())
+	if len(found) > 0 {
+		fmt.Printf("\n  plugins (%d):\n", len(found))
+		for _, p := range found {
+			fmt.Printf("    require(\"%s\")  %s\n", p.Module(), p.Path)
+		}
+	}
+}
+
+// pluginDirs returns the --plugin-dir directories followed by the defaults
+func pluginDirs() []string {
+	return append(append([]string{}, flagPluginDirs...), plugins.DefaultDirs()...)
 }
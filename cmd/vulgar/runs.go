@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/history"
+	"github.com/zepzeper/vulgar/internal/params"
+)
+
+var (
+	flagRunsStatus string
+	flagRunsScript string
+	flagRunsSince  string
+	flagRunsLimit  int
+	flagRunsJSON   bool
+)
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "Show the history of script runs",
+	Long: `Every script run is recorded in a local database with its parameters,
+outcome, output and, for stdlib.workflow graphs, each node's status,
+duration and result:
+
+  vulgar runs list --status failed --since 24h
+  vulgar runs show 3f2a9c1e
+  vulgar runs logs 3f2a9c1e
+
+Runs are named by their ID or a unique prefix of it. Pass --no-history to
+a run to leave it out.`,
+}
+
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recent runs",
+	Args:  cobra.NoArgs,
+	Run:   runRunsList,
+}
+
+var runsShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "Show a run and its workflow nodes",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRunsShow,
+}
+
+var runsLogsCmd = &cobra.Command{
+	Use:   "logs <id>",
+	Short: "Print what a run printed and logged",
+	Args:  cobra.ExactArgs(1),
+	Run:   runRunsLogs,
+}
+
+func init() {
+	runsListCmd.Flags().StringVar(&flagRunsStatus, "status", "", "Only runs with this status (queued, running, succeeded, failed, stopped, aborted)")
+	runsListCmd.Flags().StringVar(&flagRunsScript, "script", "", "Only runs of scripts whose path contains this")
+	runsListCmd.Flags().StringVar(&flagRunsSince, "since", "", "Only runs started within this duration (e.g., 24h) or since this date (2006-01-02)")
+	runsListCmd.Flags().IntVarP(&flagRunsLimit, "limit", "n", 20, "Maximum runs to list (0 lists all)")
+	for _, cmd := range []*cobra.Command{runsListCmd, runsShowCmd, runsLogsCmd} {
+		cmd.Flags().BoolVar(&flagRunsJSON, "json", false, "Print JSON")
+		runsCmd.AddCommand(cmd)
+	}
+	rootCmd.AddCommand(runsCmd)
+}
+
+func openHistory() *history.Store {
+	store, err := history.Open(history.DefaultPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+func runRunsList(cmd *cobra.Command, args []string) {
+	filter := history.Filter{Status: flagRunsStatus, Script: flagRunsScript, Limit: flagRunsLimit}
+	if flagRunsSince != "" {
+		since, err := parseSince(flagRunsSince)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --since: %v\n", err)
+			os.Exit(1)
+		}
+		filter.Since = since
+	}
+
+	store := openHistory()
+	defer store.Close()
+	runs, err := store.List(filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		store.Close()
+		os.Exit(1)
+	}
+
+	if flagRunsJSON {
+		if runs == nil {
+			runs = []history.Run{}
+		}
+		printJSON(runs)
+		return
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tSTARTED\tDURATION\tSCRIPT")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", shortID(run.ID), run.Status,
+			run.Started.Format("2006-01-02 15:04:05"), formatDuration(run.Duration()), displayPath(run.Script))
+	}
+	tw.Flush()
+}
+
+func runRunsShow(cmd *cobra.Command, args []string) {
+	store := openHistory()
+	defer store.Close()
+	run, err := store.Get(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		store.Close()
+		os.Exit(1)
+	}
+	if flagRunsJSON {
+		printJSON(run)
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run:\t%s\n", run.ID)
+	fmt.Fprintf(tw, "Script:\t%s\n", run.Script)
+	if run.Trigger != "" {
+		fmt.Fprintf(tw, "Trigger:\t%s\n", run.Trigger)
+	}
+	fmt.Fprintf(tw, "Status:\t%s\n", run.Status)
+	fmt.Fprintf(tw, "Started:\t%s\n", run.Started.Format("2006-01-02 15:04:05"))
+	if run.Finished != nil {
+		fmt.Fprintf(tw, "Finished:\t%s (%s)\n", run.Finished.Format("2006-01-02 15:04:05"), formatDuration(run.Duration()))
+	}
+	if len(run.Params) > 0 {
+		var params []string
+		for name, value := range run.Params {
+			params = append(params, name+"="+value)
+		}
+		fmt.Fprintf(tw, "Params:\t%s\n", strings.Join(params, " "))
+	}
+	if len(run.Args) > 0 {
+		fmt.Fprintf(tw, "Args:\t%s\n", strings.Join(run.Args, " "))
+	}
+	tw.Flush()
+	if run.Error != "" {
+		fmt.Printf("\nError:\n    %s\n", strings.ReplaceAll(run.Error, "\n", "\n    "))
+	}
+
+	if len(run.Nodes) > 0 {
+		fmt.Println("\nNodes:")
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, node := range run.Nodes {
+			detail := string(node.Result)
+			if node.Error != "" {
+				detail = node.Error
+			}
+			fmt.Fprintf(tw, "    %s\t%s\t%s\t%s\n", node.Workflow+"."+node.Name, node.Status,
+				formatDuration(node.Duration), truncate(detail, 60))
+		}
+		tw.Flush()
+	}
+}
+
+func runRunsLogs(cmd *cobra.Command, args []string) {
+	store := openHistory()
+	defer store.Close()
+	lines, err := store.Logs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		store.Close()
+		os.Exit(1)
+	}
+	if flagRunsJSON {
+		if lines == nil {
+			lines = []history.Line{}
+		}
+		printJSON(lines)
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line.Text)
+	}
+}
+
+// parseSince accepts a duration before now or a date
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a date", s)
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond).String()
+	}
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+func truncate(s string, n int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len(s) > n {
+		return s[:n-3] + "..."
+	}
+	return s
+}
+
+// displayPath shortens paths below the working directory
+func displayPath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// historyStore is the run history a script run is recorded in, if any
+var historyStore *history.Store
+
+// beginHistory starts recording a script run. The engine's output is then
+// also written to the history. Failing to open the history only warns, so
+// the script still runs.
+func beginHistory(eng *engine.Engine, script string, named map[string]string, args []string) *history.Recorder {
+	if flagNoHistory {
+		return nil
+	}
+	store, err := history.Open(history.DefaultPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: run not recorded: %v\n", err)
+		return nil
+	}
+	if abs, err := filepath.Abs(script); err == nil {
+		script = abs
+	}
+	// Values of secret parameters are not kept
+	in := params.RedactScript(script, params.Input{Named: named, Positional: args})
+	named, args = in.Named, in.Positional
+	// A resumed run keeps the ID it checkpoints under, but is recorded as
+	// a run of its own
+	id, trigger := eng.RunID(), ""
+	if eng.Resuming() {
+		id, trigger = uuid.NewString(), resumeTrigger+eng.RunID()
+	}
+	rec, err := store.Begin(history.Run{ID: id, Script: script, Params: named, Args: args, Trigger: trigger})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: run not recorded: %v\n", err)
+		store.Close()
+		return nil
+	}
+	historyStore = store
+
+	eng.SetOutput(io.MultiWriter(os.Stdout, rec))
+	eng.SetNodeObserver(rec.Node)
+	return rec
+}
+
+// resumeTrigger prefixes the ID of the resumed run in the trigger of a run
+// started with --resume
+const resumeTrigger = "resume "
+
+// resumeRun makes the engine continue the run --resume names. The script,
+// arguments and parameters the run had are taken from the run history
+// unless given again; the ID may be a prefix when the run is recorded.
+func resumeRun(eng *engine.Engine, script string, args []string, named map[string]string) (string, []string) {
+	id := flagResume
+	if store, err := history.Open(history.DefaultPath()); err == nil {
+		run, err := store.Get(id)
+		store.Close()
+		switch {
+		case err == nil:
+			// Resuming a resumed run continues the original
+			id = run.ID
+			if original, ok := strings.CutPrefix(run.Trigger, resumeTrigger); ok {
+				id = original
+			}
+			if script == "" {
+				script = run.Script
+			}
+			if len(args) == 0 {
+				args = run.Args
+			}
+			for name, value := range run.Params {
+				if _, ok := named[name]; !ok {
+					named[name] = value
+				}
+			}
+		case !errors.Is(err, history.ErrNotFound):
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	if script == "" {
+		fmt.Fprintf(os.Stderr, "Error: run %s is not in the run history; name the script to resume\n", flagResume)
+		os.Exit(1)
+	}
+	eng.Resume(id)
+	return script, args
+}
+
+// finishHistory records how a run ended
+func finishHistory(rec *history.Recorder, err error) {
+	if rec == nil {
+		return
+	}
+	rec.Finish(err)
+	historyStore.Close()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/zepzeper/vulgar/internal/config"
+	"github.com/zepzeper/vulgar/internal/daemon"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/history"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+var (
+	flagServeDir           string
+	flagServeAddr          string
+	flagServeMaxConcurrent int
+	flagServeAPIOnly       bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run workflows on their triggers as a daemon",
+	Long: `Load every workflow under the workflows directory and run it whenever
+one of the triggers declared in its header fires:
+
+  -- vulgar:trigger cron 0 */15 * * * *    On a schedule (seconds optional)
+  -- vulgar:trigger watch ./inbox          When a file or directory changes
+  -- vulgar:trigger webhook github/push     On a request to /hooks/github/push
+  -- vulgar:trigger manual                 On POST /run/<workflow>
+  -- vulgar:concurrency 2                  Active runs allowed (default 1)
+  -- vulgar:timeout 10m                    Stop runs that take longer
+
+Each run gets its own engine. Scripts see what started them in the global
+trigger table (trigger.type, trigger.run_id, and the request for webhooks).
+Workflows are reloaded when their files change.
+
+A webhook or manual trigger can name a secret in an environment variable:
+
+  -- vulgar:trigger webhook github/push secret=$GITHUB_WEBHOOK_SECRET
+
+Requests must then sign their body with it in X-Hub-Signature-256, as GitHub
+does, or send it as an "Authorization: Bearer" token; others get a 401.
+Without a secret anyone who can reach --addr can start the workflow, so keep
+it on loopback unless every trigger has one.
+
+There is no --max-memory here: the Go heap is shared by every run, so one
+run could not be held to it.
+
+When [[api.tokens]] are configured, an HTTP control API is served at /api/
+to list workflows, start runs with JSON parameters, poll their status,
+stream their logs and cancel them. --api-only serves just the API, leaving
+cron, watch and webhook triggers off.`,
+	Args: cobra.NoArgs,
+	Run:  runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&flagServeDir, "dir", "", "Workflows directory (default from config, or ./workflows)")
+	serveCmd.Flags().StringVar(&flagServeAddr, "addr", "127.0.0.1:8420", "Address to serve webhooks, manual runs and the API on (empty disables)")
+	serveCmd.Flags().IntVar(&flagServeMaxConcurrent, "max-concurrent", 0, "Runs executing at once across all workflows (default number of CPUs)")
+	serveCmd.Flags().BoolVar(&flagServeAPIOnly, "api-only", false, "Only run workflows the HTTP API starts (needs [[api.tokens]] in the config)")
+
+	serveCmd.Flags().StringVarP(&flagLogLevel, "log-level", "l", "INFO", "Log level (DEBUG, INFO, WARN, ERROR)")
+	serveCmd.Flags().StringVar(&flagLogFormat, "log-format", "text", "Log format (text, json)")
+	serveCmd.Flags().StringVar(&flagGrace, "shutdown-grace", "10s", "Time on_shutdown handlers of active runs get when the daemon stops")
+	serveCmd.Flags().BoolVar(&flagNoCache, "no-cache", false, "Parse project modules on every run instead of caching their bytecode")
+	serveCmd.Flags().BoolVar(&flagNoHistory, "no-history", false, "Do not record runs in the run history (see 'vulgar runs')")
+	serveCmd.Flags().StringArrayVar(&flagAllow, "allow", nil, "Grant a capability to every workflow (repeatable)")
+	serveCmd.Flags().StringArrayVar(&flagDeny, "deny", nil, "Revoke a capability from every workflow (repeatable)")
+	serveCmd.Flags().Int64Var(&flagMaxInstructions, "max-instructions", 0, "Stop a run after this many Lua VM instructions")
+	serveCmd.Flags().StringArrayVar(&flagPluginDirs, "plugin-dir", nil, "Also load plugins from this directory (repeatable, searched first)")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	dir := flagServeDir
+	if dir == "" {
+		dir = config.GetWorkflowsPath()
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: workflows directory %s not found\n", dir)
+		os.Exit(1)
+	}
+
+	// Validate the flags once, rather than in every run
+	if _, err := sandbox.NewPolicy(flagAllow, flagDeny); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid sandbox rule: %v\n", err)
+		os.Exit(1)
+	}
+	grace, err := time.ParseDuration(flagGrace)
+	if err != nil || grace <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid shutdown grace period %q\n", flagGrace)
+		os.Exit(1)
+	}
+	limits, err := buildLimits()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	tokens := config.GetAPITokens()
+	if flagServeAPIOnly && flagServeAddr == "" {
+		fmt.Fprintln(os.Stderr, "Error: --api-only needs an --addr to serve the API on")
+		os.Exit(1)
+	}
+	if flagServeAddr != "" && !isLoopback(flagServeAddr) {
+		fmt.Fprintf(os.Stderr, "Warning: serving on %s, which is reachable from other hosts; "+
+			"webhook and manual triggers without secret=$VAR run for anyone who can reach it\n", flagServeAddr)
+	}
+	if len(tokens) > 0 && flagNoHistory {
+		fmt.Fprintln(os.Stderr, "Error: the HTTP API reports runs from the run history; drop --no-history")
+		os.Exit(1)
+	}
+
+	var store *history.Store
+	if !flagNoHistory {
+		store, err = history.Open(history.DefaultPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+	}
+
+	d, err := daemon.New(daemon.Config{
+		Dir:           dir,
+		History:       store,
+		Addr:          flagServeAddr,
+		Tokens:        tokens,
+		APIOnly:       flagServeAPIOnly,
+		MaxConcurrent: flagServeMaxConcurrent,
+		NewEngine: func() (*engine.Engine, error) {
+			// Scripts narrow the policy with their declared permissions,
+			// so every run needs its own
+			policy, err := sandbox.NewPolicy(flagAllow, flagDeny)
+			if err != nil {
+				return nil, err
+			}
+			return engine.NewEngine(engine.Config{
+				LogLevel:      flagLogLevel,
+				LogFormat:     flagLogFormat,
+				Sandbox:       policy,
+				ShutdownGrace: grace,
+				Limits:        limits,
+				PluginDirs:    pluginDirs(),
+
+				BytecodeCache:   bytecodeCacheDir(),
+				OnCallbackError: printCallbackError,
+			}), nil
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// A second signal, or runs outliving the grace period, exits at once
+		<-ctx.Done()
+		stop()
+		fmt.Fprintln(os.Stderr, "\nStopping, waiting for active runs (send again to force)")
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "Forced exit")
+		case <-time.After(grace + forceExitMargin):
+			fmt.Fprintf(os.Stderr, "Runs did not stop within %s, exiting\n", grace)
+		}
+		os.Exit(1)
+	}()
+
+	fmt.Printf("Serving workflows from %s\n", dir)
+	if err := d.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// isLoopback reports whether addr only listens on the local machine
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/engine"
+)
+
+// Exit codes for runs stopped by a signal, following the 128+signal convention
+var shutdownExitCodes = map[string]int{
+	"SIGINT":  130,
+	"SIGTERM": 143,
+}
+
+// forceExitMargin is added to the grace period before a stuck shutdown is
+// abandoned, so handlers that finish right at the deadline can still report
+const forceExitMargin = 2 * time.Second
+
+func shutdownExitCode(reason string) int {
+	if code, ok := shutdownExitCodes[reason]; ok {
+		return code
+	}
+	return 1
+}
+
+// handleShutdownSignals turns SIGINT/SIGTERM into a graceful engine shutdown.
+// A second signal, or a shutdown that outlives the grace period (e.g. because
+// the script is blocked in a Go call), exits immediately.
+// The returned function stops listening.
+func handleShutdownSignals(eng *engine.Engine, grace time.Duration) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		var reason string
+		select {
+		case sig := <-signals:
+			reason = signalName(sig)
+		case <-done:
+			return
+		}
+
+		fmt.Fprintf(os.Stderr, "\nReceived %s, shutting down (send again to force)\n", reason)
+		eng.Shutdown(reason)
+
+		select {
+		case <-signals:
+			fmt.Fprintln(os.Stderr, "Forced exit")
+		case <-time.After(grace + forceExitMargin):
+			fmt.Fprintf(os.Stderr, "Shutdown did not finish within %s, exiting\n", grace)
+		case <-done:
+			return
+		}
+		os.Exit(shutdownExitCode(reason))
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func signalName(sig os.Signal) string {
+	switch sig {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return sig.String()
+}
+
+// exitWorkflowError reports a failed run and exits. Runs stopped by a signal
+// exit with the signal's code rather than 1.
+func exitWorkflowError(err error) {
+	var shutdownErr *engine.ShutdownError
+	if errors.As(err, &shutdownErr) {
+		if shutdownErr.Err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", shutdownErr.Err)
+		}
+		os.Exit(shutdownExitCode(shutdownErr.Reason))
+	}
+
+	printError("workflow failed", err)
+	os.Exit(1)
+}
@@ -0,0 +1,115 @@
+// Package bytecode stores compiled Lua scripts so they can run without being
+// parsed again.
+//
+// `vulgar compile` writes a script's compiled form to a .luac file, which
+// the engine runs like a source file. The engine also keeps compiled project
+// modules in a Cache keyed by their content, so require() only parses a
+// module again after it changed.
+//
+// Compiled chunks are tied to the format version and the gopher-lua version
+// that produced them. Loading a chunk from another version fails with
+// ErrIncompatible; recompile it from source.
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"runtime/debug"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Extension is the file extension of compiled scripts
+const Extension = ".luac"
+
+// FormatVersion is the version of the encoding written by Encode. It changes
+// whenever the encoding does.
+const FormatVersion = 1
+
+var (
+	// ErrIncompatible is returned for chunks written by another format or
+	// gopher-lua version
+	ErrIncompatible = errors.New("compiled by an incompatible vulgar version")
+	// ErrCorrupt is returned for data that is not a valid chunk
+	ErrCorrupt = errors.New("corrupt compiled chunk")
+)
+
+// Chunk is a compiled script
+type Chunk struct {
+	// Proto is the compiled main function
+	Proto *lua.FunctionProto
+	// Header is the leading comment block of the source, which holds
+	// directives such as sandbox permissions
+	Header string
+}
+
+// Compile parses and compiles Lua source. name is used in error messages and
+// tracebacks, normally the path of the source file. Errors are
+// *lua.ApiError syntax errors, as returned by LState.LoadFile.
+func Compile(source []byte, name string) (*Chunk, error) {
+	tree, err := parse.Parse(bytes.NewReader(source), name)
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+	proto, err := lua.Compile(tree, name)
+	if err != nil {
+		return nil, syntaxError(err)
+	}
+	return &Chunk{Proto: proto, Header: header(source)}, nil
+}
+
+func syntaxError(err error) error {
+	return &lua.ApiError{Type: lua.ApiErrorSyntax, Object: lua.LString(err.Error()), Cause: err}
+}
+
+// header returns the leading lines of source that are blank, comments or a
+// shebang
+func header(source []byte) string {
+	var b strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "--") && !strings.HasPrefix(line, "#!") {
+			break
+		}
+		b.WriteString(scanner.Text())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// IsChunk reports whether data starts like a compiled chunk
+func IsChunk(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Load compiles source, or decodes it if it is a compiled chunk
+func Load(data []byte, name string) (*Chunk, error) {
+	if IsChunk(data) {
+		return Decode(data)
+	}
+	return Compile(data, name)
+}
+
+// luaVersion is the version of gopher-lua this binary was built with. The
+// instruction encoding is internal to gopher-lua, so chunks only load in
+// builds using the same version.
+var luaVersion = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != "github.com/yuin/gopher-lua" {
+			continue
+		}
+		if dep.Replace != nil {
+			return dep.Replace.Path + "@" + dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "unknown"
+}()
@@ -0,0 +1,152 @@
+package bytecode
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const testScript = `-- vulgar:allow fs=./data
+local greeting = "hello"
+counter = 0
+
+local function make_adder(n)
+  return function(x, ...)
+    counter = counter + 1
+    return x + n + select("#", ...)
+  end
+end
+
+local add = make_adder(0.5)
+local parts = {}
+for i = 1, 3 do
+  parts[#parts + 1] = string.format("%s-%g", greeting, add(i, "a", "b"))
+end
+result = table.concat(parts, ",")
+
+function fail()
+  error("boom")
+end
+`
+
+func roundTrip(t *testing.T, source string) *Chunk {
+	t.Helper()
+	chunk, err := Compile([]byte(source), "test.lua")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, chunk); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return decoded
+}
+
+func TestDecodedChunkRunsLikeSource(t *testing.T) {
+	chunk := roundTrip(t, testScript)
+	if !strings.Contains(chunk.Header, "vulgar:allow fs=./data") {
+		t.Errorf("header = %q", chunk.Header)
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	L.Push(L.NewFunctionFromProto(chunk.Proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		t.Fatalf("running decoded chunk: %v", err)
+	}
+	if got := L.GetGlobal("result").String(); got != "hello-3.5,hello-4.5,hello-5.5" {
+		t.Errorf("result = %s", got)
+	}
+	if got := L.GetGlobal("counter").String(); got != "3" {
+		t.Errorf("counter = %s", got)
+	}
+
+	// Line numbers survive for error messages
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal("fail"), Protect: true})
+	if err == nil || !strings.Contains(err.Error(), "test.lua:20: boom") {
+		t.Errorf("error = %v, want test.lua:20: boom", err)
+	}
+}
+
+func TestDecodeRejectsOtherVersionsAndCorruptData(t *testing.T) {
+	chunk, err := Compile([]byte("return 1"), "test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, chunk); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	other := append([]byte(nil), data...)
+	other[len(magic)] = FormatVersion + 1
+	if _, err := Decode(other); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("other format version: err = %v, want ErrIncompatible", err)
+	}
+
+	if _, err := Decode(data[:len(data)-3]); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated chunk: err = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestCompileReportsSyntaxErrors(t *testing.T) {
+	_, err := Compile([]byte("local x = \nif"), "broken.lua")
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Type != lua.ApiErrorSyntax {
+		t.Fatalf("err = %v, want a syntax *lua.ApiError", err)
+	}
+}
+
+func TestCacheStoresChunksByContent(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewCache(filepath.Join(dir, "cache"))
+	path := filepath.Join(dir, "mod.lua")
+	if err := os.WriteFile(path, []byte("return 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := func() []string {
+		matches, _ := filepath.Glob(filepath.Join(cache.Dir(), "*"+Extension))
+		return matches
+	}
+
+	if _, err := cache.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	first := entries()
+	if len(first) != 1 {
+		t.Fatalf("cache entries = %v, want 1", first)
+	}
+
+	// A corrupt entry is compiled again and replaced
+	if err := os.WriteFile(first[0], []byte("\x1bVLGjunk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile with corrupt entry: %v", err)
+	}
+	if data, _ := os.ReadFile(first[0]); !IsChunk(data) || len(data) <= len("\x1bVLGjunk") {
+		t.Error("corrupt entry was not replaced")
+	}
+
+	// Changed source gets its own entry
+	if err := os.WriteFile(path, []byte("return 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries(); len(got) != 2 {
+		t.Errorf("cache entries = %v, want 2", got)
+	}
+}
@@ -0,0 +1,100 @@
+package bytecode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+)
+
+// Cache keeps compiled chunks on disk, keyed by a hash of the source, its
+// name and the format and gopher-lua versions. Entries never go stale: a
+// changed source has another key. Removing the directory is always safe.
+type Cache struct {
+	dir string
+}
+
+// NewCache returns a cache storing chunks in dir, which is created when the
+// first chunk is stored
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// DefaultCacheDir is the cache directory of the current user
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vulgar", "bytecode"), nil
+}
+
+// Dir returns the cache directory
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Load returns the compiled form of source, compiling and storing it on a
+// cache miss. Unreadable or outdated entries are replaced; failing to store
+// an entry is not an error.
+func (c *Cache) Load(source []byte, name string) (*Chunk, error) {
+	path := c.path(source, name)
+	if data, err := os.ReadFile(path); err == nil {
+		if chunk, err := Decode(data); err == nil {
+			return chunk, nil
+		}
+	}
+
+	chunk, err := Compile(source, name)
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, chunk)
+	return chunk, nil
+}
+
+// LoadFile is Load for the source in a file
+func (c *Cache) LoadFile(path string) (*Chunk, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return c.Load(source, path)
+}
+
+func (c *Cache) path(source []byte, name string) string {
+	h := sha256.New()
+	var version [binary.MaxVarintLen64]byte
+	h.Write(version[:binary.PutUvarint(version[:], FormatVersion)])
+	h.Write([]byte(luaVersion + "\x00" + name + "\x00"))
+	h.Write(source)
+	return filepath.Join(c.dir, hex.EncodeToString(h.Sum(nil))+Extension)
+}
+
+// store writes an entry through a temporary file, so concurrent runs never
+// read a partial chunk
+func (c *Cache) store(path string, chunk *Chunk) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, chunk); err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,314 @@
+package bytecode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"unsafe"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// magic starts every chunk. Like Lua's own precompiled chunks it begins
+// with ESC, which cannot start a Lua source file.
+var magic = []byte("\x1bVLG")
+
+// Constant tags
+const (
+	constNumber byte = iota
+	constString
+)
+
+// Encode writes a chunk:
+//
+//	magic, format version, gopher-lua version, header, main function
+//
+// Functions are written recursively with their constants, nested functions
+// and debug information. Integers are varints, strings are length-prefixed.
+func Encode(w io.Writer, c *Chunk) error {
+	enc := &encoder{w: bufio.NewWriter(w)}
+	enc.bytes(magic)
+	enc.uint(FormatVersion)
+	enc.string(luaVersion)
+	enc.string(c.Header)
+	enc.proto(c.Proto)
+	if enc.err != nil {
+		return enc.err
+	}
+	return enc.w.Flush()
+}
+
+// Decode reads a chunk written by Encode
+func Decode(data []byte) (*Chunk, error) {
+	if !IsChunk(data) {
+		return nil, ErrCorrupt
+	}
+	dec := &decoder{data: data, pos: len(magic)}
+	if version := dec.uint(); dec.err == nil && version != FormatVersion {
+		return nil, fmt.Errorf("%w (format %d, expected %d)", ErrIncompatible, version, FormatVersion)
+	}
+	if version := dec.string(); dec.err == nil && version != luaVersion {
+		return nil, fmt.Errorf("%w (gopher-lua %s, expected %s)", ErrIncompatible, version, luaVersion)
+	}
+
+	c := &Chunk{Header: dec.string()}
+	c.Proto = dec.proto()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if dec.pos != len(data) {
+		return nil, fmt.Errorf("%w: trailing data", ErrCorrupt)
+	}
+	return c, nil
+}
+
+type encoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (e *encoder) bytes(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *encoder) uint(v uint64) {
+	e.bytes(e.buf[:binary.PutUvarint(e.buf[:], v)])
+}
+
+func (e *encoder) int(v int) {
+	e.bytes(e.buf[:binary.PutVarint(e.buf[:], int64(v))])
+}
+
+func (e *encoder) byte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uint(uint64(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *encoder) proto(p *lua.FunctionProto) {
+	e.string(p.SourceName)
+	e.int(p.LineDefined)
+	e.int(p.LastLineDefined)
+	e.bytes([]byte{p.NumUpvalues, p.NumParameters, p.IsVarArg, p.NumUsedRegisters})
+
+	e.uint(uint64(len(p.Code)))
+	for _, inst := range p.Code {
+		binary.LittleEndian.PutUint32(e.buf[:4], inst)
+		e.bytes(e.buf[:4])
+	}
+
+	e.uint(uint64(len(p.Constants)))
+	for _, c := range p.Constants {
+		switch v := c.(type) {
+		case lua.LNumber:
+			e.byte(constNumber)
+			binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(float64(v)))
+			e.bytes(e.buf[:8])
+		case lua.LString:
+			e.byte(constString)
+			e.string(string(v))
+		default:
+			if e.err == nil {
+				e.err = fmt.Errorf("cannot encode constant of type %s", c.Type())
+			}
+		}
+	}
+
+	e.uint(uint64(len(p.FunctionPrototypes)))
+	for _, child := range p.FunctionPrototypes {
+		e.proto(child)
+	}
+
+	e.uint(uint64(len(p.DbgSourcePositions)))
+	for _, line := range p.DbgSourcePositions {
+		e.int(line)
+	}
+	e.uint(uint64(len(p.DbgLocals)))
+	for _, local := range p.DbgLocals {
+		e.string(local.Name)
+		e.int(local.StartPc)
+		e.int(local.EndPc)
+	}
+	e.uint(uint64(len(p.DbgCalls)))
+	for _, call := range p.DbgCalls {
+		e.string(call.Name)
+		e.int(call.Pc)
+	}
+	e.uint(uint64(len(p.DbgUpvalues)))
+	for _, name := range p.DbgUpvalues {
+		e.string(name)
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: truncated at byte %d", ErrCorrupt, d.pos)
+	}
+	d.pos = len(d.data)
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.data)-d.pos < n {
+		d.fail()
+		return nil
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.pos += n
+	return int(v)
+}
+
+// count reads a slice length. Every element takes at least one byte, so
+// corrupt lengths are caught before they are allocated.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint()
+	if n > uint64(len(d.data)-d.pos) {
+		d.fail()
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) proto() *lua.FunctionProto {
+	p := &lua.FunctionProto{
+		SourceName:      d.string(),
+		LineDefined:     d.int(),
+		LastLineDefined: d.int(),
+	}
+	if b := d.next(4); b != nil {
+		p.NumUpvalues, p.NumParameters, p.IsVarArg, p.NumUsedRegisters = b[0], b[1], b[2], b[3]
+	}
+
+	p.Code = make([]uint32, d.count())
+	for i := range p.Code {
+		if b := d.next(4); b != nil {
+			p.Code[i] = binary.LittleEndian.Uint32(b)
+		}
+	}
+
+	p.Constants = make([]lua.LValue, d.count())
+	for i := range p.Constants {
+		switch d.byte() {
+		case constNumber:
+			if b := d.next(8); b != nil {
+				p.Constants[i] = lua.LNumber(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			}
+		case constString:
+			p.Constants[i] = lua.LString(d.string())
+		default:
+			d.fail()
+		}
+		if d.err != nil {
+			return p
+		}
+	}
+
+	p.FunctionPrototypes = make([]*lua.FunctionProto, d.count())
+	for i := range p.FunctionPrototypes {
+		p.FunctionPrototypes[i] = d.proto()
+		if d.err != nil {
+			return p
+		}
+	}
+
+	p.DbgSourcePositions = make([]int, d.count())
+	for i := range p.DbgSourcePositions {
+		p.DbgSourcePositions[i] = d.int()
+	}
+	p.DbgLocals = make([]*lua.DbgLocalInfo, d.count())
+	for i := range p.DbgLocals {
+		p.DbgLocals[i] = &lua.DbgLocalInfo{Name: d.string(), StartPc: d.int(), EndPc: d.int()}
+	}
+	p.DbgCalls = make([]lua.DbgCall, d.count())
+	for i := range p.DbgCalls {
+		p.DbgCalls[i] = lua.DbgCall{Name: d.string(), Pc: d.int()}
+	}
+	p.DbgUpvalues = make([]string, d.count())
+	for i := range p.DbgUpvalues {
+		p.DbgUpvalues[i] = d.string()
+	}
+
+	if d.err == nil {
+		d.err = setStringConstants(p)
+	}
+	return p
+}
+
+// setStringConstants fills the unexported stringConstants field of a
+// decoded function. The compiler derives it from Constants and the VM uses
+// it for global lookups, but gopher-lua offers no way to set it.
+func setStringConstants(p *lua.FunctionProto) error {
+	field := reflect.ValueOf(p).Elem().FieldByName("stringConstants")
+	if !field.IsValid() || field.Type() != reflect.TypeOf([]string(nil)) {
+		return fmt.Errorf("%w (unsupported gopher-lua version)", ErrIncompatible)
+	}
+
+	values := make([]string, len(p.Constants))
+	for i, c := range p.Constants {
+		if s, ok := c.(lua.LString); ok {
+			values[i] = string(s)
+		}
+	}
+	reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Set(reflect.ValueOf(values))
+	return nil
+}
@@ -220,22 +220,36 @@ func (r *GraphRenderer) renderHorizontalFlow(layers [][]workflow.NodeInfo) strin
 				Width(r.nodeWidth - 2).
 				Align(lipgloss.Center)
 		} else {
+			border := normalBorder
+			if node.Map {
+				// A map node stands for a group of items
+				border = lipgloss.DoubleBorder()
+			}
 			boxStyle = lipgloss.NewStyle().
-				Border(normalBorder).
+				Border(border).
 				BorderForeground(statusColor(node.Status)).
 				Width(r.nodeWidth - 2).
 				Align(lipgloss.Center)
 		}
 
-		// Truncate name if needed
+		// Truncate name if needed; subflows are marked as expandable groups
 		name := node.Name
+		if node.Subflow != "" {
+			name = "▸ " + name
+		}
 		maxNameLen := r.nodeWidth - 4
 		if len(name) > maxNameLen {
 			name = name[:maxNameLen-2] + ".."
 		}
 
-		// Status line with icon
+		// Status line with icon; map nodes show their progress over items
 		statusLine := fmt.Sprintf("%s %s", statusIcon(node.Status), node.Status)
+		if node.Map && len(node.Items) > 0 {
+			statusLine = fmt.Sprintf("%s %d/%d", statusIcon(node.Status), countItems(node.Items, "completed"), len(node.Items))
+		}
+		if node.Subflow != "" && len(node.Children) > 0 && node.Status == "running" {
+			statusLine = fmt.Sprintf("%s %d/%d", statusIcon(node.Status), countNodes(node.Children, "completed"), len(node.Children))
+		}
 		if len(statusLine) > maxNameLen {
 			statusLine = statusLine[:maxNameLen]
 		}
@@ -326,6 +340,22 @@ func (r *GraphRenderer) RenderNodeDetail(node *workflow.NodeInfo, inputContext s
 		lines = append(lines, cli.Muted("Downstream:   ")+strings.Join(node.Outputs, ", "))
 	}
 
+	// Nodes of a subflow, with theirs nested below them
+	if node.Subflow != "" {
+		lines = append(lines, cli.Muted("Subflow:      ")+node.Subflow)
+		lines = append(lines, renderSubflowNodes(node.Children, "  ")...)
+	}
+
+	// Items of a map node
+	if node.Map {
+		lines = append(lines, cli.Muted("Items:        ")+itemSummary(node.Items))
+		for i, item := range node.Items {
+			if item.Error != "" && item.Status == "failed" {
+				lines = append(lines, cli.Muted(fmt.Sprintf("  item %d: ", i+1))+formatJSON(item.Error, 60))
+			}
+		}
+	}
+
 	lines = append(lines, "")
 
 	// Input section (context from dependencies)
@@ -384,6 +414,60 @@ func (r *GraphRenderer) RenderNodeCode(code *workflow.NodeCodeInfo) string {
 	return strings.Join(lines, "\n")
 }
 
+// countNodes counts the nodes of a subflow with the given status
+func countNodes(nodes []workflow.NodeInfo, status string) int {
+	n := 0
+	for _, node := range nodes {
+		if node.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// renderSubflowNodes lists the nodes of a subflow with their status,
+// descending into nested subflows
+func renderSubflowNodes(nodes []workflow.NodeInfo, indent string) []string {
+	sorted := append([]workflow.NodeInfo(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var lines []string
+	for _, node := range sorted {
+		statusStyle := lipgloss.NewStyle().Foreground(statusColor(node.Status))
+		lines = append(lines, fmt.Sprintf("%s%s %s  %s", indent,
+			statusStyle.Render(statusIcon(node.Status)), node.Name, cli.Muted(node.Status)))
+		if len(node.Children) > 0 {
+			lines = append(lines, renderSubflowNodes(node.Children, indent+"  ")...)
+		}
+	}
+	return lines
+}
+
+// countItems counts the items of a map node with the given status
+func countItems(items []workflow.ItemInfo, status string) int {
+	n := 0
+	for _, item := range items {
+		if item.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// itemSummary describes the items of a map node, e.g. "12 (10 completed, 2 running)"
+func itemSummary(items []workflow.ItemInfo) string {
+	if len(items) == 0 {
+		return "not expanded yet"
+	}
+	var parts []string
+	for _, status := range []string{"completed", "running", "failed", "pending"} {
+		if n := countItems(items, status); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	return fmt.Sprintf("%d (%s)", len(items), strings.Join(parts, ", "))
+}
+
 // formatJSON formats a JSON string for display, truncating if needed
 func formatJSON(jsonStr string, maxLen int) string {
 	// Clean up the JSON string for display
@@ -3,10 +3,14 @@ package workflow
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"sync"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 // NodeInfo represents a workflow node for TUI display
@@ -16,6 +20,19 @@ type NodeInfo struct {
 	Dependencies []string `json:"dependencies"`
 	Outputs      []string `json:"outputs"`
 	Result       string   `json:"result,omitempty"`
+	// Map nodes (workflow.map_node) fan out over items at runtime
+	Map   bool       `json:"map,omitempty"`
+	Items []ItemInfo `json:"items,omitempty"`
+	// Subflow nodes (workflow.subflow) run the workflow named Subflow,
+	// whose nodes are Children
+	Subflow  string     `json:"subflow,omitempty"`
+	Children []NodeInfo `json:"children,omitempty"`
+}
+
+// ItemInfo is the state of one item of a map node
+type ItemInfo struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 // EdgeInfo represents a connection between nodes
@@ -44,6 +61,8 @@ type Bridge struct {
 	mu         sync.RWMutex
 	loaded     bool
 	lastError  string
+	output     io.Writer // Where the script's output goes (nil: stdout)
+	graphOnly  bool      // Load without side effects or running the workflow
 }
 
 // NewBridge creates a new workflow bridge
@@ -53,6 +72,23 @@ func NewBridge() *Bridge {
 	}
 }
 
+// SetOutput sends what the script prints while it is loaded to w
+func (b *Bridge) SetOutput(w io.Writer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.output = w
+}
+
+// SetGraphOnly makes Load build the graph without side effects: the script
+// runs as a dry run with every capability denied, and workflow.run records
+// the workflow instead of running its nodes. The workflow cannot be executed
+// afterwards.
+func (b *Bridge) SetGraphOnly() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.graphOnly = true
+}
+
 // Load loads a workflow file and extracts its graph structure
 func (b *Bridge) Load(path string) error {
 	b.mu.Lock()
@@ -63,7 +99,21 @@ func (b *Bridge) Load(path string) error {
 		LogLevel:  "WARN",
 		LogFormat: "text",
 	}
+	if b.graphOnly {
+		deny := make([]string, len(sandbox.Capabilities))
+		for i, c := range sandbox.Capabilities {
+			deny[i] = string(c)
+		}
+		policy, err := sandbox.NewPolicy(nil, deny)
+		if err != nil {
+			return err
+		}
+		cfg.DryRun, cfg.GraphOnly, cfg.Sandbox = true, true, policy
+	}
 	b.engine = engine.NewEngine(cfg)
+	if b.output != nil {
+		b.engine.SetOutput(b.output)
+	}
 	b.path = path
 	b.loaded = false
 	b.lastError = ""
@@ -85,6 +135,13 @@ func (b *Bridge) Load(path string) error {
 		}
 	}
 
+	if b.workflowUD == nil {
+		// A graph-only load also knows the workflow passed to workflow.run
+		if ud, ok := b.engine.L.GetField(b.engine.L.Get(lua.RegistryIndex), util.GraphRegistryKey).(*lua.LUserData); ok {
+			b.workflowUD = ud
+		}
+	}
+
 	if b.workflowUD == nil {
 		// Try to find any workflow userdata in globals
 		// This is a fallback if the workflow uses a non-standard variable name
@@ -153,6 +210,44 @@ func (b *Bridge) refreshGraph() error {
 	L.Pop(1)
 
 	// Parse nodes
+	nodes := parseNodes(nodesTable)
+
+	// Parse edges
+	var edges []EdgeInfo
+	edgesTable.ForEach(func(_, v lua.LValue) {
+		edgeTable := v.(*lua.LTable)
+		edge := EdgeInfo{
+			From: lua.LVAsString(edgeTable.RawGetString("from")),
+			To:   lua.LVAsString(edgeTable.RawGetString("to")),
+		}
+		edges = append(edges, edge)
+	})
+
+	// Parse status
+	name := lua.LVAsString(statusTable.RawGetString("name"))
+	status := lua.LVAsString(statusTable.RawGetString("status"))
+
+	// Get the context (workflow state)
+	contextVal := statusTable.RawGetString("context")
+	contextStr := ""
+	if contextVal != lua.LNil {
+		contextStr = luaValueToString(contextVal)
+	}
+
+	b.graph = GraphInfo{
+		Name:    name,
+		Status:  status,
+		Nodes:   nodes,
+		Edges:   edges,
+		Context: contextStr,
+	}
+
+	return nil
+}
+
+// parseNodes reads the node list returned by get_nodes. The nodes of a
+// subflow are listed under the node that runs them.
+func parseNodes(nodesTable *lua.LTable) []NodeInfo {
 	var nodes []NodeInfo
 	nodesTable.ForEach(func(_, v lua.LValue) {
 		nodeTable := v.(*lua.LTable)
@@ -177,6 +272,25 @@ func (b *Bridge) refreshGraph() error {
 			})
 		}
 
+		// Parse the items of a map node
+		node.Map = lua.LVAsBool(nodeTable.RawGetString("map"))
+		if items, ok := nodeTable.RawGetString("items").(*lua.LTable); ok {
+			items.ForEach(func(_, v lua.LValue) {
+				if itemTable, ok := v.(*lua.LTable); ok {
+					node.Items = append(node.Items, ItemInfo{
+						Status: lua.LVAsString(itemTable.RawGetString("status")),
+						Error:  lua.LVAsString(itemTable.RawGetString("error")),
+					})
+				}
+			})
+		}
+
+		// Parse the nodes of a subflow
+		node.Subflow = lua.LVAsString(nodeTable.RawGetString("subflow"))
+		if children, ok := nodeTable.RawGetString("nodes").(*lua.LTable); ok {
+			node.Children = parseNodes(children)
+		}
+
 		// Parse result
 		result := nodeTable.RawGetString("result")
 		if result != lua.LNil {
@@ -186,37 +300,7 @@ func (b *Bridge) refreshGraph() error {
 		nodes = append(nodes, node)
 	})
 
-	// Parse edges
-	var edges []EdgeInfo
-	edgesTable.ForEach(func(_, v lua.LValue) {
-		edgeTable := v.(*lua.LTable)
-		edge := EdgeInfo{
-			From: lua.LVAsString(edgeTable.RawGetString("from")),
-			To:   lua.LVAsString(edgeTable.RawGetString("to")),
-		}
-		edges = append(edges, edge)
-	})
-
-	// Parse status
-	name := lua.LVAsString(statusTable.RawGetString("name"))
-	status := lua.LVAsString(statusTable.RawGetString("status"))
-
-	// Get the context (workflow state)
-	contextVal := statusTable.RawGetString("context")
-	contextStr := ""
-	if contextVal != lua.LNil {
-		contextStr = luaValueToString(contextVal)
-	}
-
-	b.graph = GraphInfo{
-		Name:    name,
-		Status:  status,
-		Nodes:   nodes,
-		Edges:   edges,
-		Context: contextStr,
-	}
-
-	return nil
+	return nodes
 }
 
 // luaValueToString converts a Lua value to a JSON string
@@ -312,6 +396,9 @@ func (b *Bridge) ExecuteNode(name string) error {
 	if !b.loaded || b.workflowUD == nil {
 		return fmt.Errorf("no workflow loaded")
 	}
+	if b.graphOnly {
+		return fmt.Errorf("workflow was loaded for its graph only")
+	}
 
 	L := b.engine.L
 
@@ -349,6 +436,9 @@ func (b *Bridge) ExecuteAll() error {
 	if !b.loaded || b.workflowUD == nil {
 		return fmt.Errorf("no workflow loaded")
 	}
+	if b.graphOnly {
+		return fmt.Errorf("workflow was loaded for its graph only")
+	}
 
 	L := b.engine.L
 
@@ -378,6 +468,67 @@ func (b *Bridge) ExecuteAll() error {
 	return b.refreshGraph()
 }
 
+// RunNode is the outcome of a workflow node in a recorded run
+type RunNode struct {
+	Workflow string
+	Name     string
+	Status   string
+	Duration time.Duration
+}
+
+// Export renders the workflow graph as mermaid, dot or json with
+// workflow.export. With status the nodes show their state, taken from runs
+// when it is not nil and from the loaded workflow otherwise.
+func (b *Bridge) Export(format string, status bool, runs []RunNode) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.loaded || b.workflowUD == nil {
+		return "", fmt.Errorf("no workflow loaded")
+	}
+
+	L := b.engine.L
+
+	// Get export method
+	statusMethod := L.GetField(L.GetMetatable(b.workflowUD), "__index")
+	if statusMethod == lua.LNil {
+		return "", fmt.Errorf("workflow has no methods")
+	}
+
+	opts := L.NewTable()
+	opts.RawSetString("status", lua.LBool(status))
+	if runs != nil {
+		list := L.NewTable()
+		for _, run := range runs {
+			node := L.NewTable()
+			node.RawSetString("workflow", lua.LString(run.Workflow))
+			node.RawSetString("name", lua.LString(run.Name))
+			node.RawSetString("status", lua.LString(run.Status))
+			node.RawSetString("duration_ns", lua.LNumber(run.Duration))
+			list.Append(node)
+		}
+		opts.RawSetString("runs", list)
+	}
+
+	// Call export(wf, format, opts)
+	L.Push(L.GetField(statusMethod.(*lua.LTable), "export"))
+	L.Push(b.workflowUD)
+	L.Push(lua.LString(format))
+	L.Push(opts)
+	if err := L.PCall(3, 2, nil); err != nil {
+		return "", fmt.Errorf("failed to export workflow: %w", err)
+	}
+
+	text := L.Get(-2)
+	errVal := L.Get(-1)
+	L.Pop(2)
+
+	if errVal != lua.LNil {
+		return "", fmt.Errorf("%s", lua.LVAsString(errVal))
+	}
+	return lua.LVAsString(text), nil
+}
+
 // Reset resets all node statuses
 func (b *Bridge) Reset() error {
 	b.mu.Lock()
@@ -0,0 +1,51 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGraphOnlyLoadRunsNoNodes(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	script := filepath.Join(dir, "deploy.lua")
+	source := `local workflow = require("stdlib.workflow")
+local http = require("http")
+wf = workflow.new("deploy")
+workflow.node(wf, "build", function()
+	local f = io.open("` + marker + `", "w")
+	f:write("built")
+	f:close()
+	return { built = true }
+end)
+workflow.node(wf, "ship", function() os.execute("touch ` + marker + `") end, { depends_on = { "build" } })
+local result, err = workflow.run(wf)
+assert(err == nil, tostring(err))
+`
+	if err := os.WriteFile(script, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBridge()
+	defer b.Close()
+	b.SetGraphOnly()
+	if err := b.Load(script); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("expected no node to run")
+	}
+
+	text, err := b.Export("mermaid", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "build") || !strings.Contains(text, "ship") {
+		t.Errorf("graph is missing nodes:\n%s", text)
+	}
+	if err := b.ExecuteAll(); err == nil {
+		t.Error("expected a graph-only workflow not to execute")
+	}
+}
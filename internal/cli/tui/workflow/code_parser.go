@@ -35,9 +35,9 @@ func ParseNodeCode(filePath string) (map[string]NodeCodeInfo, error) {
 		return nil, err
 	}
 
-	// Pattern to find workflow.node calls
-	// Matches: workflow.node(wf, "name", function(ctx) or workflow.node(wf, 'name', function(ctx)
-	nodePattern := regexp.MustCompile(`workflow\.node\s*\(\s*\w+\s*,\s*["']([^"']+)["']\s*,\s*function`)
+	// Pattern to find workflow.node, workflow.branch and workflow.map_node calls
+	// Matches: workflow.node(wf, "name", function(ctx) or workflow.map_node(wf, 'name', function(ctx)
+	nodePattern := regexp.MustCompile(`workflow\.(?:node|branch|map_node)\s*\(\s*\w+\s*,\s*["']([^"']+)["']\s*,\s*function`)
 
 	// Track nesting for end detection
 	for i := 0; i < len(lines); i++ {
@@ -39,6 +39,9 @@ type Config struct {
 	// Cloud
 	AWS AWSConfig `toml:"aws"`
 
+	// HTTP control API of 'vulgar serve'
+	API APIConfig `toml:"api"`
+
 	// Settings
 	Defaults DefaultsConfig `toml:"defaults"`
 }
@@ -133,6 +136,20 @@ type AWSConfig struct {
 	Region          string `toml:"region"`
 }
 
+// APIConfig holds the tokens clients of the HTTP control API authenticate with
+type APIConfig struct {
+	Tokens []APIToken `toml:"tokens"`
+}
+
+// APIToken is a bearer token for the HTTP control API
+type APIToken struct {
+	Name  string `toml:"name"` // who uses it, for the daemon log
+	Token string `toml:"token"`
+	// Workflows limits the token to workflows whose names match one of
+	// these patterns (e.g., "deploy/*"). Empty allows every workflow.
+	Workflows []string `toml:"workflows"`
+}
+
 // DefaultsConfig holds default settings
 type DefaultsConfig struct {
 	OutputFormat  string `toml:"output_format"` // table, json, yaml
@@ -302,6 +319,11 @@ func (c *Config) expandEnvVars() {
 
 	// HuggingFace
 	c.HuggingFace.APIKey = expandEnv(c.HuggingFace.APIKey)
+
+	// HTTP control API
+	for i := range c.API.Tokens {
+		c.API.Tokens[i].Token = expandEnv(c.API.Tokens[i].Token)
+	}
 }
 
 // expandEnv expands ${VAR} patterns in a string
@@ -410,6 +432,17 @@ access_key_id = ""
 secret_access_key = ""
 region = "us-east-1"
 
+# ============================================================================
+# HTTP CONTROL API ('vulgar serve')
+# ============================================================================
+# Clients send a token as "Authorization: Bearer <token>". Generate one with
+# e.g. 'openssl rand -hex 32'. workflows limits a token to matching names.
+#
+# [[api.tokens]]
+# name = "chatops"
+# token = "${VULGAR_CHATOPS_TOKEN}"
+# workflows = ["deploy/*"]
+
 # ============================================================================
 # DEFAULTS
 # ============================================================================
@@ -569,6 +602,12 @@ func GetAWSCredentials() (accessKeyID, secretAccessKey, region string, ok bool)
 	return "", "", "", false
 }
 
+// GetAPITokens returns the tokens of the HTTP control API
+func GetAPITokens() []APIToken {
+	cfg := Get()
+	return cfg.API.Tokens
+}
+
 // RequireConfig prints an error message if config is not set up
 func RequireConfig(service string) error {
 	if !Exists() {
@@ -0,0 +1,333 @@
+package daemon
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/config"
+	"github.com/zepzeper/vulgar/internal/history"
+)
+
+// logPollInterval is how often streamed logs are checked for new lines
+const logPollInterval = 250 * time.Millisecond
+
+// defaultRunLimit is how many runs GET /api/runs lists unless limit is given
+const defaultRunLimit = 20
+
+// checkToken rejects tokens that would let anyone in
+func checkToken(token config.APIToken) error {
+	switch {
+	case token.Token == "":
+		return fmt.Errorf("API token %q is empty", token.Name)
+	case strings.Contains(token.Token, "${"):
+		return fmt.Errorf("API token %q references an unset environment variable", token.Name)
+	}
+	for _, pattern := range token.Workflows {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("API token %q: invalid workflow pattern %q", token.Name, pattern)
+		}
+	}
+	return nil
+}
+
+type tokenKey struct{}
+
+// apiHandler serves the control API. Every request must carry one of the
+// configured tokens as "Authorization: Bearer <token>":
+//
+//	GET  /api/workflows             workflows the token may run
+//	POST /api/runs                  start a run: {"workflow": ..., "params": {...}}
+//	GET  /api/runs                  recent runs (?status=, ?workflow=, ?limit=)
+//	GET  /api/runs/{id}             a run with its workflow nodes
+//	GET  /api/runs/{id}/logs        the run's output as server-sent events
+//	POST /api/runs/{id}/cancel      cancel an active run
+func (d *Daemon) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/workflows", d.apiWorkflows)
+	mux.HandleFunc("POST /api/runs", d.apiTrigger)
+	mux.HandleFunc("GET /api/runs", d.apiRuns)
+	mux.HandleFunc("GET /api/runs/{id}", d.apiGetRun)
+	mux.HandleFunc("GET /api/runs/{id}/logs", d.apiLogs)
+	mux.HandleFunc("POST /api/runs/{id}/cancel", d.apiCancel)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token := d.token(secret)
+		if !ok || token == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="vulgar"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+			return
+		}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, token)))
+	})
+}
+
+// token returns the configured token matching secret, or nil
+func (d *Daemon) token(secret string) *config.APIToken {
+	var match *config.APIToken
+	for i := range d.cfg.Tokens {
+		// Compare every token, so timing does not tell which one is close
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(d.cfg.Tokens[i].Token)) == 1 {
+			match = &d.cfg.Tokens[i]
+		}
+	}
+	return match
+}
+
+// allowed reports whether the request's token may see and run a workflow
+func allowed(r *http.Request, workflow string) bool {
+	token, _ := r.Context().Value(tokenKey{}).(*config.APIToken)
+	if token == nil {
+		return false
+	}
+	if len(token.Workflows) == 0 {
+		return true
+	}
+	for _, pattern := range token.Workflows {
+		if ok, _ := path.Match(pattern, workflow); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apiWorkflow is a workflow as the API lists it
+type apiWorkflow struct {
+	Name        string   `json:"name"`
+	Triggers    []string `json:"triggers"`
+	Concurrency int      `json:"concurrency"`
+	Timeout     string   `json:"timeout,omitempty"`
+	Active      int      `json:"active"`
+}
+
+func (d *Daemon) apiWorkflows(w http.ResponseWriter, r *http.Request) {
+	workflows := []apiWorkflow{}
+	for _, wf := range d.Workflows() {
+		if !allowed(r, wf.Name) {
+			continue
+		}
+		item := apiWorkflow{Name: wf.Name, Triggers: []string{}, Concurrency: wf.Concurrency}
+		for _, t := range wf.Triggers {
+			item.Triggers = append(item.Triggers, t.String())
+		}
+		if wf.Timeout > 0 {
+			item.Timeout = wf.Timeout.String()
+		}
+		d.mu.Lock()
+		item.Active = d.active[wf.Name]
+		d.mu.Unlock()
+		workflows = append(workflows, item)
+	}
+	writeJSON(w, http.StatusOK, workflows)
+}
+
+// apiTrigger starts a run. Scripts see the parameters as params{...} and
+// as trigger.params, and the name of the token as trigger.token.
+func (d *Daemon) apiTrigger(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Workflow string                 `json:"workflow"`
+		Params   map[string]interface{} `json:"params"`
+	}
+	if err := readJSON(w, r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Workflow == "" {
+		writeError(w, http.StatusBadRequest, errors.New("workflow is required"))
+		return
+	}
+	if !allowed(r, req.Workflow) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("token may not run workflow %s", req.Workflow))
+		return
+	}
+
+	token := r.Context().Value(tokenKey{}).(*config.APIToken)
+	run, err := d.Trigger(req.Workflow, Event{
+		Type:   TriggerAPI,
+		Data:   map[string]interface{}{"params": req.Params, "token": token.Name},
+		Params: namedParams(req.Params),
+	})
+	switch {
+	case errors.Is(err, ErrBusy):
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, ErrStopping):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		w.Header().Set("Location", "/api/runs/"+run.ID)
+		writeJSON(w, http.StatusAccepted, map[string]string{
+			"run_id": run.ID, "workflow": run.Workflow, "status": history.StatusQueued,
+		})
+	}
+}
+
+// apiRun is a run as the API reports it
+type apiRun struct {
+	*history.Run
+	Workflow string `json:"workflow"`
+}
+
+func (d *Daemon) apiRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultRunLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", s))
+			return
+		}
+		limit = n
+	}
+
+	// The history also holds runs from the command line and other
+	// directories, so runs are filtered here rather than by the query
+	all, err := d.cfg.History.List(history.Filter{Status: query.Get("status")})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	runs := []apiRun{}
+	for i := range all {
+		run, ok := d.visible(r, &all[i])
+		if !ok || (query.Get("workflow") != "" && run.Workflow != query.Get("workflow")) {
+			continue
+		}
+		runs = append(runs, run)
+		if len(runs) == limit {
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// visible wraps a recorded run, reporting false for runs of workflows
+// outside the workflows directory or the token's reach
+func (d *Daemon) visible(r *http.Request, run *history.Run) (apiRun, bool) {
+	name, err := workflowName(d.cfg.Dir, run.Script)
+	if err != nil || strings.HasPrefix(name, "../") || !allowed(r, name) {
+		return apiRun{}, false
+	}
+	return apiRun{Run: run, Workflow: name}, true
+}
+
+// lookup finds the run a request names by its ID or a unique prefix of it
+func (d *Daemon) lookup(w http.ResponseWriter, r *http.Request) (apiRun, bool) {
+	id := r.PathValue("id")
+	run, err := d.cfg.History.Get(id)
+	if errors.Is(err, history.ErrNotFound) {
+		// Runs are recorded once their goroutine starts
+		d.mu.Lock()
+		active, ok := d.runs[id]
+		if ok {
+			wf := d.workflow(active.Workflow)
+			run = &history.Run{ID: active.ID, Trigger: string(active.Trigger), Status: history.StatusQueued, Started: active.Started}
+			if wf != nil {
+				run.Script = wf.Path
+			}
+			err = nil
+		}
+		d.mu.Unlock()
+	}
+	if err != nil && !errors.Is(err, history.ErrNotFound) {
+		writeError(w, http.StatusInternalServerError, err)
+		return apiRun{}, false
+	}
+	if err == nil {
+		if result, ok := d.visible(r, run); ok {
+			return result, true
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", history.ErrNotFound, id))
+	return apiRun{}, false
+}
+
+func (d *Daemon) apiGetRun(w http.ResponseWriter, r *http.Request) {
+	if run, ok := d.lookup(w, r); ok {
+		writeJSON(w, http.StatusOK, run)
+	}
+}
+
+// apiLogs streams a run's output as server-sent "log" events, each a
+// history.Line with its sequence number as the event ID, so clients resume
+// with Last-Event-ID. A final "end" event carries the finished run.
+func (d *Daemon) apiLogs(w http.ResponseWriter, r *http.Request) {
+	run, ok := d.lookup(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	seq, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+	for {
+		// Read the status first: a finished run has written all its lines
+		current, err := d.cfg.History.Get(run.ID)
+		if err == nil {
+			run.Run = current
+		}
+		finished := err == nil && current.Finished != nil
+
+		lines, err := d.cfg.History.LogsAfter(run.ID, seq)
+		if err != nil && !errors.Is(err, history.ErrNotFound) {
+			writeEvent(w, "error", "", map[string]string{"error": err.Error()})
+			flusher.Flush()
+			return
+		}
+		for _, line := range lines {
+			writeEvent(w, "log", strconv.Itoa(line.Seq), line)
+			seq = line.Seq
+		}
+		if finished {
+			writeEvent(w, "end", "", run)
+		}
+		flusher.Flush()
+		if finished {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event, id string, v interface{}) {
+	data, _ := json.Marshal(v)
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+func (d *Daemon) apiCancel(w http.ResponseWriter, r *http.Request) {
+	run, ok := d.lookup(w, r)
+	if !ok {
+		return
+	}
+	if err := d.Cancel(run.ID); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"run_id": run.ID, "workflow": run.Workflow, "status": "cancelling"})
+}
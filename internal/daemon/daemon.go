@@ -0,0 +1,606 @@
+// Package daemon runs the workflows of a directory on the triggers they
+// declare: cron schedules, file changes, webhooks and manual requests.
+// Every run gets its own engine, and workflows are reloaded when their
+// files change.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/radovskyb/watcher"
+	"github.com/robfig/cron/v3"
+	"github.com/zepzeper/vulgar/internal/config"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/history"
+	"github.com/zepzeper/vulgar/internal/modules/core/log"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/params"
+)
+
+// TriggerGlobal is the global table a run's trigger is exposed as
+const TriggerGlobal = "trigger"
+
+// ShutdownReasonStop is the reason on_shutdown handlers of runs receive
+// when the daemon stops
+const ShutdownReasonStop = "stop"
+
+// watchInterval is how often workflow files and watch triggers are polled
+const watchInterval = 100 * time.Millisecond
+
+var (
+	// ErrBusy is returned when a workflow already has as many active runs
+	// as its concurrency allows
+	ErrBusy = errors.New("workflow is at its concurrency limit")
+	// ErrStopping is returned for runs triggered while the daemon stops
+	ErrStopping = errors.New("daemon is stopping")
+	// ErrNotActive is returned when cancelling a run that is not queued or
+	// executing
+	ErrNotActive = errors.New("run is not active")
+)
+
+// Config configures a Daemon
+type Config struct {
+	// Dir is the directory workflows are loaded from
+	Dir string
+	// NewEngine creates the engine of a run. It is called for every run,
+	// so runs share no Lua state, sandbox policy or event queue.
+	NewEngine func() (*engine.Engine, error)
+	// MaxConcurrent bounds the runs executing at once across all
+	// workflows; further runs wait. Zero uses the number of CPUs.
+	MaxConcurrent int
+	// Addr is the address webhooks, manual runs and the API are served on.
+	// Empty disables the HTTP server.
+	Addr string
+	// History records every run when set. The API needs it.
+	History *history.Store
+	// Tokens enable the HTTP control API at /api/ for clients presenting
+	// one of them
+	Tokens []config.APIToken
+	// APIOnly leaves cron, watch and webhook triggers uninstalled, so
+	// workflows only run when the API starts them
+	APIOnly bool
+}
+
+// Event is what started a run. Scripts see Data as the global trigger
+// table, with the trigger type as trigger.type.
+type Event struct {
+	Type TriggerType
+	Data map[string]interface{}
+	// Params are the named script parameters, as --param sets them
+	Params map[string]string
+}
+
+// Run is an active run of a workflow
+type Run struct {
+	ID       string
+	Workflow string
+	Trigger  TriggerType
+	Started  time.Time
+
+	engine    *engine.Engine
+	cancelled bool
+}
+
+// Daemon schedules and runs workflows
+type Daemon struct {
+	cfg  Config
+	cron *cron.Cron
+	// slots bounds the runs executing at once
+	slots chan struct{}
+
+	mu        sync.Mutex
+	workflows map[string]*loaded // by path
+	hooks     map[string]*loaded // by webhook path
+	active    map[string]int     // runs per workflow name, queued or executing
+	runs      map[string]*Run
+	stopping  bool
+	wg        sync.WaitGroup
+}
+
+// loaded is a workflow file and the triggers installed for it. Workflow
+// is nil when the file failed to load.
+type loaded struct {
+	*Workflow
+	modTime  time.Time
+	cronIDs  []cron.EntryID
+	watchers []*watcher.Watcher
+}
+
+// New creates a daemon for the workflows in cfg.Dir
+func New(cfg Config) (*Daemon, error) {
+	if cfg.NewEngine == nil {
+		return nil, fmt.Errorf("no engine factory configured")
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+	// Runs are recorded by absolute path
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Dir = dir
+	if len(cfg.Tokens) > 0 && cfg.History == nil {
+		return nil, fmt.Errorf("the HTTP API needs the run history")
+	}
+	if cfg.APIOnly && len(cfg.Tokens) == 0 {
+		return nil, fmt.Errorf("no API tokens configured")
+	}
+	for _, token := range cfg.Tokens {
+		if err := checkToken(token); err != nil {
+			return nil, err
+		}
+	}
+	return &Daemon{
+		cfg:       cfg,
+		cron:      cron.New(cron.WithParser(cronParser)),
+		slots:     make(chan struct{}, cfg.MaxConcurrent),
+		workflows: make(map[string]*loaded),
+		hooks:     make(map[string]*loaded),
+		active:    make(map[string]int),
+		runs:      make(map[string]*Run),
+	}, nil
+}
+
+// Run loads the workflows and serves their triggers until ctx ends. Active
+// runs are then shut down, and Run returns once they have finished.
+func (d *Daemon) Run(ctx context.Context) error {
+	if err := d.Reload(); err != nil {
+		return err
+	}
+
+	// Reload workflows when their files change
+	w := watcher.New()
+	w.SetMaxEvents(1)
+	if err := w.AddRecursive(d.cfg.Dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", d.cfg.Dir, err)
+	}
+	go func() {
+		for {
+			select {
+			case event := <-w.Event:
+				if event.IsDir() || isWorkflowFile(event.Path) || isWorkflowFile(event.OldPath) {
+					if err := d.Reload(); err != nil {
+						log.Error("failed to reload workflows: %v", err)
+					}
+				}
+			case err := <-w.Error:
+				log.Warn("watching %s: %v", d.cfg.Dir, err)
+			case <-w.Closed:
+				return
+			}
+		}
+	}()
+	go w.Start(watchInterval)
+	w.Wait()
+	defer w.Close()
+
+	d.cron.Start()
+
+	var serverErr chan error
+	var server *http.Server
+	if d.cfg.Addr != "" {
+		server = &http.Server{Addr: d.cfg.Addr, Handler: d.Handler()}
+		serverErr = make(chan error, 1)
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+		switch {
+		case d.cfg.APIOnly:
+			log.Info("serving the API on %s", d.cfg.Addr)
+		case len(d.cfg.Tokens) > 0:
+			log.Info("serving webhooks, manual runs and the API on %s", d.cfg.Addr)
+		default:
+			log.Info("serving webhooks and manual runs on %s", d.cfg.Addr)
+		}
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serverErr:
+		err = fmt.Errorf("http server: %w", err)
+	}
+
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(shutdownCtx)
+		cancel()
+	}
+	d.stop()
+	return err
+}
+
+// stop removes every trigger, shuts active runs down and waits for them
+func (d *Daemon) stop() {
+	<-d.cron.Stop().Done()
+
+	d.mu.Lock()
+	d.stopping = true
+	for _, l := range d.workflows {
+		d.uninstall(l)
+	}
+	for _, run := range d.runs {
+		if run.engine != nil {
+			run.engine.Shutdown(ShutdownReasonStop)
+		}
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// Reload scans the workflows directory, loading new and changed workflows
+// and removing deleted ones. A workflow that fails to load has its triggers
+// removed until its file is fixed.
+func (d *Daemon) Reload() error {
+	files, err := discover(d.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopping {
+		return nil
+	}
+
+	for path, l := range d.workflows {
+		if _, ok := files[path]; !ok {
+			d.uninstall(l)
+			delete(d.workflows, path)
+			if l.Workflow != nil {
+				log.Info("removed workflow %s", l.Name)
+			}
+		}
+	}
+
+	for _, path := range sortedPaths(files) {
+		modTime := files[path]
+		old, ok := d.workflows[path]
+		if ok && old.modTime.Equal(modTime) {
+			continue
+		}
+		if ok {
+			d.uninstall(old)
+		}
+
+		l := &loaded{modTime: modTime}
+		d.workflows[path] = l
+		w, err := Load(d.cfg.Dir, path)
+		if err == nil {
+			err = d.checkConflicts(path, w)
+		}
+		if err != nil {
+			log.Error("workflow %s not loaded: %v", path, err)
+			continue
+		}
+		l.Workflow = w
+		if err := d.install(l); err != nil {
+			d.uninstall(l)
+			l.Workflow = nil
+			log.Error("workflow %s not loaded: %v", w.Name, err)
+			continue
+		}
+
+		verb := "loaded"
+		if ok {
+			verb = "reloaded"
+		}
+		log.Info("%s workflow %s (%s)", verb, w.Name, describeTriggers(w))
+	}
+	return nil
+}
+
+// checkConflicts reports a workflow whose name or webhook another loaded
+// workflow already uses
+func (d *Daemon) checkConflicts(path string, w *Workflow) error {
+	for other, l := range d.workflows {
+		if other != path && l.Workflow != nil && l.Name == w.Name {
+			return fmt.Errorf("name %s is already used by %s", w.Name, other)
+		}
+	}
+	for _, t := range w.Triggers {
+		if l, ok := d.hooks[t.Spec]; t.Type == TriggerWebhook && ok {
+			return fmt.Errorf("webhook %s is already served by workflow %s", t.Spec, l.Name)
+		}
+	}
+	return nil
+}
+
+func describeTriggers(w *Workflow) string {
+	if len(w.Triggers) == 0 {
+		return "no triggers"
+	}
+	s := ""
+	for i, t := range w.Triggers {
+		if i > 0 {
+			s += ", "
+		}
+		s += t.String()
+	}
+	return s
+}
+
+// install schedules the triggers of a workflow. Callers hold d.mu.
+func (d *Daemon) install(l *loaded) error {
+	if d.cfg.APIOnly {
+		return nil
+	}
+	for _, t := range l.Triggers {
+		switch t.Type {
+		case TriggerCron:
+			spec := t.Spec
+			id, err := d.cron.AddFunc(spec, func() {
+				d.fire(l, Event{Type: TriggerCron, Data: map[string]interface{}{"schedule": spec}})
+			})
+			if err != nil {
+				return err
+			}
+			l.cronIDs = append(l.cronIDs, id)
+
+		case TriggerWatch:
+			spec := t.Spec
+			w := watcher.New()
+			if err := w.Add(spec); err != nil {
+				w.Close()
+				return fmt.Errorf("failed to watch %s: %w", spec, err)
+			}
+			l.watchers = append(l.watchers, w)
+			go func() {
+				for {
+					select {
+					case event := <-w.Event:
+						// A run per changed file, rather than one for the
+						// watched directory changing along with it
+						if event.IsDir() && event.Path == spec {
+							continue
+						}
+						d.fire(l, Event{Type: TriggerWatch, Data: map[string]interface{}{
+							"path":     event.Path,
+							"old_path": event.OldPath,
+							"op":       event.Op.String(),
+						}})
+					case <-w.Closed:
+						return
+					}
+				}
+			}()
+			go w.Start(watchInterval)
+			w.Wait()
+
+		case TriggerWebhook:
+			d.hooks[t.Spec] = l
+		}
+	}
+	return nil
+}
+
+// uninstall removes the triggers of a workflow. Active runs continue.
+// Callers hold d.mu.
+func (d *Daemon) uninstall(l *loaded) {
+	for _, id := range l.cronIDs {
+		d.cron.Remove(id)
+	}
+	for _, w := range l.watchers {
+		// Close waits for the watcher's pending event, whose handler
+		// may be waiting for d.mu
+		go w.Close()
+	}
+	for path, hook := range d.hooks {
+		if hook == l {
+			delete(d.hooks, path)
+		}
+	}
+	l.cronIDs, l.watchers = nil, nil
+}
+
+// fire starts a run for a cron or watch trigger of l, which has nobody to
+// report a skipped run to but the log. Triggers that fire while their
+// workflow is reloaded are dropped.
+func (d *Daemon) fire(l *loaded, ev Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopping || d.workflows[l.Path] != l {
+		return
+	}
+	if _, err := d.start(l.Workflow, ev); err != nil {
+		log.Warn("skipped %s run of %s: %v", ev.Type, l.Name, err)
+	}
+}
+
+// Workflows returns the loaded workflows, sorted by name
+func (d *Daemon) Workflows() []*Workflow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var workflows []*Workflow
+	for _, l := range d.workflows {
+		if l.Workflow != nil {
+			workflows = append(workflows, l.Workflow)
+		}
+	}
+	sort.Slice(workflows, func(i, j int) bool { return workflows[i].Name < workflows[j].Name })
+	return workflows
+}
+
+// workflow returns the loaded workflow named name. Callers hold d.mu.
+func (d *Daemon) workflow(name string) *Workflow {
+	for _, l := range d.workflows {
+		if l.Workflow != nil && l.Name == name {
+			return l.Workflow
+		}
+	}
+	return nil
+}
+
+// Trigger starts a run of the named workflow in the background. Runs wait
+// for a free slot when MaxConcurrent runs are executing; ErrBusy is
+// returned when the workflow's own concurrency limit is reached.
+func (d *Daemon) Trigger(name string, ev Event) (*Run, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopping {
+		return nil, ErrStopping
+	}
+	w := d.workflow(name)
+	if w == nil {
+		return nil, fmt.Errorf("unknown workflow %q", name)
+	}
+	return d.start(w, ev)
+}
+
+// start starts a run of w. Callers hold d.mu.
+func (d *Daemon) start(w *Workflow, ev Event) (*Run, error) {
+	if d.active[w.Name] >= w.Concurrency {
+		return nil, ErrBusy
+	}
+
+	run := &Run{ID: uuid.NewString(), Workflow: w.Name, Trigger: ev.Type, Started: time.Now()}
+	d.active[w.Name]++
+	d.runs[run.ID] = run
+	d.wg.Add(1)
+	go d.execute(w, run, ev)
+	return run, nil
+}
+
+// execute runs a workflow in a new engine
+func (d *Daemon) execute(w *Workflow, run *Run, ev Event) {
+	defer d.wg.Done()
+	defer func() {
+		d.mu.Lock()
+		d.active[w.Name]--
+		delete(d.runs, run.ID)
+		d.mu.Unlock()
+	}()
+
+	var rec *history.Recorder
+	if d.cfg.History != nil {
+		var err error
+		// Values of secret parameters are not kept
+		in := params.RedactScript(w.Path, params.Input{Named: ev.Params})
+		rec, err = d.cfg.History.Begin(history.Run{
+			ID: run.ID, Script: w.Path, Params: in.Named, Trigger: string(ev.Type),
+			Status: history.StatusQueued, Started: run.Started,
+		})
+		if err != nil {
+			log.Warn("run %s of %s not recorded: %v", run.ID, w.Name, err)
+		}
+	}
+	finish := func(err error) {
+		if rec != nil {
+			rec.Finish(err)
+		}
+	}
+
+	d.slots <- struct{}{}
+	defer func() { <-d.slots }()
+	if d.isStopping() {
+		finish(&engine.ShutdownError{Reason: ShutdownReasonStop})
+		return
+	}
+
+	eng, err := d.cfg.NewEngine()
+	if err != nil {
+		log.Error("run %s of %s failed: %v", run.ID, w.Name, err)
+		finish(err)
+		return
+	}
+	defer eng.Close()
+
+	ctx := context.Background()
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	eng.SetContext(ctx)
+	eng.SetInput(params.Input{Named: ev.Params})
+	eng.SetRunID(run.ID)
+
+	trigger := map[string]interface{}{}
+	for k, v := range ev.Data {
+		trigger[k] = v
+	}
+	trigger["type"] = string(ev.Type)
+	trigger["workflow"] = w.Name
+	trigger["run_id"] = run.ID
+	eng.L.SetGlobal(TriggerGlobal, util.GoToLua(eng.L, trigger))
+
+	if rec != nil {
+		eng.SetOutput(io.MultiWriter(os.Stdout, rec))
+		eng.SetNodeObserver(rec.Node)
+		rec.Start()
+	}
+
+	// The daemon may have stopped, or the run been cancelled, while the
+	// engine was created
+	d.mu.Lock()
+	run.engine = eng
+	switch {
+	case d.stopping:
+		eng.Shutdown(ShutdownReasonStop)
+	case run.cancelled:
+		eng.Cancel()
+	}
+	d.mu.Unlock()
+
+	log.Info("run %s of %s started (%s)", run.ID, w.Name, ev.Type)
+	err = eng.RunWorkflow(w.Path)
+	finish(err)
+	elapsed := time.Since(run.Started).Round(time.Millisecond)
+	if err != nil {
+		log.Error("run %s of %s failed after %s: %v", run.ID, w.Name, elapsed, err)
+		return
+	}
+	log.Info("run %s of %s finished in %s", run.ID, w.Name, elapsed)
+}
+
+// Cancel cancels an active run the way engine.Cancel does. A run still
+// waiting for a slot is cancelled as soon as it starts.
+func (d *Daemon) Cancel(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	run, ok := d.runs[id]
+	if !ok {
+		return ErrNotActive
+	}
+	if !run.cancelled {
+		log.Info("cancelling run %s of %s", run.ID, run.Workflow)
+	}
+	run.cancelled = true
+	if run.engine != nil {
+		run.engine.Cancel()
+	}
+	return nil
+}
+
+func (d *Daemon) isStopping() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stopping
+}
+
+// Runs returns the active runs
+func (d *Daemon) Runs() []Run {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	runs := make([]Run, 0, len(d.runs))
+	for _, run := range d.runs {
+		runs = append(runs, Run{ID: run.ID, Workflow: run.Workflow, Trigger: run.Trigger, Started: run.Started})
+	}
+	return runs
+}
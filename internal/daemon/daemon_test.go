@@ -0,0 +1,406 @@
+package daemon
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/config"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/history"
+)
+
+func TestParse(t *testing.T) {
+	header := `#!/usr/bin/env vulgar
+-- Sync the inbox
+-- vulgar:allow fs=./inbox
+-- vulgar:trigger cron @every 1h
+-- vulgar:trigger watch ./inbox
+-- vulgar:trigger webhook /github/push/ secret=$GH_SECRET
+-- vulgar:trigger manual
+-- vulgar:concurrency 3
+-- vulgar:timeout 5m
+`
+	w, err := Parse("/srv/workflows/sync.lua", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Trigger{
+		{TriggerCron, "@every 1h", ""},
+		{TriggerWatch, "/srv/workflows/inbox", ""},
+		{TriggerWebhook, "github/push", "GH_SECRET"},
+		{TriggerManual, "", ""},
+	}
+	if len(w.Triggers) != len(want) {
+		t.Fatalf("triggers = %v, want %v", w.Triggers, want)
+	}
+	for i := range want {
+		if w.Triggers[i] != want[i] {
+			t.Errorf("trigger %d = %v, want %v", i, w.Triggers[i], want[i])
+		}
+	}
+	if w.Concurrency != 3 || w.Timeout != 5*time.Minute {
+		t.Errorf("concurrency = %d, timeout = %s", w.Concurrency, w.Timeout)
+	}
+
+	for _, bad := range []string{
+		"-- vulgar:trigger cron not a schedule",
+		"-- vulgar:trigger webhook",
+		"-- vulgar:trigger manual now",
+		"-- vulgar:trigger webhook deploy secret=hunter2",
+		"-- vulgar:trigger cron @daily secret=$CRON_SECRET",
+		"-- vulgar:trigger email",
+		"-- vulgar:concurrency 0",
+		"-- vulgar:timeout soon",
+	} {
+		if _, err := Parse("bad.lua", bad); err == nil || !strings.HasPrefix(err.Error(), "bad.lua:1: ") {
+			t.Errorf("Parse(%q) error = %v", bad, err)
+		}
+	}
+}
+
+func newDaemon(t *testing.T, dir string) *Daemon {
+	t.Helper()
+	d, err := New(Config{
+		Dir: dir,
+		NewEngine: func() (*engine.Engine, error) {
+			return engine.NewEngine(engine.Config{LogLevel: "ERROR"}), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func writeWorkflow(t *testing.T, path, source string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForFile(t *testing.T, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+			return string(data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not written", path)
+	return ""
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reports", "weekly.lua")
+	writeWorkflow(t, path, "-- vulgar:trigger manual\nprint('x')\n")
+	writeWorkflow(t, filepath.Join(dir, ".hidden", "skipped.lua"), "-- vulgar:trigger manual\n")
+	writeWorkflow(t, filepath.Join(dir, "broken.lua"), "-- vulgar:trigger manual\nthis is not lua\n")
+
+	d := newDaemon(t, dir)
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	workflows := d.Workflows()
+	if len(workflows) != 1 || workflows[0].Name != "reports/weekly" || !workflows[0].Has(TriggerManual) {
+		t.Fatalf("workflows = %+v", workflows)
+	}
+
+	// Changed triggers take effect on reload
+	writeWorkflow(t, path, "-- vulgar:trigger webhook weekly\nprint('x')\n")
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if w := d.Workflows()[0]; w.Has(TriggerManual) || !w.Has(TriggerWebhook) {
+		t.Errorf("triggers after reload = %v", w.Triggers)
+	}
+	if _, ok := d.hooks["weekly"]; !ok {
+		t.Error("webhook not installed after reload")
+	}
+
+	os.Remove(path)
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Workflows()) != 0 || len(d.hooks) != 0 {
+		t.Errorf("removed workflow still loaded: %+v", d.Workflows())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	release := filepath.Join(dir, "release")
+	writeWorkflow(t, filepath.Join(dir, "deploy.lua"), fmt.Sprintf(`-- vulgar:trigger webhook deploy
+local f = io.open(%q, "w")
+f:write(trigger.type .. " " .. trigger.method .. " " .. trigger.body .. " " .. trigger.query.env)
+f:close()
+while not io.open(%q) do end
+`, out, release))
+	writeWorkflow(t, filepath.Join(dir, "hidden.lua"), "-- vulgar:trigger cron @daily\n")
+
+	d := newDaemon(t, dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	t.Cleanup(func() {
+		writeWorkflow(t, release, "x")
+		cancel()
+		<-done
+	})
+	for len(d.Workflows()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	post := func(path, body string) int {
+		resp, err := http.Post(server.URL+path, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := post("/hooks/deploy?env=prod", "v1.2"); code != http.StatusAccepted {
+		t.Fatalf("webhook status = %d", code)
+	}
+	if got := waitForFile(t, out); got != "webhook POST v1.2 prod" {
+		t.Errorf("run saw %q", got)
+	}
+	if code := post("/hooks/deploy", ""); code != http.StatusTooManyRequests {
+		t.Errorf("second webhook status = %d, want 429 while the first run is active", code)
+	}
+	if code := post("/hooks/missing", ""); code != http.StatusNotFound {
+		t.Errorf("unknown webhook status = %d", code)
+	}
+	if code := post("/run/hidden", ""); code != http.StatusForbidden {
+		t.Errorf("manual run of a cron workflow status = %d", code)
+	}
+}
+
+func TestTriggerSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkflow(t, filepath.Join(dir, "deploy.lua"), "-- vulgar:trigger webhook deploy secret=$DEPLOY_SECRET\n-- vulgar:concurrency 2\n")
+	writeWorkflow(t, filepath.Join(dir, "manual.lua"), "-- vulgar:trigger manual secret=$UNSET_SECRET\n")
+
+	d := newDaemon(t, dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for len(d.Workflows()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Setenv("DEPLOY_SECRET", "s3cret")
+
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	post := func(path, body string, header map[string]string) int {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sign := func(secret, body string) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	for name, header := range map[string]map[string]string{
+		"unsigned":     nil,
+		"wrong secret": {"X-Hub-Signature-256": sign("guess", "{}")},
+		"wrong body":   {"X-Hub-Signature-256": sign("s3cret", "other")},
+		"wrong token":  {"Authorization": "Bearer guess"},
+		"bare secret":  {"X-Hub-Signature-256": "s3cret"},
+	} {
+		if code := post("/hooks/deploy", "{}", header); code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, code)
+		}
+	}
+	if code := post("/hooks/deploy", "{}", map[string]string{"X-Hub-Signature-256": sign("s3cret", "{}")}); code != http.StatusAccepted {
+		t.Errorf("signed webhook status = %d", code)
+	}
+	if code := post("/hooks/deploy", "{}", map[string]string{"Authorization": "Bearer s3cret"}); code != http.StatusAccepted {
+		t.Errorf("bearer webhook status = %d", code)
+	}
+	if code := post("/run/manual", "", map[string]string{"Authorization": "Bearer anything"}); code != http.StatusInternalServerError {
+		t.Errorf("manual run with an unset secret status = %d, want 500", code)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "release")
+	after := filepath.Join(dir, "after")
+	writeWorkflow(t, filepath.Join(dir, "deploy", "app.lua"), `
+local p = params { { "env", type = "string", required = true } }
+print("deploying to " .. p.env .. " by " .. trigger.token)
+`)
+	writeWorkflow(t, filepath.Join(dir, "report.lua"), fmt.Sprintf(`-- vulgar:trigger cron @daily
+local workflow = require("stdlib.workflow")
+local wf = workflow.new("report", {max_parallel = 1})
+workflow.node(wf, "slow", function() while not io.open(%q) do end end)
+workflow.node(wf, "after", function() io.open(%q, "w"):close() end, {depends_on = {"slow"}})
+workflow.run(wf)
+`, release, after))
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	d, err := New(Config{
+		Dir:     dir,
+		History: store,
+		Tokens: []config.APIToken{
+			{Name: "ops", Token: "ops-secret"},
+			{Name: "bot", Token: "bot-secret", Workflows: []string{"deploy/*"}},
+		},
+		APIOnly: true,
+		NewEngine: func() (*engine.Engine, error) {
+			return engine.NewEngine(engine.Config{LogLevel: "ERROR"}), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	t.Cleanup(func() {
+		writeWorkflow(t, release, "x")
+		cancel()
+		<-done
+	})
+	for len(d.Workflows()) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	call := func(token, method, path, body string, v interface{}) int {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	if code := call("", "GET", "/api/workflows", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d", code)
+	}
+	if code := call("ops-secret", "POST", "/hooks/anything", "", nil); code != http.StatusNotFound {
+		t.Errorf("webhook served in API-only mode: status %d", code)
+	}
+	var workflows []apiWorkflow
+	call("bot-secret", "GET", "/api/workflows", "", &workflows)
+	if len(workflows) != 1 || workflows[0].Name != "deploy/app" {
+		t.Errorf("workflows visible to bot = %+v", workflows)
+	}
+	if code := call("bot-secret", "POST", "/api/runs", `{"workflow": "report"}`, nil); code != http.StatusForbidden {
+		t.Errorf("out of scope run status = %d", code)
+	}
+
+	// Parameters reach the script, and its output is streamed
+	var started map[string]string
+	if code := call("ops-secret", "POST", "/api/runs", `{"workflow": "deploy/app", "params": {"env": "prod"}}`, &started); code != http.StatusAccepted {
+		t.Fatalf("run status = %d", code)
+	}
+	req, _ := http.NewRequest("GET", server.URL+"/api/runs/"+started["run_id"]+"/logs", nil)
+	req.Header.Set("Authorization", "Bearer ops-secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(stream), "id: 1\nevent: log\ndata: ") ||
+		!strings.Contains(string(stream), "deploying to prod by ops") ||
+		!strings.Contains(string(stream), "event: end\ndata: ") ||
+		!strings.Contains(string(stream), `"status":"succeeded"`) {
+		t.Errorf("log stream = %s", stream)
+	}
+
+	// Cancelling lets the running node finish but starts no further ones
+	call("ops-secret", "POST", "/api/runs", `{"workflow": "report"}`, &started)
+	id := started["run_id"]
+	status := func() string {
+		var run apiRun
+		call("ops-secret", "GET", "/api/runs/"+id[:8], "", &run)
+		if run.Run == nil {
+			return ""
+		}
+		return run.Status
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for status() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("run status = %q, want %q", status(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(history.StatusRunning)
+	if code := call("ops-secret", "POST", "/api/runs/"+id+"/cancel", "", nil); code != http.StatusAccepted {
+		t.Fatalf("cancel status = %d", code)
+	}
+	writeWorkflow(t, release, "x")
+	waitFor(history.StatusStopped)
+	if _, err := os.Stat(after); err == nil {
+		t.Error("node after the cancel ran")
+	}
+	if code := call("ops-secret", "POST", "/api/runs/"+id+"/cancel", "", nil); code != http.StatusConflict {
+		t.Errorf("second cancel status = %d", code)
+	}
+
+	var runs []apiRun
+	call("ops-secret", "GET", "/api/runs?workflow=report", "", &runs)
+	if len(runs) != 1 || runs[0].ID != id || runs[0].Trigger != string(TriggerAPI) {
+		t.Errorf("runs of report = %+v", runs)
+	}
+	call("bot-secret", "GET", "/api/runs", "", &runs)
+	if len(runs) != 1 || runs[0].Workflow != "deploy/app" {
+		t.Errorf("runs visible to bot = %+v", runs)
+	}
+}
@@ -0,0 +1,220 @@
+package daemon
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// maxBodySize bounds the webhook and manual run bodies read into memory
+const maxBodySize = 10 << 20
+
+// Handler serves webhooks at /hooks/<path> and manual runs at
+// POST /run/<workflow>. Both answer 202 with the run's ID once the run is
+// queued, 429 when the workflow is at its concurrency limit, and 401 when
+// the trigger declares a secret the request does not present. The control
+// API is served at /api/ when tokens are configured.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	if !d.cfg.APIOnly {
+		mux.HandleFunc("/hooks/{path...}", d.serveWebhook)
+		mux.HandleFunc("POST /run/{workflow...}", d.serveManual)
+	}
+	if len(d.cfg.Tokens) > 0 {
+		mux.Handle("/api/", d.apiHandler())
+	}
+	return mux
+}
+
+// serveWebhook starts a run of the workflow that declared the webhook.
+// The script sees the request as trigger.method, trigger.path,
+// trigger.headers, trigger.query and trigger.body; query parameters are
+// also passed as script parameters.
+func (d *Daemon) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.PathValue("path"), "/")
+	d.mu.Lock()
+	l, ok := d.hooks[path]
+	var name string
+	var trigger Trigger
+	if ok {
+		name = l.Name
+		trigger, _ = l.trigger(TriggerWebhook, path)
+	}
+	d.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no workflow serves webhook %s", path))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if status, err := authorize(r, body, trigger); err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	headers := make(map[string]interface{})
+	for name := range r.Header {
+		headers[strings.ToLower(name)] = r.Header.Get(name)
+	}
+	query := make(map[string]interface{})
+	named := make(map[string]string)
+	for name := range r.URL.Query() {
+		query[name] = r.URL.Query().Get(name)
+		named[name] = r.URL.Query().Get(name)
+	}
+
+	d.serveTrigger(w, name, Event{
+		Type: TriggerWebhook,
+		Data: map[string]interface{}{
+			"method":  r.Method,
+			"path":    path,
+			"headers": headers,
+			"query":   query,
+			"body":    string(body),
+		},
+		Params: named,
+	})
+}
+
+// serveManual starts a run of a workflow that declares a manual trigger.
+// The body may be a JSON object of script parameters:
+//
+//	curl -X POST localhost:8420/run/reports/weekly -d '{"env": "prod"}'
+func (d *Daemon) serveManual(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(r.PathValue("workflow"), "/")
+	d.mu.Lock()
+	wf := d.workflow(name)
+	d.mu.Unlock()
+	if wf == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown workflow %q", name))
+		return
+	}
+	trigger, ok := wf.trigger(TriggerManual, "")
+	if !ok {
+		writeError(w, http.StatusForbidden, fmt.Errorf("workflow %s does not declare a manual trigger", name))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if status, err := authorize(r, body, trigger); err != nil {
+		writeError(w, status, err)
+		return
+	}
+	var values map[string]interface{}
+	if err := decodeJSON(body, &values); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	d.serveTrigger(w, name, Event{Type: TriggerManual, Data: map[string]interface{}{"params": values}, Params: namedParams(values)})
+}
+
+// readJSON decodes a request body into v, leaving it unchanged when the
+// body is empty
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	return decodeJSON(body, v)
+}
+
+func decodeJSON(body []byte, v interface{}) error {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+// authorize checks a request against the secret its trigger declares.
+// Senders either sign the body with it as GitHub does, in
+// "X-Hub-Signature-256: sha256=<hex HMAC>", or present it as
+// "Authorization: Bearer <secret>". It returns the status to answer with
+// when the request is refused.
+func authorize(r *http.Request, body []byte, t Trigger) (int, error) {
+	if t.Secret == "" {
+		return http.StatusOK, nil
+	}
+	secret := os.Getenv(t.Secret)
+	if secret == "" {
+		// Refuse everything rather than run unauthenticated
+		return http.StatusInternalServerError, fmt.Errorf("trigger secret $%s is not set", t.Secret)
+	}
+
+	if signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256="); ok {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			return http.StatusOK, nil
+		}
+	} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+		return http.StatusOK, nil
+	}
+	return http.StatusUnauthorized, errors.New("missing or invalid trigger signature")
+}
+
+// namedParams turns JSON values into script parameters
+func namedParams(values map[string]interface{}) map[string]string {
+	named := make(map[string]string, len(values))
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			named[k] = s
+			continue
+		}
+		// Numbers and booleans are parsed back by params{...}
+		encoded, _ := json.Marshal(v)
+		named[k] = string(encoded)
+	}
+	return named
+}
+
+func (d *Daemon) serveTrigger(w http.ResponseWriter, name string, ev Event) {
+	run, err := d.Trigger(name, ev)
+	switch {
+	case errors.Is(err, ErrBusy):
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.Is(err, ErrStopping):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeError(w, http.StatusNotFound, err)
+	default:
+		writeJSON(w, http.StatusAccepted, map[string]string{"run_id": run.ID, "workflow": run.Workflow})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeBodyError reports a request body that is too large or not a JSON
+// object
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writeError(w, http.StatusBadRequest, fmt.Errorf("body must be a JSON object: %w", err))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,278 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/zepzeper/vulgar/internal/bytecode"
+)
+
+// TriggerType is what starts runs of a workflow
+type TriggerType string
+
+const (
+	TriggerCron    TriggerType = "cron"    // on a cron schedule
+	TriggerWatch   TriggerType = "watch"   // when a file or directory changes
+	TriggerWebhook TriggerType = "webhook" // on a request to /hooks/<path>
+	TriggerManual  TriggerType = "manual"  // on a request to /run/<workflow>
+	// TriggerAPI starts runs through the HTTP control API. Workflows do not
+	// declare it; any workflow a token allows can be run.
+	TriggerAPI TriggerType = "api"
+)
+
+// Trigger is a trigger declared by a workflow
+type Trigger struct {
+	Type TriggerType
+	// Spec is the cron expression, the watched path or the webhook path
+	Spec string
+	// Secret names the environment variable holding the secret requests to
+	// a webhook or manual trigger must present. Empty accepts any request.
+	Secret string
+}
+
+func (t Trigger) String() string {
+	s := string(t.Type)
+	if t.Spec != "" {
+		s += " " + t.Spec
+	}
+	if t.Secret != "" {
+		s += " secret=$" + t.Secret
+	}
+	return s
+}
+
+// Workflow is a script the daemon runs, with the triggers, concurrency and
+// timeout declared in its header
+type Workflow struct {
+	// Name is the path relative to the workflows directory, without the
+	// extension, as nightly/sync for workflows/nightly/sync.lua
+	Name     string
+	Path     string
+	Triggers []Trigger
+	// Concurrency is how many runs may be active at once. Runs triggered
+	// beyond it are skipped.
+	Concurrency int
+	// Timeout stops runs that take longer. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// Has reports whether the workflow declares a trigger of type t
+func (w *Workflow) Has(t TriggerType) bool {
+	for _, trigger := range w.Triggers {
+		if trigger.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// trigger returns the declared trigger of type t with the given spec
+func (w *Workflow) trigger(t TriggerType, spec string) (Trigger, bool) {
+	for _, trigger := range w.Triggers {
+		if trigger.Type == t && trigger.Spec == spec {
+			return trigger, true
+		}
+	}
+	return Trigger{}, false
+}
+
+// directivePrefix marks daemon declarations in a script header, next to
+// the sandbox permissions the engine reads:
+//
+//	-- vulgar:trigger cron 0 */15 * * * *
+//	-- vulgar:trigger watch ./inbox
+//	-- vulgar:trigger webhook github/push secret=$GITHUB_WEBHOOK_SECRET
+//	-- vulgar:trigger manual
+//	-- vulgar:concurrency 2
+//	-- vulgar:timeout 10m
+const directivePrefix = "vulgar:"
+
+// cronParser accepts the expressions stdlib.cron does, with optional seconds
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Load reads the workflow at path, which must be inside dir. The script is
+// compiled so syntax errors are reported before it is scheduled.
+func Load(dir, path string) (*Workflow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := bytecode.Load(data, path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := workflowName(dir, path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := Parse(path, chunk.Header)
+	if err != nil {
+		return nil, err
+	}
+	w.Name = name
+	return w, nil
+}
+
+// workflowName names the workflow at path inside dir
+func workflowName(dir, path string) (string, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))), nil
+}
+
+// Parse reads the trigger, concurrency and timeout declarations from the
+// header of the script at path
+func Parse(path, header string) (*Workflow, error) {
+	w := &Workflow{Path: path, Concurrency: 1}
+	// Relative watch paths are resolved against the script's directory
+	dir := filepath.Dir(path)
+
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		directive, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimPrefix(line, "--")), directivePrefix)
+		if !ok {
+			continue
+		}
+		verb, value, _ := strings.Cut(directive, " ")
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch verb {
+		case "trigger":
+			var trigger Trigger
+			trigger, err = parseTrigger(dir, value)
+			w.Triggers = append(w.Triggers, trigger)
+		case "concurrency":
+			w.Concurrency, err = strconv.Atoi(value)
+			if err == nil && w.Concurrency < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "timeout":
+			w.Timeout, err = time.ParseDuration(value)
+			if err == nil && w.Timeout <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid %s declaration: %w", filepath.Base(path), lineNum, verb, err)
+		}
+	}
+	return w, nil
+}
+
+func parseTrigger(dir, s string) (Trigger, error) {
+	kind, spec, _ := strings.Cut(s, " ")
+	t := Trigger{Type: TriggerType(kind), Spec: strings.TrimSpace(spec)}
+
+	// Requests to webhook and manual triggers may have to present a secret
+	// taken from the environment: secret=$NAME
+	if t.Type == TriggerWebhook || t.Type == TriggerManual {
+		var rest []string
+		for _, field := range strings.Fields(t.Spec) {
+			value, ok := strings.CutPrefix(field, "secret=")
+			if !ok {
+				rest = append(rest, field)
+				continue
+			}
+			name, ok := strings.CutPrefix(value, "$")
+			if !ok || name == "" {
+				return t, fmt.Errorf("secret must name an environment variable, as secret=$WEBHOOK_SECRET")
+			}
+			t.Secret = name
+		}
+		t.Spec = strings.Join(rest, " ")
+	}
+
+	switch t.Type {
+	case TriggerCron:
+		if _, err := cronParser.Parse(t.Spec); err != nil {
+			return t, err
+		}
+	case TriggerWatch:
+		if t.Spec == "" {
+			return t, fmt.Errorf("watch needs a path")
+		}
+		if !filepath.IsAbs(t.Spec) {
+			t.Spec = filepath.Join(dir, t.Spec)
+		}
+	case TriggerWebhook:
+		t.Spec = strings.Trim(t.Spec, "/")
+		if t.Spec == "" {
+			return t, fmt.Errorf("webhook needs a path")
+		}
+	case TriggerManual:
+		if t.Spec != "" {
+			return t, fmt.Errorf("manual takes no arguments")
+		}
+	default:
+		return t, fmt.Errorf("unknown trigger %q (want cron, watch, webhook or manual)", kind)
+	}
+	return t, nil
+}
+
+// isWorkflowFile reports whether path is a script the daemon loads
+func isWorkflowFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".lua" || ext == ".luac"
+}
+
+// discover returns the workflow scripts under dir with their modification
+// times. Hidden directories are skipped.
+func discover(dir string) (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isWorkflowFile(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[path] = info.ModTime()
+		return nil
+	})
+	return files, err
+}
+
+// sortedPaths returns the keys of files in order, so workflows load in the
+// same order on every scan
+func sortedPaths(files map[string]time.Time) []string {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
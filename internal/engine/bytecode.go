@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/bytecode"
+)
+
+// loadScript reads a script, which may be source or compiled with
+// 'vulgar compile'
+func (e *Engine) loadScript(path string) (*bytecode.Chunk, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := bytecode.Load(data, path)
+	if errors.Is(err, bytecode.ErrIncompatible) || errors.Is(err, bytecode.ErrCorrupt) {
+		return nil, fmt.Errorf("%s: %w; recompile it with 'vulgar compile'", path, err)
+	}
+	return chunk, err
+}
+
+// setupModuleCache makes require() load Lua modules through the bytecode
+// cache, so unchanged modules are not parsed again. It replaces the default
+// file searcher in package.loaders.
+func (e *Engine) setupModuleCache(L *lua.LState) {
+	if e.cache == nil {
+		return
+	}
+	loaders, ok := L.GetField(L.GetGlobal("package"), "loaders").(*lua.LTable)
+	if !ok {
+		return
+	}
+	// The first loader handles package.preload, the second searches files
+	L.RawSetInt(loaders, 2, L.NewFunction(e.loadCachedModule))
+}
+
+// loadCachedModule finds a module on package.path like the default
+// searcher and returns its compiled chunk
+func (e *Engine) loadCachedModule(L *lua.LState) int {
+	name := L.CheckString(1)
+	path, searched := findModule(L, name)
+	if path == "" {
+		L.Push(lua.LString(searched))
+		return 1
+	}
+
+	chunk, err := e.cache.LoadFile(path)
+	if err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+	L.Push(L.NewFunctionFromProto(chunk.Proto))
+	return 1
+}
+
+// findModule returns the first file on package.path matching a module name,
+// or why none matched, in the words of the default searcher
+func findModule(L *lua.LState, name string) (string, string) {
+	path := lua.LVAsString(L.GetField(L.GetGlobal("package"), "path"))
+	file := strings.ReplaceAll(name, ".", string(os.PathSeparator))
+
+	var messages []string
+	for _, pattern := range strings.Split(path, ";") {
+		candidate := strings.ReplaceAll(pattern, "?", file)
+		if _, err := os.Stat(candidate); err != nil {
+			messages = append(messages, err.Error())
+			continue
+		}
+		return candidate, ""
+	}
+	return "", strings.Join(messages, "\n\t")
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zepzeper/vulgar/internal/bytecode"
+)
+
+func TestRunCompiledScriptWithCachedModules(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"vulgar.toml":     "",
+		"lib/helpers.lua": "return { answer = 42 }",
+		"main.lua":        "-- vulgar:allow fs=./data\nanswer = require('helpers').answer\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source := filepath.Join(root, "main.lua")
+	data, _ := os.ReadFile(source)
+	chunk, err := bytecode.Compile(data, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf, chunk); err != nil {
+		t.Fatal(err)
+	}
+	compiled := filepath.Join(root, "main.luac")
+	if err := os.WriteFile(compiled, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	for run := 0; run < 2; run++ {
+		eng := NewEngine(Config{LogLevel: "ERROR", BytecodeCache: cacheDir})
+		if err := eng.RunWorkflow(compiled); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if got := eng.L.GetGlobal("answer").String(); got != "42" {
+			t.Errorf("run %d: answer = %s", run, got)
+		}
+		if !eng.Sandbox.Enabled() {
+			t.Errorf("run %d: permissions from the compiled header were not applied", run)
+		}
+		eng.Close()
+	}
+
+	entries, _ := filepath.Glob(filepath.Join(cacheDir, "*"+bytecode.Extension))
+	if len(entries) != 1 {
+		t.Errorf("cache entries = %v, want the helpers module", entries)
+	}
+}
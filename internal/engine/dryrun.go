@@ -0,0 +1,306 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/plugins"
+)
+
+// dryRunTarget lists the side-effecting entry points of a single module.
+// Functions are module-level exports (http.post), methods are keyed by the
+// userdata type name the module registers (client:post). Calls listed in
+// errorOnly return just an error (local err = fs.write_file(...)) rather than
+// a value and an error.
+type dryRunTarget struct {
+	functions []string
+	methods   map[string][]string
+	errorOnly []string
+}
+
+// dryRunTargets describes every call that is stubbed out in dry-run mode.
+// Read-only calls (http.get, fs.read_file, postgres.query, ...) still execute
+// so that scripts can make decisions based on real data.
+var dryRunTargets = map[string]dryRunTarget{
+	"http": {
+		functions: []string{"post", "put", "patch", "delete", "request",
+			"post_async", "put_async", "patch_async", "delete_async", "request_async"},
+		methods: map[string][]string{"http_client": {"post", "put", "patch", "delete", "request",
+			"post_async", "put_async", "patch_async", "delete_async", "request_async"}},
+	},
+	"fs": {
+		functions: []string{"write_file", "append_file", "remove", "mkdir", "copy", "move"},
+		methods:   map[string][]string{"fs_handle": {"write_file", "append_file", "remove", "mkdir", "copy", "move"}},
+		errorOnly: []string{"write_file", "append_file", "remove", "mkdir", "copy", "move"},
+	},
+	"stdlib.shell": {
+		functions: []string{"exec", "run", "pipe"},
+	},
+	"stdlib.process": {
+		functions: []string{"exec", "spawn", "kill"},
+		errorOnly: []string{"kill"},
+	},
+	"integrations.smtp": {
+		functions: []string{"send", "send_raw"},
+		methods:   map[string][]string{"smtp_client": {"send", "send_raw"}},
+		errorOnly: []string{"send", "send_raw"},
+	},
+	"integrations.slack": {
+		functions: []string{"send", "send_webhook", "send_blocks", "upload_file", "react",
+			"update_message", "delete_message", "pin_message", "unpin_message"},
+		methods: map[string][]string{"slack_client": {"send", "send_blocks", "upload_file", "react",
+			"update_message", "delete_message", "pin_message", "unpin_message"}},
+		errorOnly: []string{"send", "send_webhook", "send_blocks", "upload_file", "react",
+			"update_message", "delete_message", "pin_message", "unpin_message"},
+	},
+	"integrations.postgres": {
+		functions: []string{"exec", "exec_async", "insert", "update", "delete", "tx_exec"},
+	},
+	"integrations.sqlite": {
+		functions: []string{"exec", "insert", "update", "delete", "tx_exec"},
+	},
+	"integrations.ssh": {
+		functions: []string{"exec", "exec_async", "run", "shell", "upload"},
+		methods:   map[string][]string{"ssh_client": {"exec", "exec_async", "run", "upload"}},
+		errorOnly: []string{"upload"},
+	},
+	"integrations.gsheets": {
+		functions: []string{"set_values", "append_values", "clear_values", "create_spreadsheet",
+			"add_sheet", "delete_sheet", "batch_update"},
+		errorOnly: []string{"delete_sheet"},
+	},
+	"integrations.github": {
+		functions: []string{"create_issue", "create_pr"},
+		methods:   map[string][]string{"github_client": {"create_issue", "create_pr"}},
+	},
+	"integrations.gitlab": {
+		methods: map[string][]string{"gitlab_client": {"create_issue", "create_merge_request"}},
+	},
+	"integrations.discord": {
+		functions: []string{"send", "send_webhook", "send_embed"},
+		errorOnly: []string{"send", "send_webhook", "send_embed"},
+	},
+	"integrations.telegram": {
+		functions: []string{"send", "send_photo", "send_document"},
+		errorOnly: []string{"send", "send_photo", "send_document"},
+	},
+	"integrations.twilio": {
+		functions: []string{"send_sms", "send_whatsapp", "make_call", "verify_start"},
+	},
+	"integrations.webhook": {
+		functions: []string{"send", "send_json"},
+	},
+	"integrations.s3": {
+		functions: []string{"upload", "delete", "copy"},
+		errorOnly: []string{"upload", "delete", "copy"},
+	},
+	"integrations.redis": {
+		functions: []string{"set", "del", "expire", "hset", "lpush", "rpush", "lpop", "rpop",
+			"sadd", "publish", "incr", "incrby"},
+	},
+	"integrations.kafka": {
+		functions: []string{"produce", "commit", "create_topic", "delete_topic"},
+		errorOnly: []string{"produce", "commit", "create_topic", "delete_topic"},
+	},
+	"integrations.rabbitmq": {
+		functions: []string{"publish", "declare_queue", "declare_exchange", "bind_queue", "ack", "nack"},
+		errorOnly: []string{"publish", "declare_queue", "declare_exchange", "bind_queue", "ack", "nack"},
+	},
+	"integrations.nats": {
+		functions: []string{"publish", "request"},
+		errorOnly: []string{"publish"},
+	},
+	"integrations.stripe": {
+		functions: []string{"create_customer", "create_payment_intent", "confirm_payment_intent",
+			"create_charge", "create_refund", "create_subscription", "cancel_subscription", "create_invoice"},
+	},
+	"integrations.notion": {
+		functions: []string{"create_page", "update_page", "delete_page", "create_database", "append_blocks"},
+		errorOnly: []string{"delete_page"},
+	},
+	"integrations.airtable": {
+		functions: []string{"create_record", "create_records", "update_record", "update_records",
+			"delete_record", "delete_records"},
+		errorOnly: []string{"delete_record", "delete_records"},
+	},
+	"integrations.gdrive": {
+		functions: []string{"upload", "delete", "create_folder", "move", "copy", "share", "rename"},
+		errorOnly: []string{"delete", "move", "share"},
+	},
+	"integrations.gcalendar": {
+		functions: []string{"create_event", "update_event", "delete_event", "quick_add"},
+		errorOnly: []string{"delete_event"},
+	},
+	"integrations.docker": {
+		functions: []string{"create_container", "start_container", "stop_container", "remove_container",
+			"exec", "pull_image"},
+		errorOnly: []string{"start_container", "stop_container", "remove_container", "pull_image"},
+	},
+	"integrations.k8s": {
+		functions: []string{"apply", "delete", "scale", "exec"},
+		errorOnly: []string{"apply", "delete", "scale"},
+	},
+	"integrations.ftp": {
+		functions: []string{"upload", "mkdir", "remove", "rename"},
+		errorOnly: []string{"upload", "mkdir", "remove", "rename"},
+	},
+	"integrations.mongodb": {
+		functions: []string{"insert_one", "insert_many", "update_one", "update_many",
+			"delete_one", "delete_many", "create_index"},
+		errorOnly: []string{"create_index"},
+	},
+}
+
+// DryRunCall is a single side-effecting call that was intercepted
+type DryRunCall struct {
+	Module   string        `json:"module"`
+	Function string        `json:"function"`
+	Method   bool          `json:"method"`
+	Args     []interface{} `json:"args"`
+}
+
+// String renders the call the way it appeared in the script
+func (c DryRunCall) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		encoded, err := json.Marshal(arg)
+		if err != nil {
+			args[i] = fmt.Sprintf("%v", arg)
+			continue
+		}
+		args[i] = string(encoded)
+	}
+
+	sep := "."
+	if c.Method {
+		sep = ":"
+	}
+	return fmt.Sprintf("%s%s%s(%s)", c.Module, sep, c.Function, strings.Join(args, ", "))
+}
+
+// dryRunRecorder collects intercepted calls in the order they happened
+type dryRunRecorder struct {
+	calls []DryRunCall
+	mu    sync.Mutex
+}
+
+func (r *dryRunRecorder) record(call DryRunCall) {
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+}
+
+func (r *dryRunRecorder) snapshot() []DryRunCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]DryRunCall, len(r.calls))
+	copy(result, r.calls)
+	return result
+}
+
+// wrapDryRunLoader wraps a module loader so that its side-effecting functions
+// are replaced with recording stubs once the module table has been built
+func (e *Engine) wrapDryRunLoader(name string, loader lua.LGFunction) lua.LGFunction {
+	target, ok := dryRunTargets[name]
+	pluginName, isPlugin := strings.CutPrefix(name, plugins.ModulePrefix)
+	if !ok && !isPlugin {
+		return loader
+	}
+
+	return func(L *lua.LState) int {
+		n := loader(L)
+		if n < 1 {
+			return n
+		}
+
+		target := target
+		if isPlugin {
+			// Plugins declare their side-effecting functions in the handshake
+			target = dryRunTarget{functions: e.plugins.SideEffects(pluginName)}
+		}
+		errorOnly := make(map[string]bool, len(target.errorOnly))
+		for _, fn := range target.errorOnly {
+			errorOnly[fn] = true
+		}
+
+		if mod, ok := L.Get(-1).(*lua.LTable); ok {
+			for _, fn := range target.functions {
+				if mod.RawGetString(fn) != lua.LNil {
+					mod.RawSetString(fn, L.NewFunction(e.dryRunStub(name, fn, false, errorOnly[fn])))
+				}
+			}
+		}
+
+		for typeName, methods := range target.methods {
+			mt, ok := L.GetTypeMetatable(typeName).(*lua.LTable)
+			if !ok {
+				continue
+			}
+			index, ok := mt.RawGetString("__index").(*lua.LTable)
+			if !ok {
+				continue
+			}
+			for _, fn := range methods {
+				if index.RawGetString(fn) != lua.LNil {
+					index.RawSetString(fn, L.NewFunction(e.dryRunStub(name, fn, true, errorOnly[fn])))
+				}
+			}
+		}
+
+		return n
+	}
+}
+
+// dryRunStub returns a function that records its arguments instead of
+// performing the call. Calls that return just an error return nil; the
+// others follow the util.PushSuccess convention and hand back a placeholder
+// table (wrapped in a future for *_async functions) so scripts can keep going.
+func (e *Engine) dryRunStub(module, function string, method, errorOnly bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		first := 1
+		if method {
+			// Skip the receiver (self)
+			first = 2
+		}
+
+		var args []interface{}
+		for i := first; i <= L.GetTop(); i++ {
+			args = append(args, util.LuaToGo(L.Get(i)))
+		}
+
+		e.dryRun.record(DryRunCall{
+			Module:   module,
+			Function: function,
+			Method:   method,
+			Args:     args,
+		})
+
+		if errorOnly {
+			L.Push(lua.LNil)
+			return 1
+		}
+
+		placeholder := L.NewTable()
+		placeholder.RawSetString("dry_run", lua.LTrue)
+		if strings.HasSuffix(function, "_async") {
+			// Async variants hand back a future that is already resolved
+			f := util.NewFuture()
+			f.Resolve(L, placeholder, lua.LNil)
+			L.Push(util.NewFutureValue(L, f))
+			return 1
+		}
+		return util.PushSuccess(L, placeholder)
+	}
+}
+
+// DryRunCalls returns every call that was intercepted in dry-run mode
+func (e *Engine) DryRunCalls() []DryRunCall {
+	if e.dryRun == nil {
+		return nil
+	}
+	return e.dryRun.snapshot()
+}
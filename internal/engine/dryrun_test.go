@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDryRunInterceptsSideEffects(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "out.txt")
+
+	eng := NewEngine(Config{LogLevel: "ERROR", DryRun: true})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		local fs = require("fs")
+		local err = fs.write_file("` + target + `", "hello")
+		if err then error(err) end
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Error("expected file not to be written in dry-run mode")
+	}
+
+	calls := eng.DryRunCalls()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 recorded call, got %d", len(calls))
+	}
+	if calls[0].Module != "fs" || calls[0].Function != "write_file" {
+		t.Errorf("unexpected call recorded: %s", calls[0])
+	}
+	if len(calls[0].Args) != 2 || calls[0].Args[1] != "hello" {
+		t.Errorf("unexpected args recorded: %v", calls[0].Args)
+	}
+}
+
+func TestDryRunStubsKeepReturnConventions(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", DryRun: true})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		local fs = require("fs")
+		local http = require("http")
+		local slack = require("integrations.slack")
+
+		-- Calls returning just an error
+		for _, call in ipairs({
+			function() return fs.mkdir("/nonexistent/dir") end,
+			function() return fs.copy("/nonexistent/a", "/nonexistent/b") end,
+			function() return fs.new({ base_dir = "/nonexistent" }):remove("data.txt") end,
+			function() return slack.send_webhook("https://hooks.slack.com/x", { text = "hi" }) end,
+		}) do
+			local err = call()
+			if err then error(err) end
+		end
+
+		-- Calls returning a value and an error
+		local resp, err = http.post("https://example.com", "body")
+		if err then error(err) end
+		assert(resp.dry_run, "stub should return a placeholder")
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+	if got := len(eng.DryRunCalls()); got != 5 {
+		t.Errorf("expected 5 recorded calls, got %d", got)
+	}
+}
+
+func TestDryRunRecordsMethodCalls(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", DryRun: true})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		local fs = require("fs")
+		local h = fs.new({ base_dir = "/nonexistent" })
+		h:remove("data.txt")
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+
+	calls := eng.DryRunCalls()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 recorded call, got %d", len(calls))
+	}
+	if got := calls[0].String(); got != `fs:remove("data.txt")` {
+		t.Errorf("unexpected call rendering: %s", got)
+	}
+}
+
+func TestReadOnlyCallsRunInDryRun(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", DryRun: true})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		local fs = require("fs")
+		assert(fs.exists("/") == true, "read-only calls should execute")
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+
+	if len(eng.DryRunCalls()) != 0 {
+		t.Error("read-only calls should not be recorded")
+	}
+}
+
+func TestDryRunStubsStdlibSideEffects(t *testing.T) {
+	dir := t.TempDir()
+	touched := filepath.Join(dir, "touched")
+	written := filepath.Join(dir, "written")
+	kept := filepath.Join(dir, "kept")
+	if err := os.WriteFile(kept, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := NewEngine(Config{LogLevel: "ERROR", DryRun: true})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		assert(os.execute("touch ` + touched + `") == 0, "os.execute should report success")
+
+		local f = assert(io.open("` + written + `", "w"))
+		f:write("hello")
+		f:close()
+
+		assert(os.remove("` + kept + `") == true, "os.remove should report success")
+
+		local r = assert(io.open("` + kept + `", "r"))
+		assert(r:read("*a") == "data", "reads should still execute")
+		r:close()
+	`)
+	if err != nil {
+		t.Fatalf("eval failed: %v", err)
+	}
+
+	for _, path := range []string{touched, written} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was created in dry-run mode", filepath.Base(path))
+		}
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Error("os.remove removed a file in dry-run mode")
+	}
+
+	var got []string
+	for _, call := range eng.DryRunCalls() {
+		got = append(got, call.Module+"."+call.Function)
+	}
+	if want := "os.execute io.open os.remove"; strings.Join(got, " ") != want {
+		t.Errorf("recorded %v, want %s", got, want)
+	}
+}
@@ -3,100 +3,342 @@ package engine
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/bytecode"
 	"github.com/zepzeper/vulgar/internal/modules"
 	_ "github.com/zepzeper/vulgar/internal/modules/all"
 	log "github.com/zepzeper/vulgar/internal/modules/core/log"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/params"
+	"github.com/zepzeper/vulgar/internal/plugins"
+	"github.com/zepzeper/vulgar/internal/project"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 type Engine struct {
 	L          *lua.LState
 	EventQueue *util.EventQueue
+	Sandbox    *sandbox.Policy
+	dryRun     *dryRunRecorder
+	input      params.Input
+	// Whether the script called params{...} (see checkNamedParams)
+	paramsDeclared bool
+	ctx            context.Context
+	cancel         context.CancelFunc
+	limits         Limits
+	results        []lua.LValue
+
+	// Modules registered on this engine only (see RegisterModule)
+	modules  map[string]lua.LGFunction
+	preloads map[string]func(*lua.LState)
+	plugins  *plugins.Manager
+
+	// Project the running script belongs to (see setupProject)
+	project     *project.Project
+	packagePath string
+	cache       *bytecode.Cache
+
+	// Script being run, and where errors raised by its callbacks go
+	script          string
+	onCallbackError func(error)
+
+	// Run the engine executes (see RunID)
+	run *util.RunInfo
+
+	// Where script output and workflow node reports go (see SetOutput)
+	output io.Writer
+	onNode util.NodeObserver
+
+	cancellation   *util.Cancellation
+	shutdownHooks  []*lua.LFunction
+	shutdownGrace  time.Duration
+	shutdownReason string
+	shutdownMu     sync.Mutex
 }
 
 type Config struct {
 	LogLevel  string
 	LogFormat string
 	DryRun    bool
+	// GraphOnly loads the script for its stdlib.workflow graphs without
+	// running them (see util.RunInfo)
+	GraphOnly bool
 	Profile   bool
 	Trace     bool
+	// Sandbox restricts what scripts may do. Nil means unrestricted unless
+	// the script itself declares permissions.
+	Sandbox *sandbox.Policy
+	// ShutdownGrace bounds how long on_shutdown handlers may run.
+	// Zero uses DefaultShutdownGrace.
+	ShutdownGrace time.Duration
+	// Limits bounds memory, call depth, stack size and instructions
+	Limits Limits
+	// PluginDirs are searched for plugin executables, which scripts load
+	// with require("plugins.<name>")
+	PluginDirs []string
+	// EventQueueSize is the number of events the event loop buffers before
+	// the overflow policies of their sources apply. Zero uses
+	// util.DefaultQueueSize.
+	EventQueueSize int
+	// BytecodeCache is the directory compiled project modules are cached in,
+	// so require() skips parsing modules that did not change. Empty disables
+	// the cache.
+	BytecodeCache string
+	// OnCallbackError receives errors raised by callbacks the event loop
+	// runs (timers, event listeners, watchers) as a *RuntimeError. The script
+	// keeps running. Nil prints them to stderr.
+	OnCallbackError func(error)
 }
 
 func NewEngine(cfg Config) *Engine {
-	L := lua.NewState()
+	L := lua.NewState(cfg.Limits.stateOptions())
 
 	log.SetLevel(cfg.LogLevel)
 	log.SetFormat(cfg.LogFormat)
 
 	// Initialize EventQueue
-	queue := util.NewEventQueue(L, 100)
+	queue := util.NewEventQueue(L, cfg.EventQueueSize)
+	queue.SetOverflowHandler(warnOverflow)
 
 	// Store in registry for modules to access
 	ud := L.NewUserData()
 	ud.Value = queue
 	L.SetField(L.Get(lua.RegistryIndex), util.EventQueueRegistryKey, ud)
 
+	policy := cfg.Sandbox
+	if policy == nil {
+		policy = &sandbox.Policy{}
+	}
+	sandbox.Attach(L, policy)
+
 	e := &Engine{
 		L:          L,
 		EventQueue: queue,
+		Sandbox:    policy,
+		limits:     cfg.Limits,
+		modules:    make(map[string]lua.LGFunction),
+		preloads:   make(map[string]func(*lua.LState)),
+		plugins:    plugins.NewManager(cfg.PluginDirs),
+
+		cancellation: util.NewCancellation(),
+		run:          &util.RunInfo{ID: uuid.NewString(), DryRun: cfg.DryRun, GraphOnly: cfg.GraphOnly},
+
+		onCallbackError: cfg.OnCallbackError,
+	}
+	queue.SetErrorHandler(e.reportCallbackError)
+
+	e.shutdownGrace = cfg.ShutdownGrace
+	if e.shutdownGrace <= 0 {
+		e.shutdownGrace = DefaultShutdownGrace
+	}
+
+	if cfg.DryRun {
+		e.dryRun = &dryRunRecorder{}
+	}
+
+	if cfg.BytecodeCache != "" {
+		e.cache = bytecode.NewCache(cfg.BytecodeCache)
 	}
 
-	e.setupModuleLoader()
-	e.preloadCriticalModules()
+	e.configureState(L)
+	util.SetStateFactory(L, e.newWorkerState)
+
+	// Instruction and memory budgets are enforced through the context
+	if e.limits.needsContext() {
+		e.SetContext(context.Background())
+	}
 	return e
 }
 
-func (e *Engine) setupModuleLoader() {
-	preload := e.L.GetField(e.L.GetGlobal("package"), "preload")
+// configureState installs module loaders, sandbox guards and globals on a
+// Lua state. It is applied to the main state and to every worker state.
+func (e *Engine) configureState(L *lua.LState) {
+	e.setupModuleLoader(L)
+	e.setupStdlibGuards(L)
+	e.setupParams(L)
+	e.setupShutdownHooks(L)
+	util.SetCancellation(L, e.cancellation)
+	util.SetRunInfo(L, e.run)
+	e.setupOutput(L)
+	e.preloadCriticalModules(L)
+}
+
+// newWorkerState creates an isolated Lua state configured like the main
+// state, used by modules that run Lua code on other goroutines
+func (e *Engine) newWorkerState() *lua.LState {
+	L := lua.NewState(e.limits.stateOptions())
+	sandbox.Attach(L, e.Sandbox)
+	e.configureState(L)
+	if e.packagePath != "" {
+		prependPackagePath(L, e.packagePath)
+		e.setupModuleCache(L)
+	}
+	if e.ctx != nil {
+		L.SetContext(e.ctx)
+	}
+	return L
+}
 
+func (e *Engine) setupModuleLoader(L *lua.LState) {
 	// Register each module from the auto-registry
 	for name, loader := range modules.GetRegistry() {
-		e.L.SetField(preload, name, e.L.NewFunction(loader))
+		e.preloadModule(L, name, loader)
+	}
+	for name, loader := range e.modules {
+		e.preloadModule(L, name, loader)
+	}
+	for _, p := range e.plugins.Plugins() {
+		e.preloadModule(L, p.Module(), e.plugins.Loader(p.Name))
+	}
+}
+
+func (e *Engine) preloadModule(L *lua.LState, name string, loader lua.LGFunction) {
+	preload := L.GetField(L.GetGlobal("package"), "preload")
+	loader = guardModuleLoader(name, loader)
+	if e.dryRun != nil {
+		loader = e.wrapDryRunLoader(name, loader)
 	}
+	L.SetField(preload, name, L.NewFunction(loader))
+}
+
+// RegisterModule makes a module available to require() in this engine only,
+// next to the modules registered globally with modules.Register. It replaces
+// a global module of the same name and also applies to worker states.
+func (e *Engine) RegisterModule(name string, loader lua.LGFunction) {
+	e.modules[name] = loader
+	e.preloadModule(e.L, name, loader)
+}
+
+// RegisterPreload runs opener on the main state now and on every worker
+// state, like modules.RegisterPreload but for this engine only
+func (e *Engine) RegisterPreload(name string, opener func(*lua.LState)) {
+	e.preloads[name] = opener
+	opener(e.L)
 }
 
 // preloadCriticalModules makes certain modules globally available without require()
 // Currently only log is preloaded for error reporting before other modules load
-func (e *Engine) preloadCriticalModules() {
+func (e *Engine) preloadCriticalModules(L *lua.LState) {
 	for name, opener := range modules.GetPreloadRegistry() {
 		_ = name // name is available if we need to log which modules are preloaded
-		opener(e.L)
+		opener(L)
+	}
+	for _, opener := range e.preloads {
+		opener(L)
+	}
+}
+
+// Call calls fn with args on the main state and returns its results.
+// Errors are formatted like script errors, attributed to chunk.
+func (e *Engine) Call(chunk string, fn *lua.LFunction, args ...lua.LValue) ([]lua.LValue, error) {
+	top := e.L.GetTop()
+	defer e.L.SetTop(top)
+
+	e.L.Push(fn)
+	for _, arg := range args {
+		e.L.Push(arg)
+	}
+	if err := util.PCall(e.L, len(args), lua.MultRet); err != nil {
+		return nil, e.formatError(err, chunk)
 	}
+
+	results := make([]lua.LValue, 0, e.L.GetTop()-top)
+	for i := top + 1; i <= e.L.GetTop(); i++ {
+		results = append(results, e.L.Get(i))
+	}
+	return results, nil
+}
+
+// Plugins lists the plugins scripts can require
+func (e *Engine) Plugins() []plugins.Plugin {
+	return e.plugins.Plugins()
+}
+
+// Results returns the values returned by the last script run with RunWorkflow
+func (e *Engine) Results() []lua.LValue {
+	return e.results
 }
 
 func (e *Engine) Eval(code string) error {
-	if err := e.L.DoString(code); err != nil {
-		return formatLuaError(err, "<eval>")
+	fn, err := e.L.LoadString(code)
+	if err == nil {
+		e.L.Push(fn)
+		err = util.PCall(e.L, 0, 0)
+	}
+	if err != nil {
+		return e.formatError(err, "<eval>")
 	}
 	return nil
 }
 
+// RunWorkflow executes a script and then runs the event loop until no async
+// sources (timers, cron jobs, watchers) remain or Shutdown is called. The
+// script's on_shutdown handlers run before it returns.
 func (e *Engine) RunWorkflow(path string) error {
-	// Execute the script
-	if err := e.L.DoFile(path); err != nil {
-		return formatLuaError(err, path)
+	return e.finishRun(e.runWorkflow(path))
+}
+
+func (e *Engine) runWorkflow(path string) error {
+	e.script = path
+
+	// Read the script, which may have been compiled with 'vulgar compile'
+	chunk, err := e.loadScript(path)
+	if err != nil {
+		return e.formatError(err, path)
+	}
+
+	// Apply permissions declared in the script header
+	if err := e.applyScriptPermissions(path, chunk.Header); err != nil {
+		return err
+	}
+
+	// Resolve require() against the script's project, if it has one
+	if err := e.setupProject(path); err != nil {
+		return err
+	}
+
+	if err := e.checkNamedParams(chunk.Proto, false); err != nil {
+		return err
 	}
 
+	// Execute the script, keeping whatever the chunk returns
+	top := e.L.GetTop()
+	e.L.Push(e.L.NewFunctionFromProto(chunk.Proto))
+	if err := util.PCall(e.L, 0, lua.MultRet); err != nil {
+		return e.formatError(err, path)
+	}
+	e.results = nil
+	for i := top + 1; i <= e.L.GetTop(); i++ {
+		e.results = append(e.results, e.L.Get(i))
+	}
+	e.L.SetTop(top)
+
 	// Check if optional RunWorkflow function exists and run it
-	fn := e.L.GetGlobal("RunWorkflow")
-	if fn != lua.LNil {
-		if err := e.L.CallByParam(lua.P{
-			Fn:      fn,
-			NRet:    0,
-			Protect: true,
-		}); err != nil {
-			return formatLuaError(err, path)
+	if fn, ok := e.L.GetGlobal("RunWorkflow").(*lua.LFunction); ok {
+		e.L.Push(fn)
+		if err := util.PCall(e.L, 0, 0); err != nil {
+			return e.formatError(err, path)
 		}
 	}
 
+	if err := e.checkNamedParams(chunk.Proto, true); err != nil {
+		return err
+	}
+
 	// Main Event Loop
 	// Continue running as long as there are active async sources (timers, watchers, etc.)
-	// or pending events in the queue.
-	for e.EventQueue.HasActiveSources() {
+	// or pending events in the queue, until shutdown or the context ends.
+	for e.EventQueue.HasActiveSources() && e.ShutdownRequested() == "" {
+		if e.ctx != nil && e.ctx.Err() != nil {
+			return fmt.Errorf("workflow stopped: %w", e.ctx.Err())
+		}
 		if !e.EventQueue.WaitForEvents() {
 			break
 		}
@@ -105,101 +347,66 @@ func (e *Engine) RunWorkflow(path string) error {
 	return nil
 }
 
-// formatLuaError formats Lua errors with helpful suggestions
-func formatLuaError(err error, scriptPath string) error {
-	errStr := err.Error()
-
-	// Check for module not found errors and provide helpful suggestions
-	if strings.Contains(errStr, "module") && strings.Contains(errStr, "not found") {
-		return formatModuleNotFoundError(errStr, scriptPath)
+// formatError reports resource limit violations by name and turns any
+// other Lua error into a *RuntimeError
+func (e *Engine) formatError(err error, scriptPath string) error {
+	if limitErr := e.limitErrorFor(err); limitErr != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(scriptPath), limitErr)
 	}
-
-	// Check for syntax errors
-	if strings.Contains(errStr, "syntax error") {
-		return formatSyntaxError(errStr, scriptPath)
-	}
-
-	// Default formatting
-	return fmt.Errorf("%s: %w", filepath.Base(scriptPath), err)
+	return e.newRuntimeError(err, scriptPath)
 }
 
-// formatModuleNotFoundError provides suggestions for module naming
-func formatModuleNotFoundError(errStr string, scriptPath string) error {
-	// Extract the module name from error like "module xyz not found"
-	var moduleName string
-	if idx := strings.Index(errStr, "module "); idx != -1 {
-		rest := errStr[idx+7:]
-		if endIdx := strings.Index(rest, " "); endIdx != -1 {
-			moduleName = rest[:endIdx]
-		}
-	}
-
-	// Build suggestion based on module name
-	var suggestion string
-	if moduleName != "" {
-		// Check if it's a known module that needs a prefix
-		stdlibModules := []string{"timer", "cron", "event", "shell", "process", "filewatch",
-			"gzip", "tar", "zip", "yaml", "xml", "csv", "regex", "strings", "cache",
-			"parallel", "mathx", "jwt", "html", "url", "validator", "template",
-			"queue", "workflow", "retry", "health", "metrics", "osinfo", "secrets", "trace"}
-
-		integrationModules := []string{"redis", "postgres", "mongodb", "sqlite", "kafka",
-			"rabbitmq", "nats", "s3", "smtp", "slack", "discord", "telegram", "github",
-			"docker", "k8s", "webhook", "websocket", "graphql", "ftp", "dns", "stripe",
-			"twilio", "notion", "airtable", "gsheets", "ssh"}
-
-		aiModules := []string{"openai", "anthropic", "ollama", "huggingface", "localai"}
-
-		for _, m := range stdlibModules {
-			if moduleName == m {
-				suggestion = fmt.Sprintf("\n  Hint: Use require(\"stdlib.%s\") instead of require(\"%s\")", m, m)
-				break
-			}
-		}
-		if suggestion == "" {
-			for _, m := range integrationModules {
-				if moduleName == m {
-					suggestion = fmt.Sprintf("\n  Hint: Use require(\"integrations.%s\") instead of require(\"%s\")", m, m)
-					break
-				}
-			}
-		}
-		if suggestion == "" {
-			for _, m := range aiModules {
-				if moduleName == m {
-					suggestion = fmt.Sprintf("\n  Hint: Use require(\"ai.%s\") instead of require(\"%s\")", m, m)
-					break
-				}
-			}
-		}
-		if suggestion == "" {
-			suggestion = "\n  Run 'vulgar --list-modules' to see available modules"
-		}
+// warnOverflow logs events a source lost because the event queue was full
+func warnOverflow(w util.OverflowWarning) {
+	source := w.Source
+	if source == "" {
+		source = "an unnamed source"
 	}
-
-	return fmt.Errorf("module not found: %s%s", moduleName, suggestion)
+	log.Warn("event queue full: dropped %d event(s) from %s (overflow policy %s); raise --event-queue-size or use overflow = \"block\"",
+		w.Dropped, source, w.Policy)
 }
 
-// formatSyntaxError formats syntax errors with line information
-func formatSyntaxError(errStr string, scriptPath string) error {
-	// Lua syntax errors usually include line numbers
-	return fmt.Errorf("syntax error in %s:\n  %s", filepath.Base(scriptPath), errStr)
+// reportCallbackError reports an error raised by a callback run from the
+// event loop
+func (e *Engine) reportCallbackError(err error) {
+	err = e.formatError(err, e.script)
+	if e.onCallbackError != nil {
+		e.onCallbackError(err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Error in callback: %v\n", err)
 }
 
 func (e *Engine) Close() {
+	if e.cancel != nil {
+		e.cancel()
+	}
 	e.EventQueue.Close()
+	e.plugins.Close()
 	e.L.Close()
 }
 
+// Compile checks that a script, source or compiled, loads without errors
 func (e *Engine) Compile(path string) error {
-	_, err := e.L.LoadFile(path)
-	if err != nil {
-		return fmt.Errorf("syntax error: %w", err)
+	if _, err := e.loadScript(path); err != nil {
+		return e.newRuntimeError(err, path)
 	}
-
 	return nil
 }
 
 func (e *Engine) SetContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	if e.limits.needsContext() {
+		ctx = newLimitContext(ctx, e.limits)
+	}
+
+	e.shutdownMu.Lock()
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.ctx = ctx
+	e.cancel = cancel
+	e.shutdownMu.Unlock()
+
 	e.L.SetContext(ctx)
 }
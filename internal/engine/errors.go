@@ -0,0 +1,309 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// Kinds of RuntimeError
+const (
+	ErrorKindRuntime        = "runtime"
+	ErrorKindSyntax         = "syntax"
+	ErrorKindModuleNotFound = "module_not_found"
+)
+
+// snippetContext is the number of lines shown around the offending line
+const snippetContext = 2
+
+// maxCauseDepth bounds how many nested causes (e.g. a node of a workflow run
+// from a node of another workflow) are attached to a report
+const maxCauseDepth = 5
+
+// RuntimeError is a failed script with what is needed to debug it: the Lua
+// call stack where the error was raised, the source lines around it and the
+// Go module function that raised it. It marshals to JSON for tooling.
+type RuntimeError struct {
+	Kind    string `json:"kind"`
+	Script  string `json:"script"`
+	Message string `json:"message"`
+	// Location is the innermost Lua frame, where the error surfaced
+	Location *util.StackFrame `json:"location,omitempty"`
+	// RaisedBy is the Go module function that raised the error, if any
+	RaisedBy *util.StackFrame `json:"raised_by,omitempty"`
+	// Snippet holds the source lines around Location
+	Snippet   []SourceLine      `json:"snippet,omitempty"`
+	Traceback []util.StackFrame `json:"traceback,omitempty"`
+	Hints     []string          `json:"hints,omitempty"`
+	// Cause is the error the script's error was built from, such as the
+	// failed node of a workflow.run the script re-raised
+	Cause *RuntimeError `json:"cause,omitempty"`
+
+	err error
+}
+
+// SourceLine is a line of a source snippet
+type SourceLine struct {
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Current bool   `json:"current,omitempty"`
+}
+
+// ErrorStyle highlights parts of a rendered RuntimeError. Nil fields leave
+// that part unstyled.
+type ErrorStyle struct {
+	Message func(string) string // the error message
+	Current func(string) string // the snippet line that raised the error
+	Muted   func(string) string // other snippet lines and the traceback
+	Hint    func(string) string
+}
+
+func (s ErrorStyle) apply(fn func(string) string, text string) string {
+	if fn == nil {
+		return text
+	}
+	return fn(text)
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Render(ErrorStyle{})
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.err
+}
+
+// Render formats the error for a terminal: message, the Go function that
+// raised it, the source snippet, the traceback, hints and causes
+func (e *RuntimeError) Render(style ErrorStyle) string {
+	var b strings.Builder
+	e.render(&b, style, "")
+	return b.String()
+}
+
+func (e *RuntimeError) render(b *strings.Builder, style ErrorStyle, indent string) {
+	message := e.Message
+	if e.Script != "" && e.Kind == ErrorKindRuntime {
+		message = filepath.Base(e.Script) + ": " + message
+	}
+	b.WriteString(style.apply(style.Message, message))
+
+	if e.RaisedBy != nil {
+		fmt.Fprintf(b, "\n%s  raised by %s (Go: %s)", indent, e.RaisedBy.Function, e.RaisedBy.GoFunction)
+	}
+
+	if len(e.Snippet) > 0 {
+		width := len(strconv.Itoa(e.Snippet[len(e.Snippet)-1].Line))
+		b.WriteString("\n")
+		for _, line := range e.Snippet {
+			marker := "  "
+			if line.Current {
+				marker = "> "
+			}
+			text := fmt.Sprintf("%s  %s%*d | %s", indent, marker, width, line.Line, line.Text)
+			if line.Current {
+				text = style.apply(style.Current, text)
+			} else {
+				text = style.apply(style.Muted, text)
+			}
+			b.WriteString("\n" + text)
+		}
+	}
+
+	if len(e.Traceback) > 0 {
+		b.WriteString("\n\n" + indent + "stack traceback:")
+		for _, frame := range e.Traceback {
+			b.WriteString("\n" + style.apply(style.Muted, indent+"  "+frame.String()))
+		}
+	}
+
+	for _, hint := range e.Hints {
+		b.WriteString("\n" + style.apply(style.Hint, indent+"  Hint: "+hint))
+	}
+
+	if e.Cause != nil {
+		b.WriteString("\n\n" + indent + "caused by: ")
+		e.Cause.render(b, style, indent+"  ")
+	}
+}
+
+// newRuntimeError builds the report for an error raised while running
+// scriptPath
+func (e *Engine) newRuntimeError(err error, scriptPath string) *RuntimeError {
+	report := &RuntimeError{Kind: ErrorKindRuntime, Script: scriptPath, Message: err.Error(), err: err}
+	sources := make(map[string][]string)
+
+	var apiErr *lua.ApiError
+	var scriptErr *util.ScriptError
+	switch {
+	case errors.As(err, &scriptErr):
+		report.Message = scriptErr.Message
+		report.attachStack(scriptErr, sources)
+		report.Cause = e.causeOf(scriptErr.Message, sources, 1)
+	case errors.As(err, &apiErr) && apiErr.Type == lua.ApiErrorSyntax:
+		report.Kind = ErrorKindSyntax
+		report.Message = strings.TrimSpace(apiErr.Object.String())
+		if line := syntaxErrorLine(report.Message); line > 0 {
+			report.Location = &util.StackFrame{Source: scriptPath, Line: line}
+			report.Snippet = sourceSnippet(sources, scriptPath, line)
+		}
+	}
+
+	if name, hint := moduleNotFoundHint(report.Message); name != "" {
+		report.Kind = ErrorKindModuleNotFound
+		report.Message = fmt.Sprintf("module not found: %s", name)
+		report.Hints = append(report.Hints, hint)
+		if hint := e.projectHint(); hint != "" {
+			report.Hints = append(report.Hints, hint)
+		}
+	}
+	return report
+}
+
+// causeOf returns the report of an error a module recorded with
+// util.RecordError whose message the script's error contains
+func (e *Engine) causeOf(message string, sources map[string][]string, depth int) *RuntimeError {
+	if depth > maxCauseDepth {
+		return nil
+	}
+	causeMessage, cause := util.ErrorCause(e.L, message)
+	if cause == nil {
+		return nil
+	}
+
+	report := &RuntimeError{Kind: ErrorKindRuntime, Message: causeMessage, err: cause}
+	report.attachStack(cause, sources)
+	report.Cause = e.causeOf(cause.Message, sources, depth+1)
+	return report
+}
+
+// attachStack fills in the traceback, location, snippet and raising Go
+// function from the stack recorded with the error
+func (e *RuntimeError) attachStack(scriptErr *util.ScriptError, sources map[string][]string) {
+	e.Traceback = scriptErr.Frames
+	e.RaisedBy = scriptErr.RaisedBy()
+
+	for i := range scriptErr.Frames {
+		frame := &scriptErr.Frames[i]
+		if frame.IsGo() || frame.Line == 0 {
+			continue
+		}
+		e.Location = frame
+		e.Snippet = sourceSnippet(sources, frame.Source, frame.Line)
+		return
+	}
+}
+
+// sourceSnippet returns the lines around line in the file at path, or nil
+// if the chunk is not a readable file (e.g. code run with --eval)
+func sourceSnippet(sources map[string][]string, path string, line int) []SourceLine {
+	lines, ok := sources[path]
+	if !ok {
+		lines = readLines(path)
+		sources[path] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return nil
+	}
+
+	first := max(1, line-snippetContext)
+	last := min(len(lines), line+snippetContext)
+	snippet := make([]SourceLine, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		snippet = append(snippet, SourceLine{Line: n, Text: lines[n-1], Current: n == line})
+	}
+	return snippet
+}
+
+func readLines(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.ReplaceAll(scanner.Text(), "\t", "    "))
+	}
+	return lines
+}
+
+var syntaxLinePattern = regexp.MustCompile(`line:(\d+)`)
+
+// syntaxErrorLine extracts the line number from a gopher-lua syntax error
+// like "x.lua line:4(column:3) near 'end': syntax error"
+func syntaxErrorLine(message string) int {
+	m := syntaxLinePattern.FindStringSubmatch(message)
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+// Modules that are commonly required without their category prefix
+var (
+	stdlibModules = []string{"async", "timer", "cron", "event", "shell", "process", "filewatch",
+		"gzip", "tar", "zip", "yaml", "xml", "csv", "regex", "strings", "cache",
+		"parallel", "mathx", "jwt", "html", "url", "validator", "template",
+		"queue", "workflow", "retry", "health", "metrics", "osinfo", "secrets", "trace"}
+
+	integrationModules = []string{"redis", "postgres", "mongodb", "sqlite", "kafka",
+		"rabbitmq", "nats", "s3", "smtp", "slack", "discord", "telegram", "github",
+		"docker", "k8s", "webhook", "websocket", "graphql", "ftp", "dns", "stripe",
+		"twilio", "notion", "airtable", "gsheets", "ssh"}
+
+	aiModules = []string{"openai", "anthropic", "ollama", "huggingface", "localai"}
+)
+
+// moduleNotFoundHint recognizes "module xyz not found" errors and suggests
+// the prefixed module name. It returns an empty name for other errors.
+func moduleNotFoundHint(message string) (name, hint string) {
+	if !strings.Contains(message, "module") || !strings.Contains(message, "not found") {
+		return "", ""
+	}
+
+	// Extract the module name from error like "module xyz not found"
+	idx := strings.Index(message, "module ")
+	if idx == -1 {
+		return "", ""
+	}
+	rest := message[idx+7:]
+	endIdx := strings.Index(rest, " ")
+	if endIdx == -1 {
+		return "", ""
+	}
+	name = rest[:endIdx]
+	return name, ModuleHint(name)
+}
+
+// ModuleHint suggests how to require a module that was not found, such as
+// the prefixed name of a standard library module
+func ModuleHint(name string) string {
+	prefixes := []struct {
+		prefix  string
+		modules []string
+	}{
+		{"stdlib", stdlibModules},
+		{"integrations", integrationModules},
+		{"ai", aiModules},
+	}
+	for _, p := range prefixes {
+		for _, m := range p.modules {
+			if name == m {
+				return fmt.Sprintf("Use require(\"%s.%s\") instead of require(\"%s\")", p.prefix, m, m)
+			}
+		}
+	}
+	return "Run 'vulgar --list-modules' to see available modules"
+}
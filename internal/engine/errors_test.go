@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runScriptError(t *testing.T, code string) (*RuntimeError, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.lua")
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+	err := eng.RunWorkflow(path)
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a *RuntimeError, got %T: %v", err, err)
+	}
+	return runtimeErr, path
+}
+
+func TestRuntimeErrorFromModuleFunction(t *testing.T) {
+	report, path := runScriptError(t, `local timer = require("stdlib.timer")
+local function wait()
+  timer.sleep("soon")
+end
+wait()
+`)
+
+	if report.Kind != ErrorKindRuntime {
+		t.Errorf("kind = %q", report.Kind)
+	}
+	if report.RaisedBy == nil || report.RaisedBy.GoFunction != "timer.luaSleep" {
+		t.Errorf("raised by = %+v, want timer.luaSleep", report.RaisedBy)
+	}
+	if report.Location == nil || report.Location.Source != path || report.Location.Line != 3 {
+		t.Errorf("location = %+v", report.Location)
+	}
+
+	var current string
+	for _, line := range report.Snippet {
+		if line.Current {
+			current = line.Text
+		}
+	}
+	if current != `  timer.sleep("soon")` {
+		t.Errorf("highlighted line = %q", current)
+	}
+
+	var functions []string
+	for _, frame := range report.Traceback {
+		functions = append(functions, frame.Function)
+	}
+	if got := strings.Join(functions, ","); got != "sleep,wait,main chunk" {
+		t.Errorf("traceback functions = %s", got)
+	}
+}
+
+func TestRuntimeErrorIncludesWorkflowNodeCause(t *testing.T) {
+	report, _ := runScriptError(t, `local workflow = require("stdlib.workflow")
+local wf = workflow.new("demo")
+workflow.node(wf, "fetch", function(ctx)
+  local data = nil
+  return { count = #data.items }
+end)
+local _, err = workflow.run(wf)
+error(err)
+`)
+
+	if report.Location == nil || report.Location.Line != 8 {
+		t.Errorf("location = %+v, want line 8", report.Location)
+	}
+	cause := report.Cause
+	if cause == nil {
+		t.Fatal("expected the failed node as cause")
+	}
+	if !strings.HasPrefix(cause.Message, "node 'fetch' failed") {
+		t.Errorf("cause message = %q", cause.Message)
+	}
+	if cause.Location == nil || cause.Location.Line != 5 {
+		t.Errorf("cause location = %+v, want line 5", cause.Location)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"kind", "message", "location", "snippet", "traceback", "cause"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON report is missing %q: %s", key, data)
+		}
+	}
+}
+
+func TestSyntaxErrorReport(t *testing.T) {
+	report, _ := runScriptError(t, "local x = 1\nif x then\n  print(x\nend\n")
+
+	if report.Kind != ErrorKindSyntax {
+		t.Errorf("kind = %q", report.Kind)
+	}
+	if report.Location == nil || report.Location.Line != 4 {
+		t.Errorf("location = %+v, want line 4", report.Location)
+	}
+}
+
+func TestCallbackErrorsAreReported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.lua")
+	code := `local event = require("stdlib.event")
+event.on("x", function() error("handler broke") end)
+event.emit("x", 1)
+done = true
+`
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported []error
+	eng := NewEngine(Config{LogLevel: "ERROR", OnCallbackError: func(err error) { reported = append(reported, err) }})
+	defer eng.Close()
+	if err := eng.RunWorkflow(path); err != nil {
+		t.Fatalf("RunWorkflow: %v", err)
+	}
+
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), "handler broke") {
+		t.Fatalf("reported = %v", reported)
+	}
+	if eng.L.GetGlobal("done").String() != "true" {
+		t.Error("script should keep running after a callback error")
+	}
+}
@@ -0,0 +1,225 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Limits bounds the resources a script may use. Zero values mean no limit
+// beyond gopher-lua's defaults.
+type Limits struct {
+	// MaxMemory is the Go heap size in bytes at which the script is stopped.
+	// The heap is process-wide, so this is only exact for one engine per process.
+	MaxMemory uint64
+	// MaxCallDepth is the maximum number of nested Lua calls (default 256)
+	MaxCallDepth int
+	// MaxStackSlots is how far the Lua value stack (registry) may grow, in
+	// slots. By default it is fixed at lua.RegistrySize.
+	MaxStackSlots int
+	// MaxInstructions is the number of VM instructions the script may execute
+	MaxInstructions int64
+}
+
+// memoryCheckInterval is how often the heap is sampled when MaxMemory is set
+const memoryCheckInterval = 50 * time.Millisecond
+
+// LimitError is returned when a script exceeds one of its resource limits
+type LimitError struct {
+	Limit string // human readable name of the limit
+	Value string // configured value
+	Flag  string // CLI flag that raises it
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("resource limit exceeded: %s limit of %s reached (raise with --%s)", e.Limit, e.Value, e.Flag)
+}
+
+func (l Limits) callDepthError() *LimitError {
+	depth := l.MaxCallDepth
+	if depth <= 0 {
+		depth = lua.CallStackSize
+	}
+	return &LimitError{Limit: "call depth", Value: fmt.Sprintf("%d calls", depth), Flag: "max-call-depth"}
+}
+
+func (l Limits) stackError() *LimitError {
+	slots := l.MaxStackSlots
+	if slots <= 0 {
+		slots = lua.RegistrySize
+	}
+	return &LimitError{Limit: "Lua stack", Value: fmt.Sprintf("%d slots", slots), Flag: "max-stack"}
+}
+
+func (l Limits) instructionError() *LimitError {
+	return &LimitError{Limit: "instruction", Value: strconv.FormatInt(l.MaxInstructions, 10), Flag: "max-instructions"}
+}
+
+func (l Limits) memoryError() *LimitError {
+	return &LimitError{Limit: "memory", Value: FormatByteSize(l.MaxMemory), Flag: "max-memory"}
+}
+
+// stateOptions returns the gopher-lua options enforcing the stack limits
+func (l Limits) stateOptions() lua.Options {
+	opts := lua.Options{
+		CallStackSize: l.MaxCallDepth,
+		RegistrySize:  lua.RegistrySize,
+	}
+	if l.MaxStackSlots > 0 {
+		if l.MaxStackSlots < lua.RegistrySize {
+			opts.RegistrySize = l.MaxStackSlots
+		} else {
+			opts.RegistryMaxSize = l.MaxStackSlots
+		}
+	}
+	return opts
+}
+
+// needsContext reports whether the limits are enforced through the context
+func (l Limits) needsContext() bool {
+	return l.MaxInstructions > 0 || l.MaxMemory > 0
+}
+
+// limitErrorFor maps a Lua error caused by a limit to a *LimitError, or returns nil.
+// gopher-lua reports stack exhaustion and context cancellation as plain
+// strings, so they are recognised by message.
+func (e *Engine) limitErrorFor(err error) *LimitError {
+	var limitErr *LimitError
+	if e.ctx != nil && errors.As(context.Cause(e.ctx), &limitErr) {
+		return limitErr
+	}
+
+	msg := err.Error()
+	if first, _, ok := strings.Cut(msg, "\n"); ok {
+		msg = first
+	}
+	switch {
+	case strings.HasSuffix(msg, "registry overflow"):
+		return e.limits.stackError()
+	case strings.HasSuffix(msg, "stack overflow"):
+		return e.limits.callDepthError()
+	}
+	return nil
+}
+
+// limitContext enforces the instruction and memory budgets. gopher-lua
+// checks Done() before every VM instruction, which makes it a cheap place to
+// count them. The counter is shared with worker states, so the budget covers
+// the whole run.
+type limitContext struct {
+	context.Context
+	cancel       context.CancelCauseFunc
+	instructions atomic.Int64
+	limits       Limits
+}
+
+func newLimitContext(parent context.Context, limits Limits) *limitContext {
+	ctx, cancel := context.WithCancelCause(parent)
+	c := &limitContext{Context: ctx, cancel: cancel, limits: limits}
+	if limits.MaxMemory > 0 {
+		go c.watchMemory()
+	}
+	return c
+}
+
+func (c *limitContext) Done() <-chan struct{} {
+	if c.limits.MaxInstructions > 0 && c.instructions.Add(1) > c.limits.MaxInstructions {
+		c.cancel(c.limits.instructionError())
+	}
+	return c.Context.Done()
+}
+
+// Err reports the limit that was hit instead of a bare "context canceled",
+// since gopher-lua raises Err().Error() as the script error
+func (c *limitContext) Err() error {
+	err := c.Context.Err()
+	if err == nil {
+		return nil
+	}
+	var limitErr *LimitError
+	if errors.As(context.Cause(c.Context), &limitErr) {
+		return limitErr
+	}
+	return err
+}
+
+// watchMemory samples the heap until the context ends. The heap is only
+// collected when it is over the limit, so garbage alone does not stop a script.
+func (c *limitContext) watchMemory() {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Context.Done():
+			return
+		case <-ticker.C:
+			if heapInUse() <= c.limits.MaxMemory {
+				continue
+			}
+			runtime.GC()
+			if heapInUse() > c.limits.MaxMemory {
+				c.cancel(c.limits.memoryError())
+				return
+			}
+		}
+	}
+}
+
+func heapInUse() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses sizes such as "512MB", "1.5GB" or "1048576".
+// Units are binary (1KB = 1024 bytes).
+func ParseByteSize(s string) (uint64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := uint64(1)
+	for _, unit := range byteUnits {
+		if num, ok := strings.CutSuffix(str, unit.suffix); ok {
+			str = strings.TrimSpace(num)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 512MB or 2GB)", s)
+	}
+	return uint64(n * float64(multiplier)), nil
+}
+
+// FormatByteSize renders a byte count using the largest whole unit
+func FormatByteSize(n uint64) string {
+	for _, unit := range byteUnits {
+		if n >= unit.size && n%unit.size == 0 {
+			return fmt.Sprintf("%d%s", n/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
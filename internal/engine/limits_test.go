@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInstructionLimit(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", Limits: Limits{MaxInstructions: 10000}})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		-- pcall must not be able to swallow the limit
+		pcall(function() while true do end end)
+		while true do end
+	`)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Flag != "max-instructions" {
+		t.Fatalf("expected instruction limit error, got %v", err)
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", Limits: Limits{MaxCallDepth: 50}})
+	defer eng.Close()
+
+	if err := eng.Eval(`local function f(n) if n == 0 then return 0 end return 1 + f(n - 1) end f(40)`); err != nil {
+		t.Fatalf("recursion within the limit failed: %v", err)
+	}
+
+	err := eng.Eval(`local function f(n) return 1 + f(n + 1) end f(1)`)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Flag != "max-call-depth" {
+		t.Fatalf("expected call depth limit error, got %v", err)
+	}
+}
+
+func TestStackLimit(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", Limits: Limits{MaxStackSlots: 1024}})
+	defer eng.Close()
+
+	err := eng.Eval(`local function f(...) local x = f(1, 2, 3, 4, 5, 6, 7, 8, ...) return x end f()`)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Flag != "max-stack" {
+		t.Fatalf("expected stack limit error, got %v", err)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", Limits: Limits{MaxMemory: 64 << 20}})
+	defer eng.Close()
+
+	err := eng.Eval(`
+		local t = {}
+		while true do t[#t + 1] = string.rep("x", 1024) .. #t end
+	`)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Flag != "max-memory" {
+		t.Fatalf("expected memory limit error, got %v", err)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]uint64{
+		"1024":  1024,
+		"512MB": 512 << 20,
+		"1.5GB": 3 << 29,
+		"64kb":  64 << 10,
+	}
+	for input, want := range tests {
+		got, err := ParseByteSize(input)
+		if err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+
+	if _, err := ParseByteSize("lots"); err == nil {
+		t.Error("expected error for invalid size")
+	}
+	if got := FormatByteSize(256 << 20); got != "256MB" {
+		t.Errorf("FormatByteSize = %s, want 256MB", got)
+	}
+}
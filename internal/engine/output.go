@@ -0,0 +1,48 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// SetOutput sends what scripts print and log to w instead of stdout, e.g.
+// to also capture it for the run history. w is written to from worker
+// goroutines and must be safe for concurrent use.
+func (e *Engine) SetOutput(w io.Writer) {
+	e.output = w
+	e.setupOutput(e.L)
+}
+
+// SetNodeObserver makes fn receive a report for every stdlib.workflow node
+// that finishes
+func (e *Engine) SetNodeObserver(fn util.NodeObserver) {
+	e.onNode = fn
+	util.SetNodeObserver(e.L, fn)
+}
+
+// setupOutput applies SetOutput and SetNodeObserver to a state
+func (e *Engine) setupOutput(L *lua.LState) {
+	if e.onNode != nil {
+		util.SetNodeObserver(L, e.onNode)
+	}
+	if e.output == nil {
+		return
+	}
+	util.SetOutput(L, e.output)
+	L.SetGlobal("print", L.NewFunction(luaPrint))
+}
+
+// luaPrint is print writing to the state's output
+func luaPrint(L *lua.LState) int {
+	top := L.GetTop()
+	parts := make([]string, top)
+	for i := 1; i <= top; i++ {
+		parts[i-1] = L.ToStringMeta(L.Get(i)).String()
+	}
+	fmt.Fprintln(util.Output(L), strings.Join(parts, "\t"))
+	return 0
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/params"
+)
+
+// SetInput supplies the command-line arguments a script is run with. They
+// are exposed as the global arg table and validated by params{...}.
+func (e *Engine) SetInput(in params.Input) {
+	e.input = in
+
+	argTable := e.L.NewTable()
+	for i, value := range in.Positional {
+		argTable.RawSetInt(i+1, lua.LString(value))
+	}
+	e.L.SetGlobal("arg", argTable)
+}
+
+// setupParams registers the global params function.
+// Usage: local p = params { { "env", type = "string", required = true } }
+func (e *Engine) setupParams(L *lua.LState) {
+	L.SetGlobal(params.DeclarationFunc, L.NewFunction(func(L *lua.LState) int {
+		e.paramsDeclared = true
+		specs, err := params.ParseSpec(L.CheckTable(1))
+		if err != nil {
+			L.RaiseError("invalid parameter declaration: %v", err)
+			return 0
+		}
+
+		values, err := params.Resolve(specs, e.input)
+		if err != nil {
+			L.RaiseError("%v (run with --help to list parameters)", err)
+			return 0
+		}
+
+		result := L.NewTable()
+		for name, value := range values {
+			result.RawSetString(name, util.GoToLua(L, value))
+		}
+		L.Push(result)
+		return 1
+	}))
+}
+
+// checkNamedParams rejects strict named values (see params.Input) when the
+// script declares no parameters, as they would be ignored. Before the script runs, its chunk
+// (source or compiled) is searched for a use of params; once it has run
+// (ran), whether params{...} was actually called decides.
+func (e *Engine) checkNamedParams(proto *lua.FunctionProto, ran bool) error {
+	if !e.input.Strict || len(e.input.Named) == 0 || e.paramsDeclared {
+		return nil
+	}
+	if !ran && usesGlobal(proto, params.DeclarationFunc) {
+		return nil
+	}
+
+	names := make([]string, 0, len(e.input.Named))
+	for name := range e.input.Named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown parameter %q: the script declares no parameters", names[0])
+}
+
+// usesGlobal reports whether name is among the constants of proto or the
+// functions it defines, as it is when the code reads a global of that name
+func usesGlobal(proto *lua.FunctionProto, name string) bool {
+	for _, c := range proto.Constants {
+		if s, ok := c.(lua.LString); ok && string(s) == name {
+			return true
+		}
+	}
+	for _, child := range proto.FunctionPrototypes {
+		if usesGlobal(child, name) {
+			return true
+		}
+	}
+	return false
+}
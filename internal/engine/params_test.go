@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zepzeper/vulgar/internal/params"
+)
+
+func TestNamedParamsNeedADeclaration(t *testing.T) {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"plain.lua":    "ran = true\n",
+		"mentions.lua": "local request = { params = {} }\nran = true\n",
+		"declares.lua": "local p = params { { \"env\" } }\nenv = p.env\n",
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(name string, strict bool) (*Engine, error) {
+		eng := NewEngine(Config{LogLevel: "ERROR"})
+		t.Cleanup(eng.Close)
+		eng.SetInput(params.Input{Named: map[string]string{"env": "prod"}, Strict: strict})
+		return eng, eng.RunWorkflow(filepath.Join(dir, name))
+	}
+
+	eng, err := run("plain.lua", true)
+	if err == nil || !strings.Contains(err.Error(), `unknown parameter "env"`) {
+		t.Errorf("expected unknown parameter error, got %v", err)
+	}
+	if eng.L.GetGlobal("ran").String() == "true" {
+		t.Error("expected the script not to run")
+	}
+
+	// Found only once the script has run
+	if _, err := run("mentions.lua", true); err == nil || !strings.Contains(err.Error(), "declares no parameters") {
+		t.Errorf("expected unknown parameter error, got %v", err)
+	}
+
+	if _, err := run("plain.lua", false); err != nil {
+		t.Errorf("expected values that are not strict to be left unused, got %v", err)
+	}
+
+	eng, err = run("declares.lua", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := eng.L.GetGlobal("env").String(); got != "prod" {
+		t.Errorf("env = %s", got)
+	}
+}
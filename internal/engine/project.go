@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/project"
+)
+
+// setupProject makes the lib directories and installed dependencies of the
+// project containing the script available to require()
+func (e *Engine) setupProject(scriptPath string) error {
+	p, err := project.Find(filepath.Dir(scriptPath))
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return nil
+	}
+
+	e.project = p
+	e.packagePath = p.PackagePath()
+	prependPackagePath(e.L, e.packagePath)
+	e.setupModuleCache(e.L)
+	return nil
+}
+
+// prependPackagePath puts entries in front of package.path
+func prependPackagePath(L *lua.LState, entries string) {
+	pkg := L.GetGlobal("package")
+	current := lua.LVAsString(L.GetField(pkg, "path"))
+	if current != "" {
+		entries += ";" + current
+	}
+	L.SetField(pkg, "path", lua.LString(entries))
+}
+
+// projectHint suggests installing dependencies when a module is missing
+// from a project that has uninstalled ones
+func (e *Engine) projectHint() string {
+	if e.project == nil {
+		return ""
+	}
+	missing := e.project.Missing()
+	if len(missing) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Dependencies not installed (%s): run 'vulgar deps install' in %s",
+		strings.Join(missing, ", "), e.project.Root)
+}
@@ -0,0 +1,70 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProjectLibAndDependencyHint(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"vulgar.toml":          "[dependencies]\nslackfmt = { path = \"../slackfmt\" }\n",
+		"lib/helpers.lua":      "return { answer = 42 }",
+		"scripts/uses_lib.lua": "answer = require('helpers').answer",
+		"scripts/uses_dep.lua": "require('slackfmt')",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+	if err := eng.RunWorkflow(filepath.Join(root, "scripts", "uses_lib.lua")); err != nil {
+		t.Fatalf("RunWorkflow: %v", err)
+	}
+	if got := eng.L.GetGlobal("answer").String(); got != "42" {
+		t.Errorf("answer = %s", got)
+	}
+
+	eng2 := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng2.Close()
+	err := eng2.RunWorkflow(filepath.Join(root, "scripts", "uses_dep.lua"))
+	if err == nil || !strings.Contains(err.Error(), "vulgar deps install") {
+		t.Errorf("expected a hint to install dependencies, got %v", err)
+	}
+}
+
+func TestRequireSingleFileDependencyByName(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"vulgar.toml":                      "[dependencies]\ninspect = { git = \"https://github.com/kikito/inspect.lua\", rev = \"a3b9b9e\" }\n",
+		".vulgar/deps/inspect/inspect.lua": "return { version = 'vendored' }",
+		"main.lua":                         "version = require('inspect').version",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+	if err := eng.RunWorkflow(filepath.Join(root, "main.lua")); err != nil {
+		t.Fatalf("RunWorkflow: %v", err)
+	}
+	if got := eng.L.GetGlobal("version").String(); got != "vendored" {
+		t.Errorf("version = %s", got)
+	}
+}
@@ -0,0 +1,26 @@
+package engine
+
+// RunID identifies the run in the run history and in stdlib.workflow
+// checkpoints. Every engine starts with a random ID.
+func (e *Engine) RunID() string {
+	return e.run.ID
+}
+
+// SetRunID gives the run an ID, e.g. the daemon's. Call it before
+// RunWorkflow.
+func (e *Engine) SetRunID(id string) {
+	e.run.ID = id
+}
+
+// Resume makes the run continue the earlier run id: stdlib.workflow graphs
+// with a checkpoint store restore what that run saved and skip the nodes it
+// completed. Call it before RunWorkflow.
+func (e *Engine) Resume(id string) {
+	e.run.ID = id
+	e.run.Resume = true
+}
+
+// Resuming reports whether Resume was called
+func (e *Engine) Resuming() bool {
+	return e.run.Resume
+}
@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+// moduleCapabilities maps modules that are useless without a capability to
+// that capability, so they can be refused at require() time
+var moduleCapabilities = map[string]sandbox.Capability{
+	"stdlib.shell":     sandbox.Shell,
+	"stdlib.process":   sandbox.Process,
+	"integrations.ssh": sandbox.SSH,
+}
+
+// unconfinedModules maps modules that reach the network or the filesystem
+// without checking each host or path against the policy to the capabilities
+// they use. They only load when the policy grants those for every target
+// (--allow net rather than --allow net=host).
+var unconfinedModules = map[string][]sandbox.Capability{
+	"ai.anthropic":           {sandbox.Net},
+	"ai.huggingface":         {sandbox.Net},
+	"ai.localai":             {sandbox.Net},
+	"ai.ollama":              {sandbox.Net},
+	"integrations.airtable":  {sandbox.Net},
+	"integrations.discord":   {sandbox.Net},
+	"integrations.dns":       {sandbox.Net},
+	"integrations.docker":    {sandbox.Net},
+	"integrations.ftp":       {sandbox.Net, sandbox.FS},
+	"integrations.graphql":   {sandbox.Net},
+	"integrations.k8s":       {sandbox.Net},
+	"integrations.kafka":     {sandbox.Net},
+	"integrations.mongodb":   {sandbox.Net},
+	"integrations.nats":      {sandbox.Net},
+	"integrations.notion":    {sandbox.Net},
+	"integrations.rabbitmq":  {sandbox.Net},
+	"integrations.s3":        {sandbox.Net},
+	"integrations.stripe":    {sandbox.Net},
+	"integrations.telegram":  {sandbox.Net},
+	"integrations.twilio":    {sandbox.Net},
+	"integrations.webhook":   {sandbox.Net},
+	"integrations.websocket": {sandbox.Net},
+	"stdlib.health":          {sandbox.Net},
+	"stdlib.secrets":         {sandbox.Net, sandbox.FS},
+}
+
+// guardModuleLoader refuses to load a module when the sandbox policy does not
+// grant the capability it depends on
+func guardModuleLoader(name string, loader lua.LGFunction) lua.LGFunction {
+	capability, gated := moduleCapabilities[name]
+	unconfined := unconfinedModules[name]
+	if !gated && unconfined == nil {
+		return loader
+	}
+
+	return func(L *lua.LState) int {
+		policy := sandbox.FromState(L)
+		// Loading a script for its graph denies everything, yet must get past
+		// its requires; these modules still check each call
+		if gated && !util.GetRunInfo(L).GraphOnly {
+			if err := policy.CheckAny(capability); err != nil {
+				L.RaiseError("cannot load module %s: %v", name, err)
+				return 0
+			}
+		}
+		for _, capability := range unconfined {
+			if err := policy.CheckAll(capability); err != nil {
+				L.RaiseError("cannot load module %s, which does not check each target: %v", name, err)
+				return 0
+			}
+		}
+		return loader(L)
+	}
+}
+
+// stdlibGuard describes a gopher-lua stdlib function that must be checked
+// against the sandbox policy before it runs
+type stdlibGuard struct {
+	table      string
+	name       string
+	capability sandbox.Capability
+	args       []int // argument positions holding the target (path or command)
+
+	// dryRun reports whether a call changes something outside the script,
+	// in which case dry-run mode records it and answers with stub instead
+	dryRun func(L *lua.LState) bool
+	stub   stdlibStub
+}
+
+// stdlibStub answers a stdlib call in dry-run mode. original is the
+// function being replaced and open the unguarded io.open.
+type stdlibStub func(L *lua.LState, original, open *lua.LFunction) int
+
+var stdlibGuards = []stdlibGuard{
+	{table: "os", name: "execute", capability: sandbox.Shell, args: []int{1}, dryRun: isString(1), stub: stubExitStatus},
+	{table: "io", name: "popen", capability: sandbox.Shell, args: []int{1}, dryRun: isString(1), stub: stubNullFile(2)},
+	{table: "io", name: "open", capability: sandbox.FS, args: []int{1}, dryRun: writeMode(2), stub: stubNullFile(2)},
+	{table: "io", name: "lines", capability: sandbox.FS, args: []int{1}},
+	{table: "io", name: "input", capability: sandbox.FS, args: []int{1}},
+	{table: "io", name: "output", capability: sandbox.FS, args: []int{1}, dryRun: isString(1), stub: stubNullOutput},
+	{table: "os", name: "remove", capability: sandbox.FS, args: []int{1}, dryRun: isString(1), stub: stubTrue},
+	{table: "os", name: "rename", capability: sandbox.FS, args: []int{1, 2}, dryRun: isString(1), stub: stubTrue},
+	{name: "dofile", capability: sandbox.FS, args: []int{1}},
+	{name: "loadfile", capability: sandbox.FS, args: []int{1}},
+}
+
+// isString matches calls whose argument idx is a string, i.e. names a
+// command or file rather than a handle
+func isString(idx int) func(L *lua.LState) bool {
+	return func(L *lua.LState) bool {
+		_, ok := L.Get(idx).(lua.LString)
+		return ok
+	}
+}
+
+// writeMode matches calls that open a file for writing, by the mode at idx
+func writeMode(idx int) func(L *lua.LState) bool {
+	return func(L *lua.LState) bool {
+		return strings.ContainsAny(L.OptString(idx, "r"), "wa+")
+	}
+}
+
+// stubExitStatus reports a command that exited successfully
+func stubExitStatus(L *lua.LState, _, _ *lua.LFunction) int {
+	L.Push(lua.LNumber(0))
+	return 1
+}
+
+func stubTrue(L *lua.LState, _, _ *lua.LFunction) int {
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// stubNullFile hands back the null device, opened with the mode at idx, in
+// place of a file or pipe: writes are discarded and reads find nothing
+func stubNullFile(idx int) stdlibStub {
+	return func(L *lua.LState, _, open *lua.LFunction) int {
+		mode := L.OptString(idx, "r")
+		top := L.GetTop()
+		L.Push(open)
+		L.Push(lua.LString(os.DevNull))
+		L.Push(lua.LString(mode))
+		L.Call(2, lua.MultRet)
+		return L.GetTop() - top
+	}
+}
+
+// stubNullOutput makes io.output(filename) write to the null device
+func stubNullOutput(L *lua.LState, original, open *lua.LFunction) int {
+	L.Push(open)
+	L.Push(lua.LString(os.DevNull))
+	L.Push(lua.LString("w"))
+	L.Call(2, 1)
+	file := L.Get(-1)
+	L.Pop(1)
+
+	top := L.GetTop()
+	L.Push(original)
+	L.Push(file)
+	L.Call(1, lua.MultRet)
+	return L.GetTop() - top
+}
+
+// setupStdlibGuards wraps the built-in os/io functions that bypass vulgar
+// modules so that sandbox rules, and dry-run mode, apply to them as well
+func (e *Engine) setupStdlibGuards(L *lua.LState) {
+	var open *lua.LFunction
+	if io, ok := L.GetGlobal("io").(*lua.LTable); ok {
+		open, _ = io.RawGetString("open").(*lua.LFunction)
+	}
+
+	for _, g := range stdlibGuards {
+		tbl := L.G.Global
+		if g.table != "" {
+			t, ok := L.GetGlobal(g.table).(*lua.LTable)
+			if !ok {
+				continue
+			}
+			tbl = t
+		}
+
+		original, ok := tbl.RawGetString(g.name).(*lua.LFunction)
+		if !ok {
+			continue
+		}
+		tbl.RawSetString(g.name, L.NewFunction(e.guardStdlibFunction(g, original, open)))
+	}
+}
+
+func (e *Engine) guardStdlibFunction(g stdlibGuard, original, open *lua.LFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		for _, idx := range g.args {
+			// Only string arguments name a file or command; io.lines() without
+			// arguments reads stdin and io.output(file) takes a handle
+			if s, ok := L.Get(idx).(lua.LString); ok {
+				if err := sandbox.Check(L, g.capability, string(s)); err != nil {
+					L.RaiseError("%v", err)
+					return 0
+				}
+			}
+		}
+
+		if e.dryRun != nil && g.dryRun != nil && open != nil && g.dryRun(L) {
+			var args []interface{}
+			for i := 1; i <= L.GetTop(); i++ {
+				args = append(args, util.LuaToGo(L.Get(i)))
+			}
+			e.dryRun.record(DryRunCall{Module: g.table, Function: g.name, Args: args})
+			return g.stub(L, original, open)
+		}
+
+		top := L.GetTop()
+		L.Push(original)
+		for i := 1; i <= top; i++ {
+			L.Push(L.Get(i))
+		}
+		L.Call(top, lua.MultRet)
+		return L.GetTop() - top
+	}
+}
+
+// scriptPermissionPrefix marks permission declarations in a script header:
+//
+//	-- vulgar:allow net=api.github.com
+//	-- vulgar:allow fs=./data
+//	-- vulgar:deny shell
+const scriptPermissionPrefix = "vulgar:"
+
+// applyScriptPermissions reads permission declarations from the leading
+// comment block of the script at path and declares them on the engine's
+// policy, where they can only narrow what the operator granted
+func (e *Engine) applyScriptPermissions(path, header string) error {
+	// Relative fs targets are resolved against the script's directory
+	dir := filepath.Dir(path)
+
+	scanner := bufio.NewScanner(strings.NewReader(header))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#!") {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+
+		directive, ok := strings.CutPrefix(strings.TrimSpace(strings.TrimPrefix(line, "--")), scriptPermissionPrefix)
+		if !ok {
+			continue
+		}
+
+		verb, rule, _ := strings.Cut(directive, " ")
+		rule = strings.TrimSpace(rule)
+		if name, target, found := strings.Cut(rule, "="); found && sandbox.Capability(name) == sandbox.FS && !filepath.IsAbs(target) {
+			rule = name + "=" + filepath.Join(dir, target)
+		}
+
+		if verb != "allow" && verb != "deny" {
+			continue
+		}
+		if err := e.Sandbox.Declare(verb, rule); err != nil {
+			return fmt.Errorf("%s:%d: invalid permission declaration: %w", filepath.Base(path), lineNum, err)
+		}
+	}
+
+	return nil
+}
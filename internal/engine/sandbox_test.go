@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+func TestScriptHeaderCannotWidenOperatorPolicy(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+	script := filepath.Join(dir, "main.lua")
+	source := "-- vulgar:allow shell\n-- vulgar:allow net\nos.execute('touch " + marker + "')\n"
+	if err := os.WriteFile(script, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := sandbox.NewPolicy([]string{"net=api.github.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := NewEngine(Config{LogLevel: "ERROR", Sandbox: policy})
+	defer eng.Close()
+
+	err = eng.RunWorkflow(script)
+	if err == nil || !strings.Contains(err.Error(), "--allow shell") {
+		t.Errorf("expected the operator policy to refuse shell, got %v", err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("expected the command not to run")
+	}
+	if err := policy.Check(sandbox.Net, "example.com"); err == nil {
+		t.Error("expected the declared net allow not to widen the operator's")
+	}
+}
+
+func TestUnconfinedModulesNeedUnscopedGrant(t *testing.T) {
+	scoped, _ := sandbox.NewPolicy([]string{"net=api.stripe.com"}, nil)
+	eng := NewEngine(Config{LogLevel: "ERROR", Sandbox: scoped})
+	err := eng.Eval(`require("integrations.stripe")`)
+	eng.Close()
+	if err == nil || !strings.Contains(err.Error(), "does not check each target") {
+		t.Errorf("expected the module to be refused under a scoped grant, got %v", err)
+	}
+
+	unscoped, _ := sandbox.NewPolicy([]string{"net"}, nil)
+	eng = NewEngine(Config{LogLevel: "ERROR", Sandbox: unscoped})
+	defer eng.Close()
+	if err := eng.Eval(`require("integrations.stripe")`); err != nil {
+		t.Errorf("expected the module to load with --allow net, got %v", err)
+	}
+}
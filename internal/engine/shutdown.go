@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ShutdownHookFunc is the name of the global scripts use to register
+// shutdown handlers
+const ShutdownHookFunc = "on_shutdown"
+
+// DefaultShutdownGrace is how long shutdown handlers may run when
+// Config.ShutdownGrace is not set
+const DefaultShutdownGrace = 10 * time.Second
+
+// Shutdown reasons passed to on_shutdown handlers
+const (
+	ShutdownReasonExit    = "exit"    // script and event loop finished
+	ShutdownReasonError   = "error"   // script failed
+	ShutdownReasonTimeout = "timeout" // --timeout elapsed
+	ShutdownReasonCancel  = "cancel"  // Cancel was called
+)
+
+// ShutdownError is returned by RunWorkflow when the run was stopped by
+// Shutdown rather than finishing on its own
+type ShutdownError struct {
+	Reason string
+	Err    error // error from a shutdown handler, if any
+}
+
+func (e *ShutdownError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("shut down (%s): %v", e.Reason, e.Err)
+	}
+	return fmt.Sprintf("shut down (%s)", e.Reason)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// setupShutdownHooks registers the global on_shutdown function.
+// Usage: on_shutdown(function(reason) db:close() end)
+func (e *Engine) setupShutdownHooks(L *lua.LState) {
+	L.SetGlobal(ShutdownHookFunc, L.NewFunction(func(L *lua.LState) int {
+		fn := L.CheckFunction(1)
+		if L != e.L {
+			L.RaiseError("%s can only be called from the main script", ShutdownHookFunc)
+		}
+		e.shutdownHooks = append(e.shutdownHooks, fn)
+		return 0
+	}))
+}
+
+// Shutdown asks a running workflow to stop. The engine context is cancelled,
+// which aborts running Lua code, and the event loop exits; RunWorkflow then
+// stops timers, cron jobs and watchers, runs the on_shutdown handlers and
+// returns a *ShutdownError. Safe to call from any goroutine, e.g. a signal
+// handler. Only the first reason is kept.
+func (e *Engine) Shutdown(reason string) {
+	e.shutdownMu.Lock()
+	if e.shutdownReason == "" {
+		e.shutdownReason = reason
+	}
+	cancel := e.cancel
+	e.shutdownMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Cancel stops a run gracefully. Running stdlib.workflow graphs are
+// cancelled the way workflow.cancel does it: no further nodes start and
+// running ones finish. Once they have, or at once when no graph is running,
+// the run is shut down as Shutdown(ShutdownReasonCancel) does. Safe to call
+// from any goroutine.
+func (e *Engine) Cancel() {
+	// Record the reason now, so the run reports being cancelled even when
+	// the script ends while its graphs wind down
+	e.shutdownMu.Lock()
+	if e.shutdownReason == "" {
+		e.shutdownReason = ShutdownReasonCancel
+	}
+	e.shutdownMu.Unlock()
+
+	idle := e.cancellation.Cancel()
+	go func() {
+		<-idle
+		e.Shutdown(ShutdownReasonCancel)
+	}()
+}
+
+// ShutdownRequested returns the reason passed to Shutdown, or ""
+func (e *Engine) ShutdownRequested() string {
+	e.shutdownMu.Lock()
+	defer e.shutdownMu.Unlock()
+	return e.shutdownReason
+}
+
+// finishRun stops async sources and runs the shutdown handlers once the
+// script and event loop are done
+func (e *Engine) finishRun(runErr error) error {
+	requested := e.ShutdownRequested()
+
+	reason := requested
+	if reason == "" {
+		switch {
+		case e.ctx != nil && errors.Is(e.ctx.Err(), context.DeadlineExceeded):
+			reason = ShutdownReasonTimeout
+		case runErr != nil:
+			reason = ShutdownReasonError
+		default:
+			reason = ShutdownReasonExit
+		}
+	}
+
+	e.EventQueue.StopSources()
+	e.EventQueue.FlushOverflowWarnings()
+	hookErr := e.runShutdownHooks(reason)
+
+	if requested != "" {
+		return &ShutdownError{Reason: requested, Err: hookErr}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return hookErr
+}
+
+// runShutdownHooks calls the on_shutdown handlers, most recently registered
+// first, within the grace period. Handlers run under a fresh context because
+// the engine context is usually cancelled by now.
+func (e *Engine) runShutdownHooks(reason string) error {
+	hooks := e.shutdownHooks
+	e.shutdownHooks = nil
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.shutdownGrace)
+	defer cancel()
+
+	previous := e.L.Context()
+	e.L.SetContext(ctx)
+	defer func() {
+		if previous != nil {
+			e.L.SetContext(previous)
+		} else {
+			e.L.RemoveContext()
+		}
+	}()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("grace period of %s exceeded, %d shutdown handler(s) skipped", e.shutdownGrace, i+1))
+			break
+		}
+
+		if err := e.L.CallByParam(lua.P{
+			Fn:      hooks[i],
+			NRet:    0,
+			Protect: true,
+		}, lua.LString(reason)); err != nil {
+			if ctx.Err() != nil {
+				err = fmt.Errorf("grace period of %s exceeded", e.shutdownGrace)
+			}
+			errs = append(errs, fmt.Errorf("shutdown handler failed: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeScript(t *testing.T, code string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.lua")
+	if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestShutdownHooksRunOnExit(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+
+	script := writeScript(t, `
+		order = {}
+		on_shutdown(function(reason) table.insert(order, "first:" .. reason) end)
+		on_shutdown(function(reason) table.insert(order, "second:" .. reason) end)
+	`)
+	if err := eng.RunWorkflow(script); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	if err := eng.Eval(`assert(order[1] == "second:exit" and order[2] == "first:exit", "unexpected order")`); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShutdownStopsEventLoop(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+	eng.SetContext(context.Background())
+
+	script := writeScript(t, `
+		local timer = require("stdlib.timer")
+		timer.every(50, function() end)
+		on_shutdown(function(reason) shutdown_reason = reason end)
+	`)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		eng.Shutdown("SIGTERM")
+	}()
+
+	done := make(chan error, 1)
+	go func() { done <- eng.RunWorkflow(script) }()
+
+	select {
+	case err := <-done:
+		var shutdownErr *ShutdownError
+		if !errors.As(err, &shutdownErr) || shutdownErr.Reason != "SIGTERM" {
+			t.Fatalf("expected ShutdownError(SIGTERM), got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event loop did not stop after Shutdown")
+	}
+
+	if eng.EventQueue.HasActiveSources() {
+		t.Error("expected timers to be stopped")
+	}
+	if reason := eng.L.GetGlobal("shutdown_reason").String(); reason != "SIGTERM" {
+		t.Errorf("expected handler to receive SIGTERM, got %s", reason)
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR", ShutdownGrace: 100 * time.Millisecond})
+	defer eng.Close()
+
+	script := writeScript(t, `
+		on_shutdown(function() end)
+		on_shutdown(function() while true do end end)
+	`)
+
+	start := time.Now()
+	err := eng.RunWorkflow(script)
+	if err == nil {
+		t.Fatal("expected an error from the stuck handler")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handler was not interrupted after the grace period (%v)", elapsed)
+	}
+}
+
+func TestCancelLetsRunningNodesFinish(t *testing.T) {
+	eng := NewEngine(Config{LogLevel: "ERROR"})
+	defer eng.Close()
+	eng.SetContext(context.Background())
+
+	script := writeScript(t, `
+		local time = require("time")
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("cancel", {max_parallel = 1})
+		workflow.node(wf, "slow", function() time.sleep(0.3); slow_done = true end)
+		workflow.node(wf, "next", function() next_ran = true end, {depends_on = {"slow"}})
+		on_shutdown(function(reason) shutdown_reason = reason end)
+		local _, err = workflow.run(wf)
+		run_err = err
+	`)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		eng.Cancel()
+	}()
+
+	err := eng.RunWorkflow(script)
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) || shutdownErr.Reason != ShutdownReasonCancel {
+		t.Fatalf("expected ShutdownError(cancel), got %v", err)
+	}
+	if err := eng.Eval(`assert(slow_done and not next_ran, "nodes after the cancel ran")
+		assert(run_err == "workflow cancelled", tostring(run_err))
+		assert(shutdown_reason == "cancel", tostring(shutdown_reason))`); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,422 @@
+// Package history records script runs in a local SQLite database: their
+// parameters, outcome, output and the nodes of stdlib.workflow graphs.
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/config"
+
+	_ "modernc.org/sqlite"
+)
+
+// Run statuses
+const (
+	StatusQueued    = "queued" // waiting for the daemon to start it
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusStopped   = "stopped" // shut down by a signal or the daemon stopping
+	StatusAborted   = "aborted" // its process exited without recording the end (e.g. killed)
+)
+
+// ErrNotFound is returned for run IDs that match no run
+var ErrNotFound = errors.New("run not found")
+
+// Run is a recorded run of a script
+type Run struct {
+	ID     string `json:"id"`
+	Script string `json:"script"`
+	// Params are the named parameters the script was run with, Args its
+	// positional arguments. Callers leave out the values of secret
+	// parameters (see params.RedactScript).
+	Params   map[string]string `json:"params,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Trigger  string            `json:"trigger,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Started  time.Time         `json:"started"`
+	Finished *time.Time        `json:"finished,omitempty"`
+	Nodes    []Node            `json:"nodes,omitempty"`
+}
+
+// Duration is how long the run took, or has been running
+func (r *Run) Duration() time.Duration {
+	if r.Finished == nil {
+		return time.Since(r.Started)
+	}
+	return r.Finished.Sub(r.Started)
+}
+
+// Node is a stdlib.workflow node that finished during a run
+type Node struct {
+	Workflow string          `json:"workflow"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Started  time.Time       `json:"started"`
+	Duration time.Duration   `json:"duration_ns"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Line is a line a run printed or logged
+type Line struct {
+	// Seq numbers the lines of a run from 1
+	Seq  int       `json:"seq"`
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// Filter selects runs to list. Zero fields match every run.
+type Filter struct {
+	Status string
+	// Script matches runs whose script path contains it
+	Script string
+	Since  time.Time
+	Limit  int
+}
+
+// Store is a run history database
+type Store struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id          TEXT PRIMARY KEY,
+	script      TEXT NOT NULL,
+	params      TEXT NOT NULL DEFAULT '{}',
+	args        TEXT NOT NULL DEFAULT '[]',
+	trigger     TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL,
+	error       TEXT NOT NULL DEFAULT '',
+	started_at  INTEGER NOT NULL,
+	finished_at INTEGER,
+	pid         INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS runs_started_at ON runs (started_at);
+
+CREATE TABLE IF NOT EXISTS nodes (
+	run_id     TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	seq        INTEGER NOT NULL,
+	workflow   TEXT NOT NULL,
+	name       TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	started_at INTEGER NOT NULL,
+	duration   INTEGER NOT NULL,
+	result     TEXT,
+	error      TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (run_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS logs (
+	run_id TEXT NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	seq    INTEGER NOT NULL,
+	time   INTEGER NOT NULL,
+	text   TEXT NOT NULL,
+	PRIMARY KEY (run_id, seq)
+);
+`
+
+// DefaultPath is where the run history of the current user is kept
+func DefaultPath() string {
+	return filepath.Join(config.ConfigDir(), "history.db")
+}
+
+// Open opens the history database at path, creating it if needed
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// The CLI and a daemon may write at the same time
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open run history: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open run history %s: %w", path, err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open run history %s: %w", path, err)
+	}
+	if err := s.abortStale(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open run history %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// migrate adds the columns newer versions need to an existing database
+func (s *Store) migrate() error {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('runs') WHERE name = 'pid'`).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.db.Exec(`ALTER TABLE runs ADD COLUMN pid INTEGER NOT NULL DEFAULT 0`)
+		return err
+	}
+	return nil
+}
+
+// abortStale marks queued and running runs whose process is gone, as after
+// a SIGKILL or a crash, as aborted; they would otherwise stay running
+// forever. They are taken to have ended with their last line of output.
+func (s *Store) abortStale() error {
+	rows, err := s.db.Query(`SELECT id, pid FROM runs WHERE status IN (?, ?)`, StatusQueued, StatusRunning)
+	if err != nil {
+		return err
+	}
+	var stale []string
+	for rows.Next() {
+		var id string
+		var pid int
+		if err := rows.Scan(&id, &pid); err != nil {
+			rows.Close()
+			return err
+		}
+		if !alive(pid) {
+			stale = append(stale, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range stale {
+		_, err := s.db.Exec(`UPDATE runs SET status = ?, error = ?,
+			finished_at = COALESCE((SELECT MAX(time) FROM logs WHERE run_id = runs.id), started_at)
+			WHERE id = ? AND status IN (?, ?)`,
+			StatusAborted, "the process running it exited without recording the end of the run", id, StatusQueued, StatusRunning)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alive reports whether a process with the ID exists. Runs recorded before
+// process IDs were (pid 0) are taken to be gone.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = proc.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func marshal(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
+
+// Begin records the start of a run and returns a Recorder for its output
+// and nodes. run.Started defaults to now and run.Status to StatusRunning.
+func (s *Store) Begin(run Run) (*Recorder, error) {
+	if run.Started.IsZero() {
+		run.Started = time.Now()
+	}
+	if run.Status == "" {
+		run.Status = StatusRunning
+	}
+	if run.Params == nil {
+		run.Params = map[string]string{}
+	}
+	if run.Args == nil {
+		run.Args = []string{}
+	}
+	_, err := s.db.Exec(`INSERT INTO runs (id, script, params, args, trigger, status, started_at, pid) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.ID, run.Script, marshal(run.Params), marshal(run.Args), run.Trigger, run.Status, run.Started.UnixNano(), os.Getpid())
+	if err != nil {
+		return nil, fmt.Errorf("failed to record run: %w", err)
+	}
+	return &Recorder{store: s, id: run.ID}, nil
+}
+
+// start records that a queued run is running
+func (s *Store) start(id string) error {
+	_, err := s.db.Exec(`UPDATE runs SET status = ? WHERE id = ? AND status = ?`, StatusRunning, id, StatusQueued)
+	return err
+}
+
+// finish records how a run ended
+func (s *Store) finish(id, status, message string) error {
+	_, err := s.db.Exec(`UPDATE runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+		status, message, time.Now().UnixNano(), id)
+	return err
+}
+
+const runColumns = `id, script, params, args, trigger, status, error, started_at, finished_at`
+
+func scanRun(rows interface{ Scan(...interface{}) error }) (Run, error) {
+	var run Run
+	var params, args string
+	var started int64
+	var finished sql.NullInt64
+	if err := rows.Scan(&run.ID, &run.Script, &params, &args, &run.Trigger, &run.Status, &run.Error, &started, &finished); err != nil {
+		return run, err
+	}
+	json.Unmarshal([]byte(params), &run.Params)
+	json.Unmarshal([]byte(args), &run.Args)
+	run.Started = time.Unix(0, started)
+	if finished.Valid {
+		t := time.Unix(0, finished.Int64)
+		run.Finished = &t
+	}
+	return run, nil
+}
+
+// List returns the runs matching f, most recent first
+func (s *Store) List(f Filter) ([]Run, error) {
+	var where []string
+	var args []interface{}
+	if f.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Script != "" {
+		where = append(where, "instr(script, ?) > 0")
+		args = append(args, f.Script)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, f.Since.UnixNano())
+	}
+
+	query := "SELECT " + runColumns + " FROM runs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at DESC"
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []Run
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Get returns a run with its nodes. id may be a unique prefix of the ID,
+// as the short IDs 'vulgar runs list' prints.
+func (s *Store) Get(id string) (*Run, error) {
+	id, err := s.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	run, err := scanRun(s.db.QueryRow("SELECT "+runColumns+" FROM runs WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT workflow, name, status, started_at, duration, result, error FROM nodes WHERE run_id = ? ORDER BY seq`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var node Node
+		var started, duration int64
+		var result sql.NullString
+		if err := rows.Scan(&node.Workflow, &node.Name, &node.Status, &started, &duration, &result, &node.Error); err != nil {
+			return nil, err
+		}
+		node.Started = time.Unix(0, started)
+		node.Duration = time.Duration(duration)
+		if result.Valid {
+			node.Result = json.RawMessage(result.String)
+		}
+		run.Nodes = append(run.Nodes, node)
+	}
+	return &run, rows.Err()
+}
+
+// Logs returns the output of a run, in the order it was written
+func (s *Store) Logs(id string) ([]Line, error) {
+	return s.LogsAfter(id, 0)
+}
+
+// LogsAfter returns the output of a run after line seq, so a run can be
+// followed while it writes
+func (s *Store) LogsAfter(id string, seq int) ([]Line, error) {
+	id, err := s.resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT seq, time, text FROM logs WHERE run_id = ? AND seq > ? ORDER BY seq`, id, seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var lines []Line
+	for rows.Next() {
+		var line Line
+		var t int64
+		if err := rows.Scan(&line.Seq, &t, &line.Text); err != nil {
+			return nil, err
+		}
+		line.Time = time.Unix(0, t)
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// resolve expands an ID prefix to the full ID of a single run
+func (s *Store) resolve(prefix string) (string, error) {
+	rows, err := s.db.Query(`SELECT id FROM runs WHERE substr(id, 1, ?) = ? LIMIT 2`, len(prefix), prefix)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return "", err
+		}
+		ids = append(ids, id)
+	}
+	switch {
+	case prefix == "" || len(ids) == 0:
+		return "", fmt.Errorf("%w: %s", ErrNotFound, prefix)
+	case len(ids) > 1:
+		return "", fmt.Errorf("run ID %s is ambiguous", prefix)
+	}
+	return ids[0], rows.Err()
+}
@@ -0,0 +1,201 @@
+package history
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/engine"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	return openAt(t, filepath.Join(t.TempDir(), "history.db"))
+}
+
+func openAt(t *testing.T, path string) *Store {
+	t.Helper()
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRecordRun(t *testing.T) {
+	store := openStore(t)
+	script := filepath.Join(t.TempDir(), "sync.lua")
+	os.WriteFile(script, []byte(`
+local workflow = require("stdlib.workflow")
+local wf = workflow.new("sync", {max_parallel = 1})
+workflow.node(wf, "fetch", function(ctx) return {rows = 3} end)
+workflow.node(wf, "store", function(ctx) error("disk full") end, {depends_on = {"fetch"}})
+print("syncing", 3)
+log.info("starting")
+local _, err = workflow.run(wf)
+error(err)
+`), 0644)
+
+	rec, err := store.Begin(Run{ID: "run-1", Script: script, Params: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := engine.NewEngine(engine.Config{LogLevel: "INFO"})
+	defer eng.Close()
+	eng.SetOutput(rec)
+	eng.SetNodeObserver(rec.Node)
+	runErr := eng.RunWorkflow(script)
+	if runErr == nil {
+		t.Fatal("run succeeded")
+	}
+	rec.Finish(runErr)
+
+	run, err := store.Get("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != StatusFailed || !strings.Contains(run.Error, "disk full") || run.Finished == nil || run.Params["env"] != "prod" {
+		t.Errorf("run = %+v", run)
+	}
+	if len(run.Nodes) != 2 {
+		t.Fatalf("nodes = %+v", run.Nodes)
+	}
+	if n := run.Nodes[0]; n.Name != "fetch" || n.Status != "completed" || string(n.Result) != `{"rows":3}` {
+		t.Errorf("fetch = %+v", n)
+	}
+	if n := run.Nodes[1]; n.Name != "store" || n.Status != "failed" || !strings.Contains(n.Error, "disk full") {
+		t.Errorf("store = %+v", n)
+	}
+
+	lines, err := store.Logs("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].Text != "syncing\t3" || !strings.HasSuffix(lines[1].Text, "[INFO] starting") {
+		t.Errorf("logs = %+v", lines)
+	}
+}
+
+func TestList(t *testing.T) {
+	store := openStore(t)
+	start := time.Now().Add(-time.Hour)
+	for i, r := range []struct {
+		id, script string
+		err        error
+	}{
+		{"a1", "/srv/nightly.lua", nil},
+		{"a2", "/srv/nightly.lua", errors.New("boom")},
+		{"b1", "/srv/report.lua", &engine.ShutdownError{Reason: "SIGINT"}},
+	} {
+		rec, err := store.Begin(Run{ID: r.id, Script: r.script, Started: start.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Write([]byte("partial line"))
+		rec.Finish(r.err)
+	}
+
+	ids := func(f Filter) string {
+		runs, err := store.List(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, run := range runs {
+			ids = append(ids, run.ID+":"+run.Status)
+		}
+		return strings.Join(ids, " ")
+	}
+	tests := []struct {
+		filter Filter
+		want   string
+	}{
+		{Filter{}, "b1:stopped a2:failed a1:succeeded"},
+		{Filter{Status: StatusFailed}, "a2:failed"},
+		{Filter{Script: "nightly"}, "a2:failed a1:succeeded"},
+		{Filter{Since: start.Add(30 * time.Second)}, "b1:stopped a2:failed"},
+		{Filter{Limit: 1}, "b1:stopped"},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.filter); got != tt.want {
+			t.Errorf("List(%+v) = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+
+	if _, err := store.Get("a"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous prefix error = %v", err)
+	}
+	if _, err := store.Get("zz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ID error = %v", err)
+	}
+	if lines, _ := store.Logs("b1"); len(lines) != 1 || lines[0].Text != "partial line" {
+		t.Errorf("unterminated output = %+v", lines)
+	}
+	if lines, _ := store.LogsAfter("b1", 1); len(lines) != 0 {
+		t.Errorf("lines after the last = %+v", lines)
+	}
+}
+
+func TestOpenAbortsStaleRuns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	// A run recorded before process IDs were
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE runs (id TEXT PRIMARY KEY, script TEXT NOT NULL, params TEXT NOT NULL DEFAULT '{}',
+		args TEXT NOT NULL DEFAULT '[]', trigger TEXT NOT NULL DEFAULT '', status TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT '', started_at INTEGER NOT NULL, finished_at INTEGER);
+		INSERT INTO runs (id, script, status, started_at) VALUES ('legacy', '/srv/old.lua', 'running', 1)`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Begin(Run{ID: "live", Script: "/srv/live.lua"}); err != nil {
+		t.Fatal(err)
+	}
+	killed, err := store.Begin(Run{ID: "killed", Script: "/srv/killed.lua"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	killed.Write([]byte("last words\n"))
+
+	// Record the killed run under a process that has exited
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	if _, err := store.db.Exec(`UPDATE runs SET pid = ? WHERE id = 'killed'`, cmd.Process.Pid); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openAt(t, path)
+	for id, want := range map[string]string{"legacy": StatusAborted, "killed": StatusAborted, "live": StatusRunning} {
+		run, err := store.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run.Status != want {
+			t.Errorf("%s: status = %s, want %s", id, run.Status, want)
+		}
+		if want == StatusAborted && (run.Finished == nil || run.Error == "") {
+			t.Errorf("%s: run = %+v", id, run)
+		}
+	}
+	if run, _ := store.Get("killed"); run.Finished == nil || run.Finished.Before(run.Started) {
+		t.Errorf("killed run should end with its last line, got %+v", run)
+	}
+}
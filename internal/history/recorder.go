@@ -0,0 +1,114 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// Recorder records the output and workflow nodes of a running script. It
+// is an io.Writer for engine.SetOutput and its Node method an observer for
+// engine.SetNodeObserver; both are safe for concurrent use.
+//
+// Failing to record never fails the run: the first error is printed to
+// stderr and later ones are dropped.
+type Recorder struct {
+	store *Store
+	id    string
+
+	mu      sync.Mutex
+	partial []byte
+	lines   int
+	nodes   int
+	failed  bool
+}
+
+// ID returns the ID of the recorded run
+func (r *Recorder) ID() string {
+	return r.id
+}
+
+// Start records that a run begun as StatusQueued is running
+func (r *Recorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.check(r.store.start(r.id))
+}
+
+// Write records every complete line of p. It always succeeds, so a
+// recorder can be combined with stdout in an io.MultiWriter.
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.partial = append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.writeLine(string(r.partial[:i]))
+		r.partial = r.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// writeLine stores a line. Callers hold r.mu.
+func (r *Recorder) writeLine(text string) {
+	r.lines++
+	_, err := r.store.db.Exec(`INSERT INTO logs (run_id, seq, time, text) VALUES (?, ?, ?, ?)`,
+		r.id, r.lines, time.Now().UnixNano(), text)
+	r.check(err)
+}
+
+// Node records a finished stdlib.workflow node
+func (r *Recorder) Node(report util.NodeReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result interface{}
+	if report.Result != nil {
+		result = marshal(report.Result)
+	}
+	r.nodes++
+	_, err := r.store.db.Exec(`INSERT INTO nodes (run_id, seq, workflow, name, status, started_at, duration, result, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.id, r.nodes, report.Workflow, report.Node, report.Status, report.Started.UnixNano(), int64(report.Duration), result, report.Error)
+	r.check(err)
+}
+
+// Finish records how the run ended: succeeded when err is nil, stopped
+// when it was shut down, and failed otherwise
+func (r *Recorder) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.partial) > 0 {
+		r.writeLine(string(r.partial))
+		r.partial = nil
+	}
+
+	status, message := StatusSucceeded, ""
+	var shutdownErr *engine.ShutdownError
+	switch {
+	case errors.As(err, &shutdownErr):
+		status, message = StatusStopped, err.Error()
+	case err != nil:
+		status, message = StatusFailed, err.Error()
+	}
+	r.check(r.store.finish(r.id, status, message))
+}
+
+// check reports the first error recording the run. Callers hold r.mu.
+func (r *Recorder) check(err error) {
+	if err == nil || r.failed {
+		return
+	}
+	r.failed = true
+	fmt.Fprintf(os.Stderr, "Warning: failed to record run %s in the history: %v\n", r.id, err)
+}
@@ -281,6 +281,11 @@ func (c *Client) PostForm(ctx context.Context, urlPath string, data url.Values)
 	return NewResponse(resp)
 }
 
+// ResolveURL returns the absolute URL a request for path would be sent to
+func (c *Client) ResolveURL(path string) string {
+	return c.buildURL(path)
+}
+
 // buildURL combines the base URL with a path
 func (c *Client) buildURL(path string) string {
 	if c.baseURL == "" {
@@ -1,6 +1,7 @@
 package httpclient
 
 import (
+	"errors"
 	"net/http"
 	"time"
 )
@@ -130,3 +131,25 @@ func WithMaxRedirects(max int) Option {
 		}
 	}
 }
+
+// WithRedirectCheck runs check before every redirect is followed, after the
+// redirect policy the client already has. An error from check stops the
+// request.
+func WithRedirectCheck(check func(req *http.Request) error) Option {
+	return func(c *Client) {
+		// Copy the http.Client, which clones share
+		httpClient := *c.httpClient
+		previous := httpClient.CheckRedirect
+		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if previous != nil {
+				if err := previous(req, via); err != nil {
+					return err
+				}
+			} else if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return check(req)
+		}
+		c.httpClient = &httpClient
+	}
+}
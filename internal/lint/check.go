@@ -0,0 +1,441 @@
+package lint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/zepzeper/vulgar/internal/project"
+)
+
+// checker walks the syntax tree of one script, tracking local scopes
+type checker struct {
+	linter  *Linter
+	file    string
+	dir     string
+	project *project.Project
+	scope   *scope
+	diags   []Diagnostic
+
+	// Globals the script assigns anywhere, and the reads to check against
+	// them once the whole script has been seen
+	globalWrites  map[string]bool
+	globalReads   []globalRead
+	globalModules map[string]*module
+}
+
+type globalRead struct {
+	name string
+	line int
+}
+
+type scope struct {
+	parent *scope
+	vars   []*variable
+}
+
+type variable struct {
+	name     string
+	line     int
+	used     bool
+	param    bool // parameters and loop variables are not reported unused
+	function bool
+	// module is set while the variable holds the result of require()
+	module *module
+}
+
+func newChecker(l *Linter, path string) *checker {
+	dir := filepath.Dir(path)
+	p, _ := project.Find(dir)
+	return &checker{
+		linter:        l,
+		file:          path,
+		dir:           dir,
+		project:       p,
+		globalWrites:  make(map[string]bool),
+		globalModules: make(map[string]*module),
+	}
+}
+
+func (c *checker) report(line int, rule, hint, format string, args ...interface{}) {
+	c.diags = append(c.diags, Diagnostic{
+		File:     c.file,
+		Line:     line,
+		Rule:     rule,
+		Severity: severityOf(rule),
+		Message:  fmt.Sprintf(format, args...),
+		Hint:     hint,
+	})
+}
+
+func (c *checker) chunk(stmts []ast.Stmt) {
+	c.block(stmts)
+	for _, read := range c.globalReads {
+		if !c.globalWrites[read.name] && !c.linter.globals[read.name] {
+			c.report(read.line, RuleUndefinedGlobal, "", "undefined global %s", read.name)
+		}
+	}
+}
+
+func (c *checker) open() {
+	c.scope = &scope{parent: c.scope}
+}
+
+// close leaves the current scope, reporting its unused locals
+func (c *checker) close() {
+	for _, v := range c.scope.vars {
+		if v.used || v.param || strings.HasPrefix(v.name, "_") {
+			continue
+		}
+		kind := "variable"
+		if v.function {
+			kind = "function"
+		}
+		c.report(v.line, RuleUnusedLocal, "", "unused local %s %s", kind, v.name)
+	}
+	c.scope = c.scope.parent
+}
+
+func (c *checker) declare(name string, line int) *variable {
+	v := &variable{name: name, line: line}
+	c.scope.vars = append(c.scope.vars, v)
+	return v
+}
+
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if s.vars[i].name == name {
+				return s.vars[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) block(stmts []ast.Stmt) {
+	c.open()
+	c.stmts(stmts)
+	c.close()
+}
+
+func (c *checker) stmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		c.stmt(stmt)
+	}
+}
+
+func (c *checker) stmt(stmt ast.Stmt) {
+	switch s := stmt.(type) {
+	case *ast.LocalAssignStmt:
+		// local function f() is visible inside its own body
+		if len(s.Names) == 1 && len(s.Exprs) == 1 {
+			if fn, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				c.declare(s.Names[0], s.Line()).function = true
+				c.function(fn, false)
+				return
+			}
+		}
+		c.exprs(s.Exprs)
+		c.checkIgnoredErrors(s.Exprs, len(s.Names))
+		for i, name := range s.Names {
+			v := c.declare(name, s.Line())
+			if i < len(s.Exprs) {
+				v.module = c.required(s.Exprs[i])
+			}
+		}
+
+	case *ast.AssignStmt:
+		c.exprs(s.Rhs)
+		c.checkIgnoredErrors(s.Rhs, len(s.Lhs))
+		for i, lhs := range s.Lhs {
+			var m *module
+			if i < len(s.Rhs) {
+				m = c.required(s.Rhs[i])
+			}
+			c.assign(lhs, m)
+		}
+
+	case *ast.FuncCallStmt:
+		c.expr(s.Expr)
+		c.checkIgnoredErrors([]ast.Expr{s.Expr}, 0)
+
+	case *ast.DoBlockStmt:
+		c.block(s.Stmts)
+
+	case *ast.WhileStmt:
+		c.expr(s.Condition)
+		c.block(s.Stmts)
+
+	case *ast.RepeatStmt:
+		// The condition sees the locals of the body
+		c.open()
+		c.stmts(s.Stmts)
+		c.expr(s.Condition)
+		c.close()
+
+	case *ast.IfStmt:
+		c.expr(s.Condition)
+		c.block(s.Then)
+		c.block(s.Else)
+
+	case *ast.NumberForStmt:
+		c.exprs([]ast.Expr{s.Init, s.Limit, s.Step})
+		c.open()
+		c.declare(s.Name, s.Line()).param = true
+		c.stmts(s.Stmts)
+		c.close()
+
+	case *ast.GenericForStmt:
+		c.exprs(s.Exprs)
+		c.open()
+		for _, name := range s.Names {
+			c.declare(name, s.Line()).param = true
+		}
+		c.stmts(s.Stmts)
+		c.close()
+
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			c.assign(s.Name.Func, nil)
+			c.function(s.Func, false)
+		} else {
+			c.expr(s.Name.Receiver)
+			c.function(s.Func, true)
+		}
+
+	case *ast.ReturnStmt:
+		c.exprs(s.Exprs)
+	}
+}
+
+// function checks a function body in a scope holding its parameters
+func (c *checker) function(fn *ast.FunctionExpr, method bool) {
+	c.open()
+	if method {
+		c.declare("self", fn.Line()).param = true
+	}
+	for _, name := range fn.ParList.Names {
+		c.declare(name, fn.Line()).param = true
+	}
+	c.stmts(fn.Stmts)
+	c.close()
+}
+
+// assign records a write to a variable or field; m is the module assigned,
+// if the value is a require() call
+func (c *checker) assign(lhs ast.Expr, m *module) {
+	switch target := lhs.(type) {
+	case *ast.IdentExpr:
+		if v := c.lookup(target.Value); v != nil {
+			v.module = m
+			return
+		}
+		if c.globalWrites[target.Value] && c.globalModules[target.Value] != m {
+			// Assigned more than once: no longer known to be the module
+			m = nil
+		}
+		c.globalWrites[target.Value] = true
+		c.globalModules[target.Value] = m
+	case *ast.AttrGetExpr:
+		// Setting a field, which may add to a module table
+		c.expr(target.Object)
+		c.expr(target.Key)
+	default:
+		c.expr(lhs)
+	}
+}
+
+func (c *checker) exprs(exprs []ast.Expr) {
+	for _, e := range exprs {
+		c.expr(e)
+	}
+}
+
+func (c *checker) expr(expr ast.Expr) {
+	switch e := expr.(type) {
+	case nil:
+	case *ast.IdentExpr:
+		if v := c.lookup(e.Value); v != nil {
+			v.used = true
+		} else {
+			c.globalReads = append(c.globalReads, globalRead{name: e.Value, line: e.Line()})
+		}
+	case *ast.AttrGetExpr:
+		c.expr(e.Object)
+		c.expr(e.Key)
+		if key, ok := e.Key.(*ast.StringExpr); ok {
+			c.checkExport(e.Object, key.Value, e.Line())
+		}
+	case *ast.FuncCallExpr:
+		if e.Func != nil {
+			c.expr(e.Func)
+			c.checkRequire(e)
+		} else {
+			c.expr(e.Receiver)
+			c.checkExport(e.Receiver, e.Method, e.Line())
+		}
+		c.exprs(e.Args)
+	case *ast.FunctionExpr:
+		c.function(e, false)
+	case *ast.TableExpr:
+		for _, field := range e.Fields {
+			c.expr(field.Key)
+			c.expr(field.Value)
+		}
+	case *ast.LogicalOpExpr:
+		c.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.RelationalOpExpr:
+		c.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.StringConcatOpExpr:
+		c.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.ArithmeticOpExpr:
+		c.exprs([]ast.Expr{e.Lhs, e.Rhs})
+	case *ast.UnaryMinusOpExpr:
+		c.expr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		c.expr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		c.expr(e.Expr)
+	}
+}
+
+// requireName returns the module name of a require("name") call
+func (c *checker) requireName(expr ast.Expr) (string, bool) {
+	call, ok := expr.(*ast.FuncCallExpr)
+	if !ok || len(call.Args) == 0 {
+		return "", false
+	}
+	fn, ok := call.Func.(*ast.IdentExpr)
+	if !ok || fn.Value != "require" || c.lookup("require") != nil {
+		return "", false
+	}
+	name, ok := call.Args[0].(*ast.StringExpr)
+	if !ok {
+		return "", false
+	}
+	return name.Value, true
+}
+
+// required returns the built-in or plugin module expr requires, if any
+func (c *checker) required(expr ast.Expr) *module {
+	name, ok := c.requireName(expr)
+	if !ok {
+		return nil
+	}
+	return c.linter.module(name)
+}
+
+func (c *checker) checkRequire(call *ast.FuncCallExpr) {
+	name, ok := c.requireName(call)
+	if !ok || c.linter.module(name) != nil || c.linter.findModule(name, c.dir, c.project) {
+		return
+	}
+	hint := ""
+	if c.linter.ModuleHint != nil {
+		hint = c.linter.ModuleHint(name)
+	}
+	c.report(call.Line(), RuleUnknownModule, hint, "unknown module %q", name)
+}
+
+// boundModule returns the module a variable holds
+func (c *checker) boundModule(expr ast.Expr) (string, *module) {
+	ident, ok := expr.(*ast.IdentExpr)
+	if !ok {
+		return "", nil
+	}
+	if v := c.lookup(ident.Value); v != nil {
+		return ident.Value, v.module
+	}
+	return ident.Value, c.globalModules[ident.Value]
+}
+
+func (c *checker) checkExport(object ast.Expr, name string, line int) {
+	variable, m := c.boundModule(object)
+	if m == nil || m.exports == nil || m.exports[name] {
+		return
+	}
+	hint := ""
+	if similar := closestName(name, m.exports); similar != "" {
+		hint = fmt.Sprintf("Did you mean %s.%s?", variable, similar)
+	}
+	c.report(line, RuleUnknownFunction, hint, "module %q has no function %s", m.name, name)
+}
+
+// checkIgnoredErrors reports calls among exprs to functions returning
+// (result, err) whose error is not assigned to one of targets variables
+func (c *checker) checkIgnoredErrors(exprs []ast.Expr, targets int) {
+	for i, expr := range exprs {
+		call, ok := expr.(*ast.FuncCallExpr)
+		if !ok {
+			continue
+		}
+		attr, ok := call.Func.(*ast.AttrGetExpr)
+		if !ok {
+			continue
+		}
+		key, ok := attr.Key.(*ast.StringExpr)
+		if !ok {
+			continue
+		}
+		variable, m := c.boundModule(attr.Object)
+		if m == nil || !m.errors[key.Value] {
+			continue
+		}
+
+		// Only the last expression can spread into several variables
+		received := 0
+		if i == len(exprs)-1 {
+			received = targets - i
+		} else if i < targets {
+			received = 1
+		}
+		if received >= 2 {
+			continue
+		}
+		c.report(call.Line(), RuleIgnoredError,
+			fmt.Sprintf("Use: local result, err = %s.%s(...) and handle err", variable, key.Value),
+			"error returned by %s.%s is ignored", variable, key.Value)
+	}
+}
+
+// closestName returns the name in names nearest to name, if it is a likely
+// typo
+func closestName(name string, names map[string]bool) string {
+	candidates := make([]string, 0, len(names))
+	for n := range names {
+		candidates = append(candidates, n)
+	}
+	sort.Strings(candidates)
+
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,247 @@
+// Package lint checks vulgar scripts for mistakes that only show up when a
+// script runs: requires of unknown modules, calls to functions a module does
+// not export, ignored error returns, undefined globals and unused locals.
+//
+// Module exports and globals are read from a Lua state set up like the one
+// scripts run in, so the checks follow the modules of the build. A finding
+// is silenced by a comment on its line:
+//
+//	local data = json.decode(body) -- vulgar:ignore ignored-error
+package lint
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"github.com/zepzeper/vulgar/internal/modules/docs"
+	"github.com/zepzeper/vulgar/internal/project"
+)
+
+// Rule IDs
+const (
+	RuleSyntax          = "syntax"
+	RuleUnknownModule   = "unknown-module"
+	RuleUnknownFunction = "unknown-function"
+	RuleIgnoredError    = "ignored-error"
+	RuleUndefinedGlobal = "undefined-global"
+	RuleUnusedLocal     = "unused-local"
+)
+
+// Severity of a diagnostic
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Rule describes a check
+type Rule struct {
+	ID          string
+	Severity    Severity
+	Description string
+}
+
+// Rules lists the checks the linter runs
+var Rules = []Rule{
+	{RuleSyntax, SeverityError, "The script does not parse"},
+	{RuleUnknownModule, SeverityError, "require() of a module that is neither built in, a plugin, nor found on the project's path"},
+	{RuleUnknownFunction, SeverityError, "Use of a function a module does not export"},
+	{RuleIgnoredError, SeverityWarning, "The error returned by a module function is discarded"},
+	{RuleUndefinedGlobal, SeverityWarning, "Read of a global that is never assigned"},
+	{RuleUnusedLocal, SeverityWarning, "Local variable or function that is never read"},
+}
+
+func severityOf(rule string) Severity {
+	for _, r := range Rules {
+		if r.ID == rule {
+			return r.Severity
+		}
+	}
+	return SeverityWarning
+}
+
+// Diagnostic is a problem found in a script
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	Hint     string   `json:"hint,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s [%s]", d.File, d.Line, d.Severity, d.Message, d.Rule)
+}
+
+// Linter checks scripts against the modules and globals of a Lua state
+type Linter struct {
+	L *lua.LState
+	// ModuleHint suggests a fix for a require of an unknown module
+	ModuleHint func(name string) string
+
+	globals map[string]bool
+	opaque  map[string]bool
+	modules map[string]*module
+}
+
+// New returns a linter for scripts run in L, normally the state of an engine
+// that has not run anything yet. The exports of opaque modules, such as
+// plugins, are not checked; they are not loaded either.
+func New(L *lua.LState, opaque []string) *Linter {
+	l := &Linter{
+		L:       L,
+		globals: map[string]bool{"arg": true},
+		opaque:  make(map[string]bool),
+		modules: make(map[string]*module),
+	}
+	L.G.Global.ForEach(func(k, _ lua.LValue) {
+		if name, ok := k.(lua.LString); ok {
+			l.globals[string(name)] = true
+		}
+	})
+	for _, name := range opaque {
+		l.opaque[name] = true
+	}
+	return l
+}
+
+// LintFile checks the script at path
+func (l *Linter) LintFile(path string) ([]Diagnostic, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return l.Lint(source, path), nil
+}
+
+var syntaxLinePattern = regexp.MustCompile(`line:(\d+)`)
+
+// Lint checks the source of the script at path, sorted by line
+func (l *Linter) Lint(source []byte, path string) []Diagnostic {
+	chunk, err := parse.Parse(strings.NewReader(string(source)), path)
+	if err != nil {
+		line := 1
+		if m := syntaxLinePattern.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return []Diagnostic{{File: path, Line: line, Rule: RuleSyntax, Severity: SeverityError,
+			Message: strings.TrimSpace(strings.TrimPrefix(err.Error(), path))}}
+	}
+
+	c := newChecker(l, path)
+	c.chunk(chunk)
+
+	ignores := ignoredRules(source)
+	var diags []Diagnostic
+	for _, d := range c.diags {
+		if rules, ok := ignores[d.Line]; ok && (len(rules) == 0 || rules[d.Rule]) {
+			continue
+		}
+		diags = append(diags, d)
+	}
+	sort.SliceStable(diags, func(i, j int) bool { return diags[i].Line < diags[j].Line })
+	return diags
+}
+
+var ignorePattern = regexp.MustCompile(`--.*vulgar:ignore\b([\w\-, ]*)`)
+
+// ignoredRules maps line numbers to the rules a vulgar:ignore comment
+// silences there. An empty set silences all rules.
+func ignoredRules(source []byte) map[int]map[string]bool {
+	ignores := make(map[int]map[string]bool)
+	for i, line := range strings.Split(string(source), "\n") {
+		m := ignorePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		rules := make(map[string]bool)
+		for _, rule := range strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || r == ' ' }) {
+			rules[rule] = true
+		}
+		ignores[i+1] = rules
+	}
+	return ignores
+}
+
+// module is what the linter knows about a required module
+type module struct {
+	name string
+	// exports is nil when the module's contents are unknown
+	exports map[string]bool
+	// errors holds exported functions that return (result, err)
+	errors map[string]bool
+}
+
+// module returns a built-in or plugin module, or nil if name is not one
+func (l *Linter) module(name string) *module {
+	if m, ok := l.modules[name]; ok {
+		return m
+	}
+	pkg := l.L.GetGlobal("package")
+	if l.L.GetField(l.L.GetField(pkg, "preload"), name) == lua.LNil &&
+		l.L.GetField(l.L.GetField(pkg, "loaded"), name) == lua.LNil {
+		l.modules[name] = nil
+		return nil
+	}
+
+	m := &module{name: name}
+	l.modules[name] = m
+	if l.opaque[name] {
+		return m
+	}
+
+	contents, err := docs.Load(l.L, name)
+	if err != nil {
+		return m
+	}
+	m.exports = make(map[string]bool)
+	m.errors = make(map[string]bool)
+	for key, doc := range contents.Exports {
+		m.exports[key] = true
+		m.errors[key] = doc.ReturnsError
+	}
+	return m
+}
+
+// findModule reports whether require(name) finds a Lua file for a script in
+// dir: in its project's lib directories and dependencies, or on
+// package.path relative to the working or the script's directory
+func (l *Linter) findModule(name, dir string, p *project.Project) bool {
+	if p != nil {
+		root := strings.SplitN(name, ".", 2)[0]
+		if _, declared := p.Manifest.Dependencies[root]; declared {
+			return true
+		}
+	}
+
+	path := lua.LVAsString(l.L.GetField(l.L.GetGlobal("package"), "path"))
+	if p != nil {
+		path = p.PackagePath() + ";" + path
+	}
+	file := strings.ReplaceAll(name, ".", string(os.PathSeparator))
+	for _, pattern := range strings.Split(path, ";") {
+		if pattern == "" {
+			continue
+		}
+		candidate := strings.ReplaceAll(pattern, "?", file)
+		candidates := []string{candidate}
+		if !filepath.IsAbs(candidate) {
+			candidates = append(candidates, filepath.Join(dir, candidate))
+		}
+		for _, c := range candidates {
+			if info, err := os.Stat(c); err == nil && !info.IsDir() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zepzeper/vulgar/internal/engine"
+)
+
+func newTestLinter(t *testing.T) *Linter {
+	t.Helper()
+	eng := engine.NewEngine(engine.Config{LogLevel: "ERROR"})
+	t.Cleanup(eng.Close)
+	l := New(eng.L, nil)
+	l.ModuleHint = engine.ModuleHint
+	return l
+}
+
+// findings returns "line:rule" for each diagnostic
+func findings(diags []Diagnostic) []string {
+	var out []string
+	for _, d := range diags {
+		out = append(out, fmt.Sprintf("%d:%s", d.Line, d.Rule))
+	}
+	return out
+}
+
+func TestLintRules(t *testing.T) {
+	source := `local http = require("http")
+local json = require("json")
+local timer = require("timer")
+
+local unused = 1
+local data = json.decode("{}")
+http.gett("https://example.com")
+local resp, err = http.get("https://example.com")
+if err then print(err) end
+print(resp, data, missing_global)
+json.encode({}) -- vulgar:ignore ignored-error
+
+function RunWorkflow()
+  count = (count or 0) + 1
+end
+
+local function helper(a, b) return a end
+for i, v in ipairs({}) do end
+local _ignored = timer
+`
+	diags := newTestLinter(t).Lint([]byte(source), "script.lua")
+	want := []string{
+		"3:unknown-module",
+		"5:unused-local",
+		"6:ignored-error",
+		"7:unknown-function",
+		"10:undefined-global",
+		"17:unused-local",
+	}
+	if got := findings(diags); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("findings = %v\nwant       %v", got, want)
+	}
+
+	for _, d := range diags {
+		switch d.Rule {
+		case RuleUnknownModule:
+			if !strings.Contains(d.Hint, `require("stdlib.timer")`) {
+				t.Errorf("unknown module hint = %q", d.Hint)
+			}
+		case RuleUnknownFunction:
+			if d.Hint != "Did you mean http.get?" || d.Severity != SeverityError {
+				t.Errorf("unknown function = %+v", d)
+			}
+		}
+	}
+}
+
+func TestLintScopes(t *testing.T) {
+	source := `local x = 1
+do
+  local x = 2
+  print(x)
+end
+local function fact(n)
+  if n <= 1 then return 1 end
+  return n * fact(n - 1)
+end
+repeat local done = true until done
+print(fact(3))
+`
+	diags := newTestLinter(t).Lint([]byte(source), "script.lua")
+	if got := findings(diags); len(got) != 1 || got[0] != "1:unused-local" {
+		t.Errorf("findings = %v, want only the shadowed x on line 1", got)
+	}
+}
+
+func TestLintProjectModulesAndSyntaxErrors(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"vulgar.toml":     "[dependencies]\nslackfmt = { path = \"../slackfmt\" }\n",
+		"lib/helpers.lua": "return {}",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := newTestLinter(t)
+	script := filepath.Join(root, "scripts", "main.lua")
+	diags := l.Lint([]byte("print(require('helpers'), require('slackfmt'))\n"), script)
+	if len(diags) != 0 {
+		t.Errorf("project modules reported: %v", diags)
+	}
+
+	diags = l.Lint([]byte("local x = \nif"), script)
+	if len(diags) != 1 || diags[0].Rule != RuleSyntax || diags[0].Line != 2 {
+		t.Errorf("syntax error = %+v", diags)
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	diags := []Diagnostic{{File: "dir/script.lua", Line: 3, Rule: RuleUnknownModule, Severity: SeverityError,
+		Message: `unknown module "x"`, Hint: "Run 'vulgar --list-modules' to see available modules"}}
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, diags, "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Tool.Driver.Rules) != len(Rules) {
+		t.Fatalf("unexpected log: %s", buf.String())
+	}
+	result := log.Runs[0].Results[0]
+	loc := result.Locations[0].PhysicalLocation
+	if result.RuleID != RuleUnknownModule || result.Level != "error" ||
+		loc.ArtifactLocation.URI != "dir/script.lua" || loc.Region.StartLine != 3 {
+		t.Errorf("result = %+v", result)
+	}
+}
@@ -0,0 +1,318 @@
+package lsp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/zepzeper/vulgar/internal/modules/docs"
+)
+
+var (
+	// local json = require("json")
+	bindingPattern = regexp.MustCompile(`local\s+([A-Za-z_]\w*)\s*=\s*require\s*\(?\s*["']([\w.\-]+)["']`)
+	// require("stdlib.ti| or require 'ti|
+	requirePrefixPattern = regexp.MustCompile(`require\s*\(?\s*["']([\w.\-]*)$`)
+	// json.dec|
+	memberPrefixPattern = regexp.MustCompile(`([A-Za-z_]\w*)\.(\w*)$`)
+	// json.decode( before a call's parenthesis
+	calleePattern = regexp.MustCompile(`([A-Za-z_]\w*)\.(\w+)\s*$`)
+)
+
+// bindings maps the locals of a script that hold required modules to the
+// module names
+func bindings(text string) map[string]string {
+	b := make(map[string]string)
+	for _, m := range bindingPattern.FindAllStringSubmatch(text, -1) {
+		b[m[1]] = m[2]
+	}
+	return b
+}
+
+func (s *Server) completion(text string, pos position) completionList {
+	lines := splitLines(text)
+	if pos.Line >= len(lines) {
+		return completionList{Items: []completionItem{}}
+	}
+	line := lines[pos.Line]
+	prefix := line[:byteOffset(line, pos.Character)]
+
+	items := []completionItem{}
+	if m := requirePrefixPattern.FindStringSubmatch(prefix); m != nil {
+		// Replace the whole typed name, since editors split words at dots
+		edit := lspRange{
+			Start: position{Line: pos.Line, Character: pos.Character - utf16Len(m[1])},
+			End:   pos,
+		}
+		for _, name := range s.names {
+			items = append(items, completionItem{
+				Label:    name,
+				Kind:     kindModule,
+				TextEdit: &textEdit{Range: edit, NewText: name},
+			})
+		}
+		return completionList{Items: items}
+	}
+
+	m := memberPrefixPattern.FindStringSubmatch(prefix)
+	if m == nil {
+		return completionList{Items: items}
+	}
+	name, ok := bindings(text)[m[1]]
+	if !ok {
+		return completionList{Items: items}
+	}
+	mod := s.module(name)
+	if mod == nil {
+		return completionList{Items: items}
+	}
+	for _, export := range sortedExports(mod) {
+		item := completionItem{Label: export, Kind: kindField}
+		if mod.Functions[export] {
+			item.Kind = kindFunction
+			doc := mod.Exports[export]
+			item.Detail, _ = doc.Signature(export)
+			item.Documentation = functionDoc(doc)
+		}
+		items = append(items, item)
+	}
+	return completionList{Items: items}
+}
+
+func (s *Server) hover(text string, pos position) *hover {
+	lines := splitLines(text)
+	if pos.Line >= len(lines) {
+		return nil
+	}
+	line := lines[pos.Line]
+	at := byteOffset(line, pos.Character)
+
+	// The dotted name up to the end of the word under the cursor, so
+	// hovering json in json.decode describes the module
+	start, end := at, at
+	for start > 0 && (isWordByte(line[start-1]) || line[start-1] == '.') {
+		start--
+	}
+	for end < len(line) && isWordByte(line[end]) {
+		end++
+	}
+	parts := strings.Split(line[start:end], ".")
+	if len(parts) > 2 {
+		parts = parts[len(parts)-2:]
+	}
+	name, ok := bindings(text)[parts[0]]
+	if !ok {
+		return nil
+	}
+	mod := s.module(name)
+	if mod == nil {
+		return nil
+	}
+
+	var contents string
+	switch len(parts) {
+	case 1:
+		contents = fmt.Sprintf("```lua\nlocal %s = require(%q)\n```\n\n%s",
+			parts[0], name, strings.Join(sortedExports(mod), ", "))
+	case 2:
+		if !mod.Functions[parts[1]] {
+			return nil
+		}
+		doc := functionDoc(mod.Exports[parts[1]])
+		if doc == nil {
+			contents = fmt.Sprintf("```lua\nfunction %s.%s(...)\n```", parts[0], parts[1])
+		} else {
+			contents = doc.Value
+		}
+	}
+	r := lspRange{
+		Start: position{Line: pos.Line, Character: utf16Len(line[:start])},
+		End:   position{Line: pos.Line, Character: utf16Len(line[:end])},
+	}
+	return &hover{Contents: markupContent{Kind: "markdown", Value: contents}, Range: &r}
+}
+
+func (s *Server) signatureHelp(text string, pos position) *signatureHelp {
+	lines := splitLines(text)
+	if pos.Line >= len(lines) {
+		return nil
+	}
+	raw := strings.Split(text, "\n")
+	offset := byteOffset(raw[pos.Line], pos.Character)
+	for _, line := range raw[:pos.Line] {
+		offset += len(line) + 1
+	}
+	call, ok := openCall(text[:min(offset, len(text))])
+	if !ok {
+		return nil
+	}
+
+	m := calleePattern.FindStringSubmatch(text[:call.paren])
+	if m == nil {
+		return nil
+	}
+	name, ok := bindings(text)[m[1]]
+	if !ok {
+		return nil
+	}
+	mod := s.module(name)
+	if mod == nil || !mod.Functions[m[2]] {
+		return nil
+	}
+	doc := mod.Exports[m[2]]
+	label, params := doc.Signature(m[2])
+	if label == "" {
+		return nil
+	}
+
+	sig := signatureInformation{Label: label, Parameters: []parameterInformation{}}
+	if doc.Summary != "" {
+		sig.Documentation = &markupContent{Kind: "markdown", Value: doc.Summary}
+	}
+	for _, p := range params {
+		sig.Parameters = append(sig.Parameters, parameterInformation{Label: p})
+	}
+	return &signatureHelp{Signatures: []signatureInformation{sig}, ActiveParameter: call.commas}
+}
+
+// call is a call whose argument list is still open
+type call struct {
+	// paren is the offset of its opening parenthesis
+	paren int
+	// commas counts the arguments before the current one
+	commas int
+}
+
+// openCall finds the innermost unclosed call in text, skipping strings and
+// comments. Tables in the arguments, as in f(a, {b, c, belong to the call.
+func openCall(text string) (call, bool) {
+	type bracket struct {
+		char   byte
+		pos    int
+		commas int
+	}
+	var stack []bracket
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '"', '\'':
+			for i++; i < len(text) && text[i] != c && text[i] != '\n'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+		case '-':
+			if strings.HasPrefix(text[i:], "--") {
+				if end, ok := longBracketEnd(text, i+2); ok {
+					i = end
+				} else if nl := strings.IndexByte(text[i:], '\n'); nl >= 0 {
+					i += nl
+				} else {
+					i = len(text)
+				}
+			}
+		case '[':
+			if end, ok := longBracketEnd(text, i); ok {
+				i = end
+				continue
+			}
+			stack = append(stack, bracket{char: c, pos: i})
+		case '(', '{':
+			stack = append(stack, bracket{char: c, pos: i})
+		case ')', '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ',':
+			if len(stack) > 0 {
+				stack[len(stack)-1].commas++
+			}
+		}
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].char == '(' {
+			return call{paren: stack[i].pos, commas: stack[i].commas}, true
+		}
+	}
+	return call{}, false
+}
+
+// longBracketEnd returns the offset of the last byte of a long string or
+// comment such as [[...]] or [==[...]==] opening at i. An unclosed one runs
+// to the end of text.
+func longBracketEnd(text string, i int) (int, bool) {
+	j := i + 1
+	for j < len(text) && text[j] == '=' {
+		j++
+	}
+	if i >= len(text) || text[i] != '[' || j >= len(text) || text[j] != '[' {
+		return 0, false
+	}
+	closing := "]" + strings.Repeat("=", j-i-1) + "]"
+	end := strings.Index(text[j+1:], closing)
+	if end < 0 {
+		return len(text), true
+	}
+	return j + 1 + end + len(closing) - 1, true
+}
+
+// functionDoc renders the usage examples and summary of a function
+func functionDoc(doc docs.Function) *markupContent {
+	if len(doc.Usage) == 0 && doc.Summary == "" {
+		return nil
+	}
+	var b strings.Builder
+	if len(doc.Usage) > 0 {
+		b.WriteString("```lua\n" + strings.Join(doc.Usage, "\n") + "\n```")
+		if doc.Summary != "" {
+			b.WriteString("\n\n")
+		}
+	}
+	b.WriteString(doc.Summary)
+	return &markupContent{Kind: "markdown", Value: b.String()}
+}
+
+func sortedExports(mod *docs.Module) []string {
+	names := make([]string, 0, len(mod.Exports))
+	for name := range mod.Exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// byteOffset converts a position in a line, counted in UTF-16 code units
+// as the protocol does, to a byte offset
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16.RuneLen(r)
+	}
+	return len(line)
+}
+
+// utf16Len returns the length of s in UTF-16 code units
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
@@ -0,0 +1,214 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zepzeper/vulgar/internal/engine"
+)
+
+// session runs a server over an in-memory connection
+type session struct {
+	t      *testing.T
+	server *Server
+	in     bytes.Buffer
+	nextID int
+}
+
+func newSession(t *testing.T) *session {
+	t.Helper()
+	eng := engine.NewEngine(engine.Config{LogLevel: "ERROR"})
+	t.Cleanup(eng.Close)
+	return &session{t: t, server: New(eng.L, []string{"plugins.demo"})}
+}
+
+func (s *session) send(method string, params any) {
+	s.t.Helper()
+	data, err := json.Marshal(params)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	msg := &message{Method: method, Params: data}
+	if !strings.HasPrefix(method, "textDocument/did") && method != "exit" {
+		s.nextID++
+		id := json.RawMessage(fmt.Sprint(s.nextID))
+		msg.ID = &id
+	}
+	if err := writeMessage(&s.in, msg); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// run serves the messages sent so far and returns the server's messages
+func (s *session) run() []message {
+	s.t.Helper()
+	var out bytes.Buffer
+	if err := s.server.Serve(&s.in, &out); err != nil {
+		s.t.Fatalf("Serve: %v", err)
+	}
+	var msgs []message
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.t.Fatalf("invalid message %s: %v", body, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+const script = `local json = require("json")
+local http = require("stdlib.`
+
+func TestServer(t *testing.T) {
+	s := newSession(t)
+	s.send("initialize", map[string]any{"capabilities": map[string]any{}})
+	s.send("textDocument/didOpen", map[string]any{"textDocument": map[string]any{
+		"uri": "file:///tmp/script.lua", "languageId": "lua", "version": 1, "text": script}})
+	s.send("textDocument/completion", positionAt("file:///tmp/script.lua", 1, 29))
+	s.send("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": "file:///tmp/script.lua", "version": 2},
+		"contentChanges": []map[string]any{{"text": "local json = require(\"json\")\nlocal s = json.en\n"}},
+	})
+	s.send("textDocument/completion", positionAt("file:///tmp/script.lua", 1, 17))
+	s.send("shutdown", nil)
+	s.send("exit", nil)
+	msgs := s.run()
+
+	if len(msgs) != 6 {
+		t.Fatalf("got %d messages, want 6", len(msgs))
+	}
+	var init struct {
+		Capabilities struct {
+			HoverProvider bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(msgs[0].Result, &init); err != nil || !init.Capabilities.HoverProvider {
+		t.Errorf("initialize result = %s", msgs[0].Result)
+	}
+
+	var diags publishDiagnosticsParams
+	if err := json.Unmarshal(msgs[1].Params, &diags); err != nil {
+		t.Fatal(err)
+	}
+	if msgs[1].Method != "textDocument/publishDiagnostics" || len(diags.Diagnostics) != 1 ||
+		diags.Diagnostics[0].Code != "syntax" || diags.Diagnostics[0].Severity != severityError {
+		t.Errorf("diagnostics = %+v", diags)
+	}
+
+	var modules completionList
+	if err := json.Unmarshal(msgs[2].Result, &modules); err != nil {
+		t.Fatal(err)
+	}
+	labels := completionLabels(modules)
+	if !strings.Contains(labels, "stdlib.timer") || !strings.Contains(labels, "plugins.demo") {
+		t.Errorf("require completion = %s", labels)
+	}
+	edit := modules.Items[0].TextEdit
+	if edit == nil || edit.Range.Start.Character != 22 || edit.Range.End.Character != 29 {
+		t.Errorf("require completion edit = %+v", edit)
+	}
+
+	// The edited script parses, so the linter's findings are reported
+	if err := json.Unmarshal(msgs[3].Params, &diags); err != nil {
+		t.Fatal(err)
+	}
+	if len(diags.Diagnostics) != 2 || diags.Diagnostics[0].Code != "unknown-function" ||
+		diags.Diagnostics[1].Code != "unused-local" {
+		t.Errorf("diagnostics after change = %+v", diags)
+	}
+
+	var members completionList
+	if err := json.Unmarshal(msgs[4].Result, &members); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, item := range members.Items {
+		if item.Label == "encode" {
+			found = true
+			if item.Kind != kindFunction || item.Detail != "json.encode(lua_table)" || item.Documentation == nil {
+				t.Errorf("encode item = %+v", item)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("member completion = %s", completionLabels(members))
+	}
+
+	if string(msgs[5].Result) != "null" || msgs[5].Error != nil {
+		t.Errorf("shutdown response = %+v", msgs[5])
+	}
+}
+
+func TestHoverAndSignatureHelp(t *testing.T) {
+	source := `local json = require("json")
+local data, err = json.decode(body)
+local s = json.encode({a = 1,
+  b = 2}, `
+	s := newSession(t)
+	s.server.docs["file:///s.lua"] = source
+
+	h := s.server.hover(source, position{Line: 1, Character: 25})
+	if h == nil || !strings.Contains(h.Contents.Value, "local data, err = json.decode(json_string)") ||
+		!strings.Contains(h.Contents.Value, "Parses a JSON string") {
+		t.Fatalf("hover on decode = %+v", h)
+	}
+	if h.Range.Start.Character != 18 || h.Range.End.Character != 29 {
+		t.Errorf("hover range = %+v", h.Range)
+	}
+	if h := s.server.hover(source, position{Line: 1, Character: 19}); h == nil ||
+		!strings.Contains(h.Contents.Value, "decode, encode") {
+		t.Errorf("hover on module = %+v", h)
+	}
+	if h := s.server.hover(source, position{Line: 1, Character: 8}); h != nil {
+		t.Errorf("hover on local = %+v", h)
+	}
+
+	help := s.server.signatureHelp(source, position{Line: 1, Character: 31})
+	if help == nil || help.Signatures[0].Label != "json.decode(json_string)" || help.ActiveParameter != 0 {
+		t.Fatalf("signature help = %+v", help)
+	}
+	// The table's commas do not count
+	help = s.server.signatureHelp(source, position{Line: 3, Character: 10})
+	if help == nil || help.Signatures[0].Label != "json.encode(lua_table)" || help.ActiveParameter != 1 {
+		t.Errorf("signature help after table = %+v", help)
+	}
+	inString := "local json = require(\"json\")\nprint(\"json.decode(\", "
+	if help := s.server.signatureHelp(inString, position{Line: 1, Character: 22}); help != nil {
+		t.Errorf("signature help inside string = %+v", help)
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	line := "héllo 😀 x"
+	if got := byteOffset(line, 9); line[got:] != "x" {
+		t.Errorf("byteOffset = %d", got)
+	}
+	if got := utf16Len(line); got != 10 {
+		t.Errorf("utf16Len = %d, want 10", got)
+	}
+}
+
+func positionAt(uri string, line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func completionLabels(list completionList) string {
+	var labels []string
+	for _, item := range list.Items {
+		labels = append(labels, item.Label)
+	}
+	return strings.Join(labels, " ")
+}
@@ -0,0 +1,282 @@
+// Package lsp implements a language server for vulgar scripts. Editors get
+// completion of module names in require() calls and of the functions
+// modules export, hover documentation and signature help taken from the
+// "Usage:" comments of module functions, and the findings of the linter as
+// diagnostics.
+//
+// The server speaks the Language Server Protocol over a single connection,
+// normally the stdin and stdout of "vulgar lsp", and handles one message at a
+// time.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/lint"
+	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/modules/docs"
+)
+
+const codeInvalidRequest = -32600
+
+// Server is a language server for scripts run in a Lua state
+type Server struct {
+	// Linter produces the diagnostics of open documents
+	Linter *lint.Linter
+	// Version is reported to the editor in the initialize response
+	Version string
+
+	L        *lua.LState
+	names    []string
+	opaque   map[string]bool
+	modules  map[string]*docs.Module
+	docs     map[string]string
+	out      io.Writer
+	shutdown bool
+}
+
+// New returns a server for scripts run in L, normally the state of an engine
+// that has not run anything yet. plugins are offered in require() completion
+// but never loaded.
+func New(L *lua.LState, plugins []string) *Server {
+	s := &Server{
+		Linter:  lint.New(L, plugins),
+		L:       L,
+		opaque:  make(map[string]bool),
+		modules: make(map[string]*docs.Module),
+		docs:    make(map[string]string),
+	}
+	for name := range modules.GetRegistry() {
+		s.names = append(s.names, name)
+	}
+	for _, name := range plugins {
+		s.names = append(s.names, name)
+		s.opaque[name] = true
+	}
+	sort.Strings(s.names)
+	return s
+}
+
+// errExitWithoutShutdown is returned by Serve when the editor sends exit
+// without a shutdown request first
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Serve handles messages from in until the editor sends exit or closes the
+// connection
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	r := bufio.NewReader(in)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{codeParseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+
+		if msg.ID == nil {
+			if err := s.notification(msg.Method, msg.Params); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rpcErr := s.request(msg.Method, msg.Params)
+		if err := s.reply(msg.ID, result, rpcErr); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, rpcErr *responseError) error {
+	msg := &message{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			msg.Error = &responseError{codeInternalError, err.Error()}
+		} else {
+			msg.Result = data
+		}
+	}
+	if id == nil {
+		null := json.RawMessage("null")
+		msg.ID = &null
+	}
+	return writeMessage(s.out, msg)
+}
+
+func (s *Server) notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+func (s *Server) request(method string, params json.RawMessage) (any, *responseError) {
+	if s.shutdown {
+		return nil, &responseError{codeInvalidRequest, "server is shutting down"}
+	}
+
+	switch method {
+	case "initialize":
+		return s.initialize(), nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	}
+
+	var p positionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, &responseError{codeInvalidParams, err.Error()}
+	}
+	text := s.docs[p.TextDocument.URI]
+	switch method {
+	case "textDocument/completion":
+		return s.completion(text, p.Position), nil
+	case "textDocument/hover":
+		return s.hover(text, p.Position), nil
+	case "textDocument/signatureHelp":
+		return s.signatureHelp(text, p.Position), nil
+	}
+	return nil, &responseError{codeMethodNotFound, fmt.Sprintf("method not supported: %s", method)}
+}
+
+func (s *Server) initialize() any {
+	return map[string]any{
+		"capabilities": map[string]any{
+			// Full document sync
+			"textDocumentSync": 1,
+			"completionProvider": map[string]any{
+				"triggerCharacters": []string{".", `"`, "'"},
+			},
+			"hoverProvider": true,
+			"signatureHelpProvider": map[string]any{
+				"triggerCharacters": []string{"(", ","},
+			},
+		},
+		"serverInfo": map[string]any{"name": "vulgar", "version": s.Version},
+	}
+}
+
+// notification handles a notification. Unknown notifications are ignored,
+// as the protocol requires.
+func (s *Server) notification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		return s.publishDiagnostics(p.TextDocument.URI)
+
+	case "textDocument/didChange":
+		var p didChangeParams
+		if json.Unmarshal(params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.publishDiagnostics(p.TextDocument.URI)
+
+	case "textDocument/didSave":
+		// Modules the document requires may have changed on disk
+		var p documentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		if _, ok := s.docs[p.TextDocument.URI]; ok {
+			return s.publishDiagnostics(p.TextDocument.URI)
+		}
+
+	case "textDocument/didClose":
+		var p documentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []diagnostic{}})
+	}
+	return nil
+}
+
+// publishDiagnostics lints an open document and sends the findings
+func (s *Server) publishDiagnostics(uri string) error {
+	text := s.docs[uri]
+	lines := splitLines(text)
+
+	diags := []diagnostic{}
+	for _, d := range s.Linter.Lint([]byte(text), uriPath(uri)) {
+		line := min(max(d.Line-1, 0), len(lines)-1)
+		severity := severityWarning
+		if d.Severity == lint.SeverityError {
+			severity = severityError
+		}
+		message := d.Message
+		if d.Hint != "" {
+			message += ". " + d.Hint
+		}
+		diags = append(diags, diagnostic{
+			Range: lspRange{
+				Start: position{Line: line},
+				End:   position{Line: line, Character: utf16Len(lines[line])},
+			},
+			Severity: severity,
+			Code:     d.Rule,
+			Source:   "vulgar",
+			Message:  message,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// uriPath returns the file path of a file: URI, which the linter resolves
+// project modules against, or the URI itself for other schemes
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// module returns the contents of a built-in module, or nil if name is not
+// one or it cannot be inspected
+func (s *Server) module(name string) *docs.Module {
+	i := sort.SearchStrings(s.names, name)
+	if i == len(s.names) || s.names[i] != name || s.opaque[name] {
+		return nil
+	}
+	if m, ok := s.modules[name]; ok {
+		return m
+	}
+	m, err := docs.Load(s.L, name)
+	if err != nil {
+		m = nil
+	}
+	s.modules[name] = m
+	return m
+}
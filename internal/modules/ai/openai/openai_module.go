@@ -1,12 +1,11 @@
 package openai
 
 import (
-	"context"
-
 	"github.com/sashabaranov/go-openai"
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	openaiSvc "github.com/zepzeper/vulgar/internal/services/openai"
 )
 
@@ -40,6 +39,10 @@ func luaConfigure(L *lua.LState) int {
 		}
 	}
 
+	if err := sandbox.Check(L, sandbox.Net, "api.openai.com"); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
 	var client *openaiSvc.Client
 	var err error
 
@@ -112,7 +115,7 @@ func luaChat(L *lua.LState) int {
 	}
 
 	req, _ := parseChatArgs(L, 2)
-	resp, err := wrapper.service.Chat(context.Background(), req)
+	resp, err := wrapper.service.Chat(util.Context(L), req)
 	if err != nil {
 		return util.PushError(L, "chat failed: %v", err)
 	}
@@ -145,7 +148,7 @@ func luaChatStream(L *lua.LState) int {
 
 	req.Stream = true
 
-	stream, err := wrapper.service.ChatStream(context.Background(), req)
+	stream, err := wrapper.service.ChatStream(util.Context(L), req)
 	if err != nil {
 		return util.PushError(L, "stream creation failed: %v", err)
 	}
@@ -197,7 +200,7 @@ func luaEmbed(L *lua.LState) int {
 		})
 	}
 
-	resp, err := wrapper.service.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
+	resp, err := wrapper.service.CreateEmbeddings(util.Context(L), openai.EmbeddingRequest{
 		Model: model,
 		Input: input,
 	})
@@ -257,7 +260,7 @@ func luaImage(L *lua.LState) int {
 		req.Style = style.String()
 	}
 
-	resp, err := wrapper.service.GenerateImage(context.Background(), req)
+	resp, err := wrapper.service.GenerateImage(util.Context(L), req)
 	if err != nil {
 		return util.PushError(L, "image generation failed: %v", err)
 	}
@@ -307,7 +310,7 @@ func luaTranscribe(L *lua.LState) int {
 		FilePath: filePath,
 	}
 
-	resp, err := wrapper.service.CreateTranscription(context.Background(), req)
+	resp, err := wrapper.service.CreateTranscription(util.Context(L), req)
 	if err != nil {
 		return util.PushError(L, "transcription failed: %v", err)
 	}
@@ -349,7 +352,7 @@ func luaTranslate(L *lua.LState) int {
 		FilePath: filePath,
 	}
 
-	resp, err := wrapper.service.CreateTranslation(context.Background(), req)
+	resp, err := wrapper.service.CreateTranslation(util.Context(L), req)
 	if err != nil {
 		return util.PushError(L, "translation failed: %v", err)
 	}
@@ -376,7 +379,7 @@ func luaModerate(L *lua.LState) int {
 		return util.PushError(L, "input text is required")
 	}
 
-	resp, err := wrapper.service.Moderation(context.Background(), input)
+	resp, err := wrapper.service.Moderation(util.Context(L), input)
 	if err != nil {
 		return util.PushError(L, "moderation failed: %v", err)
 	}
@@ -434,7 +437,7 @@ func luaListModels(L *lua.LState) int {
 		return util.PushError(L, "expected openai client")
 	}
 
-	resp, err := wrapper.service.ListModels(context.Background())
+	resp, err := wrapper.service.ListModels(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "list models failed: %v", err)
 	}
@@ -17,6 +17,7 @@ import (
 	_ "github.com/zepzeper/vulgar/internal/modules/core/uuid"
 
 	// Standard library modules (stdlib.*)
+	_ "github.com/zepzeper/vulgar/internal/modules/stdlib/async"
 	_ "github.com/zepzeper/vulgar/internal/modules/stdlib/cache"
 	_ "github.com/zepzeper/vulgar/internal/modules/stdlib/compress/gzip"
 	_ "github.com/zepzeper/vulgar/internal/modules/stdlib/compress/tar"
@@ -54,6 +54,8 @@ func luaExists(L *lua.LState) int {
 	return 1
 }
 
+// luaUnset removes an environment variable
+// Usage: local err = env.unset(name)
 func luaUnset(L *lua.LState) int {
 	name := L.CheckString(1)
 
@@ -66,6 +68,8 @@ func luaUnset(L *lua.LState) int {
 	return 1
 }
 
+// luaAll returns all environment variables as a table
+// Usage: local vars = env.all()
 func luaAll(L *lua.LState) int {
 	tbl := L.NewTable()
 
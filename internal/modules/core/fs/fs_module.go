@@ -8,6 +8,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "fs"
@@ -156,8 +157,21 @@ func handleStat(L *lua.LState) int {
 	return statImpl(L, path)
 }
 
+// checkAccess enforces sandbox filesystem rules for the given paths
+func checkAccess(L *lua.LState, paths ...string) error {
+	for _, path := range paths {
+		if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Implementation functions
 func readFileImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return util.PushError(L, "failed to read file: %v", err)
@@ -166,6 +180,10 @@ func readFileImpl(L *lua.LState, path string) int {
 }
 
 func writeFileImpl(L *lua.LState, path, content string) int {
+	if err := checkAccess(L, path); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	err := os.WriteFile(path, []byte(content), 0644)
 	if err != nil {
 		L.Push(lua.LString("failed to write file: " + err.Error()))
@@ -176,6 +194,10 @@ func writeFileImpl(L *lua.LState, path, content string) int {
 }
 
 func appendFileImpl(L *lua.LState, path, content string) int {
+	if err := checkAccess(L, path); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		L.Push(lua.LString("failed to open file for append: " + err.Error()))
@@ -192,12 +214,21 @@ func appendFileImpl(L *lua.LState, path, content string) int {
 }
 
 func existsImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		L.Push(lua.LFalse)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
 	_, err := os.Stat(path)
 	L.Push(lua.LBool(err == nil))
 	return 1
 }
 
 func removeImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	err := os.RemoveAll(path)
 	if err != nil {
 		L.Push(lua.LString("failed to remove: " + err.Error()))
@@ -208,6 +239,10 @@ func removeImpl(L *lua.LState, path string) int {
 }
 
 func mkdirImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	err := os.MkdirAll(path, 0755)
 	if err != nil {
 		L.Push(lua.LString("failed to create directory: " + err.Error()))
@@ -218,6 +253,9 @@ func mkdirImpl(L *lua.LState, path string) int {
 }
 
 func listDirImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		return util.PushError(L, "failed to list directory: %v", err)
@@ -234,6 +272,10 @@ func listDirImpl(L *lua.LState, path string) int {
 }
 
 func copyImpl(L *lua.LState, src, dst string) int {
+	if err := checkAccess(L, src, dst); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	srcFile, err := os.Open(src)
 	if err != nil {
 		L.Push(lua.LString("failed to open source: " + err.Error()))
@@ -257,6 +299,10 @@ func copyImpl(L *lua.LState, src, dst string) int {
 }
 
 func moveImpl(L *lua.LState, src, dst string) int {
+	if err := checkAccess(L, src, dst); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	err := os.Rename(src, dst)
 	if err != nil {
 		L.Push(lua.LString("failed to move: " + err.Error()))
@@ -267,6 +313,9 @@ func moveImpl(L *lua.LState, src, dst string) int {
 }
 
 func statImpl(L *lua.LState, path string) int {
+	if err := checkAccess(L, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	info, err := os.Stat(path)
 	if err != nil {
 		return util.PushError(L, "failed to stat: %v", err)
@@ -283,42 +332,63 @@ func statImpl(L *lua.LState, path string) int {
 }
 
 // Simple function-based API (no configuration, uses current working directory)
+
+// luaReadFile reads a whole file
+// Usage: local content, err = fs.read_file(path)
 func luaReadFile(L *lua.LState) int {
 	return readFileImpl(L, L.CheckString(1))
 }
 
+// luaWriteFile writes content to a file, replacing it
+// Usage: local err = fs.write_file(path, content)
 func luaWriteFile(L *lua.LState) int {
 	return writeFileImpl(L, L.CheckString(1), L.CheckString(2))
 }
 
+// luaAppendFile appends content to a file, creating it if needed
+// Usage: local err = fs.append_file(path, content)
 func luaAppendFile(L *lua.LState) int {
 	return appendFileImpl(L, L.CheckString(1), L.CheckString(2))
 }
 
+// luaExists reports whether a file or directory exists
+// Usage: local exists = fs.exists(path)
 func luaExists(L *lua.LState) int {
 	return existsImpl(L, L.CheckString(1))
 }
 
+// luaRemove removes a file or directory tree
+// Usage: local err = fs.remove(path)
 func luaRemove(L *lua.LState) int {
 	return removeImpl(L, L.CheckString(1))
 }
 
+// luaMkdir creates a directory and any missing parents
+// Usage: local err = fs.mkdir(path)
 func luaMkdir(L *lua.LState) int {
 	return mkdirImpl(L, L.CheckString(1))
 }
 
+// luaListDir lists the entries of a directory
+// Usage: local entries, err = fs.list_dir(path)
 func luaListDir(L *lua.LState) int {
 	return listDirImpl(L, L.CheckString(1))
 }
 
+// luaCopy copies a file
+// Usage: local err = fs.copy(src, dst)
 func luaCopy(L *lua.LState) int {
 	return copyImpl(L, L.CheckString(1), L.CheckString(2))
 }
 
+// luaMove moves or renames a file
+// Usage: local err = fs.move(src, dst)
 func luaMove(L *lua.LState) int {
 	return moveImpl(L, L.CheckString(1), L.CheckString(2))
 }
 
+// luaStat returns the name, size, is_dir, mod_time and mode of a file
+// Usage: local info, err = fs.stat(path)
 func luaStat(L *lua.LState) int {
 	return statImpl(L, L.CheckString(1))
 }
@@ -2,6 +2,9 @@ package http
 
 import (
 	"context"
+	"fmt"
+	nethttp "net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	"github.com/zepzeper/vulgar/internal/httpclient"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "http"
@@ -33,6 +37,13 @@ var clientMethods = map[string]lua.LGFunction{
 	"patch":   clientPatch,
 	"delete":  clientDelete,
 	"request": clientRequest,
+
+	"get_async":     clientGetAsync,
+	"post_async":    clientPostAsync,
+	"put_async":     clientPutAsync,
+	"patch_async":   clientPatchAsync,
+	"delete_async":  clientDeleteAsync,
+	"request_async": clientRequestAsync,
 }
 
 // checkHTTPClient extracts the http client from userdata
@@ -135,9 +146,28 @@ func buildLuaResponse(L *lua.LState, resp *httpclient.Response) *lua.LTable {
 	return tbl
 }
 
-// doRequest performs the actual HTTP request using httpclient
-func doRequest(L *lua.LState, client *httpclient.Client, method, urlPath string, body string, opts *lua.LTable) int {
-	ctx := context.Background()
+// httpRequest is a request whose Lua arguments have been read and checked
+// against the sandbox, so it can be performed off the main thread
+type httpRequest struct {
+	client  *httpclient.Client
+	method  string
+	urlPath string
+	body    string
+}
+
+// requestFunc performs a prepared request and pushes its results
+type requestFunc func(L *lua.LState, client *httpclient.Client, method, urlPath string, body string, opts *lua.LTable) int
+
+// prepareRequest applies per-request options and enforces the sandbox
+func prepareRequest(L *lua.LState, client *httpclient.Client, method, urlPath string, body string, opts *lua.LTable) (*httpRequest, error) {
+	// Enforce sandbox network rules against the resolved host
+	target, err := url.Parse(client.ResolveURL(urlPath))
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if err := sandbox.Check(L, sandbox.Net, target.Host); err != nil {
+		return nil, err
+	}
 
 	// Parse per-request options for additional headers or timeout
 	var extraOpts []httpclient.Option
@@ -160,24 +190,41 @@ func doRequest(L *lua.LState, client *httpclient.Client, method, urlPath string,
 		}
 	}
 
+	// Check every redirect hop as well, since any host may be redirected to
+	if policy := sandbox.FromState(L); policy.Enabled() {
+		extraOpts = append(extraOpts, httpclient.WithRedirectCheck(func(req *nethttp.Request) error {
+			return policy.Check(sandbox.Net, req.URL.Host)
+		}))
+	}
+
 	// Create request client with overrides if needed
 	reqClient := client
 	if len(extraOpts) > 0 {
 		reqClient = client.With(extraOpts...)
 	}
 
-	var resp *httpclient.Response
-	var err error
+	return &httpRequest{client: reqClient, method: method, urlPath: urlPath, body: body}, nil
+}
 
-	if body != "" {
-		resp, err = reqClient.NewRequest(method, urlPath).
+// do performs the request. It does not touch the Lua state.
+func (r *httpRequest) do(ctx context.Context) (*httpclient.Response, error) {
+	if r.body != "" {
+		return r.client.NewRequest(r.method, r.urlPath).
 			Context(ctx).
-			BodyString(body).
+			BodyString(r.body).
 			Do()
-	} else {
-		resp, err = reqClient.Request(ctx, method, urlPath, nil)
+	}
+	return r.client.Request(ctx, r.method, r.urlPath, nil)
+}
+
+// doRequest performs the actual HTTP request using httpclient
+func doRequest(L *lua.LState, client *httpclient.Client, method, urlPath string, body string, opts *lua.LTable) int {
+	req, err := prepareRequest(L, client, method, urlPath, body, opts)
+	if err != nil {
+		return util.PushError(L, "%v", err)
 	}
 
+	resp, err := req.do(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "request failed: %v", err)
 	}
@@ -185,6 +232,26 @@ func doRequest(L *lua.LState, client *httpclient.Client, method, urlPath string,
 	return util.PushSuccess(L, buildLuaResponse(L, resp))
 }
 
+// doRequestAsync performs the request on a goroutine and pushes a future
+// that resolves to the same (response, err) pair as doRequest
+func doRequestAsync(L *lua.LState, client *httpclient.Client, method, urlPath string, body string, opts *lua.LTable) int {
+	req, prepErr := prepareRequest(L, client, method, urlPath, body, opts)
+
+	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+		if prepErr != nil {
+			return func(L *lua.LState) int { return util.PushError(L, "%v", prepErr) }
+		}
+		resp, err := req.do(ctx)
+		return func(L *lua.LState) int {
+			if err != nil {
+				return util.PushError(L, "request failed: %v", err)
+			}
+			return util.PushSuccess(L, buildLuaResponse(L, resp))
+		}
+	}))
+	return 1
+}
+
 // =============================================================================
 // Module Functions
 // =============================================================================
@@ -208,63 +275,95 @@ func luaNew(L *lua.LState) int {
 // Client Instance Methods (called with : syntax)
 // =============================================================================
 
-func clientGet(L *lua.LState) int {
+// clientMethodRequest reads the arguments of a client method and performs
+// the request with perform
+func clientMethodRequest(L *lua.LState, method string, perform requestFunc) int {
 	client := checkHTTPClient(L)
 	url := L.CheckString(2)
+	if hasBody(method) {
+		body := L.OptString(3, "")
+		opts := L.OptTable(4, nil)
+		return perform(L, client.client, method, url, body, opts)
+	}
 	opts := L.OptTable(3, nil)
-	return doRequest(L, client.client, "GET", url, "", opts)
+	return perform(L, client.client, method, url, "", opts)
+}
+
+func clientGet(L *lua.LState) int {
+	return clientMethodRequest(L, "GET", doRequest)
 }
 
 func clientPost(L *lua.LState) int {
-	client := checkHTTPClient(L)
-	url := L.CheckString(2)
-	body := L.OptString(3, "")
-	opts := L.OptTable(4, nil)
-	return doRequest(L, client.client, "POST", url, body, opts)
+	return clientMethodRequest(L, "POST", doRequest)
 }
 
 func clientPut(L *lua.LState) int {
-	client := checkHTTPClient(L)
-	url := L.CheckString(2)
-	body := L.OptString(3, "")
-	opts := L.OptTable(4, nil)
-	return doRequest(L, client.client, "PUT", url, body, opts)
+	return clientMethodRequest(L, "PUT", doRequest)
 }
 
 func clientPatch(L *lua.LState) int {
-	client := checkHTTPClient(L)
-	url := L.CheckString(2)
-	body := L.OptString(3, "")
-	opts := L.OptTable(4, nil)
-	return doRequest(L, client.client, "PATCH", url, body, opts)
+	return clientMethodRequest(L, "PATCH", doRequest)
 }
 
 func clientDelete(L *lua.LState) int {
-	client := checkHTTPClient(L)
-	url := L.CheckString(2)
-	opts := L.OptTable(3, nil)
-	return doRequest(L, client.client, "DELETE", url, "", opts)
+	return clientMethodRequest(L, "DELETE", doRequest)
 }
 
 func clientRequest(L *lua.LState) int {
+	return clientGenericRequest(L, doRequest)
+}
+
+func clientGenericRequest(L *lua.LState, perform requestFunc) int {
 	client := checkHTTPClient(L)
 	method := L.CheckString(2)
 	url := L.CheckString(3)
 	body := L.OptString(4, "")
 	opts := L.OptTable(5, nil)
-	return doRequest(L, client.client, strings.ToUpper(method), url, body, opts)
+	return perform(L, client.client, strings.ToUpper(method), url, body, opts)
+}
+
+// clientGetAsync and the other async methods return a future resolving to
+// (response, err)
+// Usage: local resp, err = client:get_async("/users"):await()
+func clientGetAsync(L *lua.LState) int {
+	return clientMethodRequest(L, "GET", doRequestAsync)
+}
+
+func clientPostAsync(L *lua.LState) int {
+	return clientMethodRequest(L, "POST", doRequestAsync)
+}
+
+func clientPutAsync(L *lua.LState) int {
+	return clientMethodRequest(L, "PUT", doRequestAsync)
+}
+
+func clientPatchAsync(L *lua.LState) int {
+	return clientMethodRequest(L, "PATCH", doRequestAsync)
+}
+
+func clientDeleteAsync(L *lua.LState) int {
+	return clientMethodRequest(L, "DELETE", doRequestAsync)
+}
+
+func clientRequestAsync(L *lua.LState) int {
+	return clientGenericRequest(L, doRequestAsync)
 }
 
 // =============================================================================
 // Convenience Functions (one-off requests without creating a client)
 // =============================================================================
 
-func simpleRequest(L *lua.LState, method string) int {
+// hasBody reports whether a convenience function takes a body argument
+func hasBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+func simpleRequest(L *lua.LState, method string, perform requestFunc) int {
 	url := L.CheckString(1)
 	body := ""
 	var opts *lua.LTable
 
-	if method == "POST" || method == "PUT" || method == "PATCH" {
+	if hasBody(method) {
 		body = L.OptString(2, "")
 		opts = L.OptTable(3, nil)
 	} else {
@@ -274,32 +373,46 @@ func simpleRequest(L *lua.LState, method string) int {
 	clientOpts := parseClientOptions(L, opts)
 	client := httpclient.New(clientOpts...)
 
-	return doRequest(L, client, method, url, body, opts)
+	return perform(L, client, method, url, body, opts)
 }
 
+// luaGet sends a GET request
+// Usage: local resp, err = http.get(url, { headers = { Accept = "application/json" } })
 func luaGet(L *lua.LState) int {
-	return simpleRequest(L, "GET")
+	return simpleRequest(L, "GET", doRequest)
 }
 
+// luaPost sends a POST request with body
+// Usage: local resp, err = http.post(url, body, { headers = { ["Content-Type"] = "application/json" } })
 func luaPost(L *lua.LState) int {
-	return simpleRequest(L, "POST")
+	return simpleRequest(L, "POST", doRequest)
 }
 
+// luaPut sends a PUT request with body
+// Usage: local resp, err = http.put(url, body, opts)
 func luaPut(L *lua.LState) int {
-	return simpleRequest(L, "PUT")
+	return simpleRequest(L, "PUT", doRequest)
 }
 
+// luaPatch sends a PATCH request with body
+// Usage: local resp, err = http.patch(url, body, opts)
 func luaPatch(L *lua.LState) int {
-	return simpleRequest(L, "PATCH")
+	return simpleRequest(L, "PATCH", doRequest)
 }
 
+// luaDelete sends a DELETE request
+// Usage: local resp, err = http.delete(url, opts)
 func luaDelete(L *lua.LState) int {
-	return simpleRequest(L, "DELETE")
+	return simpleRequest(L, "DELETE", doRequest)
 }
 
-// luaRequest is a general-purpose request function
-// Usage: local resp, err = http.request("GET", url, body, { timeout = 10 })
+// luaRequest sends a request with any method
+// Usage: local resp, err = http.request(method, url, body, { timeout = 10 })
 func luaRequest(L *lua.LState) int {
+	return genericRequest(L, doRequest)
+}
+
+func genericRequest(L *lua.LState, perform requestFunc) int {
 	method := L.CheckString(1)
 	url := L.CheckString(2)
 	body := L.OptString(3, "")
@@ -308,7 +421,48 @@ func luaRequest(L *lua.LState) int {
 	clientOpts := parseClientOptions(L, opts)
 	client := httpclient.New(clientOpts...)
 
-	return doRequest(L, client, strings.ToUpper(method), url, body, opts)
+	return perform(L, client, strings.ToUpper(method), url, body, opts)
+}
+
+// =============================================================================
+// Async Functions (return a future, see stdlib.async)
+// =============================================================================
+
+// luaGetAsync starts a GET request and returns a future for (response, err)
+// Usage: local resp, err = async.await(http.get_async(url))
+func luaGetAsync(L *lua.LState) int {
+	return simpleRequest(L, "GET", doRequestAsync)
+}
+
+// luaPostAsync starts a POST request and returns a future for (response, err)
+// Usage: local fut = http.post_async(url, body, opts)
+func luaPostAsync(L *lua.LState) int {
+	return simpleRequest(L, "POST", doRequestAsync)
+}
+
+// luaPutAsync starts a PUT request and returns a future for (response, err)
+// Usage: local fut = http.put_async(url, body, opts)
+func luaPutAsync(L *lua.LState) int {
+	return simpleRequest(L, "PUT", doRequestAsync)
+}
+
+// luaPatchAsync starts a PATCH request and returns a future for (response, err)
+// Usage: local fut = http.patch_async(url, body, opts)
+func luaPatchAsync(L *lua.LState) int {
+	return simpleRequest(L, "PATCH", doRequestAsync)
+}
+
+// luaDeleteAsync starts a DELETE request and returns a future for (response, err)
+// Usage: local fut = http.delete_async(url, opts)
+func luaDeleteAsync(L *lua.LState) int {
+	return simpleRequest(L, "DELETE", doRequestAsync)
+}
+
+// luaRequestAsync starts a request with any method and returns a future for
+// (response, err)
+// Usage: local fut = http.request_async(method, url, body, { timeout = 10 })
+func luaRequestAsync(L *lua.LState) int {
+	return genericRequest(L, doRequestAsync)
 }
 
 // =============================================================================
@@ -323,6 +477,13 @@ var exports = map[string]lua.LGFunction{
 	"patch":   luaPatch,
 	"delete":  luaDelete,
 	"request": luaRequest,
+
+	"get_async":     luaGetAsync,
+	"post_async":    luaPostAsync,
+	"put_async":     luaPutAsync,
+	"patch_async":   luaPatchAsync,
+	"delete_async":  luaDeleteAsync,
+	"request_async": luaRequestAsync,
 }
 
 // Loader is called when the module is required via require("http")
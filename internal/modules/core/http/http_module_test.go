@@ -3,9 +3,13 @@ package http
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 func setupLuaState() *lua.LState {
@@ -585,3 +589,100 @@ func TestLoaderExportsAllFunctions(t *testing.T) {
 		}
 	}
 }
+
+func TestAsyncRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	L := setupLuaState()
+	defer L.Close()
+
+	queue := util.NewEventQueue(L, 10)
+	defer queue.Close()
+	ud := L.NewUserData()
+	ud.Value = queue
+	L.SetField(L.Get(lua.RegistryIndex), util.EventQueueRegistryKey, ud)
+
+	L.SetGlobal("test_url", lua.LString(server.URL))
+	err := L.DoString(`
+		local http = require("http")
+		local client = http.new({ base_url = test_url })
+
+		local a = http.get_async(test_url .. "/a")
+		local b = client:post_async("/b", "payload")
+
+		local resp_a, err_a = a:await()
+		local resp_b, err_b = b:await()
+		assert(err_a == nil and err_b == nil, tostring(err_a or err_b))
+		body_a = resp_a.body
+		body_b = resp_b.body
+	`)
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+
+	if got := L.GetGlobal("body_a").String(); got != "GET /a" {
+		t.Errorf("unexpected body %q", got)
+	}
+	if got := L.GetGlobal("body_b").String(); got != "POST /b" {
+		t.Errorf("unexpected body %q", got)
+	}
+}
+
+func TestSandboxChecksRedirects(t *testing.T) {
+	reached := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer target.Close()
+
+	targetURL, _ := url.Parse(target.URL)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Same server under a host the policy does not allow
+		host := "localhost:" + targetURL.Port()
+		if r.URL.Path == "/allowed" {
+			host = targetURL.Host
+		}
+		http.Redirect(w, r, "http://"+host+"/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	L := setupLuaState()
+	defer L.Close()
+
+	policy, err := sandbox.NewPolicy([]string{"net=127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sandbox.Attach(L, policy)
+
+	L.SetGlobal("test_url", lua.LString(server.URL))
+	err = L.DoString(`
+		local http = require("http")
+		local resp, err = http.get(test_url .. "/allowed")
+		assert(err == nil, tostring(err))
+		allowed_body = resp.body
+	`)
+	if err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+
+	if got := L.GetGlobal("allowed_body").String(); got != "ok" {
+		t.Errorf("expected redirect to an allowed host to be followed, got %q", got)
+	}
+
+	reached = false
+	if err := L.DoString(`_, redirect_err = require("http").get(test_url .. "/denied")`); err != nil {
+		t.Fatalf("failed to execute: %v", err)
+	}
+	if errVal := L.GetGlobal("redirect_err"); errVal == lua.LNil || !strings.Contains(errVal.String(), "sandbox") {
+		t.Errorf("expected sandbox error for redirect to a denied host, got %v", errVal)
+	}
+	if reached {
+		t.Error("expected the denied host not to be contacted")
+	}
+}
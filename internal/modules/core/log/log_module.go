@@ -7,6 +7,7 @@ import (
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
 const ModuleName = "log"
@@ -78,13 +79,37 @@ func formatLog(level, message string) string {
 	return fmt.Sprintf("[%s] [%s] %s", timestamp, level, message)
 }
 
+// Info logs a message from Go code, formatted like log.info in scripts
+func Info(format string, args ...interface{}) {
+	if !shouldLog(LevelInfo) {
+		return
+	}
+	fmt.Println(formatLog(LevelInfo, fmt.Sprintf(format, args...)))
+}
+
+// Warn logs a warning from Go code, formatted like log.warn in scripts
+func Warn(format string, args ...interface{}) {
+	if !shouldLog(LevelWarn) {
+		return
+	}
+	fmt.Println(formatLog(LevelWarn, fmt.Sprintf(format, args...)))
+}
+
+// Error logs an error from Go code, formatted like log.error in scripts
+func Error(format string, args ...interface{}) {
+	if !shouldLog(LevelError) {
+		return
+	}
+	fmt.Println(formatLog(LevelError, fmt.Sprintf(format, args...)))
+}
+
 // luaDebug logs a debug message
 func luaDebug(L *lua.LState) int {
 	if !shouldLog(LevelDebug) {
 		return 0
 	}
 	message := L.CheckString(1)
-	fmt.Println(formatLog(LevelDebug, message))
+	fmt.Fprintln(util.Output(L), formatLog(LevelDebug, message))
 	return 0
 }
 
@@ -94,7 +119,7 @@ func luaInfo(L *lua.LState) int {
 		return 0
 	}
 	message := L.CheckString(1)
-	fmt.Println(formatLog(LevelInfo, message))
+	fmt.Fprintln(util.Output(L), formatLog(LevelInfo, message))
 	return 0
 }
 
@@ -104,7 +129,7 @@ func luaWarn(L *lua.LState) int {
 		return 0
 	}
 	message := L.CheckString(1)
-	fmt.Println(formatLog(LevelWarn, message))
+	fmt.Fprintln(util.Output(L), formatLog(LevelWarn, message))
 	return 0
 }
 
@@ -114,7 +139,7 @@ func luaError(L *lua.LState) int {
 		return 0
 	}
 	message := L.CheckString(1)
-	fmt.Println(formatLog(LevelError, message))
+	fmt.Fprintln(util.Output(L), formatLog(LevelError, message))
 	return 0
 }
 
@@ -10,6 +10,8 @@ import (
 
 const ModuleName = "path"
 
+// luaJoin joins path elements with the separator of the platform
+// Usage: local p = path.join(dir, name)
 func luaJoin(L *lua.LState) int {
 	n := L.GetTop()
 
@@ -22,24 +24,32 @@ func luaJoin(L *lua.LState) int {
 	return 1
 }
 
+// luaDir returns all but the last element of a path
+// Usage: local dir = path.dir(p)
 func luaDir(L *lua.LState) int {
 	p := L.CheckString(1)
 	L.Push(lua.LString(filepath.Dir(p)))
 	return 1
 }
 
+// luaBase returns the last element of a path
+// Usage: local name = path.base(p)
 func luaBase(L *lua.LState) int {
 	p := L.CheckString(1)
 	L.Push(lua.LString(filepath.Base(p)))
 	return 1
 }
 
+// luaExt returns the file name extension of a path, including the dot
+// Usage: local ext = path.ext(p)
 func luaExt(L *lua.LState) int {
 	p := L.CheckString(1)
 	L.Push(lua.LString(filepath.Ext(p)))
 	return 1
 }
 
+// luaAbs returns an absolute version of a path
+// Usage: local abs, err = path.abs(p)
 func luaAbs(L *lua.LState) int {
 	p := L.CheckString(1)
 
@@ -51,12 +61,16 @@ func luaAbs(L *lua.LState) int {
 	return util.PushSuccess(L, lua.LString(abs))
 }
 
+// luaClean returns the shortest equivalent of a path
+// Usage: local p = path.clean(p)
 func luaClean(L *lua.LState) int {
 	p := L.CheckString(1)
 	L.Push(lua.LString(filepath.Clean(p)))
 	return 1
 }
 
+// luaSplit splits a path into its directory and file name
+// Usage: local dir, file = path.split(p)
 func luaSplit(L *lua.LState) int {
 	p := L.CheckString(1)
 	dir, file := filepath.Split(p)
@@ -65,6 +79,8 @@ func luaSplit(L *lua.LState) int {
 	return 2
 }
 
+// luaIsAbs reports whether a path is absolute
+// Usage: local abs = path.is_abs(p)
 func luaIsAbs(L *lua.LState) int {
 	p := L.CheckString(1)
 	L.Push(lua.LBool(filepath.IsAbs(p)))
@@ -0,0 +1,161 @@
+// Package docs holds the documentation of module functions, generated from
+// the doc comments of the Go functions behind them:
+//
+//	// luaDecode parses a JSON string into a Lua value
+//	// Usage: local data, err = json.decode(json_string)
+//	func luaDecode(L *lua.LState) int
+//
+// Functions are found by their Go implementation, so a module exporting a
+// function under any name is documented without further registration.
+package docs
+
+//go:generate go run ./gen
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Function documents a module function
+type Function struct {
+	// Summary is the doc comment without its usage examples
+	Summary string
+	// Usage holds examples of calling the function from Lua
+	Usage []string
+	// ReturnsError is set for functions returning (result, err) through
+	// util.PushError and util.PushSuccess
+	ReturnsError bool
+}
+
+// GoName returns the fully qualified name of a Go function
+func GoName(fn lua.LGFunction) string {
+	rf := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if rf == nil {
+		return ""
+	}
+	return strings.TrimSuffix(rf.Name(), "-fm")
+}
+
+// Lookup returns the documentation of the Go function behind a module
+// function
+func Lookup(fn lua.LGFunction) (Function, bool) {
+	f, ok := functions[GoName(fn)]
+	return f, ok
+}
+
+// Module is the documented contents of a module
+type Module struct {
+	Name string
+	// Exports maps the exported names to their documentation. Values that
+	// are not Go functions, or are undocumented, have an empty Function.
+	Exports map[string]Function
+	// Functions is the set of exported names that are functions
+	Functions map[string]bool
+}
+
+// Load requires a module in L and returns its documented contents. It fails
+// for modules that are not tables, or whose contents are computed by an
+// __index metamethod.
+func Load(L *lua.LState, name string) (*Module, error) {
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal("require"), NRet: 1, Protect: true}, lua.LString(name))
+	if err != nil {
+		return nil, err
+	}
+	table, ok := L.Get(-1).(*lua.LTable)
+	L.Pop(1)
+	if !ok {
+		return nil, fmt.Errorf("module %q is not a table", name)
+	}
+	if L.GetMetaField(table, "__index") != lua.LNil {
+		return nil, fmt.Errorf("module %q has dynamic contents", name)
+	}
+
+	m := &Module{Name: name, Exports: make(map[string]Function), Functions: make(map[string]bool)}
+	table.ForEach(func(k, v lua.LValue) {
+		key, ok := k.(lua.LString)
+		if !ok {
+			return
+		}
+		var doc Function
+		if fn, ok := v.(*lua.LFunction); ok {
+			m.Functions[string(key)] = true
+			if fn.IsG {
+				doc, _ = Lookup(fn.GFunction)
+			}
+		}
+		m.Exports[string(key)] = doc
+	})
+	return m, nil
+}
+
+// Signature returns the call of the function exported as name in the first
+// usage example of f, as in "json.decode(json_string)", and its parameters.
+// Table and function arguments spanning several lines are shortened to
+// "{...}" and "function(...) end".
+func (f Function) Signature(name string) (string, []string) {
+	if len(f.Usage) == 0 {
+		return "", nil
+	}
+	usage := f.Usage[0]
+	// The call may be wrapped, as in async.await(http.get_async(url))
+	open := -1
+	for _, sep := range []string{".", ":"} {
+		if i := strings.Index(usage, sep+name+"("); i >= 0 && (open < 0 || i < open) {
+			open = i + len(sep+name)
+		}
+	}
+	if open < 0 {
+		open = strings.Index(usage, "(")
+	}
+	if open < 0 {
+		return "", nil
+	}
+	// Drop the assignment in "local data, err = json.decode(...)" and
+	// whatever the call is wrapped in
+	start := 0
+	if i := strings.LastIndexAny(usage[:open], "=( "); i >= 0 {
+		start = i + 1
+	}
+
+	var params []string
+	depth, from := 0, open+1
+	for i := open; i < len(usage); i++ {
+		switch usage[i] {
+		case '(', '{', '[':
+			depth++
+		case ')', '}', ']':
+			depth--
+		case ',':
+			if depth == 1 {
+				params = append(params, shortenArg(usage[from:i]))
+				from = i + 1
+			}
+		}
+		if depth == 0 {
+			if arg := shortenArg(usage[from:i]); arg != "" || len(params) > 0 {
+				params = append(params, arg)
+			}
+			break
+		}
+	}
+	label := strings.TrimSpace(usage[start:open]) + "(" + strings.Join(params, ", ") + ")"
+	return label, params
+}
+
+func shortenArg(arg string) string {
+	arg = strings.TrimSpace(arg)
+	if !strings.Contains(arg, "\n") {
+		return arg
+	}
+	if strings.HasPrefix(arg, "function") {
+		return "function(...) end"
+	}
+	if strings.HasPrefix(arg, "{") {
+		return "{...}"
+	}
+	return strings.Fields(arg)[0] + "..."
+}
@@ -0,0 +1,193 @@
+package docs
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/core/fs"
+	"github.com/zepzeper/vulgar/internal/modules/core/http"
+	"github.com/zepzeper/vulgar/internal/modules/core/json"
+	"github.com/zepzeper/vulgar/internal/modules/integrations/google/gsheets"
+)
+
+func TestLoad(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	L.PreloadModule("json", json.Loader)
+
+	m, err := Load(L, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decode := m.Exports["decode"]
+	if !m.Functions["decode"] || !decode.ReturnsError || !strings.HasPrefix(decode.Summary, "Parses") {
+		t.Errorf("decode = %+v", decode)
+	}
+
+	if _, err := Load(L, "missing"); err == nil {
+		t.Error("loading a missing module succeeded")
+	}
+}
+
+func TestSummariesAreProse(t *testing.T) {
+	for name, fn := range functions {
+		if strings.HasPrefix(fn.Summary, "Or:") || strings.HasPrefix(fn.Summary, "local ") {
+			t.Errorf("%s: summary is a usage line: %q", name, fn.Summary)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		usage  string
+		label  string
+		params []string
+	}{
+		{"decode", "local data, err = json.decode(json_string)", "json.decode(json_string)", []string{"json_string"}},
+		{"now", "local t = time.now()", "time.now()", nil},
+		{"configure", "local client, err = ollama.configure({base_url = \"x\", model = \"y\"})",
+			"ollama.configure({base_url = \"x\", model = \"y\"})", []string{"{base_url = \"x\", model = \"y\"}"}},
+		{"create_event", "local event, err = gcalendar.create_event(client, {\n  summary = \"Meeting\",\n})",
+			"gcalendar.create_event(client, {...})", []string{"client", "{...}"}},
+		{"get_async", "local resp, err = async.await(http.get_async(url))", "http.get_async(url)", []string{"url"}},
+	}
+	for _, tt := range tests {
+		label, params := Function{Usage: []string{tt.usage}}.Signature(tt.name)
+		if label != tt.label || strings.Join(params, "|") != strings.Join(tt.params, "|") {
+			t.Errorf("Signature(%q) = %q %q, want %q %q", tt.usage, label, params, tt.label, tt.params)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestWriteModuleGolden pins what 'vulgar doc' derives for modules that are
+// documented only by the comments of their functions
+func TestWriteModuleGolden(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	L.PreloadModule(http.ModuleName, http.Loader)
+	L.PreloadModule(fs.ModuleName, fs.Loader)
+
+	for _, name := range []string{http.ModuleName, fs.ModuleName} {
+		ref, err := Describe(L, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := WriteModule(&buf, ref); err != nil {
+			t.Fatal(err)
+		}
+
+		golden := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(want) {
+			t.Errorf("vulgar doc %s:\n%s\nwant:\n%s", name, buf.String(), want)
+		}
+	}
+}
+
+func newDescribeState(t *testing.T) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	L.PreloadModule("json", json.Loader)
+	L.PreloadModule(gsheets.ModuleName, gsheets.Loader)
+	return L
+}
+
+func TestDescribe(t *testing.T) {
+	L := newDescribeState(t)
+
+	// Documented only by comments
+	ref, fn, err := Query(L, "json.decode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Name != "json" || fn == nil || len(fn.Params) != 1 || fn.Params[0].Name != "json_string" {
+		t.Fatalf("json.decode = %+v", fn)
+	}
+	if len(fn.Returns) != 2 || fn.Returns[0].Name != "data" || !fn.Returns[0].Optional ||
+		fn.Returns[1].Name != "err" || fn.Returns[1].Type != "string" {
+		t.Errorf("json.decode returns = %+v", fn.Returns)
+	}
+
+	// Registered with modules.Info, named by its last component
+	ref, fn, err = Query(L, "gsheets.batch_update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Name != "integrations.gsheets" || ref.Summary == "" || len(ref.Config) == 0 {
+		t.Errorf("gsheets reference = %+v", ref)
+	}
+	if got := ref.Signature(*fn); got != "gsheets.batch_update(client, spreadsheet_id, opts)" {
+		t.Errorf("signature = %q", got)
+	}
+
+	if _, _, err := Query(L, "gsheets.missing"); err == nil || !strings.Contains(err.Error(), "no function missing") {
+		t.Errorf("unknown function error = %v", err)
+	}
+	if _, _, err := Query(L, "nope"); err == nil {
+		t.Error("unknown module succeeded")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	f := derive("set_values", Function{
+		Usage:        []string{`local result, err = gsheets.set_values(client, "id", "Sheet1!A1", {{"a"}}, 3, function(x) end)`},
+		ReturnsError: true,
+	})
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, p.Name+":"+p.Type)
+	}
+	want := "client:any arg2:string arg3:string opts:table arg5:number fn:function"
+	if got := strings.Join(params, " "); got != want {
+		t.Errorf("params = %s\nwant     %s", got, want)
+	}
+}
+
+func TestWriteMeta(t *testing.T) {
+	ref, err := Describe(newDescribeState(t), gsheets.ModuleName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteMeta(&buf, ref); err != nil {
+		t.Fatal(err)
+	}
+	meta := buf.String()
+	for _, want := range []string{
+		"---@meta integrations.gsheets\n",
+		"---@class gsheets.client\n",
+		"---@param client gsheets.client Client from gsheets.configure()\n",
+		"---@return string? err Error message, nil on success\n",
+		"function gsheets.batch_update(client, spreadsheet_id, opts) end\n",
+		"return gsheets\n",
+	} {
+		if !strings.Contains(meta, want) {
+			t.Errorf("definition file lacks %q", want)
+		}
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	if _, err := L.LoadString(meta); err != nil {
+		t.Errorf("definition file is not valid Lua: %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+// Command gen writes functions_gen.go, the documentation of the Go functions
+// behind module functions: the summary and "Usage:" lines of their doc
+// comments, and whether they return (result, err) by the util.PushError
+// convention.
+//
+// Run it through go generate in internal/modules/docs after adding or
+// changing module functions.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func main() {
+	modulesDir := flag.String("modules", "..", "directory with the module packages")
+	output := flag.String("o", "functions_gen.go", "output file")
+	flag.Parse()
+
+	funcs, err := scan(*modulesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := render(funcs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// function is a function declaration found in a module package
+type function struct {
+	// name is the qualified name runtime.FuncForPC reports
+	name    string
+	summary string
+	usage   []string
+	pushes  bool
+	// lgFunction is set for func(*lua.LState) int
+	lgFunction bool
+	// delegates are same-package functions whose results it may return
+	delegates []string
+}
+
+// scan returns the documented or error-returning lua.LGFunction
+// implementations below dir, sorted by name
+func scan(dir string) ([]*function, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, modulePath, err := findModule(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var funcs []*function
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		found, err := scanPackage(path, modulePath+"/"+filepath.ToSlash(rel))
+		funcs = append(funcs, found...)
+		return err
+	})
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].name < funcs[j].name })
+	return funcs, err
+}
+
+func scanPackage(dir, importPath string) ([]*function, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*function
+	for _, pkg := range pkgs {
+		funcs := make(map[string]*function)
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if fd, ok := decl.(*ast.FuncDecl); ok && fd.Body != nil {
+					f := inspectFunc(fd)
+					funcs[f.name] = f
+				}
+			}
+		}
+
+		// Functions that return a call to a returning function return
+		// (result, err) as well
+		for changed := true; changed; {
+			changed = false
+			for _, f := range funcs {
+				for _, callee := range f.delegates {
+					if g, ok := funcs[callee]; ok && g.pushes && !f.pushes {
+						f.pushes, changed = true, true
+					}
+				}
+			}
+		}
+
+		for _, f := range funcs {
+			if f.lgFunction && (f.pushes || f.summary != "" || len(f.usage) > 0) {
+				f.name = importPath + "." + f.name
+				found = append(found, f)
+			}
+		}
+	}
+	return found, nil
+}
+
+func inspectFunc(fd *ast.FuncDecl) *function {
+	f := &function{name: fd.Name.Name, lgFunction: isLGFunction(fd.Type)}
+	if fd.Recv != nil && len(fd.Recv.List) == 1 {
+		switch t := fd.Recv.List[0].Type.(type) {
+		case *ast.StarExpr:
+			if id, ok := t.X.(*ast.Ident); ok {
+				f.name = "(*" + id.Name + ")." + f.name
+			}
+		case *ast.Ident:
+			f.name = t.Name + "." + f.name
+		}
+	}
+	if fd.Doc != nil {
+		f.summary, f.usage = parseDoc(fd.Name.Name, fd.Doc.Text())
+	}
+
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			// Closures are separate functions
+			return false
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok {
+				if pkg, ok := sel.X.(*ast.Ident); ok && pkg.Name == "util" &&
+					(sel.Sel.Name == "PushError" || sel.Sel.Name == "PushSuccess") {
+					f.pushes = true
+				}
+			}
+		case *ast.ReturnStmt:
+			if len(n.Results) == 1 {
+				if call, ok := n.Results[0].(*ast.CallExpr); ok {
+					// The callee, or a function it is handed, as in
+					// return simpleRequest(L, "GET", doRequest)
+					for _, expr := range append([]ast.Expr{call.Fun}, call.Args...) {
+						if id, ok := expr.(*ast.Ident); ok {
+							f.delegates = append(f.delegates, id.Name)
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+	return f
+}
+
+// isLGFunction reports whether a function has the lua.LGFunction signature
+func isLGFunction(t *ast.FuncType) bool {
+	if t.Params == nil || len(t.Params.List) != 1 || len(t.Params.List[0].Names) > 1 ||
+		t.Results == nil || len(t.Results.List) != 1 {
+		return false
+	}
+	star, ok := t.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "LState" {
+		return false
+	}
+	result, ok := t.Results.List[0].Type.(*ast.Ident)
+	return ok && result.Name == "int"
+}
+
+// parseDoc splits a doc comment into its summary and usage examples. A
+// usage example starts with "Usage:", or "Or:" for an alternative, and
+// continues over the following lines while its brackets are open. Other
+// lines of code are left out of the summary.
+func parseDoc(funcName, doc string) (string, []string) {
+	var summary []string
+	var usage []string
+	open := 0
+	for _, line := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if open > 0 {
+			usage[len(usage)-1] += "\n" + strings.TrimRight(strings.TrimPrefix(line, "\t"), " ")
+			open += bracketBalance(trimmed)
+			continue
+		}
+		if example, ok := cutUsage(trimmed); ok {
+			example = strings.TrimSpace(example)
+			if example != "" {
+				usage = append(usage, example)
+				open = bracketBalance(example)
+			}
+			continue
+		}
+		if trimmed != "" && !codeLine.MatchString(trimmed) {
+			summary = append(summary, trimmed)
+		}
+	}
+
+	text := strings.Join(summary, " ")
+	// "luaGet fetches a URL" documents get: drop the Go name, and the verb
+	// of "luaV4 is an alias for new"
+	if rest, ok := strings.CutPrefix(text, funcName+" "); ok {
+		text = strings.TrimPrefix(rest, "is ")
+		if r, size := utf8.DecodeRuneInString(text); size > 0 {
+			text = string(unicode.ToUpper(r)) + text[size:]
+		}
+	}
+	return text, usage
+}
+
+func cutUsage(line string) (string, bool) {
+	if example, ok := strings.CutPrefix(line, "Usage:"); ok {
+		return example, true
+	}
+	return strings.CutPrefix(line, "Or:")
+}
+
+// codeLine matches doc lines that are Lua rather than prose: statements,
+// calls and tables, such as the shape of a result after "Returns:"
+var codeLine = regexp.MustCompile(`^(local\s|[\w.:]+\(|(Returns:\s*)?\{)`)
+
+func bracketBalance(s string) int {
+	n := 0
+	for _, r := range s {
+		switch r {
+		case '(', '{', '[':
+			n++
+		case ')', '}', ']':
+			n--
+		}
+	}
+	return n
+}
+
+// findModule returns the directory and path of the Go module containing dir
+func findModule(dir string) (string, string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		data, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if path, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+					return d, strings.TrimSpace(path), nil
+				}
+			}
+			return "", "", fmt.Errorf("%s/go.mod has no module line", d)
+		}
+		if d == filepath.Dir(d) {
+			return "", "", fmt.Errorf("no go.mod above %s", dir)
+		}
+	}
+}
+
+func render(funcs []*function) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(`// Code generated by go run ./gen; DO NOT EDIT.
+
+package docs
+
+var functions = map[string]Function{
+`)
+	for _, f := range funcs {
+		var fields []string
+		if f.summary != "" {
+			fields = append(fields, fmt.Sprintf("Summary: %q", f.summary))
+		}
+		if len(f.usage) > 0 {
+			quoted := make([]string, len(f.usage))
+			for i, u := range f.usage {
+				quoted[i] = fmt.Sprintf("%q", u)
+			}
+			fields = append(fields, "Usage: []string{"+strings.Join(quoted, ", ")+"}")
+		}
+		if f.pushes {
+			fields = append(fields, "ReturnsError: true")
+		}
+		fmt.Fprintf(&b, "\t%q: {%s},\n", f.name, strings.Join(fields, ", "))
+	}
+	b.WriteString("}\n")
+	return format.Source(b.Bytes())
+}
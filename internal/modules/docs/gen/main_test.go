@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedFileIsCurrent(t *testing.T) {
+	names, err := scan("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := render(names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../functions_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("functions_gen.go is out of date; run go generate ./internal/modules/docs")
+	}
+}
+
+func TestParseDoc(t *testing.T) {
+	doc := "luaCreateEvent creates a new\nevent\n\n\tUsage: local event, err = gcalendar.create_event(client, {\n\t  summary = \"Meeting\"\n\t})\n\tUsage: gcalendar.create_event(client, opts)\n"
+	summary, usage := parseDoc("luaCreateEvent", doc)
+	if summary != "Creates a new event" {
+		t.Errorf("summary = %q", summary)
+	}
+	want := []string{
+		"local event, err = gcalendar.create_event(client, {\n  summary = \"Meeting\"\n})",
+		"gcalendar.create_event(client, opts)",
+	}
+	if len(usage) != len(want) || usage[0] != want[0] || usage[1] != want[1] {
+		t.Errorf("usage = %q\nwant    %q", usage, want)
+	}
+	if summary, _ := parseDoc("luaV4", "luaV4 is an alias for new\n"); summary != "An alias for new" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	doc = "Usage: local client, err = slack.client()\nOr: local client, err = slack.client({\n  token = \"xoxb-...\"\n})\n"
+	summary, usage = parseDoc("luaClient", doc)
+	if summary != "" {
+		t.Errorf("summary = %q", summary)
+	}
+	want = []string{"local client, err = slack.client()", "local client, err = slack.client({\n  token = \"xoxb-...\"\n})"}
+	if len(usage) != len(want) || usage[0] != want[0] || usage[1] != want[1] {
+		t.Errorf("usage = %q\nwant    %q", usage, want)
+	}
+
+	doc = "luaGetNodes returns every node\nReturns: { {name=\"node1\"}, ... }\nUsage: local nodes = workflow.get_nodes(wf)\n"
+	if summary, _ := parseDoc("luaGetNodes", doc); summary != "Returns every node" {
+		t.Errorf("summary = %q", summary)
+	}
+}
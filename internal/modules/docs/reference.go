@@ -0,0 +1,242 @@
+package docs
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules"
+)
+
+// Reference is the documentation of a module: the modules.Info it was
+// registered with, completed from the doc comments of its functions
+type Reference struct {
+	Name    string
+	Summary string
+	// Functions are the exported functions, sorted by name
+	Functions []modules.FunctionInfo
+	// Fields are the exported values that are not functions
+	Fields   []string
+	Config   []modules.ConfigKey
+	Examples []string
+}
+
+// Describe loads a module in L and documents it
+func Describe(L *lua.LState, name string) (*Reference, error) {
+	m, err := Load(L, name)
+	if err != nil {
+		return nil, err
+	}
+	info, _ := modules.GetInfo(name)
+	ref := &Reference{Name: name, Summary: info.Summary, Config: info.Config, Examples: info.Examples}
+
+	explicit := make(map[string]modules.FunctionInfo)
+	for _, f := range info.Functions {
+		explicit[f.Name] = f
+	}
+	exports := make([]string, 0, len(m.Exports))
+	for export := range m.Exports {
+		exports = append(exports, export)
+	}
+	sort.Strings(exports)
+
+	for _, export := range exports {
+		if !m.Functions[export] {
+			ref.Fields = append(ref.Fields, export)
+			continue
+		}
+		derived := derive(export, m.Exports[export])
+		f, ok := explicit[export]
+		if !ok {
+			ref.Functions = append(ref.Functions, derived)
+			continue
+		}
+		if f.Summary == "" {
+			f.Summary = derived.Summary
+		}
+		if len(f.Examples) == 0 {
+			f.Examples = derived.Examples
+		}
+		ref.Functions = append(ref.Functions, f)
+	}
+	return ref, nil
+}
+
+// Function returns the documentation of an exported function
+func (r *Reference) Function(name string) (modules.FunctionInfo, bool) {
+	for _, f := range r.Functions {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return modules.FunctionInfo{}, false
+}
+
+// Variable is the local a script conventionally requires the module into,
+// as gsheets for integrations.gsheets
+func (r *Reference) Variable() string {
+	name := r.Name[strings.LastIndex(r.Name, ".")+1:]
+	if !identPattern.MatchString(name) || luaKeywords[name] {
+		return "M"
+	}
+	return name
+}
+
+// Signature returns how a function is called, as
+// gsheets.get_values(client, spreadsheet_id, range). Optional parameters
+// are marked with a question mark.
+func (r *Reference) Signature(f modules.FunctionInfo) string {
+	params := make([]string, len(f.Params))
+	for i, p := range f.Params {
+		params[i] = p.Name
+		if p.Optional {
+			params[i] += "?"
+		}
+	}
+	return r.Variable() + "." + f.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+// Resolve splits a query such as integrations.gsheets.batch_update into a
+// module name and an optional function name. Modules can be named by their
+// last component alone, as in gsheets.batch_update.
+func Resolve(query string) (string, string, bool) {
+	registry := modules.GetRegistry()
+	if _, ok := registry[query]; ok {
+		return query, "", true
+	}
+	if i := strings.LastIndex(query, "."); i > 0 {
+		if _, ok := registry[query[:i]]; ok {
+			return query[:i], query[i+1:], true
+		}
+	}
+
+	short, fn, _ := strings.Cut(query, ".")
+	var matches []string
+	for name := range registry {
+		if strings.HasSuffix(name, "."+short) {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) != 1 {
+		return "", "", false
+	}
+	return matches[0], fn, true
+}
+
+var (
+	identPattern  = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+	numberPattern = regexp.MustCompile(`^-?[\d.]+$`)
+	luaKeywords   = map[string]bool{
+		"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+		"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+		"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+		"true": true, "until": true, "while": true,
+	}
+)
+
+// derive documents a function from its doc comment. Parameters and returns
+// are named after the first usage example; literal arguments are described
+// by their type and the example value.
+func derive(name string, doc Function) modules.FunctionInfo {
+	f := modules.FunctionInfo{Name: name, Summary: doc.Summary, Examples: doc.Usage}
+	_, args := doc.Signature(name)
+	used := make(map[string]bool)
+	for i, arg := range args {
+		p := modules.Value{Name: arg, Type: "any"}
+		switch {
+		case arg == "...":
+		case identPattern.MatchString(arg) && !luaKeywords[arg]:
+		case strings.HasPrefix(arg, `"`) || strings.HasPrefix(arg, "'"):
+			p = modules.Value{Type: "string", Description: "e.g. " + arg}
+		case numberPattern.MatchString(arg):
+			p = modules.Value{Type: "number", Description: "e.g. " + arg}
+		case arg == "true" || arg == "false":
+			p = modules.Value{Type: "boolean"}
+		case strings.HasPrefix(arg, "function"):
+			p = modules.Value{Name: "fn", Type: "function"}
+		case strings.HasPrefix(arg, "{"):
+			p = modules.Value{Name: "opts", Type: "table"}
+			if arg != "{...}" {
+				p.Description = "e.g. " + arg
+			}
+		default:
+			p = modules.Value{Type: "any", Description: "e.g. " + arg}
+		}
+		if p.Name == "" || used[p.Name] {
+			p.Name = "arg" + strconv.Itoa(i+1)
+		}
+		used[p.Name] = true
+		f.Params = append(f.Params, p)
+	}
+
+	for _, ret := range returnNames(doc) {
+		v := modules.Value{Name: ret, Type: "any"}
+		if ret == "err" {
+			v = modules.Value{Name: ret, Type: "string", Description: "Error message, nil on success", Optional: true}
+		} else if doc.ReturnsError {
+			// Results are nil when the call fails
+			v.Optional = true
+		}
+		f.Returns = append(f.Returns, v)
+	}
+	return f
+}
+
+// returnNames returns the names a usage example assigns results to, as
+// data and err in local data, err = json.decode(s)
+func returnNames(doc Function) []string {
+	if len(doc.Usage) == 0 {
+		return nil
+	}
+	usage := doc.Usage[0]
+	call := strings.Index(usage, "(")
+	eq := strings.Index(usage, "=")
+	if eq < 0 || call >= 0 && eq > call {
+		return nil
+	}
+	var names []string
+	for _, name := range strings.Split(strings.TrimPrefix(usage[:eq], "local "), ",") {
+		name = strings.TrimSpace(name)
+		if !identPattern.MatchString(name) {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// typeString returns the type of a value as LuaLS writes it
+func typeString(v modules.Value) string {
+	t := v.Type
+	if t == "" {
+		t = "any"
+	}
+	if v.Optional && t != "any" && !strings.HasSuffix(t, "?") {
+		t += "?"
+	}
+	return t
+}
+
+// Query documents what a query such as gsheets.batch_update names: a
+// module, or a function when the returned FunctionInfo is not nil
+func Query(L *lua.LState, query string) (*Reference, *modules.FunctionInfo, error) {
+	name, fn, ok := Resolve(query)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown module %q", query)
+	}
+	ref, err := Describe(L, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fn == "" {
+		return ref, nil, nil
+	}
+	f, ok := ref.Function(fn)
+	if !ok {
+		return nil, nil, fmt.Errorf("module %q has no function %s", name, fn)
+	}
+	return ref, &f, nil
+}
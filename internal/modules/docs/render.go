@@ -0,0 +1,196 @@
+package docs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zepzeper/vulgar/internal/modules"
+)
+
+// WriteModule writes the documentation of a module as text
+func WriteModule(w io.Writer, ref *Reference) error {
+	var b strings.Builder
+	b.WriteString(ref.Name)
+	if ref.Summary != "" {
+		b.WriteString(" - " + ref.Summary)
+	}
+	fmt.Fprintf(&b, "\n\n    local %s = require(%q)\n", ref.Variable(), ref.Name)
+
+	if len(ref.Config) > 0 {
+		b.WriteString("\nConfiguration (~/.config/vulgar/config.toml):\n")
+		tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		for _, c := range ref.Config {
+			fmt.Fprintf(tw, "    %s\t%s\n", c.Key, c.Description)
+		}
+		tw.Flush()
+	}
+
+	if len(ref.Functions) > 0 {
+		b.WriteString("\nFunctions:\n")
+		tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+		for _, f := range ref.Functions {
+			fmt.Fprintf(tw, "    %s\t%s\n", ref.Signature(f), firstSentence(f.Summary))
+		}
+		tw.Flush()
+	}
+	if len(ref.Fields) > 0 {
+		fmt.Fprintf(&b, "\nFields:\n    %s\n", strings.Join(ref.Fields, ", "))
+	}
+	writeExamples(&b, ref.Examples)
+
+	if len(ref.Functions) > 0 {
+		fmt.Fprintf(&b, "\nRun 'vulgar doc %s.<function>' for details.\n", ref.Name)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteFunction writes the documentation of a module function as text
+func WriteFunction(w io.Writer, ref *Reference, f modules.FunctionInfo) error {
+	var b strings.Builder
+	b.WriteString(ref.Signature(f) + "\n")
+	if f.Summary != "" {
+		b.WriteString("\n" + f.Summary + "\n")
+	}
+	writeValues(&b, "Parameters", f.Params)
+	writeValues(&b, "Returns", f.Returns)
+	writeExamples(&b, f.Examples)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeValues(b *strings.Builder, title string, values []modules.Value) {
+	if len(values) == 0 {
+		return
+	}
+	b.WriteString("\n" + title + ":\n")
+	tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	for _, v := range values {
+		fmt.Fprintf(tw, "    %s\t%s\t%s\n", v.Name, typeString(v), v.Description)
+	}
+	tw.Flush()
+}
+
+func writeExamples(b *strings.Builder, examples []string) {
+	if len(examples) == 0 {
+		return
+	}
+	b.WriteString("\nExamples:\n")
+	for _, example := range examples {
+		b.WriteString("    " + strings.ReplaceAll(example, "\n", "\n    ") + "\n")
+	}
+}
+
+// firstSentence shortens a summary for one-line listings
+func firstSentence(s string) string {
+	if i := strings.Index(s, ". "); i >= 0 {
+		return s[:i]
+	}
+	return strings.TrimSuffix(s, ".")
+}
+
+// WriteMeta writes a LuaLS definition file for a module. Placed in a
+// workspace library, it gives the Lua language server the module's
+// functions, parameter and return types:
+//
+//	---@meta integrations.gsheets
+//	local gsheets = {}
+//	---@param client any
+//	function gsheets.list_sheets(client, spreadsheet_id) end
+//	return gsheets
+func WriteMeta(w io.Writer, ref *Reference) error {
+	v := ref.Variable()
+	var b strings.Builder
+	fmt.Fprintf(&b, "---@meta %s\n\n", ref.Name)
+	for _, t := range customTypes(ref) {
+		fmt.Fprintf(&b, "---@class %s\n\n", t)
+	}
+	if ref.Summary != "" {
+		writeDocComment(&b, ref.Summary)
+	}
+	fmt.Fprintf(&b, "---@class %s\nlocal %s = {}\n", ref.Name, v)
+	for _, field := range ref.Fields {
+		fmt.Fprintf(&b, "\n---@type any\n%s[%q] = nil\n", v, field)
+	}
+
+	for _, f := range ref.Functions {
+		b.WriteString("\n")
+		if f.Summary != "" {
+			writeDocComment(&b, f.Summary)
+		}
+		for _, example := range f.Examples {
+			b.WriteString("---\n---```lua\n")
+			writeDocComment(&b, example)
+			b.WriteString("---```\n")
+		}
+
+		names := make([]string, len(f.Params))
+		for i, p := range f.Params {
+			names[i] = p.Name
+			name := p.Name
+			if p.Optional && name != "..." {
+				name += "?"
+			}
+			fmt.Fprintf(&b, "---@param %s %s%s\n", name, orAny(p.Type), metaDescription(p.Description))
+		}
+		for _, r := range f.Returns {
+			fmt.Fprintf(&b, "---@return %s %s%s\n", typeString(r), r.Name, metaDescription(r.Description))
+		}
+		if identPattern.MatchString(f.Name) && !luaKeywords[f.Name] {
+			fmt.Fprintf(&b, "function %s.%s(%s) end\n", v, f.Name, strings.Join(names, ", "))
+		} else {
+			fmt.Fprintf(&b, "%s[%q] = function(%s) end\n", v, f.Name, strings.Join(names, ", "))
+		}
+	}
+	fmt.Fprintf(&b, "\nreturn %s\n", v)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var builtinTypes = map[string]bool{
+	"any": true, "nil": true, "boolean": true, "string": true, "number": true, "integer": true,
+	"table": true, "function": true, "userdata": true, "thread": true,
+}
+
+// customTypes returns the types of a module's values that LuaLS does not
+// know, such as gsheets.client, so definition files can declare them
+func customTypes(ref *Reference) []string {
+	seen := make(map[string]bool)
+	var types []string
+	for _, f := range ref.Functions {
+		for _, v := range append(append([]modules.Value{}, f.Params...), f.Returns...) {
+			for _, t := range strings.Split(v.Type, "|") {
+				t = strings.TrimRight(strings.TrimSpace(t), "?[]")
+				if t != "" && !builtinTypes[t] && !seen[t] {
+					seen[t] = true
+					types = append(types, t)
+				}
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func writeDocComment(b *strings.Builder, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString("---" + line + "\n")
+	}
+}
+
+func metaDescription(s string) string {
+	if s == "" {
+		return ""
+	}
+	return " " + strings.ReplaceAll(s, "\n", " ")
+}
+
+func orAny(t string) string {
+	if t == "" {
+		return "any"
+	}
+	return t
+}
@@ -0,0 +1,57 @@
+package modules
+
+import lua "github.com/yuin/gopher-lua"
+
+// Info documents a module for 'vulgar doc', the REPL and editor annotations.
+// Functions it leaves out are documented from the "Usage:" comments of
+// their Go implementations.
+type Info struct {
+	// Summary says what the module is for, in one sentence
+	Summary string
+	// Functions documents exported functions
+	Functions []FunctionInfo
+	// Config lists the keys of ~/.config/vulgar/config.toml the module reads
+	Config []ConfigKey
+	// Examples show the module in use
+	Examples []string
+}
+
+// FunctionInfo documents an exported function
+type FunctionInfo struct {
+	Name     string
+	Summary  string
+	Params   []Value
+	Returns  []Value
+	Examples []string
+}
+
+// Value documents a parameter or return value
+type Value struct {
+	Name string
+	// Type is a LuaLS type such as string, table, string[] or gsheets.client
+	Type        string
+	Description string
+	// Optional parameters may be omitted; optional returns may be nil
+	Optional bool
+}
+
+// ConfigKey documents a configuration key, as "section.key"
+type ConfigKey struct {
+	Key         string
+	Description string
+}
+
+var infos = make(map[string]Info)
+
+// RegisterWithInfo registers a module loader together with its
+// documentation
+func RegisterWithInfo(name string, loader lua.LGFunction, info Info) {
+	Register(name, loader)
+	infos[name] = info
+}
+
+// GetInfo returns the documentation a module was registered with
+func GetInfo(name string) (Info, bool) {
+	info, ok := infos[name]
+	return info, ok
+}
@@ -1,13 +1,13 @@
 package codeberg
 
 import (
-	"context"
 	"strings"
 	"sync"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	codeberg "github.com/zepzeper/vulgar/internal/services/codeberg"
 )
 
@@ -92,6 +92,9 @@ func luaClient(L *lua.LState) int {
 	if err != nil {
 		return util.PushError(L, "failed to create client: %v", err)
 	}
+	if err := sandbox.CheckURL(L, svc.BaseURL()); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	client := &codebergClient{svc: svc}
 
@@ -125,9 +128,9 @@ func luaListRepos(L *lua.LState) int {
 	var err error
 
 	if owner != "" {
-		repos, err = client.svc.ListOwnerRepositories(context.Background(), owner, limit)
+		repos, err = client.svc.ListOwnerRepositories(util.Context(L), owner, limit)
 	} else {
-		repos, err = client.svc.ListUserRepositories(context.Background(), limit)
+		repos, err = client.svc.ListUserRepositories(util.Context(L), limit)
 	}
 
 	if err != nil {
@@ -162,7 +165,7 @@ func luaListIssues(L *lua.LState) int {
 		}
 	}
 
-	issues, err := client.svc.ListIssues(context.Background(), owner, repoName, state, limit)
+	issues, err := client.svc.ListIssues(util.Context(L), owner, repoName, state, limit)
 	if err != nil {
 		return util.PushError(L, "list issues failed: %v", err)
 	}
@@ -195,7 +198,7 @@ func luaListPRs(L *lua.LState) int {
 		}
 	}
 
-	prs, err := client.svc.ListPullRequests(context.Background(), owner, repoName, state, limit)
+	prs, err := client.svc.ListPullRequests(util.Context(L), owner, repoName, state, limit)
 	if err != nil {
 		return util.PushError(L, "list prs failed: %v", err)
 	}
@@ -229,7 +232,7 @@ func luaCreateIssue(L *lua.LState) int {
 		req.Body = lua.LVAsString(v)
 	}
 
-	issue, err := client.svc.CreateIssue(context.Background(), owner, repoName, req)
+	issue, err := client.svc.CreateIssue(util.Context(L), owner, repoName, req)
 	if err != nil {
 		return util.PushError(L, "create issue failed: %v", err)
 	}
@@ -273,7 +276,7 @@ func luaCreatePR(L *lua.LState) int {
 		req.Body = lua.LVAsString(v)
 	}
 
-	pr, err := client.svc.CreatePullRequest(context.Background(), owner, repoName, req)
+	pr, err := client.svc.CreatePullRequest(util.Context(L), owner, repoName, req)
 	if err != nil {
 		return util.PushError(L, "create pr failed: %v", err)
 	}
@@ -288,7 +291,7 @@ func luaGetUser(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	user, err := client.svc.GetCurrentUser(context.Background())
+	user, err := client.svc.GetCurrentUser(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "get user failed: %v", err)
 	}
@@ -3,6 +3,7 @@ package github
 import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	github "github.com/zepzeper/vulgar/internal/services/github"
 )
 
@@ -81,6 +82,9 @@ func luaClient(L *lua.LState) int {
 	if err != nil {
 		return util.PushError(L, "failed to create client: %v", err)
 	}
+	if err := sandbox.CheckURL(L, github.DefaultBaseURL); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	client := &githubClient{svc: svc}
 
@@ -1,8 +1,6 @@
 package github
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	github "github.com/zepzeper/vulgar/internal/services/github"
@@ -35,7 +33,7 @@ func luaListCommits(L *lua.LState) int {
 		}
 	}
 
-	commits, err := client.svc.ListCommits(context.Background(), owner, repo, opts)
+	commits, err := client.svc.ListCommits(util.Context(L), owner, repo, opts)
 	if err != nil {
 		return util.PushError(L, "list commits failed: %v", err)
 	}
@@ -1,7 +1,6 @@
 package github
 
 import (
-	"context"
 	"strings"
 
 	lua "github.com/yuin/gopher-lua"
@@ -33,7 +32,7 @@ func luaListIssues(L *lua.LState) int {
 		}
 	}
 
-	issues, err := client.svc.ListIssues(context.Background(), owner, repo, opts)
+	issues, err := client.svc.ListIssues(util.Context(L), owner, repo, opts)
 	if err != nil {
 		return util.PushError(L, "list issues failed: %v", err)
 	}
@@ -86,7 +85,7 @@ func luaCreateIssue(L *lua.LState) int {
 		}
 	}
 
-	issue, err := client.svc.CreateIssue(context.Background(), owner, repo, req)
+	issue, err := client.svc.CreateIssue(util.Context(L), owner, repo, req)
 	if err != nil {
 		return util.PushError(L, "create issue failed: %v", err)
 	}
@@ -1,8 +1,6 @@
 package github
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	github "github.com/zepzeper/vulgar/internal/services/github"
@@ -35,7 +33,7 @@ func luaListPRs(L *lua.LState) int {
 		}
 	}
 
-	prs, err := client.svc.ListPullRequests(context.Background(), owner, repo, opts)
+	prs, err := client.svc.ListPullRequests(util.Context(L), owner, repo, opts)
 	if err != nil {
 		return util.PushError(L, "list prs failed: %v", err)
 	}
@@ -75,7 +73,7 @@ func luaCreatePR(L *lua.LState) int {
 		req.Body = lua.LVAsString(v)
 	}
 
-	pr, err := client.svc.CreatePullRequest(context.Background(), owner, repo, req)
+	pr, err := client.svc.CreatePullRequest(util.Context(L), owner, repo, req)
 	if err != nil {
 		return util.PushError(L, "create pr failed: %v", err)
 	}
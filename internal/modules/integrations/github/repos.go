@@ -1,8 +1,6 @@
 package github
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	github "github.com/zepzeper/vulgar/internal/services/github"
@@ -18,7 +16,7 @@ func luaGetRepo(L *lua.LState) int {
 	owner := L.CheckString(2)
 	repo := L.CheckString(3)
 
-	repository, err := client.svc.GetRepository(context.Background(), owner, repo)
+	repository, err := client.svc.GetRepository(util.Context(L), owner, repo)
 	if err != nil {
 		return util.PushError(L, "get repo failed: %v", err)
 	}
@@ -61,9 +59,9 @@ func luaListRepos(L *lua.LState) int {
 	var err error
 
 	if owner != "" {
-		repos, err = client.svc.ListOwnerRepositories(context.Background(), owner, opts)
+		repos, err = client.svc.ListOwnerRepositories(util.Context(L), owner, opts)
 	} else {
-		repos, err = client.svc.ListUserRepositories(context.Background(), opts)
+		repos, err = client.svc.ListUserRepositories(util.Context(L), opts)
 	}
 
 	if err != nil {
@@ -1,8 +1,6 @@
 package github
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -14,7 +12,7 @@ func luaGetUser(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	user, err := client.svc.GetCurrentUser(context.Background())
+	user, err := client.svc.GetCurrentUser(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "get user failed: %v", err)
 	}
@@ -29,7 +27,7 @@ func luaRateLimit(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	limit, err := client.svc.GetRateLimit(context.Background())
+	limit, err := client.svc.GetRateLimit(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "get rate limit failed: %v", err)
 	}
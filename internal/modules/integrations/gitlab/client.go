@@ -3,6 +3,7 @@ package gitlab
 import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
 )
 
@@ -102,6 +103,9 @@ func luaClient(L *lua.LState) int {
 	if err != nil {
 		return util.PushError(L, "failed to create client: %v", err)
 	}
+	if err := sandbox.CheckURL(L, svc.BaseURL()); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	client := &gitlabClient{svc: svc}
 
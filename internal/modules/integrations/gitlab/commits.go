@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -34,7 +32,7 @@ func luaListCommits(L *lua.LState) int {
 		}
 	}
 
-	commits, err := client.svc.ListCommits(context.Background(), project, opts)
+	commits, err := client.svc.ListCommits(util.Context(L), project, opts)
 	if err != nil {
 		return util.PushError(L, "list commits failed: %v", err)
 	}
@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -31,7 +29,7 @@ func luaListIssues(L *lua.LState) int {
 		}
 	}
 
-	issues, err := client.svc.ListIssues(context.Background(), project, opts)
+	issues, err := client.svc.ListIssues(util.Context(L), project, opts)
 	if err != nil {
 		return util.PushError(L, "list issues failed: %v", err)
 	}
@@ -71,7 +69,7 @@ func luaCreateIssue(L *lua.LState) int {
 		}
 	}
 
-	issue, err := client.svc.CreateIssue(context.Background(), project, req)
+	issue, err := client.svc.CreateIssue(util.Context(L), project, req)
 	if err != nil {
 		return util.PushError(L, "create issue failed: %v", err)
 	}
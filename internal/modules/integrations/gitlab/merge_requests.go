@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -31,7 +29,7 @@ func luaListMergeRequests(L *lua.LState) int {
 		}
 	}
 
-	mrs, err := client.svc.ListMergeRequests(context.Background(), project, opts)
+	mrs, err := client.svc.ListMergeRequests(util.Context(L), project, opts)
 	if err != nil {
 		return util.PushError(L, "list merge requests failed: %v", err)
 	}
@@ -70,7 +68,7 @@ func luaCreateMergeRequest(L *lua.LState) int {
 		req.Description = lua.LVAsString(v)
 	}
 
-	mr, err := client.svc.CreateMergeRequest(context.Background(), project, req)
+	mr, err := client.svc.CreateMergeRequest(util.Context(L), project, req)
 	if err != nil {
 		return util.PushError(L, "create merge request failed: %v", err)
 	}
@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -34,7 +32,7 @@ func luaListPipelines(L *lua.LState) int {
 		}
 	}
 
-	pipelines, err := client.svc.ListPipelines(context.Background(), project, opts)
+	pipelines, err := client.svc.ListPipelines(util.Context(L), project, opts)
 	if err != nil {
 		return util.PushError(L, "list pipelines failed: %v", err)
 	}
@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -17,7 +15,7 @@ func luaGetProject(L *lua.LState) int {
 
 	project := L.CheckString(2)
 
-	proj, err := client.svc.GetProject(context.Background(), project)
+	proj, err := client.svc.GetProject(util.Context(L), project)
 	if err != nil {
 		return util.PushError(L, "get project failed: %v", err)
 	}
@@ -50,7 +48,7 @@ func luaListProjects(L *lua.LState) int {
 		}
 	}
 
-	projects, err := client.svc.ListProjects(context.Background(), opts)
+	projects, err := client.svc.ListProjects(util.Context(L), opts)
 	if err != nil {
 		return util.PushError(L, "list projects failed: %v", err)
 	}
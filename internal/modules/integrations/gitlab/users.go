@@ -1,8 +1,6 @@
 package gitlab
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 	gitlab "github.com/zepzeper/vulgar/internal/services/gitlab"
@@ -15,7 +13,7 @@ func luaGetUser(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	user, err := client.svc.GetCurrentUser(context.Background())
+	user, err := client.svc.GetCurrentUser(util.Context(L))
 	if err != nil {
 		return util.PushError(L, "get user failed: %v", err)
 	}
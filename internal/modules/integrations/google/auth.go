@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	googleauth "github.com/zepzeper/vulgar/internal/services/google"
 	"google.golang.org/api/option"
 )
@@ -21,3 +23,17 @@ func ClientOption(ctx context.Context) (option.ClientOption, error) {
 	}
 	return option.WithHTTPClient(client), nil
 }
+
+// tokenHost serves the OAuth token refreshes every Google API client makes
+const tokenHost = "oauth2.googleapis.com"
+
+// CheckAccess enforces sandbox network rules for a Google API served from
+// host, e.g. --allow net=*.googleapis.com
+func CheckAccess(L *lua.LState, host string) error {
+	for _, h := range []string{host, tokenHost} {
+		if err := sandbox.Check(L, sandbox.Net, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
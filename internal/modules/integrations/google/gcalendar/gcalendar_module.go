@@ -53,6 +53,10 @@ func getClient(L *lua.LState, idx int) *clientWrapper {
 // Usage: local client, err = gcalendar.configure()
 // Note: Requires prior authentication via 'vulgar gcalendar login'
 func luaConfigure(L *lua.LState) int {
+	if err := gauth.CheckAccess(L, "www.googleapis.com"); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
 	ctx := context.Background()
 
 	clientOpt, err := gauth.ClientOption(ctx)
@@ -13,6 +13,10 @@ import (
 // Usage: local client, err = gdrive.configure()
 // Note: Requires prior authentication via 'vulgar gdrive login'
 func luaConfigure(L *lua.LState) int {
+	if err := gauth.CheckAccess(L, "www.googleapis.com"); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
 	ctx := context.Background()
 
 	clientOpt, err := gauth.ClientOption(ctx)
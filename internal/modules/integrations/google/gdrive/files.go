@@ -8,6 +8,7 @@ import (
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	"google.golang.org/api/drive/v3"
 )
 
@@ -146,6 +147,9 @@ func luaDownload(L *lua.LState) int {
 	if opts != nil {
 		if v := opts.RawGetString("path"); v != lua.LNil {
 			destPath := v.String()
+			if err := sandbox.Check(L, sandbox.FS, destPath); err != nil {
+				return util.PushError(L, "%v", err)
+			}
 			if err := os.WriteFile(destPath, content, 0644); err != nil {
 				return util.PushError(L, "failed to write file: %v", err)
 			}
@@ -185,6 +189,9 @@ func luaUpload(L *lua.LState) int {
 
 	if v := opts.RawGetString("path"); v != lua.LNil {
 		filePath := v.String()
+		if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 		file, err := os.Open(filePath)
 		if err != nil {
 			return util.PushError(L, "failed to open file: %v", err)
@@ -45,6 +45,10 @@ func getClient(L *lua.LState, idx int) *clientWrapper {
 // Usage: local client, err = gsheets.configure()
 // Note: Requires prior authentication via 'vulgar gsheets login'
 func luaConfigure(L *lua.LState) int {
+	if err := gauth.CheckAccess(L, "sheets.googleapis.com"); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
 	ctx := context.Background()
 
 	clientOpt, err := gauth.ClientOption(ctx)
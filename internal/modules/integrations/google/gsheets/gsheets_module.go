@@ -32,5 +32,5 @@ func Loader(L *lua.LState) int {
 
 // Auto-register with the module registry
 func init() {
-	modules.Register(ModuleName, Loader)
+	modules.RegisterWithInfo(ModuleName, Loader, info)
 }
@@ -0,0 +1,137 @@
+package gsheets
+
+import "github.com/zepzeper/vulgar/internal/modules"
+
+var (
+	clientParam        = modules.Value{Name: "client", Type: clientType, Description: "Client from gsheets.configure()"}
+	spreadsheetIDParam = modules.Value{Name: "spreadsheet_id", Type: "string", Description: "ID from the spreadsheet's URL"}
+	errReturn          = modules.Value{Name: "err", Type: "string", Description: "Error message, nil on success", Optional: true}
+)
+
+// info documents the module for 'vulgar doc'
+var info = modules.Info{
+	Summary: "Read and write Google Sheets spreadsheets",
+	Config: []modules.ConfigKey{
+		{Key: "google.client_id", Description: "OAuth client ID of a Desktop app from the Google Cloud Console"},
+		{Key: "google.client_secret", Description: "OAuth client secret of the same app"},
+	},
+	Examples: []string{`local client, err = gsheets.configure()
+local rows, err = gsheets.get_values(client, spreadsheet_id, "Sheet1!A2:C")
+for _, row in ipairs(rows) do print(row[1]) end`},
+	Functions: []modules.FunctionInfo{
+		{
+			Name:    "configure",
+			Summary: "Creates a client with the credentials stored by 'vulgar gsheets login'.",
+			Returns: []modules.Value{{Name: "client", Type: clientType, Optional: true}, errReturn},
+		},
+		{
+			Name:    "get_values",
+			Summary: "Reads the values of a range as rows of cells.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "range", Type: "string", Description: `A1 notation, as "Sheet1!A1:D10"`}},
+			Returns: []modules.Value{{Name: "values", Type: "any[][]", Description: "Rows of cell values", Optional: true}, errReturn},
+		},
+		{
+			Name:    "set_values",
+			Summary: "Writes rows of values starting at a range. Values are parsed as if typed into the sheet.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "range", Type: "string", Description: `A1 notation of the top-left cell, as "Sheet1!A1"`},
+				{Name: "values", Type: "any[][]", Description: "Rows of cell values"}},
+			Returns: []modules.Value{{Name: "result", Type: "table", Description: "updated_cells, updated_rows, updated_columns, updated_range", Optional: true}, errReturn},
+		},
+		{
+			Name:    "append_values",
+			Summary: "Appends rows after the last row of a table in the range.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "range", Type: "string", Description: `A1 notation of the table, as "Sheet1"`},
+				{Name: "values", Type: "any[][]", Description: "Rows of cell values"}},
+			Returns: []modules.Value{{Name: "result", Type: "table", Description: "updated_cells, updated_rows, updated_range", Optional: true}, errReturn},
+		},
+		{
+			Name:    "clear_values",
+			Summary: "Clears the values of a range, keeping its formatting.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "range", Type: "string", Description: "A1 notation"}},
+			Returns: []modules.Value{{Name: "result", Type: "table", Description: "cleared_range", Optional: true}, errReturn},
+		},
+		{
+			Name:    "batch_get_values",
+			Summary: "Reads several ranges in one request.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "ranges", Type: "string[]", Description: "Ranges in A1 notation"}},
+			Returns: []modules.Value{{Name: "values", Type: "table[]", Description: "A {range, values} table per range", Optional: true}, errReturn},
+		},
+		{
+			Name:    "batch_update",
+			Summary: "Applies several structural changes in one request.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "opts", Type: "table", Description: "{requests = {...}}, each request one of " +
+					"{add_sheet = {title}}, {delete_sheet = {sheet_id}} or {rename_sheet = {sheet_id, title}}"}},
+			Returns: []modules.Value{{Name: "result", Type: "table", Description: "spreadsheet_id, replies_count", Optional: true}, errReturn},
+			Examples: []string{`local result, err = gsheets.batch_update(client, spreadsheet_id, {requests = {
+  {add_sheet = {title = "2025"}},
+  {rename_sheet = {sheet_id = 0, title = "Archive"}},
+}})`},
+		},
+		{
+			Name:    "get_spreadsheet",
+			Summary: "Returns a spreadsheet's properties and sheets.",
+			Params:  []modules.Value{clientParam, spreadsheetIDParam},
+			Returns: []modules.Value{{Name: "spreadsheet", Type: "table", Description: "spreadsheet_id, title, locale, time_zone, url, sheets", Optional: true}, errReturn},
+		},
+		{
+			Name:    "create_spreadsheet",
+			Summary: "Creates a spreadsheet.",
+			Params: []modules.Value{clientParam,
+				{Name: "opts", Type: "table", Description: "title, and sheets as a list of {title} tables"}},
+			Returns: []modules.Value{{Name: "spreadsheet", Type: "table", Description: "spreadsheet_id, title, url", Optional: true}, errReturn},
+		},
+		{
+			Name:    "add_sheet",
+			Summary: "Adds a sheet (tab) to a spreadsheet.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "opts", Type: "table", Description: "title"}},
+			Returns: []modules.Value{{Name: "sheet", Type: "table", Description: "sheet_id, title, index", Optional: true}, errReturn},
+		},
+		{
+			Name:    "delete_sheet",
+			Summary: "Deletes a sheet (tab) from a spreadsheet.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "sheet_id", Type: "integer", Description: "sheet_id from list_sheets or find_sheet"}},
+			Returns: []modules.Value{errReturn},
+		},
+		{
+			Name:    "find_sheet",
+			Summary: "Finds a sheet (tab) by title.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "title", Type: "string"}},
+			Returns: []modules.Value{{Name: "sheet", Type: "table", Description: "sheet_id, title, index, row_count, column_count", Optional: true}, errReturn},
+		},
+		{
+			Name:    "list_sheets",
+			Summary: "Lists the sheets (tabs) of a spreadsheet.",
+			Params:  []modules.Value{clientParam, spreadsheetIDParam},
+			Returns: []modules.Value{{Name: "sheets", Type: "table[]", Description: "sheet_id, title, index, row_count, column_count", Optional: true}, errReturn},
+		},
+		{
+			Name:    "find_row",
+			Summary: "Finds the first row of a range containing a value.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "range", Type: "string", Description: `A1 notation, as "Sheet1!A:A"`},
+				{Name: "value", Type: "string"}},
+			Returns: []modules.Value{
+				{Name: "row_index", Type: "integer", Description: "Row number within the range, from 1", Optional: true},
+				{Name: "row_data", Type: "any[]", Optional: true},
+				errReturn,
+			},
+		},
+		{
+			Name:    "find_column",
+			Summary: "Finds a column by the header in its first row.",
+			Params: []modules.Value{clientParam, spreadsheetIDParam,
+				{Name: "sheet", Type: "string", Description: "Sheet title"},
+				{Name: "header", Type: "string"}},
+			Returns: []modules.Value{{Name: "column", Type: "string", Description: `Column letter, as "C"`, Optional: true}, errReturn},
+		},
+	},
+}
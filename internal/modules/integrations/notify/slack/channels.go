@@ -16,7 +16,7 @@ func luaListChannels(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	result, err := client.apiRequest("GET", "/conversations.list?types=public_channel,private_channel", nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/conversations.list?types=public_channel,private_channel", nil)
 	if err != nil {
 		return util.PushError(L, "list channels failed: %v", err)
 	}
@@ -57,7 +57,7 @@ func luaGetChannel(L *lua.LState) int {
 
 	channelID := L.CheckString(2)
 
-	result, err := client.apiRequest("GET", "/conversations.info?channel="+channelID, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/conversations.info?channel="+channelID, nil)
 	if err != nil {
 		return util.PushError(L, "get channel failed: %v", err)
 	}
@@ -101,7 +101,7 @@ func luaClientListChannels(L *lua.LState) int {
 		return util.PushError(L, "invalid client")
 	}
 
-	result, err := client.apiRequest("GET", "/conversations.list?types=public_channel,private_channel", nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/conversations.list?types=public_channel,private_channel", nil)
 	if err != nil {
 		return util.PushError(L, "list channels failed: %v", err)
 	}
@@ -136,7 +136,7 @@ func luaClientGetChannel(L *lua.LState) int {
 
 	channelID := L.CheckString(2)
 
-	result, err := client.apiRequest("GET", "/conversations.info?channel="+channelID, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/conversations.info?channel="+channelID, nil)
 	if err != nil {
 		return util.PushError(L, "get channel failed: %v", err)
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/zepzeper/vulgar/internal/config"
 	"github.com/zepzeper/vulgar/internal/httpclient"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 var clientMethods = map[string]lua.LGFunction{
@@ -61,12 +62,10 @@ func (c *slackClient) isClosed() bool {
 }
 
 // apiRequest makes an authenticated request to Slack API using httpclient
-func (c *slackClient) apiRequest(method, endpoint string, body interface{}) (map[string]interface{}, error) {
+func (c *slackClient) apiRequest(ctx context.Context, method, endpoint string, body interface{}) (map[string]interface{}, error) {
 	if c.isClosed() {
 		return nil, fmt.Errorf("client is closed")
 	}
-
-	ctx := context.Background()
 	var resp *httpclient.Response
 	var err error
 
@@ -115,6 +114,10 @@ func luaClient(L *lua.LState) int {
 		}
 	}
 
+	if err := sandbox.Check(L, sandbox.Net, "slack.com"); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
 	// Create httpclient with Slack configuration
 	httpClient := httpclient.New(
 		httpclient.WithBaseURL(slackAPIBase),
@@ -9,13 +9,13 @@ import (
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
-func sendWebhook(webhookURL string, payload *webhookPayload) error {
+func sendWebhook(ctx context.Context, webhookURL string, payload *webhookPayload) error {
 	client := httpclient.New(
 		httpclient.WithHeader("Content-Type", "application/json"),
 	)
 
 	resp, err := client.NewRequest("POST", webhookURL).
-		Context(context.Background()).
+		Context(ctx).
 		BodyJSON(payload).
 		Do()
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
 // Usage: local err = slack.send(client, "#channel", "Hello!")
@@ -37,7 +38,7 @@ func luaSend(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.postMessage", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.postMessage", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -79,7 +80,7 @@ func luaSendBlocks(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.postMessage", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.postMessage", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -201,7 +202,7 @@ func luaUpdateMessage(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.update", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.update", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -232,7 +233,7 @@ func luaDeleteMessage(L *lua.LState) int {
 		"ts":      timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/chat.delete", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.delete", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -267,7 +268,7 @@ func luaClientSend(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.postMessage", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.postMessage", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -302,7 +303,7 @@ func luaClientSendBlocks(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.postMessage", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.postMessage", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -410,7 +411,7 @@ func luaClientUpdateMessage(L *lua.LState) int {
 		}
 	}
 
-	_, err := client.apiRequest("POST", "/chat.update", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.update", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -435,7 +436,7 @@ func luaClientDeleteMessage(L *lua.LState) int {
 		"ts":      timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/chat.delete", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/chat.delete", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
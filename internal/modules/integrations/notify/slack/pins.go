@@ -2,6 +2,7 @@ package slack
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
 // Usage: local err = slack.pin_message(client, "#channel", "1234567890.123456")
@@ -25,7 +26,7 @@ func luaPinMessage(L *lua.LState) int {
 		"timestamp": timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/pins.add", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/pins.add", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -56,7 +57,7 @@ func luaUnpinMessage(L *lua.LState) int {
 		"timestamp": timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/pins.remove", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/pins.remove", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -82,7 +83,7 @@ func luaClientPinMessage(L *lua.LState) int {
 		"timestamp": timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/pins.add", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/pins.add", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -107,7 +108,7 @@ func luaClientUnpinMessage(L *lua.LState) int {
 		"timestamp": timestamp,
 	}
 
-	_, err := client.apiRequest("POST", "/pins.remove", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/pins.remove", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -2,6 +2,7 @@ package slack
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
 // Usage: local err = slack.react(client, "#channel", "1234567890.123456", "thumbsup")
@@ -27,7 +28,7 @@ func luaReact(L *lua.LState) int {
 		"name":      emoji,
 	}
 
-	_, err := client.apiRequest("POST", "/reactions.add", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/reactions.add", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -55,7 +56,7 @@ func luaClientReact(L *lua.LState) int {
 		"name":      emoji,
 	}
 
-	_, err := client.apiRequest("POST", "/reactions.add", req)
+	_, err := client.apiRequest(util.Context(L), "POST", "/reactions.add", req)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
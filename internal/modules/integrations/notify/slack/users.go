@@ -20,7 +20,7 @@ func luaGetUser(L *lua.LState) int {
 
 	userID := L.CheckString(2)
 
-	result, err := client.apiRequest("GET", "/users.info?user="+userID, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/users.info?user="+userID, nil)
 	if err != nil {
 		return util.PushError(L, "get user failed: %v", err)
 	}
@@ -67,7 +67,7 @@ func luaListUsers(L *lua.LState) int {
 	}
 
 	endpoint := "/users.list" + limit
-	result, err := client.apiRequest("GET", endpoint, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", endpoint, nil)
 	if err != nil {
 		return util.PushError(L, "list users failed: %v", err)
 	}
@@ -117,7 +117,7 @@ func luaClientGetUser(L *lua.LState) int {
 
 	userID := L.CheckString(2)
 
-	result, err := client.apiRequest("GET", "/users.info?user="+userID, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", "/users.info?user="+userID, nil)
 	if err != nil {
 		return util.PushError(L, "get user failed: %v", err)
 	}
@@ -158,7 +158,7 @@ func luaClientListUsers(L *lua.LState) int {
 	}
 
 	endpoint := "/users.list" + limit
-	result, err := client.apiRequest("GET", endpoint, nil)
+	result, err := client.apiRequest(util.Context(L), "GET", endpoint, nil)
 	if err != nil {
 		return util.PushError(L, "list users failed: %v", err)
 	}
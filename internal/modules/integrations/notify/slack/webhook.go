@@ -2,6 +2,8 @@ package slack
 
 import (
 	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 // Usage: local err = slack.send_webhook(webhook_url, {text = "Hello!", channel = "#general"})
@@ -14,6 +16,11 @@ func luaSendWebhook(L *lua.LState) int {
 		return 1
 	}
 
+	if err := sandbox.CheckURL(L, webhookURL); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
+
 	payload := &webhookPayload{}
 
 	if opts != nil {
@@ -47,7 +54,7 @@ func luaSendWebhook(L *lua.LState) int {
 		return 1
 	}
 
-	if err := sendWebhook(webhookURL, payload); err != nil {
+	if err := sendWebhook(util.Context(L), webhookURL, payload); err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
 	}
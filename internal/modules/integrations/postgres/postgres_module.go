@@ -2,11 +2,13 @@ package postgres
 
 import (
 	"context"
+	"net/url"
 	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	postgres "github.com/zepzeper/vulgar/internal/services/postgres"
 )
 
@@ -30,12 +32,19 @@ func luaConnect(L *lua.LState) int {
 
 	if L.Get(1).Type() == lua.LTString {
 		connStr := L.CheckString(1)
+		if err := sandbox.Check(L, sandbox.Net, connHost(connStr)); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 		client, err = postgres.NewClient(connStr)
 	} else {
 		opts := L.CheckTable(1)
+		host := getTableString(opts, "host", "localhost")
+		if err := sandbox.Check(L, sandbox.Net, host); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 
 		client, err = postgres.NewClientFromOptions(postgres.ConnectOptions{
-			Host:     getTableString(opts, "host", "localhost"),
+			Host:     host,
 			Port:     getTableInt(opts, "port", 5432),
 			User:     getTableString(opts, "user", "postgres"),
 			Password: getTableString(opts, "password", ""),
@@ -57,6 +66,23 @@ func luaConnect(L *lua.LState) int {
 	return 2
 }
 
+// connHost returns the host of a connection string, either a URL
+// (postgres://user@host/db) or key/value pairs (host=... dbname=...)
+func connHost(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.Scheme != "" {
+		if u.Hostname() != "" {
+			return u.Hostname()
+		}
+		return "localhost"
+	}
+	for _, field := range strings.Fields(connStr) {
+		if host, ok := strings.CutPrefix(field, "host="); ok {
+			return strings.Trim(host, "'")
+		}
+	}
+	return "localhost"
+}
+
 func getWrapper(L *lua.LState, idx int) *wrapper {
 	ud := L.CheckUserData(idx)
 	if w, ok := ud.Value.(*wrapper); ok {
@@ -75,7 +101,7 @@ func luaQuery(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.client.Query(context.Background(), query, args...)
+	rows, err := w.client.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -101,7 +127,7 @@ func luaQueryOne(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.client.Query(context.Background(), query, args...)
+	rows, err := w.client.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -127,7 +153,7 @@ func luaExec(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.client.Exec(context.Background(), query, args...)
+	res, err := w.client.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "exec failed: %v", err)
 	}
@@ -140,6 +166,56 @@ func luaExec(L *lua.LState) int {
 	return 2
 }
 
+// luaQueryAsync runs a query on a goroutine and returns a future for (rows, err)
+// Usage: local rows, err = async.await(postgres.query_async(db, "SELECT * FROM users WHERE id = $1", {id}))
+func luaQueryAsync(L *lua.LState) int {
+	w := getWrapper(L, 1)
+	query := L.CheckString(2)
+	args := extractArgs(L, 3)
+
+	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+		if w == nil || w.client == nil {
+			return func(L *lua.LState) int { return util.PushError(L, "invalid database handle") }
+		}
+		rows, err := w.client.Query(ctx, query, args...)
+		return func(L *lua.LState) int {
+			if err != nil {
+				return util.PushError(L, "query failed: %v", err)
+			}
+			result := L.NewTable()
+			for i, row := range rows {
+				result.RawSetInt(i+1, util.GoToLua(L, row))
+			}
+			return util.PushSuccess(L, result)
+		}
+	}))
+	return 1
+}
+
+// luaExecAsync runs a statement on a goroutine and returns a future for (result, err)
+// Usage: local res, err = async.await(postgres.exec_async(db, "DELETE FROM sessions"))
+func luaExecAsync(L *lua.LState) int {
+	w := getWrapper(L, 1)
+	query := L.CheckString(2)
+	args := extractArgs(L, 3)
+
+	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+		if w == nil || w.client == nil {
+			return func(L *lua.LState) int { return util.PushError(L, "invalid database handle") }
+		}
+		res, err := w.client.Exec(ctx, query, args...)
+		return func(L *lua.LState) int {
+			if err != nil {
+				return util.PushError(L, "exec failed: %v", err)
+			}
+			resultTable := L.NewTable()
+			L.SetField(resultTable, "rows_affected", lua.LNumber(res.RowsAffected))
+			return util.PushSuccess(L, resultTable)
+		}
+	}))
+	return 1
+}
+
 // luaInsert inserts a row
 func luaInsert(L *lua.LState) int {
 	w := getWrapper(L, 1)
@@ -151,7 +227,7 @@ func luaInsert(L *lua.LState) int {
 	args := extractArgs(L, 3)
 
 	if strings.Contains(strings.ToUpper(query), "RETURNING") {
-		rows, err := w.client.Query(context.Background(), query, args...)
+		rows, err := w.client.Query(util.Context(L), query, args...)
 		if err != nil {
 			return util.PushError(L, "insert failed: %v", err)
 		}
@@ -165,7 +241,7 @@ func luaInsert(L *lua.LState) int {
 		return 2
 	}
 
-	res, err := w.client.Exec(context.Background(), query, args...)
+	res, err := w.client.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "insert failed: %v", err)
 	}
@@ -244,7 +320,7 @@ func luaTxExec(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.tx.Exec(context.Background(), query, args...)
+	res, err := w.tx.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "exec failed: %v", err)
 	}
@@ -267,7 +343,7 @@ func luaTxQuery(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.tx.Query(context.Background(), query, args...)
+	rows, err := w.tx.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -330,19 +406,21 @@ func extractArgs(L *lua.LState, idx int) []interface{} {
 }
 
 var exports = map[string]lua.LGFunction{
-	"connect":   luaConnect,
-	"query":     luaQuery,
-	"query_one": luaQueryOne,
-	"exec":      luaExec,
-	"insert":    luaInsert,
-	"update":    luaUpdate,
-	"delete":    luaDelete,
-	"begin":     luaBegin,
-	"commit":    luaCommit,
-	"rollback":  luaRollback,
-	"tx_exec":   luaTxExec,
-	"tx_query":  luaTxQuery,
-	"close":     luaClose,
+	"connect":     luaConnect,
+	"query":       luaQuery,
+	"query_one":   luaQueryOne,
+	"exec":        luaExec,
+	"query_async": luaQueryAsync,
+	"exec_async":  luaExecAsync,
+	"insert":      luaInsert,
+	"update":      luaUpdate,
+	"delete":      luaDelete,
+	"begin":       luaBegin,
+	"commit":      luaCommit,
+	"rollback":    luaRollback,
+	"tx_exec":     luaTxExec,
+	"tx_query":    luaTxQuery,
+	"close":       luaClose,
 }
 
 func Loader(L *lua.LState) int {
@@ -1,8 +1,6 @@
 package redis
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -17,7 +15,7 @@ func luaHGet(L *lua.LState) int {
 	key := L.CheckString(2)
 	field := L.CheckString(3)
 
-	val, err := w.client.HGet(context.Background(), key, field)
+	val, err := w.client.HGet(util.Context(L), key, field)
 	if w.client.IsNilError(err) {
 		L.Push(lua.LNil)
 		L.Push(lua.LNil)
@@ -58,12 +56,12 @@ func luaHSet(L *lua.LState) int {
 		tbl.ForEach(func(k, v lua.LValue) {
 			args = append(args, util.LuaToGo(k), util.LuaToGo(v))
 		})
-		_, err = w.client.HSet(context.Background(), key, args...)
+		_, err = w.client.HSet(util.Context(L), key, args...)
 	} else {
 		// Single field-value
 		field := L.CheckString(3)
 		value := L.CheckString(4)
-		_, err = w.client.HSet(context.Background(), key, field, value)
+		_, err = w.client.HSet(util.Context(L), key, field, value)
 	}
 
 	if err != nil {
@@ -84,7 +82,7 @@ func luaHGetAll(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	fields, err := w.client.HGetAll(context.Background(), key)
+	fields, err := w.client.HGetAll(util.Context(L), key)
 	if err != nil {
 		return util.PushError(L, "hgetall failed: %v", err)
 	}
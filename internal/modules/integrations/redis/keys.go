@@ -1,7 +1,6 @@
 package redis
 
 import (
-	"context"
 	"time"
 
 	lua "github.com/yuin/gopher-lua"
@@ -20,7 +19,7 @@ func luaDel(L *lua.LState) int {
 		keys = append(keys, L.CheckString(i))
 	}
 
-	count, err := w.client.Del(context.Background(), keys...)
+	count, err := w.client.Del(util.Context(L), keys...)
 	if err != nil {
 		return util.PushError(L, "del failed: %v", err)
 	}
@@ -42,7 +41,7 @@ func luaExists(L *lua.LState) int {
 		keys = append(keys, L.CheckString(i))
 	}
 
-	count, err := w.client.Exists(context.Background(), keys...)
+	count, err := w.client.Exists(util.Context(L), keys...)
 	if err != nil {
 		return util.PushError(L, "exists failed: %v", err)
 	}
@@ -62,7 +61,7 @@ func luaExpire(L *lua.LState) int {
 	key := L.CheckString(2)
 	seconds := L.CheckInt(3)
 
-	ok, err := w.client.Expire(context.Background(), key, time.Duration(seconds)*time.Second)
+	ok, err := w.client.Expire(util.Context(L), key, time.Duration(seconds)*time.Second)
 	if err != nil {
 		return util.PushError(L, "expire failed: %v", err)
 	}
@@ -81,7 +80,7 @@ func luaTTL(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	ttl, err := w.client.TTL(context.Background(), key)
+	ttl, err := w.client.TTL(util.Context(L), key)
 	if err != nil {
 		return util.PushError(L, "ttl failed: %v", err)
 	}
@@ -100,7 +99,7 @@ func luaKeys(L *lua.LState) int {
 
 	pattern := L.CheckString(2)
 
-	keys, err := w.client.Keys(context.Background(), pattern)
+	keys, err := w.client.Keys(util.Context(L), pattern)
 	if err != nil {
 		return util.PushError(L, "keys failed: %v", err)
 	}
@@ -1,8 +1,6 @@
 package redis
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -21,7 +19,7 @@ func luaLPush(L *lua.LState) int {
 		values = append(values, util.LuaToGo(L.Get(i)))
 	}
 
-	length, err := w.client.LPush(context.Background(), key, values...)
+	length, err := w.client.LPush(util.Context(L), key, values...)
 	if err != nil {
 		return util.PushError(L, "lpush failed: %v", err)
 	}
@@ -45,7 +43,7 @@ func luaRPush(L *lua.LState) int {
 		values = append(values, util.LuaToGo(L.Get(i)))
 	}
 
-	length, err := w.client.RPush(context.Background(), key, values...)
+	length, err := w.client.RPush(util.Context(L), key, values...)
 	if err != nil {
 		return util.PushError(L, "rpush failed: %v", err)
 	}
@@ -64,7 +62,7 @@ func luaLPop(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	val, err := w.client.LPop(context.Background(), key)
+	val, err := w.client.LPop(util.Context(L), key)
 	if w.client.IsNilError(err) {
 		L.Push(lua.LNil)
 		L.Push(lua.LNil)
@@ -88,7 +86,7 @@ func luaRPop(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	val, err := w.client.RPop(context.Background(), key)
+	val, err := w.client.RPop(util.Context(L), key)
 	if w.client.IsNilError(err) {
 		L.Push(lua.LNil)
 		L.Push(lua.LNil)
@@ -114,7 +112,7 @@ func luaLRange(L *lua.LState) int {
 	start := L.CheckInt64(3)
 	stop := L.CheckInt64(4)
 
-	values, err := w.client.LRange(context.Background(), key, start, stop)
+	values, err := w.client.LRange(util.Context(L), key, start, stop)
 	if err != nil {
 		return util.PushError(L, "lrange failed: %v", err)
 	}
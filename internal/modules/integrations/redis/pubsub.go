@@ -1,8 +1,6 @@
 package redis
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -17,7 +15,7 @@ func luaPublish(L *lua.LState) int {
 	channel := L.CheckString(2)
 	message := L.CheckString(3)
 
-	count, err := w.client.Publish(context.Background(), channel, message)
+	count, err := w.client.Publish(util.Context(L), channel, message)
 	if err != nil {
 		return util.PushError(L, "publish failed: %v", err)
 	}
@@ -1,9 +1,12 @@
 package redis
 
 import (
+	"net/url"
+
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	redis "github.com/zepzeper/vulgar/internal/services/redis"
 )
 
@@ -23,12 +26,23 @@ func luaConnect(L *lua.LState) int {
 
 	if L.Get(1).Type() == lua.LTString {
 		connStr := L.CheckString(1)
+		host := "localhost"
+		if u, err := url.Parse(connStr); err == nil && u.Hostname() != "" {
+			host = u.Hostname()
+		}
+		if err := sandbox.Check(L, sandbox.Net, host); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 		client, err = redis.NewClient(connStr)
 	} else {
 		opts := L.CheckTable(1)
+		host := getTableString(opts, "host", "localhost")
+		if err := sandbox.Check(L, sandbox.Net, host); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 
 		client, err = redis.NewClientFromOptions(redis.ConnectOptions{
-			Host:     getTableString(opts, "host", "localhost"),
+			Host:     host,
 			Port:     getTableInt(opts, "port", 6379),
 			Password: getTableString(opts, "password", ""),
 			DB:       getTableInt(opts, "db", 0),
@@ -1,8 +1,6 @@
 package redis
 
 import (
-	"context"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -21,7 +19,7 @@ func luaSAdd(L *lua.LState) int {
 		members = append(members, util.LuaToGo(L.Get(i)))
 	}
 
-	count, err := w.client.SAdd(context.Background(), key, members...)
+	count, err := w.client.SAdd(util.Context(L), key, members...)
 	if err != nil {
 		return util.PushError(L, "sadd failed: %v", err)
 	}
@@ -40,7 +38,7 @@ func luaSMembers(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	members, err := w.client.SMembers(context.Background(), key)
+	members, err := w.client.SMembers(util.Context(L), key)
 	if err != nil {
 		return util.PushError(L, "smembers failed: %v", err)
 	}
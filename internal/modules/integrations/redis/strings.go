@@ -1,7 +1,6 @@
 package redis
 
 import (
-	"context"
 	"time"
 
 	lua "github.com/yuin/gopher-lua"
@@ -17,7 +16,7 @@ func luaGet(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	val, err := w.client.Get(context.Background(), key)
+	val, err := w.client.Get(util.Context(L), key)
 	if w.client.IsNilError(err) {
 		L.Push(lua.LNil)
 		L.Push(lua.LNil)
@@ -56,7 +55,7 @@ func luaSet(L *lua.LState) int {
 		}
 	}
 
-	err := w.client.Set(context.Background(), key, value, expiration)
+	err := w.client.Set(util.Context(L), key, value, expiration)
 	if err != nil {
 		L.Push(lua.LString(err.Error()))
 		return 1
@@ -75,7 +74,7 @@ func luaIncr(L *lua.LState) int {
 
 	key := L.CheckString(2)
 
-	val, err := w.client.Incr(context.Background(), key)
+	val, err := w.client.Incr(util.Context(L), key)
 	if err != nil {
 		return util.PushError(L, "incr failed: %v", err)
 	}
@@ -95,7 +94,7 @@ func luaIncrBy(L *lua.LState) int {
 	key := L.CheckString(2)
 	incr := L.CheckInt64(3)
 
-	val, err := w.client.IncrBy(context.Background(), key, incr)
+	val, err := w.client.IncrBy(util.Context(L), key, incr)
 	if err != nil {
 		return util.PushError(L, "incrby failed: %v", err)
 	}
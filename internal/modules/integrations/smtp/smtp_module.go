@@ -11,6 +11,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "integrations.smtp"
@@ -221,6 +222,9 @@ func luaConnect(L *lua.LState) int {
 	if host == "" {
 		return util.PushError(L, "host is required")
 	}
+	if err := sandbox.Check(L, sandbox.Net, host); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	// Create auth if credentials provided
 	var auth smtp.Auth
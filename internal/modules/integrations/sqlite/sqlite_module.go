@@ -2,10 +2,12 @@ package sqlite
 
 import (
 	"context"
+	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 	sqlite "github.com/zepzeper/vulgar/internal/services/sqlite"
 )
 
@@ -26,6 +28,12 @@ const (
 func luaOpen(L *lua.LState) int {
 	path := L.CheckString(1)
 
+	if file := databaseFile(path); file != "" {
+		if err := sandbox.Check(L, sandbox.FS, file); err != nil {
+			return util.PushError(L, "%v", err)
+		}
+	}
+
 	client, err := sqlite.NewClient(path)
 	if err != nil {
 		return util.PushError(L, "failed to open database: %v", err)
@@ -40,6 +48,17 @@ func luaOpen(L *lua.LState) int {
 	return 2
 }
 
+// databaseFile returns the file a SQLite data source name refers to, or ""
+// for in-memory databases
+func databaseFile(dsn string) string {
+	dsn = strings.TrimPrefix(dsn, "file:")
+	dsn, _, _ = strings.Cut(dsn, "?")
+	if dsn == "" || dsn == ":memory:" {
+		return ""
+	}
+	return dsn
+}
+
 func getWrapper(L *lua.LState, idx int) *wrapper {
 	ud := L.CheckUserData(idx)
 	if w, ok := ud.Value.(*wrapper); ok {
@@ -58,7 +77,7 @@ func luaExec(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.client.Exec(context.Background(), query, args...)
+	res, err := w.client.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "exec failed: %v", err)
 	}
@@ -82,7 +101,7 @@ func luaQuery(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.client.Query(context.Background(), query, args...)
+	rows, err := w.client.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -107,7 +126,7 @@ func luaQueryOne(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.client.Query(context.Background(), query, args...)
+	rows, err := w.client.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -133,7 +152,7 @@ func luaInsert(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.client.Exec(context.Background(), query, args...)
+	res, err := w.client.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "insert failed: %v", err)
 	}
@@ -153,7 +172,7 @@ func luaUpdate(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.client.Exec(context.Background(), query, args...)
+	res, err := w.client.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "update failed: %v", err)
 	}
@@ -233,7 +252,7 @@ func luaTxExec(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	res, err := w.tx.Exec(context.Background(), query, args...)
+	res, err := w.tx.Exec(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "exec failed: %v", err)
 	}
@@ -257,7 +276,7 @@ func luaTxQuery(L *lua.LState) int {
 	query := L.CheckString(2)
 	args := extractArgs(L, 3)
 
-	rows, err := w.tx.Query(context.Background(), query, args...)
+	rows, err := w.tx.Query(util.Context(L), query, args...)
 	if err != nil {
 		return util.PushError(L, "query failed: %v", err)
 	}
@@ -2,6 +2,7 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -14,6 +15,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const (
@@ -41,11 +43,12 @@ type sshTunnel struct {
 }
 
 var clientMethods = map[string]lua.LGFunction{
-	"exec":     luaClientExec,
-	"run":      luaClientRun,
-	"upload":   luaClientUpload,
-	"download": luaClientDownload,
-	"close":    luaClientClose,
+	"exec":       luaClientExec,
+	"exec_async": luaClientExecAsync,
+	"run":        luaClientRun,
+	"upload":     luaClientUpload,
+	"download":   luaClientDownload,
+	"close":      luaClientClose,
 }
 
 var tunnelMethods = map[string]lua.LGFunction{
@@ -146,6 +149,9 @@ func luaConnect(L *lua.LState) int {
 	if user == "" {
 		return util.PushError(L, "user is required")
 	}
+	if err := sandbox.Check(L, sandbox.SSH, host); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	var authMethods []ssh.AuthMethod
 
@@ -234,6 +240,52 @@ func luaExec(L *lua.LState) int {
 	return 2
 }
 
+// execAsync runs a command on a goroutine and returns a future resolving to
+// the same (output, err) pair as exec
+func execAsync(L *lua.LState, c *sshClient, cmd string) int {
+	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+		c.mu.Lock()
+		closed := c.closed || c.client == nil
+		client := c.client
+		c.mu.Unlock()
+		if closed {
+			return func(L *lua.LState) int { return util.PushError(L, "client is closed") }
+		}
+
+		session, err := client.NewSession()
+		if err != nil {
+			return func(L *lua.LState) int { return util.PushError(L, "failed to create session: %v", err) }
+		}
+		defer session.Close()
+
+		// Closing the session aborts the command when the script is stopped
+		stop := context.AfterFunc(ctx, func() { session.Close() })
+		defer stop()
+
+		output, err := session.CombinedOutput(cmd)
+		return func(L *lua.LState) int {
+			if err != nil {
+				if len(output) > 0 {
+					L.Push(lua.LString(string(output)))
+					L.Push(lua.LString(err.Error()))
+					return 2
+				}
+				return util.PushError(L, "exec failed: %v", err)
+			}
+			return util.PushSuccess(L, lua.LString(string(output)))
+		}
+	}))
+	return 1
+}
+
+// Usage: local output, err = async.await(ssh.exec_async(client, "uptime"))
+func luaExecAsync(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		return util.PushError(L, "client is nil")
+	}
+	return execAsync(L, checkClient(L, 1), L.CheckString(2))
+}
+
 // Usage: local code, stdout, stderr = ssh.run(client, "make build")
 func luaRun(L *lua.LState) int {
 	if L.Get(1) == lua.LNil {
@@ -402,6 +454,10 @@ func luaUpload(L *lua.LState) int {
 	c := checkClient(L, 1)
 	localPath := L.CheckString(2)
 	remotePath := L.CheckString(3)
+	if err := sandbox.Check(L, sandbox.FS, localPath); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	c.mu.Lock()
 	if c.closed || c.client == nil {
@@ -457,6 +513,10 @@ func luaDownload(L *lua.LState) int {
 	c := checkClient(L, 1)
 	remotePath := L.CheckString(2)
 	localPath := L.CheckString(3)
+	if err := sandbox.Check(L, sandbox.FS, localPath); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	c.mu.Lock()
 	if c.closed || c.client == nil {
@@ -538,6 +598,11 @@ func luaClientExec(L *lua.LState) int {
 	return 2
 }
 
+// Usage: local output, err = client:exec_async("uptime"):await()
+func luaClientExecAsync(L *lua.LState) int {
+	return execAsync(L, checkClient(L, 1), L.CheckString(2))
+}
+
 // Usage: local code, stdout, stderr = client:run("make build")
 func luaClientRun(L *lua.LState) int {
 	c := checkClient(L, 1)
@@ -590,6 +655,10 @@ func luaClientUpload(L *lua.LState) int {
 	c := checkClient(L, 1)
 	localPath := L.CheckString(2)
 	remotePath := L.CheckString(3)
+	if err := sandbox.Check(L, sandbox.FS, localPath); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	c.mu.Lock()
 	if c.closed || c.client == nil {
@@ -638,6 +707,10 @@ func luaClientDownload(L *lua.LState) int {
 	c := checkClient(L, 1)
 	remotePath := L.CheckString(2)
 	localPath := L.CheckString(3)
+	if err := sandbox.Check(L, sandbox.FS, localPath); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	c.mu.Lock()
 	if c.closed || c.client == nil {
@@ -716,14 +789,15 @@ func getNumberField(tbl *lua.LTable, key string, defaultVal float64) float64 {
 }
 
 var exports = map[string]lua.LGFunction{
-	"connect":  luaConnect,
-	"exec":     luaExec,
-	"run":      luaRun,
-	"shell":    luaShell,
-	"tunnel":   luaTunnel,
-	"upload":   luaUpload,
-	"download": luaDownload,
-	"close":    luaClose,
+	"connect":    luaConnect,
+	"exec":       luaExec,
+	"exec_async": luaExecAsync,
+	"run":        luaRun,
+	"shell":      luaShell,
+	"tunnel":     luaTunnel,
+	"upload":     luaUpload,
+	"download":   luaDownload,
+	"close":      luaClose,
 }
 
 func Loader(L *lua.LState) int {
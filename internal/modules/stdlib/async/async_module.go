@@ -0,0 +1,149 @@
+package async
+
+import (
+	"context"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+const ModuleName = "stdlib.async"
+
+// luaRun starts a function as a task and returns a future for its results.
+// The task runs until it awaits something that is not ready yet, so I/O
+// started by several tasks overlaps. Errors raised by the task resolve the
+// future to nil, err.
+// Usage: local fut = async.run(function(url) return http.get_async(url):await() end, url)
+func luaRun(L *lua.LState) int {
+	fn := L.CheckFunction(1)
+	args := make([]lua.LValue, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+
+	f := util.RunTask(L, fn, args...)
+	L.Push(util.NewFutureValue(L, f))
+	return 1
+}
+
+// luaAwait waits for a future and returns its values. Inside a task only the
+// task waits; in the main script the event loop runs until the future is
+// ready. Values that are not futures are returned unchanged.
+// Usage: local resp, err = async.await(http.get_async(url))
+func luaAwait(L *lua.LState) int {
+	f := util.ToFuture(L.Get(1))
+	if f == nil {
+		L.SetTop(1)
+		return 1
+	}
+	return util.Await(L, f)
+}
+
+// luaGather combines futures (or functions, which are run as tasks) into one
+// future. It resolves once all of them have to a list of their first values
+// and the first error, in list order.
+// Usage: local results, err = async.await(async.gather({http.get_async(a), http.get_async(b)}))
+func luaGather(L *lua.LState) int {
+	list := L.CheckTable(1)
+	n := list.Len()
+
+	futures := make([]*util.Future, n)
+	for i := 1; i <= n; i++ {
+		item := list.RawGetInt(i)
+		switch v := item.(type) {
+		case *lua.LFunction:
+			futures[i-1] = util.RunTask(L, v)
+		default:
+			if f := util.ToFuture(v); f != nil {
+				futures[i-1] = f
+				continue
+			}
+			// Plain values count as already resolved
+			f := util.NewFuture()
+			f.Resolve(L, item)
+			futures[i-1] = f
+		}
+	}
+
+	combined := util.NewFuture()
+	results := L.NewTable()
+	errs := make([]lua.LValue, n)
+	remaining := n
+
+	finish := func(L *lua.LState) {
+		var firstErr lua.LValue = lua.LNil
+		for _, err := range errs {
+			if err != nil && err != lua.LNil {
+				firstErr = err
+				break
+			}
+		}
+		combined.Resolve(L, results, firstErr)
+	}
+
+	if n == 0 {
+		finish(L)
+	}
+	for i, f := range futures {
+		index := i
+		f.OnDone(L, func(L *lua.LState, values []lua.LValue) {
+			if len(values) > 0 {
+				results.RawSetInt(index+1, values[0])
+			}
+			if len(values) > 1 {
+				errs[index] = values[1]
+			}
+			remaining--
+			if remaining == 0 {
+				finish(L)
+			}
+		})
+	}
+
+	L.Push(util.NewFutureValue(L, combined))
+	return 1
+}
+
+// luaSleep returns a future that resolves after the given number of milliseconds
+// Usage: async.await(async.sleep(500))
+func luaSleep(L *lua.LState) int {
+	d := time.Duration(L.CheckNumber(1)) * time.Millisecond
+	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+		select {
+		case <-time.After(d):
+			return func(L *lua.LState) int { return 0 }
+		case <-ctx.Done():
+			err := ctx.Err()
+			return func(L *lua.LState) int { return util.PushError(L, "sleep interrupted: %v", err) }
+		}
+	}))
+	return 1
+}
+
+// luaIsFuture reports whether a value is a future
+// Usage: if async.is_future(v) then v = async.await(v) end
+func luaIsFuture(L *lua.LState) int {
+	L.Push(lua.LBool(util.ToFuture(L.Get(1)) != nil))
+	return 1
+}
+
+var exports = map[string]lua.LGFunction{
+	"run":       luaRun,
+	"await":     luaAwait,
+	"gather":    luaGather,
+	"sleep":     luaSleep,
+	"is_future": luaIsFuture,
+}
+
+// Loader is called when the module is required via require("stdlib.async")
+func Loader(L *lua.LState) int {
+	mod := L.SetFuncs(L.NewTable(), exports)
+	L.Push(mod)
+	return 1
+}
+
+func init() {
+	modules.Register(ModuleName, Loader)
+}
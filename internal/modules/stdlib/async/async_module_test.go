@@ -0,0 +1,184 @@
+package async
+
+import (
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+func newTestState() *lua.LState {
+	L := lua.NewState()
+	L.PreloadModule(ModuleName, Loader)
+	return L
+}
+
+// newLoopState returns a state with an event queue, as the engine sets up
+func newLoopState() (*lua.LState, *util.EventQueue) {
+	L := newTestState()
+	queue := util.NewEventQueue(L, 100)
+	ud := L.NewUserData()
+	ud.Value = queue
+	L.SetField(L.Get(lua.RegistryIndex), util.EventQueueRegistryKey, ud)
+	return L, queue
+}
+
+func TestWithoutEventLoop(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+
+		local fut = async.run(function(a, b)
+			async.await(async.sleep(1))
+			return a + b
+		end, 2, 3)
+		assert(fut:done(), "task should finish synchronously without an event loop")
+		assert(async.await(fut) == 5)
+
+		local results, err = async.await(async.gather({fut, async.sleep(1), 7}))
+		assert(err == nil, tostring(err))
+		assert(results[1] == 5 and results[3] == 7)
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestTasksOverlap(t *testing.T) {
+	L, queue := newLoopState()
+	defer L.Close()
+	defer queue.Close()
+
+	start := time.Now()
+	err := L.DoString(`
+		local async = require("stdlib.async")
+
+		local tasks = {}
+		for i = 1, 5 do
+			tasks[i] = function()
+				async.await(async.sleep(200))
+				return i * 10
+			end
+		end
+
+		local results, err = async.await(async.gather(tasks))
+		assert(err == nil, tostring(err))
+		for i = 1, 5 do
+			assert(results[i] == i * 10, "result " .. i .. " was " .. tostring(results[i]))
+		end
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Errorf("tasks did not overlap: took %s", elapsed)
+	}
+}
+
+func TestTaskError(t *testing.T) {
+	L, queue := newLoopState()
+	defer L.Close()
+	defer queue.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+
+		local fut = async.run(function()
+			async.await(async.sleep(10))
+			error("boom")
+		end)
+		local result, err = fut:await()
+		assert(result == nil)
+		assert(string.find(err, "boom"), err)
+
+		local results, first_err = async.await(async.gather({
+			function() return "ok" end,
+			fut,
+		}))
+		assert(results[1] == "ok")
+		assert(string.find(first_err, "boom"), first_err)
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestUnawaitedTaskRunsInEventLoop(t *testing.T) {
+	L, queue := newLoopState()
+	defer L.Close()
+	defer queue.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+		finished = false
+		async.run(function()
+			async.await(async.sleep(20))
+			finished = true
+		end)
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+	if L.GetGlobal("finished") != lua.LFalse {
+		t.Fatal("task should still be waiting")
+	}
+
+	for queue.WaitForEvents() {
+	}
+	if L.GetGlobal("finished") != lua.LTrue {
+		t.Error("task did not resume from the event loop")
+	}
+}
+
+func TestAwaitPlainValue(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+		assert(async.await(42) == 42)
+		assert(async.is_future(async.sleep(0)))
+		assert(not async.is_future({}))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestAwaitOutsideTask(t *testing.T) {
+	L, queue := newLoopState()
+	defer L.Close()
+	defer queue.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+		local co = coroutine.wrap(function()
+			async.await(async.sleep(10))
+		end)
+		co()
+	`)
+	if err == nil {
+		t.Fatal("expected an error awaiting in a plain coroutine")
+	}
+}
+
+func TestAwaitInsidePcall(t *testing.T) {
+	L, queue := newLoopState()
+	defer L.Close()
+	defer queue.Close()
+
+	err := L.DoString(`
+		local async = require("stdlib.async")
+		local ok, err = async.await(async.run(function()
+			return pcall(function() return async.await(async.sleep(10)) end)
+		end))
+		assert(ok == false)
+		assert(string.find(err, "inside pcall"), err)
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
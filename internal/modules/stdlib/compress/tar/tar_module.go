@@ -9,10 +9,21 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "stdlib.tar"
 
+// checkAccess enforces sandbox filesystem rules for the given paths
+func checkAccess(L *lua.LState, paths ...string) error {
+	for _, path := range paths {
+		if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Usage: local err = tar.create(output_path, {source_paths...})
 func luaCreate(L *lua.LState) int {
 	outputPath := L.CheckString(1)
@@ -29,6 +40,10 @@ func luaCreate(L *lua.LState) int {
 		L.Push(lua.LString("no source files provided"))
 		return 1
 	}
+	if err := checkAccess(L, append([]string{outputPath}, sources...)...); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	// Create output file
 	outFile, err := os.Create(outputPath)
@@ -143,6 +158,10 @@ func addDirToTar(writer *tar.Writer, dirPath, baseInTar string) error {
 func luaExtract(L *lua.LState) int {
 	archivePath := L.CheckString(1)
 	destDir := L.CheckString(2)
+	if err := checkAccess(L, archivePath, destDir); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 
 	file, err := os.Open(archivePath)
 	if err != nil {
@@ -223,6 +242,9 @@ func isValidPath(base, target string) bool {
 // Usage: local files, err = tar.list(archive_path)
 func luaList(L *lua.LState) int {
 	archivePath := L.CheckString(1)
+	if err := checkAccess(L, archivePath); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	file, err := os.Open(archivePath)
 	if err != nil {
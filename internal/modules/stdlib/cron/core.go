@@ -8,13 +8,15 @@ import (
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
-// luaSchedule schedules a job with a cron expression
-// Usage: local job, err = cron.schedule("0 * * * * *", function() print("every minute") end)
+// luaSchedule schedules a job with a cron expression. Every scheduling
+// function takes an optional options table after the callback whose overflow
+// field sets what happens to runs that find the event queue full.
+// Usage: local job, err = cron.schedule("0 * * * * *", function() print("every minute") end, { overflow = "coalesce" })
 func luaSchedule(L *lua.LState) int {
 	expr := L.CheckString(1)
 	callback := L.CheckFunction(2)
 
-	h, err := createJob(L, expr, callback)
+	h, err := createJob(L, expr, callback, util.OptOverflowPolicy(L, 3, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "invalid cron expression: %v", err)
 	}
@@ -36,7 +38,7 @@ func luaEvery(L *lua.LState) int {
 
 	expr := fmt.Sprintf("@every %s", duration.String())
 
-	h, err := createJob(L, expr, callback)
+	h, err := createJob(L, expr, callback, util.OptOverflowPolicy(L, 3, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -50,6 +52,7 @@ func luaEvery(L *lua.LState) int {
 func luaAt(L *lua.LState) int {
 	timeStr := L.CheckString(1)
 	callback := L.CheckFunction(2)
+	policy := util.OptOverflowPolicy(L, 3, util.OverflowDropNewest)
 
 	targetTime, err := time.Parse(time.RFC3339, timeStr)
 	if err != nil {
@@ -72,6 +75,7 @@ func luaAt(L *lua.LState) int {
 		L:        L,
 		expr:     timeStr,
 		queue:    queue,
+		source:   queue.NewSource("cron "+timeStr, policy),
 	}
 
 	queue.AddSource()
@@ -86,14 +90,10 @@ func luaAt(L *lua.LState) int {
 	)
 
 	id, err := s.c.AddFunc(expr, func() {
-		h.mu.Lock()
-		if h.stopped {
-			h.mu.Unlock()
+		if !h.running() {
 			return
 		}
-
-		queue.Queue(h.callback, nil)
-		h.mu.Unlock()
+		h.source.Queue(h.callback, nil)
 
 		// One-shot: stop after firing
 		h.stop()
@@ -105,6 +105,7 @@ func luaAt(L *lua.LState) int {
 	}
 
 	h.id = id
+	queue.RegisterStopper(h, h.stop)
 
 	s.mu.Lock()
 	s.jobs[id] = h
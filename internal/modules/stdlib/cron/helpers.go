@@ -11,7 +11,7 @@ import (
 // Usage: local job, err = cron.every_second(function() print("tick") end)
 func luaEverySecond(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "* * * * * *", callback)
+	h, err := createJob(L, "* * * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -23,7 +23,7 @@ func luaEverySecond(L *lua.LState) int {
 // Usage: local job, err = cron.every_minute(function() print("tick") end)
 func luaEveryMinute(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 * * * * *", callback)
+	h, err := createJob(L, "0 * * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -35,7 +35,7 @@ func luaEveryMinute(L *lua.LState) int {
 // Usage: local job, err = cron.every_five_minutes(function() print("tick") end)
 func luaEveryFiveMinutes(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 */5 * * * *", callback)
+	h, err := createJob(L, "0 */5 * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -47,7 +47,7 @@ func luaEveryFiveMinutes(L *lua.LState) int {
 // Usage: local job, err = cron.every_fifteen_minutes(function() print("tick") end)
 func luaEveryFifteenMinutes(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 */15 * * * *", callback)
+	h, err := createJob(L, "0 */15 * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -59,7 +59,7 @@ func luaEveryFifteenMinutes(L *lua.LState) int {
 // Usage: local job, err = cron.every_thirty_minutes(function() print("tick") end)
 func luaEveryThirtyMinutes(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 */30 * * * *", callback)
+	h, err := createJob(L, "0 */30 * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -71,7 +71,7 @@ func luaEveryThirtyMinutes(L *lua.LState) int {
 // Usage: local job, err = cron.every_hour(function() print("tick") end)
 func luaEveryHour(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 0 * * * *", callback)
+	h, err := createJob(L, "0 0 * * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -83,7 +83,7 @@ func luaEveryHour(L *lua.LState) int {
 // Usage: local job, err = cron.every_day(function() print("daily") end)
 func luaEveryDay(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 0 0 * * *", callback)
+	h, err := createJob(L, "0 0 0 * * *", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -104,7 +104,7 @@ func luaEveryDayAt(L *lua.LState) int {
 	}
 
 	expr := fmt.Sprintf("0 %d %d * * *", minute, hour)
-	h, err := createJob(L, expr, callback)
+	h, err := createJob(L, expr, callback, util.OptOverflowPolicy(L, 3, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -116,7 +116,7 @@ func luaEveryDayAt(L *lua.LState) int {
 // Usage: local job, err = cron.every_week(function() print("weekly") end)
 func luaEveryWeek(L *lua.LState) int {
 	callback := L.CheckFunction(1)
-	h, err := createJob(L, "0 0 0 * * 0", callback)
+	h, err := createJob(L, "0 0 0 * * 0", callback, util.OptOverflowPolicy(L, 2, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -137,7 +137,7 @@ func luaEveryWeekday(L *lua.LState) int {
 	}
 
 	expr := fmt.Sprintf("0 %d %d * * 1-5", minute, hour)
-	h, err := createJob(L, expr, callback)
+	h, err := createJob(L, expr, callback, util.OptOverflowPolicy(L, 3, util.OverflowDropNewest))
 	if err != nil {
 		return util.PushError(L, "failed to create job: %v", err)
 	}
@@ -42,7 +42,9 @@ func (h *jobHandle) stop() {
 	s.mu.Unlock()
 
 	if h.queue != nil {
+		h.source.Close()
 		h.queue.RemoveSource()
+		h.queue.UnregisterStopper(h)
 	}
 }
 
@@ -54,7 +56,14 @@ func jobGC(L *lua.LState) int {
 	return 0
 }
 
-func createJob(L *lua.LState, expr string, callback *lua.LFunction) (*jobHandle, error) {
+// running reports whether the job has not been stopped
+func (h *jobHandle) running() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.stopped
+}
+
+func createJob(L *lua.LState, expr string, callback *lua.LFunction, policy util.OverflowPolicy) (*jobHandle, error) {
 	s := getScheduler()
 	queue := util.GetEventQueue(L)
 	if queue == nil {
@@ -66,21 +75,18 @@ func createJob(L *lua.LState, expr string, callback *lua.LFunction) (*jobHandle,
 		L:        L,
 		expr:     expr,
 		queue:    queue,
+		source:   queue.NewSource("cron "+expr, policy),
 	}
 
 	// Register source
 	queue.AddSource()
 
 	id, err := s.c.AddFunc(expr, func() {
-		h.mu.Lock()
-		if h.stopped {
-			h.mu.Unlock()
-			return
+		// Queue without holding the lock: with the block overflow policy
+		// this waits for the main thread, which may be stopping the job
+		if h.running() {
+			h.source.Queue(h.callback, nil)
 		}
-
-		// Queue the event
-		queue.Queue(h.callback, nil)
-		h.mu.Unlock()
 	})
 
 	if err != nil {
@@ -90,6 +96,7 @@ func createJob(L *lua.LState, expr string, callback *lua.LFunction) (*jobHandle,
 	}
 
 	h.id = id
+	queue.RegisterStopper(h, h.stop)
 
 	s.mu.Lock()
 	s.jobs[id] = h
@@ -30,4 +30,5 @@ type jobHandle struct {
 	mu       sync.Mutex
 	stopped  bool
 	queue    *util.EventQueue
+	source   *util.EventSource
 }
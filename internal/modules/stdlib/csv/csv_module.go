@@ -10,6 +10,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "stdlib.csv"
@@ -152,6 +153,9 @@ func luaReadFile(L *lua.LState) int {
 	filePath := L.CheckString(1)
 	header, delimiter := parseOptions(L, 2)
 
+	if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return util.PushError(L, "file read error: %v", err)
@@ -248,6 +252,10 @@ func luaWriteFile(L *lua.LState) int {
 		records = append(records, row)
 	})
 
+	if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+		L.Push(lua.LString(err.Error()))
+		return 1
+	}
 	file, err := os.Create(filePath)
 	if err != nil {
 		L.Push(lua.LString(fmt.Sprintf("file create error: %v", err)))
@@ -109,8 +109,8 @@ func (e *eventEmitter) emit(L *lua.LState, eventName string, data lua.LValue) in
 	for _, l := range listeners {
 		L.Push(l.callback)
 		L.Push(data)
-		if err := L.PCall(1, 0, nil); err != nil {
-			_ = err
+		if err := util.PCall(L, 1, 0); err != nil {
+			util.ReportCallbackError(L, err)
 		}
 		count++
 	}
@@ -273,17 +273,55 @@ func luaWait(L *lua.LState) int {
 	return util.PushError(L, "blocking wait not supported; use event.on() with callbacks instead")
 }
 
+// luaQueueStats reports the counters of the engine's event queue, which
+// carries timer, cron and file watcher callbacks to the script: how many
+// events were queued, processed, dropped because the queue was full, or
+// coalesced, in total and per source.
+// Usage: local stats, err = event.queue_stats()
+//
+//	for _, src in ipairs(stats.sources) do print(src.name, src.policy, src.dropped) end
+func luaQueueStats(L *lua.LState) int {
+	queue := util.GetEventQueue(L)
+	if queue == nil {
+		return util.PushError(L, "engine event queue not initialized")
+	}
+	stats := queue.Stats()
+
+	tbl := L.NewTable()
+	tbl.RawSetString("capacity", lua.LNumber(stats.Capacity))
+	tbl.RawSetString("pending", lua.LNumber(stats.Pending))
+	tbl.RawSetString("queued", lua.LNumber(stats.Queued))
+	tbl.RawSetString("processed", lua.LNumber(stats.Processed))
+	tbl.RawSetString("dropped", lua.LNumber(stats.Dropped))
+	tbl.RawSetString("coalesced", lua.LNumber(stats.Coalesced))
+
+	sources := L.NewTable()
+	for _, src := range stats.Sources {
+		s := L.NewTable()
+		s.RawSetString("name", lua.LString(src.Name))
+		s.RawSetString("policy", lua.LString(src.Policy.String()))
+		s.RawSetString("queued", lua.LNumber(src.Queued))
+		s.RawSetString("dropped", lua.LNumber(src.Dropped))
+		s.RawSetString("coalesced", lua.LNumber(src.Coalesced))
+		sources.Append(s)
+	}
+	tbl.RawSetString("sources", sources)
+
+	return util.PushSuccess(L, tbl)
+}
+
 var exports = map[string]lua.LGFunction{
-	"on":        luaOn,
-	"once":      luaOnce,
-	"emit":      luaEmit,
-	"off":       luaOff,
-	"off_all":   luaOffAll,
-	"listeners": luaListeners,
-	"count":     luaCount,
-	"names":     luaNames,
-	"clear":     luaClear,
-	"wait":      luaWait,
+	"on":          luaOn,
+	"once":        luaOnce,
+	"emit":        luaEmit,
+	"off":         luaOff,
+	"off_all":     luaOffAll,
+	"listeners":   luaListeners,
+	"count":       luaCount,
+	"names":       luaNames,
+	"clear":       luaClear,
+	"wait":        luaWait,
+	"queue_stats": luaQueueStats,
 }
 
 func Loader(L *lua.LState) int {
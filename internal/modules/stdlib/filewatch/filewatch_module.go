@@ -10,6 +10,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const (
@@ -27,6 +28,7 @@ type watcherHandle struct {
 	closed   bool
 	done     chan struct{}
 	queue    *util.EventQueue
+	source   *util.EventSource
 }
 
 // watcherMethods are methods available on watcher instances (called with : syntax)
@@ -68,7 +70,9 @@ func (h *watcherHandle) close() {
 	}
 
 	if h.queue != nil {
+		h.source.Close()
 		h.queue.RemoveSource()
+		h.queue.UnregisterStopper(h)
 	}
 }
 
@@ -108,7 +112,7 @@ func (h *watcherHandle) queueEvent(event watcher.Event) {
 	h.mu.Unlock()
 
 	// Queue task to run on main thread
-	h.queue.QueueTask(func(L *lua.LState) {
+	h.source.QueueTask(func(L *lua.LState) {
 		eventTbl := L.NewTable()
 		eventTbl.RawSetString("path", lua.LString(event.Path))
 		eventTbl.RawSetString("type", lua.LString(event.Op.String()))
@@ -116,8 +120,8 @@ func (h *watcherHandle) queueEvent(event watcher.Event) {
 
 		L.Push(callback)
 		L.Push(eventTbl)
-		if err := L.PCall(1, 0, nil); err != nil {
-			_ = err
+		if err := util.PCall(L, 1, 0); err != nil {
+			util.ReportCallbackError(L, err)
 		}
 	})
 }
@@ -130,11 +134,17 @@ func watcherGC(L *lua.LState) int {
 	return 0
 }
 
-// Usage: local watcher, err = filewatch.watch(path, callback)
+// The overflow option decides what happens to changes that arrive while the
+// event queue is full: drop_newest (default), drop_oldest, block or coalesce.
+// Usage: local watcher, err = filewatch.watch(path, callback, { overflow = "block" })
 func luaWatch(L *lua.LState) int {
 	path := L.CheckString(1)
 	callback := L.CheckFunction(2)
+	policy := util.OptOverflowPolicy(L, 3, util.OverflowDropNewest)
 
+	if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	if _, err := os.Stat(path); err != nil {
 		return util.PushError(L, "path does not exist: %v", err)
 	}
@@ -157,10 +167,12 @@ func luaWatch(L *lua.LState) int {
 		L:        L,
 		done:     make(chan struct{}),
 		queue:    queue,
+		source:   queue.NewSource("filewatch "+path, policy),
 	}
 
 	// Register source
 	queue.AddSource()
+	queue.RegisterStopper(handle, handle.close)
 
 	go func() {
 		if err := w.Start(defaultWatchDelay); err != nil {
@@ -195,15 +207,21 @@ func luaUnwatch(L *lua.LState) int {
 	return 1
 }
 
-// Usage: local watcher, err = filewatch.watch_glob(pattern, callback)
+// Usage: local watcher, err = filewatch.watch_glob(pattern, callback, { overflow = "block" })
 func luaWatchGlob(L *lua.LState) int {
 	pattern := L.CheckString(1)
 	callback := L.CheckFunction(2)
+	policy := util.OptOverflowPolicy(L, 3, util.OverflowDropNewest)
 
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		return util.PushError(L, "invalid glob pattern: %v", err)
 	}
+	for _, match := range matches {
+		if err := sandbox.Check(L, sandbox.FS, match); err != nil {
+			return util.PushError(L, "%v", err)
+		}
+	}
 
 	queue := util.GetEventQueue(L)
 	if queue == nil {
@@ -226,10 +244,12 @@ func luaWatchGlob(L *lua.LState) int {
 		L:        L,
 		done:     make(chan struct{}),
 		queue:    queue,
+		source:   queue.NewSource("filewatch "+pattern, policy),
 	}
 
 	// Register source
 	queue.AddSource()
+	queue.RegisterStopper(handle, handle.close)
 
 	go func() {
 		if err := w.Start(defaultWatchDelay); err != nil {
@@ -307,6 +327,9 @@ func luaAddPath(L *lua.LState) int {
 		return util.PushError(L, "watcher not initialized")
 	}
 
+	if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	if _, err := os.Stat(path); err != nil {
 		return util.PushError(L, "path does not exist: %v", err)
 	}
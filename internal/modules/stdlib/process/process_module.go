@@ -7,10 +7,12 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const (
@@ -50,6 +52,9 @@ func checkProcess(L *lua.LState) *processHandle {
 // Usage: local output, err = process.exec("ls", {"-la", "/tmp"})
 func luaExec(L *lua.LState) int {
 	name := L.CheckString(1)
+	if err := sandbox.Check(L, sandbox.Process, name); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	argsTable := L.OptTable(2, L.NewTable())
 
 	var args []string
@@ -59,7 +64,9 @@ func luaExec(L *lua.LState) int {
 		}
 	})
 
-	cmd := exec.Command(name, args...)
+	// Killed when the run is stopped or the node attempt times out
+	cmd := exec.CommandContext(util.Context(L), name, args...)
+	cmd.WaitDelay = 100 * time.Millisecond
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		if len(output) > 0 {
@@ -78,6 +85,9 @@ func luaExec(L *lua.LState) int {
 // Usage: local proc, err = process.spawn("long-running-cmd", {args...})
 func luaSpawn(L *lua.LState) int {
 	name := L.CheckString(1)
+	if err := sandbox.Check(L, sandbox.Process, name); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 	argsTable := L.OptTable(2, L.NewTable())
 
 	var args []string
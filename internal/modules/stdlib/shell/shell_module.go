@@ -4,19 +4,36 @@ import (
 	"bytes"
 	"os/exec"
 	"strings"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "stdlib.shell"
 
+// waitDelay bounds how long a killed command's output is waited for, since
+// processes it started may keep it open
+const waitDelay = 100 * time.Millisecond
+
+// command runs cmdStr with sh, killing it when the run is stopped or the
+// workflow node attempt running it times out
+func command(L *lua.LState, cmdStr string) *exec.Cmd {
+	cmd := exec.CommandContext(util.Context(L), "sh", "-c", cmdStr)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
 // Usage: local output, err = shell.exec("ls -la /tmp")
 func luaExec(L *lua.LState) int {
 	cmdStr := L.CheckString(1)
+	if err := sandbox.Check(L, sandbox.Shell, cmdStr); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
-	cmd := exec.Command("sh", "-c", cmdStr)
+	cmd := command(L, cmdStr)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		// Return output even on error (for stderr)
@@ -36,8 +53,14 @@ func luaExec(L *lua.LState) int {
 // Usage: local code, output, err = shell.run("make build")
 func luaRun(L *lua.LState) int {
 	cmdStr := L.CheckString(1)
+	if err := sandbox.Check(L, sandbox.Shell, cmdStr); err != nil {
+		L.Push(lua.LNumber(-1))
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 3
+	}
 
-	cmd := exec.Command("sh", "-c", cmdStr)
+	cmd := command(L, cmdStr)
 	output, err := cmd.CombinedOutput()
 
 	exitCode := 0
@@ -68,12 +91,15 @@ func luaPipe(L *lua.LState) int {
 	commands := make([]string, nArgs)
 	for i := 1; i <= nArgs; i++ {
 		commands[i-1] = L.CheckString(i)
+		if err := sandbox.Check(L, sandbox.Shell, commands[i-1]); err != nil {
+			return util.PushError(L, "%v", err)
+		}
 	}
 
 	// Build pipeline
 	var cmds []*exec.Cmd
 	for _, cmdStr := range commands {
-		cmd := exec.Command("sh", "-c", cmdStr)
+		cmd := command(L, cmdStr)
 		cmds = append(cmds, cmd)
 	}
 
@@ -8,6 +8,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "stdlib.template"
@@ -62,6 +63,9 @@ func luaRender(L *lua.LState) int {
 func luaRenderFile(L *lua.LState) int {
 	filePath := L.CheckString(1)
 	dataTable := L.CheckTable(2)
+	if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	// Read file
 	content, err := os.ReadFile(filePath)
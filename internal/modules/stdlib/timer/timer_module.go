@@ -23,6 +23,7 @@ type timerHandle struct {
 	mu        sync.Mutex
 	stopped   bool
 	queue     *util.EventQueue
+	source    *util.EventSource
 }
 
 var timerMethods = map[string]lua.LGFunction{
@@ -57,7 +58,68 @@ func (h *timerHandle) stop() {
 
 	// Notify engine that this source is done
 	if h.queue != nil {
+		h.source.Close()
 		h.queue.RemoveSource()
+		h.queue.UnregisterStopper(h)
+	}
+}
+
+// tick queues the callback of a repeating timer. It reports false once the
+// timer has stopped. The lock is not held while queueing: with the block
+// overflow policy that waits for the main thread, which may be stopping us.
+func (h *timerHandle) tick() bool {
+	h.mu.Lock()
+	stopped, source := h.stopped, h.source
+	h.mu.Unlock()
+	if stopped {
+		return false
+	}
+	source.Queue(h.callback, nil)
+	return true
+}
+
+// fire queues the callback of a one-shot timer, which is done afterwards
+func (h *timerHandle) fire() {
+	h.mu.Lock()
+	if h.stopped {
+		h.mu.Unlock()
+		return
+	}
+	h.stopped = true
+	close(h.done)
+	h.queue.UnregisterStopper(h)
+	source := h.source
+	h.mu.Unlock()
+
+	source.Queue(h.callback, nil)
+	// The queued event keeps the event loop running from here
+	source.Close()
+	h.queue.RemoveSource()
+}
+
+// runOnce fires the timer after its interval unless it is stopped first
+func (h *timerHandle) runOnce(done chan struct{}) {
+	select {
+	case <-time.After(h.interval):
+		h.fire()
+	case <-done:
+	}
+}
+
+// runRepeating fires the timer every interval until it is stopped
+func (h *timerHandle) runRepeating(done chan struct{}) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !h.tick() {
+				return
+			}
+		case <-done:
+			return
+		}
 	}
 }
 
@@ -71,11 +133,13 @@ func timerGC(L *lua.LState) int {
 	return 0
 }
 
-// luaAfter executes a function after a delay
-// Usage: local t, err = timer.after(5000, function() print("done") end)
+// luaAfter executes a function after a delay. The overflow option sets what
+// happens when the event queue is full (see stdlib.event queue_stats).
+// Usage: local t, err = timer.after(5000, function() print("done") end, { overflow = "block" })
 func luaAfter(L *lua.LState) int {
 	delayMs := L.CheckNumber(1)
 	callback := L.CheckFunction(2)
+	policy := util.OptOverflowPolicy(L, 3, util.OverflowDropNewest)
 
 	if delayMs < 0 {
 		return util.PushError(L, "delay cannot be negative")
@@ -95,38 +159,26 @@ func luaAfter(L *lua.LState) int {
 		repeating: false,
 		done:      make(chan struct{}),
 		queue:     queue,
+		source:    queue.NewSource("timer.after "+delay.String(), policy),
 	}
 
 	// Register source
 	queue.AddSource()
+	queue.RegisterStopper(h, h.stop)
 
-	go func() {
-		select {
-		case <-time.After(delay):
-			h.mu.Lock()
-			if !h.stopped {
-				// Queue the event
-				queue.Queue(h.callback, nil)
-				// One-shot timer is done after firing
-				h.stopped = true
-				close(h.done)
-				queue.RemoveSource()
-			}
-			h.mu.Unlock()
-		case <-h.done:
-			return
-		}
-	}()
+	go h.runOnce(h.done)
 
 	ud := util.NewUserData(L, h, luaTimerTypeName)
 	return util.PushSuccess(L, ud)
 }
 
-// luaEvery executes a function repeatedly at an interval
-// Usage: local t, err = timer.every(1000, function() print("tick") end)
+// luaEvery executes a function repeatedly at an interval. Ticks that find
+// the event queue full are handled by the overflow option.
+// Usage: local t, err = timer.every(1000, function() print("tick") end, { overflow = "coalesce" })
 func luaEvery(L *lua.LState) int {
 	intervalMs := L.CheckNumber(1)
 	callback := L.CheckFunction(2)
+	policy := util.OptOverflowPolicy(L, 3, util.OverflowDropNewest)
 
 	if intervalMs <= 0 {
 		return util.PushError(L, "interval must be positive")
@@ -146,30 +198,14 @@ func luaEvery(L *lua.LState) int {
 		repeating: true,
 		done:      make(chan struct{}),
 		queue:     queue,
+		source:    queue.NewSource("timer.every "+interval.String(), policy),
 	}
 
 	// Register source
 	queue.AddSource()
+	queue.RegisterStopper(h, h.stop)
 
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				h.mu.Lock()
-				if h.stopped {
-					h.mu.Unlock()
-					return
-				}
-				queue.Queue(h.callback, nil)
-				h.mu.Unlock()
-			case <-h.done:
-				return
-			}
-		}
-	}()
+	go h.runRepeating(h.done)
 
 	ud := util.NewUserData(L, h, luaTimerTypeName)
 	return util.PushSuccess(L, ud)
@@ -208,7 +244,9 @@ func luaReset(L *lua.LState) int {
 		// Re-activate
 		h.stopped = false
 		h.done = make(chan struct{})
+		h.source = h.queue.NewSource(h.source.Name(), h.source.Policy())
 		h.queue.AddSource()
+		h.queue.RegisterStopper(h, h.stop)
 	} else {
 		// Stop current goroutine
 		close(h.done)
@@ -219,41 +257,9 @@ func luaReset(L *lua.LState) int {
 
 	// Restart the timer goroutine
 	if h.repeating {
-		go func() {
-			ticker := time.NewTicker(h.interval)
-			defer ticker.Stop()
-
-			for {
-				select {
-				case <-ticker.C:
-					h.mu.Lock()
-					if h.stopped {
-						h.mu.Unlock()
-						return
-					}
-					h.queue.Queue(h.callback, nil)
-					h.mu.Unlock()
-				case <-h.done:
-					return
-				}
-			}
-		}()
+		go h.runRepeating(h.done)
 	} else {
-		go func() {
-			select {
-			case <-time.After(h.interval):
-				h.mu.Lock()
-				if !h.stopped {
-					h.queue.Queue(h.callback, nil)
-					h.stopped = true
-					close(h.done)
-					h.queue.RemoveSource()
-				}
-				h.mu.Unlock()
-			case <-h.done:
-				return
-			}
-		}()
+		go h.runOnce(h.done)
 	}
 
 	L.Push(lua.LNil)
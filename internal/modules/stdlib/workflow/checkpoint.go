@@ -0,0 +1,290 @@
+package workflow
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/core/log"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+
+	_ "modernc.org/sqlite"
+)
+
+// checkpoint is the progress of a workflow run: the results of the nodes
+// that completed and the context after the last of them
+type checkpoint struct {
+	Nodes   map[string]interface{} `json:"nodes"`
+	Context interface{}            `json:"context"`
+	Updated time.Time              `json:"updated"`
+}
+
+// checkpointStore persists checkpoints by run ID and workflow name, or the
+// path of the subflow node (parent/node) for a child workflow
+type checkpointStore interface {
+	// load returns nil when nothing was saved for the run
+	load(runID, workflow string) (*checkpoint, error)
+	save(runID, workflow string, cp *checkpoint) error
+	close() error
+}
+
+// checkpointConfig is where a workflow keeps its checkpoints, from the
+// checkpoint option of workflow.new
+type checkpointConfig struct {
+	file   string // directory of JSON files
+	sqlite string // SQLite database
+}
+
+// parseCheckpointConfig reads {file = "dir"} or {sqlite = "path.db"}
+func parseCheckpointConfig(L *lua.LState, v lua.LValue) (*checkpointConfig, error) {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("checkpoint must be a table like {file = \"dir\"} or {sqlite = \"path.db\"}")
+	}
+	cfg := &checkpointConfig{
+		file:   lua.LVAsString(L.GetField(tbl, "file")),
+		sqlite: lua.LVAsString(L.GetField(tbl, "sqlite")),
+	}
+	if (cfg.file == "") == (cfg.sqlite == "") {
+		return nil, fmt.Errorf("checkpoint needs exactly one of file or sqlite")
+	}
+	return cfg, nil
+}
+
+// open opens the store, checking the sandbox allows writing to it
+func (c *checkpointConfig) open(L *lua.LState) (checkpointStore, error) {
+	path := c.file
+	if path == "" {
+		path = c.sqlite
+	}
+	if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+		return nil, err
+	}
+	if c.file != "" {
+		return &fileCheckpoints{dir: c.file}, nil
+	}
+	return openSQLiteCheckpoints(c.sqlite)
+}
+
+// fileCheckpoints keeps a JSON file per run and workflow:
+// <dir>/<run ID>/<workflow>.json
+type fileCheckpoints struct {
+	dir string
+}
+
+func (s *fileCheckpoints) path(runID, workflow string) string {
+	return filepath.Join(s.dir, url.PathEscape(runID), url.PathEscape(workflow)+".json")
+}
+
+func (s *fileCheckpoints) load(runID, workflow string) (*checkpoint, error) {
+	data, err := os.ReadFile(s.path(runID, workflow))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint %s: %w", s.path(runID, workflow), err)
+	}
+	return &cp, nil
+}
+
+// save replaces the file atomically, so a crash leaves the previous
+// checkpoint intact
+func (s *fileCheckpoints) save(runID, workflow string, cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := s.path(runID, workflow)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileCheckpoints) close() error {
+	return nil
+}
+
+// sqliteCheckpoints keeps checkpoints in a table of a SQLite database,
+// which may be shared with other workflows and runs
+type sqliteCheckpoints struct {
+	db *sql.DB
+}
+
+func openSQLiteCheckpoints(path string) (*sqliteCheckpoints, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS workflow_checkpoints (
+		run_id     TEXT NOT NULL,
+		workflow   TEXT NOT NULL,
+		data       TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (run_id, workflow)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open checkpoint database %s: %w", path, err)
+	}
+	return &sqliteCheckpoints{db: db}, nil
+}
+
+func (s *sqliteCheckpoints) load(runID, workflow string) (*checkpoint, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM workflow_checkpoints WHERE run_id = ? AND workflow = ?`, runID, workflow).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal([]byte(data), &cp); err != nil {
+		return nil, fmt.Errorf("corrupt checkpoint for run %s: %w", runID, err)
+	}
+	return &cp, nil
+}
+
+func (s *sqliteCheckpoints) save(runID, workflow string, cp *checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO workflow_checkpoints (run_id, workflow, data, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (run_id, workflow) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`,
+		runID, workflow, string(data), cp.Updated.UnixNano())
+	return err
+}
+
+func (s *sqliteCheckpoints) close() error {
+	return s.db.Close()
+}
+
+// openCheckpoints opens the checkpoint store for a run of the workflow. When
+// resuming, the run's saved progress is loaded and its context restored
+// over the input. Dry runs read checkpoints but never write them.
+func (wf *workflowHandle) openCheckpoints(L *lua.LState, runID, key string, resume, required bool) error {
+	if runID == "" {
+		// A state created outside an engine
+		runID = uuid.NewString()
+	}
+	dryRun := util.GetRunInfo(L).DryRun
+	progress := &checkpoint{Nodes: make(map[string]interface{})}
+
+	var store checkpointStore
+	if resume || !dryRun {
+		var err error
+		store, err = wf.checkpoint.open(L)
+		if err != nil {
+			return fmt.Errorf("failed to open checkpoints: %w", err)
+		}
+	}
+	if resume {
+		saved, err := store.load(runID, key)
+		if err != nil {
+			store.close()
+			return fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		if saved == nil && required {
+			store.close()
+			return fmt.Errorf("no checkpoint of workflow '%s' for run %s", key, runID)
+		}
+		if saved != nil {
+			progress = saved
+			if progress.Nodes == nil {
+				progress.Nodes = make(map[string]interface{})
+			}
+			log.Info("resuming workflow %s of run %s: %d of %d nodes completed", key, runID, len(progress.Nodes), len(wf.nodes))
+		}
+	}
+	if dryRun && store != nil {
+		store.close()
+		store = nil
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	if ctx, ok := util.GoToLua(L, progress.Context).(*lua.LTable); ok {
+		ctx.ForEach(func(k, v lua.LValue) {
+			wf.context.RawSet(k, v)
+		})
+	}
+	wf.runID = runID
+	wf.key = key
+	wf.store = store
+	wf.progress = progress
+	return nil
+}
+
+// saveCheckpoint records a completed node and the context after it. Runs on
+// the main goroutine, which owns the node results.
+func (wf *workflowHandle) saveCheckpoint(node *workflowNode) error {
+	node.mu.Lock()
+	result := node.result
+	node.mu.Unlock()
+
+	wf.mu.Lock()
+	store, progress := wf.store, wf.progress
+	if store == nil {
+		wf.mu.Unlock()
+		return nil
+	}
+	progress.Nodes[node.name] = util.LuaToGo(result)
+	progress.Context = util.LuaToGo(wf.context)
+	progress.Updated = time.Now()
+	runID, key := wf.runID, wf.key
+	wf.mu.Unlock()
+
+	// The next node must not start before this one is durable: its work
+	// would be repeated on resume
+	if err := store.save(runID, key, progress); err != nil {
+		return fmt.Errorf("failed to checkpoint node '%s': %w", node.name, err)
+	}
+	return nil
+}
+
+// closeCheckpoints closes the store once the run is over
+func (wf *workflowHandle) closeCheckpoints() {
+	wf.mu.Lock()
+	store := wf.store
+	wf.store, wf.progress = nil, nil
+	wf.mu.Unlock()
+	if store != nil {
+		store.close()
+	}
+}
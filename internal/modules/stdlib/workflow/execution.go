@@ -2,38 +2,203 @@ package workflow
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
 
-func (wf *workflowHandle) getReadyNodes() []*workflowNode {
+// readiness is what the trigger rule of a pending node makes of the outcome
+// of its dependencies so far
+type readiness int
+
+const (
+	nodeWaiting readiness = iota
+	nodeReady
+	nodeSkip
+)
+
+// resolveNodes returns the pending nodes that are ready to run, after
+// marking those that never will as skipped. A skip can rule out the nodes
+// after it in turn, so this repeats until nothing more is skipped.
+func (wf *workflowHandle) resolveNodes(L *lua.LState) []*workflowNode {
 	wf.mu.Lock()
-	defer wf.mu.Unlock()
+	var ready, skipped []*workflowNode
+	for changed := true; changed; {
+		changed = false
+		ready = ready[:0]
+		for _, node := range wf.nodes {
+			// Skip completed, failed, skipped or running
+			if node.status != NodeStatusPending {
+				continue
+			}
 
-	var ready []*workflowNode
-	for _, node := range wf.nodes {
-		// Skip completed, failed or running
-		if node.status != NodeStatusPending {
-			continue
+			switch wf.readiness(node) {
+			case nodeReady:
+				ready = append(ready, node)
+			case nodeSkip:
+				node.mu.Lock()
+				node.status = NodeStatusSkipped
+				node.started = time.Now()
+				node.mu.Unlock()
+				skipped = append(skipped, node)
+				changed = true
+			}
+		}
+	}
+	wf.mu.Unlock()
+
+	for _, node := range skipped {
+		wf.report(L, node, nil)
+	}
+
+	// Launch in a stable order so runs are reproducible
+	sort.Slice(ready, func(i, j int) bool { return ready[i].name < ready[j].name })
+	return ready
+}
+
+// readiness applies a node's trigger rule to its dependencies (wf.mu held)
+func (wf *workflowHandle) readiness(node *workflowNode) readiness {
+	if len(node.dependencies) == 0 {
+		return nodeReady
+	}
+
+	completed, skipped, failed := 0, 0, 0
+	for _, depName := range node.dependencies {
+		depNode, exists := wf.nodes[depName]
+		if !exists {
+			return nodeWaiting
+		}
+		switch wf.outcome(depNode, node) {
+		case NodeStatusCompleted:
+			completed++
+		case NodeStatusSkipped:
+			skipped++
+		case NodeStatusFailed:
+			// Only seen when the failure did not end the run (see unhandled)
+			failed++
 		}
+	}
 
-		// Check if all dependencies are completed
-		allDepsReady := true
-		for _, depName := range node.dependencies {
-			depNode, exists := wf.nodes[depName]
-			if !exists || depNode.status != NodeStatusCompleted {
-				allDepsReady = false
-				break
+	all := len(node.dependencies)
+	switch node.trigger {
+	case TriggerAllDone:
+		if completed+skipped+failed == all {
+			return nodeReady
+		}
+	case TriggerOneSuccess:
+		if completed > 0 {
+			return nodeReady
+		}
+		if skipped+failed == all {
+			return nodeSkip
+		}
+	default:
+		if skipped > 0 || failed > 0 {
+			return nodeSkip
+		}
+		if completed == all {
+			return nodeReady
+		}
+	}
+	return nodeWaiting
+}
+
+// outcome is the status of dep as seen by the node after it: a completed
+// branch that did not choose the node counts as skipped
+func (wf *workflowHandle) outcome(dep, node *workflowNode) NodeStatus {
+	if dep.status == NodeStatusCompleted && dep.branch {
+		taken, err := dep.branchTargets()
+		if err != nil || !taken[node.name] {
+			return NodeStatusSkipped
+		}
+	}
+	return dep.status
+}
+
+// checkWhen evaluates a node's when predicate on the main state. A node
+// whose predicate returns false is marked skipped.
+func (wf *workflowHandle) checkWhen(L *lua.LState, node *workflowNode) (bool, error) {
+	node.start()
+
+	L.Push(node.when)
+	L.Push(wf.context)
+	if err := util.PCall(L, 1, 1); err != nil {
+		node.mu.Lock()
+		node.status = NodeStatusFailed
+		node.mu.Unlock()
+		err = fmt.Errorf("node '%s' failed: when: %w", node.name, err)
+		wf.report(L, node, err)
+		return false, err
+	}
+	run := lua.LVAsBool(L.Get(-1))
+	L.Pop(1)
+
+	node.mu.Lock()
+	if run {
+		node.status = NodeStatusPending
+	} else {
+		node.status = NodeStatusSkipped
+	}
+	node.mu.Unlock()
+	if !run {
+		wf.report(L, node, nil)
+	}
+	return run, nil
+}
+
+// unhandled returns err, the failure of node, unless an "all_done" node
+// depends on node: that node runs in spite of the failure, so the run goes
+// on rather than failing
+func (wf *workflowHandle) unhandled(node *workflowNode, err error) error {
+	node.mu.Lock()
+	failed := node.status == NodeStatusFailed
+	node.mu.Unlock()
+	if !failed {
+		return err
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, other := range wf.nodes {
+		if other.trigger != TriggerAllDone {
+			continue
+		}
+		for _, dep := range other.dependencies {
+			if dep == node.name {
+				return nil
 			}
 		}
+	}
+	return err
+}
+
+func (wf *workflowHandle) isCancelled() bool {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return wf.cancelled
+}
 
-		if allDepsReady {
-			ready = append(ready, node)
+// cancel stops the workflow, and the workflows its subflow nodes run, from
+// starting further nodes
+func (wf *workflowHandle) cancel() {
+	wf.mu.Lock()
+	wf.cancelled = true
+	if wf.status == WorkflowStatusPending {
+		wf.status = WorkflowStatusCancelled
+	}
+	var children []*workflowHandle
+	for _, node := range wf.nodes {
+		if node.sub != nil {
+			children = append(children, node.sub.wf)
 		}
 	}
+	wf.mu.Unlock()
 
-	return ready
+	for _, child := range children {
+		child.cancel()
+	}
 }
 
 func (wf *workflowHandle) mergeContext(result lua.LValue) {
@@ -52,111 +217,247 @@ func (wf *workflowHandle) mergeContext(result lua.LValue) {
 	}
 }
 
-func (wf *workflowHandle) executeNode(L *lua.LState, node *workflowNode) error {
-	// Mark as running
+// start marks a node as running
+func (node *workflowNode) start() {
 	node.mu.Lock()
 	node.status = NodeStatusRunning
+	node.started = time.Now()
 	node.mu.Unlock()
+}
+
+// report passes a finished node to the engine's node observer, which
+// records it in the run history
+func (wf *workflowHandle) report(L *lua.LState, node *workflowNode, err error) {
+	node.mu.Lock()
+	report := util.NodeReport{
+		Workflow: wf.name,
+		Node:     node.name,
+		Status:   string(node.status),
+		Started:  node.started,
+		Duration: time.Since(node.started),
+	}
+	node.duration = report.Duration
+	if node.result != nil {
+		report.Result = util.LuaToGo(node.result)
+	}
+	node.mu.Unlock()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	util.ReportNode(L, report)
+}
+
+func (wf *workflowHandle) executeNode(L *lua.LState, node *workflowNode) error {
+	if node.mapper != nil {
+		_, err := wf.startMap(L, nil, node, 0, nil)
+		return err
+	}
+	if node.sub != nil {
+		return wf.runSubflow(L, node)
+	}
+
+	node.start()
 
 	// Call the node's function with the current context
-	L.Push(node.fn)
-	L.Push(wf.context)
-	if err := L.PCall(1, 1, nil); err != nil {
+	var retryOn lua.LValue
+	if node.policy.retryOn != nil {
+		retryOn = node.policy.retryOn
+	}
+	result, err := wf.callNode(L, node, node.fn, wf.context, retryOn)
+	if err != nil {
 		node.mu.Lock()
 		node.status = NodeStatusFailed
 		node.mu.Unlock()
-		return fmt.Errorf("node '%s' failed: %w", node.name, err)
+		wf.report(L, node, err)
+		return err
 	}
 
-	// Get result
-	result := L.Get(-1)
-	L.Pop(1)
+	return wf.completeNode(L, node, result)
+}
 
+// completeNode stores the result of a node that ran, merges it into the
+// shared context and checkpoints it. Runs on the main goroutine.
+func (wf *workflowHandle) completeNode(L *lua.LState, node *workflowNode, result lua.LValue) error {
 	// Store result and mark as completed
 	node.mu.Lock()
 	node.result = result
 	node.status = NodeStatusCompleted
 	node.mu.Unlock()
 
+	// A branch names the nodes to take rather than producing data
+	if node.branch {
+		if _, err := node.branchTargets(); err != nil {
+			node.mu.Lock()
+			node.status = NodeStatusFailed
+			node.mu.Unlock()
+			wf.report(L, node, err)
+			return err
+		}
+	}
+	wf.report(L, node, nil)
+
 	// Merge result into shared context
-	wf.mergeContext(result)
+	if !node.branch {
+		wf.mergeContext(result)
+	}
 
-	return nil
+	return wf.saveCheckpoint(node)
 }
 
+// executeGraph runs every node once its trigger rule is satisfied, skipping
+// the nodes that are ruled out by when predicates and branches.
+// Isolated nodes are handed to worker goroutines with their own Lua state,
+// up to maxParallel at a time; the main goroutine launches nodes, runs the
+// ones pinned to the main state and collects results. After a failure that
+// no "all_done" node handles, or a cancellation, no new nodes are started,
+// but running ones are waited for.
 func (wf *workflowHandle) executeGraph(L *lua.LState) error {
-	// Reset all node statuses
+	// Reset all node statuses, except for nodes a resumed run completed
 	wf.mu.Lock()
 	for _, node := range wf.nodes {
 		node.status = NodeStatusPending
 		node.result = nil
+		node.attempts = 0
+		node.lastError = ""
+		node.items = nil
+		node.duration = 0
+		if wf.progress == nil {
+			continue
+		}
+		if data, ok := wf.progress.Nodes[node.name]; ok {
+			node.status = NodeStatusCompleted
+			node.result = lua.LNil
+			if data != nil {
+				node.result = util.GoToLua(L, data)
+			}
+		}
 	}
+	maxParallel := wf.maxParallel
 	wf.mu.Unlock()
 
-	completedCount := 0
-	totalNodes := len(wf.nodes)
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
 
-	// Main execution loop
-	for completedCount < totalNodes {
-		// Check for cancellation
-		wf.mu.Lock()
-		if wf.cancelled {
-			wf.status = WorkflowStatusCancelled
-			wf.mu.Unlock()
-			return fmt.Errorf("workflow cancelled")
-		}
-		wf.mu.Unlock()
+	pool := newStatePool(L)
+	defer pool.close()
 
-		// Get all ready nodes (nodes with satisfied dependencies)
-		readyNodes := wf.getReadyNodes()
+	// Buffered so workers never block while the main goroutine runs a node
+	results := make(chan nodeExecutionResult, maxParallel)
+	running := 0
+	var firstError error
 
-		if len(readyNodes) == 0 {
-			// No ready nodes - check if we're stuck (circular dependency or error)
-			wf.mu.Lock()
-			hasPending := false
-			for _, node := range wf.nodes {
-				if node.status == NodeStatusPending {
-					hasPending = true
+	for {
+		ranInline := false
+		if firstError == nil && !wf.isCancelled() {
+			for _, node := range wf.resolveNodes(L) {
+				if running >= maxParallel {
 					break
 				}
-			}
-			wf.mu.Unlock()
 
-			if hasPending {
-				return fmt.Errorf("deadlock detected: nodes have unsatisfied dependencies")
+				if node.when != nil {
+					run, err := wf.checkWhen(L, node)
+					if err != nil {
+						if firstError = wf.unhandled(node, err); firstError != nil {
+							break
+						}
+						ranInline = true
+						continue
+					}
+					if !run {
+						// The skip may cascade to the nodes after it
+						ranInline = true
+						continue
+					}
+				}
+
+				if node.mapper != nil {
+					workers := 0
+					if maxParallel > 1 && node.isolated {
+						workers = node.mapper.maxParallel
+						if workers == 0 {
+							workers = maxParallel
+						}
+					}
+					started, err := wf.startMap(L, pool, node, workers, results)
+					if err != nil {
+						if firstError = wf.unhandled(node, err); firstError != nil {
+							break
+						}
+					}
+					if started {
+						running++
+					} else {
+						ranInline = true
+					}
+					continue
+				}
+
+				if maxParallel > 1 && node.isolated {
+					task, err := wf.prepareWorker(L, pool, node)
+					if err == nil {
+						node.start()
+						running++
+						go wf.runInWorker(pool, task, node, results)
+						continue
+					}
+					// The node captures something that cannot leave the main
+					// state (e.g. userdata), so run it there instead
+				}
+
+				if err := wf.executeNode(L, node); err != nil {
+					if firstError = wf.unhandled(node, err); firstError != nil {
+						break
+					}
+				}
+				ranInline = true
 			}
+		}
+
+		// A node that finished or was skipped inline may have unblocked others
+		if ranInline && firstError == nil {
+			continue
+		}
+		if running == 0 {
 			break
 		}
 
-		// Execute ready nodes in parallel
-		// Worker goroutines prepare data, coordinator executes functions in main state
-		if err := wf.executeNodesInParallel(L, readyNodes); err != nil {
-			wf.mu.Lock()
-			wf.status = WorkflowStatusFailed
-			wf.mu.Unlock()
-			return err
+		res := <-results
+		running--
+		if err := wf.finishNode(L, res); err != nil && firstError == nil {
+			firstError = wf.unhandled(res.node, err)
 		}
+	}
 
-		// Count completed nodes
+	if firstError != nil {
 		wf.mu.Lock()
-		for _, node := range readyNodes {
-			if node.status == NodeStatusCompleted {
-				completedCount++
-			}
-		}
+		wf.status = WorkflowStatusFailed
 		wf.mu.Unlock()
+		return firstError
 	}
 
-	// All nodes completed successfully
 	wf.mu.Lock()
-	wf.status = WorkflowStatusCompleted
-	// Set final output to the last completed node's result (or merge all?)
-	wf.mu.Unlock()
+	defer wf.mu.Unlock()
 
+	if wf.cancelled {
+		wf.status = WorkflowStatusCancelled
+		return fmt.Errorf("workflow cancelled")
+	}
+
+	// Nodes still pending could never become ready (circular dependency)
+	for _, node := range wf.nodes {
+		if node.status == NodeStatusPending {
+			return fmt.Errorf("deadlock detected: nodes have unsatisfied dependencies")
+		}
+	}
+
+	wf.status = WorkflowStatusCompleted
 	return nil
 }
 
-// luaWorkflowRun executes the workflow graph
+// luaWorkflowRun executes the workflow graph. When the engine resumes an
+// earlier run (vulgar --resume), nodes checkpointed by it are skipped.
+// Usage: local result, err = workflow.run(wf, input)
 func luaWorkflowRun(L *lua.LState) int {
 	if L.Get(1) == lua.LNil {
 		return util.PushError(L, "workflow is required")
@@ -168,16 +469,73 @@ func luaWorkflowRun(L *lua.LState) int {
 	}
 
 	input := L.OptTable(2, L.NewTable())
+	run := util.GetRunInfo(L)
+	if run.GraphOnly {
+		return recordGraph(L, input)
+	}
+	return wf.run(L, input, run.ID, run.Resume, false)
+}
+
+// recordGraph stands in for running the workflow at index 1 when the script
+// is only loaded for its graph: the workflow is kept for the caller, and the
+// input is handed back as the result
+func recordGraph(L *lua.LState, input *lua.LTable) int {
+	L.SetField(L.Get(lua.RegistryIndex), util.GraphRegistryKey, L.Get(1))
+	return util.PushSuccess(L, input)
+}
+
+// luaWorkflowResume continues a run of the workflow from its checkpoint:
+// completed nodes are skipped and the context is restored
+// Usage: local result, err = workflow.resume(wf, run_id)
+func luaWorkflowResume(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		return util.PushError(L, "workflow is required")
+	}
+
+	wf := checkWorkflow(L, 1)
+	if wf == nil {
+		return util.PushError(L, "invalid workflow")
+	}
+
+	runID := L.CheckString(2)
+	if wf.checkpoint == nil {
+		return util.PushError(L, "workflow '%s' has no checkpoint store", wf.name)
+	}
+	if util.GetRunInfo(L).GraphOnly {
+		return recordGraph(L, L.NewTable())
+	}
+	return wf.run(L, L.NewTable(), runID, true, true)
+}
 
+// run executes the graph as part of run runID and pushes its result. With
+// resume, progress the checkpoint store holds for the run is restored;
+// required makes a missing checkpoint an error rather than a fresh start.
+func (wf *workflowHandle) run(L *lua.LState, input *lua.LTable, runID string, resume, required bool) int {
+	result, err := wf.execute(L, input, runID, wf.name, resume, required)
+	if err != nil {
+		// Keep the node's traceback for the engine's error report in case
+		// the script raises this error
+		util.RecordError(L, err.Error(), err)
+		return util.PushError(L, "%s", err.Error())
+	}
+	return util.PushSuccess(L, result)
+}
+
+// execute runs the graph with input as the context, returning the final
+// context. It is run for workflow.run and for the subflow nodes that embed
+// the workflow in another. key names the checkpoints of this execution in
+// the run: the workflow's name, or the path of the subflow node running it
+// (parent/node), so a child embedded twice keeps two sets of progress.
+func (wf *workflowHandle) execute(L *lua.LState, input *lua.LTable, runID, key string, resume, required bool) (*lua.LTable, error) {
 	wf.mu.Lock()
 	if wf.status == WorkflowStatusRunning {
 		wf.mu.Unlock()
-		return util.PushError(L, "workflow is already running")
+		return nil, fmt.Errorf("workflow is already running")
 	}
 
 	if len(wf.nodes) == 0 {
 		wf.mu.Unlock()
-		return util.PushError(L, "workflow has no nodes")
+		return nil, fmt.Errorf("workflow has no nodes")
 	}
 
 	wf.status = WorkflowStatusRunning
@@ -186,6 +544,24 @@ func luaWorkflowRun(L *lua.LState) int {
 	errorHandler := wf.errorHandler
 	wf.mu.Unlock()
 
+	if wf.checkpoint != nil {
+		if err := wf.openCheckpoints(L, runID, key, resume, required); err != nil {
+			wf.mu.Lock()
+			wf.status = WorkflowStatusFailed
+			wf.mu.Unlock()
+			return nil, err
+		}
+		defer wf.closeCheckpoints()
+	} else {
+		wf.mu.Lock()
+		wf.runID = runID
+		wf.key = key
+		wf.mu.Unlock()
+	}
+
+	// Cancelling the run (e.g. through the HTTP API) cancels the graph
+	defer util.OnCancel(L, wf.cancel)()
+
 	// Execute the graph
 	err := wf.executeGraph(L)
 
@@ -200,8 +576,7 @@ func luaWorkflowRun(L *lua.LState) int {
 			L.Push(lua.LString(err.Error()))
 			_ = L.PCall(1, 0, nil) // Ignore error handler errors
 		}
-
-		return util.PushError(L, "%s", err.Error())
+		return nil, err
 	}
 
 	// Return final context as result
@@ -209,5 +584,5 @@ func luaWorkflowRun(L *lua.LState) int {
 	result := wf.context
 	wf.mu.Unlock()
 
-	return util.PushSuccess(L, result)
+	return result, nil
 }
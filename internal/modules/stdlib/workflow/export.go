@@ -0,0 +1,426 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// ExportFormat is a text format a workflow graph can be exported to
+type ExportFormat string
+
+const (
+	ExportMermaid ExportFormat = "mermaid"
+	ExportDOT     ExportFormat = "dot"
+	ExportJSON    ExportFormat = "json"
+)
+
+// graphExport is a snapshot of a workflow's graph, and optionally of the
+// state its nodes were left in, as workflow.export renders it
+type graphExport struct {
+	Name   string         `json:"name"`
+	Status WorkflowStatus `json:"status,omitempty"`
+	Nodes  []graphNode    `json:"nodes"`
+	Edges  []graphEdge    `json:"edges"`
+}
+
+type graphNode struct {
+	Name    string      `json:"name"`
+	Kind    string      `json:"kind"` // node, branch, map or subflow
+	Trigger TriggerRule `json:"trigger"`
+	Status  NodeStatus  `json:"status,omitempty"`
+	// DurationMS is how long a completed or failed node took
+	DurationMS int64 `json:"duration_ms,omitempty"`
+	// Items and ItemsDone count the items of a map node in the last run
+	Items     int          `json:"items,omitempty"`
+	ItemsDone int          `json:"items_done,omitempty"`
+	Subflow   *graphExport `json:"subflow,omitempty"`
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// nodeRun is the outcome of a node in a recorded run
+type nodeRun struct {
+	status   NodeStatus
+	duration time.Duration
+}
+
+// Usage: local text, err = workflow.export(wf, "mermaid")
+// Usage: local text, err = workflow.export(wf, "dot", {status = true})
+// Renders the graph as a Mermaid flowchart, a Graphviz DOT digraph or JSON,
+// e.g. for runbooks and merge request descriptions. Branch nodes are drawn
+// as diamonds, map nodes with a double border and subflows as a group of
+// their own nodes. status = true adds the status and duration of every node
+// from the workflow's last run. runs, a list of {workflow, name, status,
+// duration_ns} like the nodes 'vulgar runs show --json' prints, shows a
+// recorded run instead.
+func luaExport(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		return util.PushError(L, "workflow is required")
+	}
+	wf := checkWorkflow(L, 1)
+	if wf == nil {
+		return util.PushError(L, "invalid workflow")
+	}
+
+	format := ExportFormat(L.OptString(2, string(ExportMermaid)))
+	var status bool
+	var runs map[string]nodeRun
+	if opts := L.OptTable(3, nil); opts != nil {
+		status = lua.LVAsBool(L.GetField(opts, "status"))
+		if v := L.GetField(opts, "runs"); v != lua.LNil {
+			list, ok := v.(*lua.LTable)
+			if !ok {
+				return util.PushError(L, "runs must be a list of nodes")
+			}
+			runs = parseRuns(list)
+			status = true
+		}
+	}
+
+	text, err := wf.snapshot(status, runs).render(format)
+	if err != nil {
+		return util.PushError(L, "%v", err)
+	}
+	return util.PushSuccess(L, lua.LString(text))
+}
+
+// parseRuns reads the nodes of a recorded run by workflow and node name.
+// A node recorded more than once keeps its last outcome.
+func parseRuns(list *lua.LTable) map[string]nodeRun {
+	runs := make(map[string]nodeRun)
+	for i := 1; i <= list.Len(); i++ {
+		tbl, ok := list.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		key := runKey(lua.LVAsString(tbl.RawGetString("workflow")), lua.LVAsString(tbl.RawGetString("name")))
+		runs[key] = nodeRun{
+			status:   NodeStatus(lua.LVAsString(tbl.RawGetString("status"))),
+			duration: time.Duration(lua.LVAsNumber(tbl.RawGetString("duration_ns"))),
+		}
+	}
+	return runs
+}
+
+func runKey(workflow, node string) string {
+	return workflow + "\x00" + node
+}
+
+// snapshot captures the graph of the workflow, with the state of its nodes
+// when status is set. runs, when not nil, replaces that state.
+func (wf *workflowHandle) snapshot(status bool, runs map[string]nodeRun) *graphExport {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+
+	g := &graphExport{Name: wf.name}
+	if status && runs == nil {
+		g.Status = wf.status
+	}
+
+	names := make([]string, 0, len(wf.nodes))
+	for name := range wf.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := wf.nodes[name]
+		gn := graphNode{Name: name, Kind: "node", Trigger: node.trigger}
+		switch {
+		case node.branch:
+			gn.Kind = "branch"
+		case node.mapper != nil:
+			gn.Kind = "map"
+		case node.sub != nil:
+			gn.Kind = "subflow"
+			gn.Subflow = node.sub.wf.snapshot(status, runs)
+		}
+
+		if status {
+			node.mu.Lock()
+			gn.Status = node.status
+			duration := node.duration
+			for _, item := range node.items {
+				if item.status == NodeStatusCompleted {
+					gn.ItemsDone++
+				}
+			}
+			gn.Items = len(node.items)
+			node.mu.Unlock()
+
+			if runs != nil {
+				run, ok := runs[runKey(wf.name, name)]
+				if !ok {
+					run.status = NodeStatusPending
+				}
+				gn.Status, duration = run.status, run.duration
+				gn.Items, gn.ItemsDone = 0, 0
+			}
+			if gn.Status == NodeStatusCompleted || gn.Status == NodeStatusFailed {
+				gn.DurationMS = duration.Milliseconds()
+			}
+		}
+		g.Nodes = append(g.Nodes, gn)
+
+		for _, dep := range node.dependencies {
+			g.Edges = append(g.Edges, graphEdge{From: dep, To: name})
+		}
+	}
+	if g.Nodes == nil {
+		g.Nodes = []graphNode{}
+	}
+	if g.Edges == nil {
+		g.Edges = []graphEdge{}
+	}
+	return g
+}
+
+// render writes the snapshot in format
+func (g *graphExport) render(format ExportFormat) (string, error) {
+	switch format {
+	case ExportMermaid:
+		return g.mermaid(), nil
+	case ExportDOT:
+		return g.dot(), nil
+	case ExportJSON:
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(data) + "\n", nil
+	}
+	return "", fmt.Errorf("unknown format '%s' (mermaid, dot, json)", format)
+}
+
+// label is the text of a node: its name, its trigger rule when that is
+// not the default, and its state when the snapshot has one
+func (n *graphNode) label() []string {
+	lines := []string{n.Name}
+	if n.Trigger != "" && n.Trigger != TriggerAllSuccess {
+		lines = append(lines, "("+string(n.Trigger)+")")
+	}
+	if n.Status == "" {
+		return lines
+	}
+	state := []string{string(n.Status)}
+	if n.Items > 0 {
+		state = append(state, fmt.Sprintf("%d/%d items", n.ItemsDone, n.Items))
+	}
+	if n.DurationMS > 0 {
+		state = append(state, formatDuration(time.Duration(n.DurationMS)*time.Millisecond))
+	}
+	return append(lines, strings.Join(state, ", "))
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// grouped reports whether a node is drawn as a group of its subflow's nodes
+func (n *graphNode) grouped() bool {
+	return n.Subflow != nil && len(n.Subflow.Nodes) > 0
+}
+
+// graphIDs gives the nodes of a snapshot and its subflows identifiers that
+// are unique across the whole diagram and safe in every format
+type graphIDs struct {
+	ids  map[string]string
+	used map[string]bool
+}
+
+func newGraphIDs() *graphIDs {
+	return &graphIDs{ids: make(map[string]string), used: make(map[string]bool)}
+}
+
+// id returns the identifier of node name in the workflow at prefix
+func (ids *graphIDs) id(prefix, name string) string {
+	key := prefix + "\x00" + name
+	if id, ok := ids.ids[key]; ok {
+		return id
+	}
+	var b strings.Builder
+	b.WriteString("n_")
+	if prefix != "" {
+		b.WriteString(prefix)
+		b.WriteString("__")
+	}
+	for _, r := range name {
+		if r < 128 && (r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	id := b.String()
+	for i := 2; ids.used[id]; i++ {
+		id = fmt.Sprintf("%s_%d", b.String(), i)
+	}
+	ids.used[id] = true
+	ids.ids[key] = id
+	return id
+}
+
+// statusColors are the fill and stroke of nodes by status
+var statusColors = map[NodeStatus][2]string{
+	NodeStatusCompleted: {"#d3f9d8", "#2b8a3e"},
+	NodeStatusFailed:    {"#ffe3e3", "#c92a2a"},
+	NodeStatusRunning:   {"#fff3bf", "#e67700"},
+	NodeStatusSkipped:   {"#f1f3f5", "#868e96"},
+}
+
+// mermaid renders the snapshot as a Mermaid flowchart. Subflows become
+// subgraphs that edges connect to as a whole.
+func (g *graphExport) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	ids := newGraphIDs()
+	classes := make(map[NodeStatus][]string)
+	g.mermaidNodes(&b, ids, "", "    ", classes)
+	g.mermaidEdges(&b, ids, "", "    ")
+
+	var statuses []string
+	for status := range classes {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		colors := statusColors[NodeStatus(status)]
+		fmt.Fprintf(&b, "    classDef %s fill:%s,stroke:%s\n", status, colors[0], colors[1])
+		fmt.Fprintf(&b, "    class %s %s\n", strings.Join(classes[NodeStatus(status)], ","), status)
+	}
+	return b.String()
+}
+
+func (g *graphExport) mermaidNodes(b *strings.Builder, ids *graphIDs, prefix, indent string, classes map[NodeStatus][]string) {
+	for _, n := range g.Nodes {
+		id := ids.id(prefix, n.Name)
+		label := mermaidLabel(n.label())
+		switch {
+		case n.grouped():
+			fmt.Fprintf(b, "%ssubgraph %s [\"%s\"]\n", indent, id, label)
+			n.Subflow.mermaidNodes(b, ids, strings.TrimPrefix(id, "n_"), indent+"    ", classes)
+			n.Subflow.mermaidEdges(b, ids, strings.TrimPrefix(id, "n_"), indent+"    ")
+			fmt.Fprintf(b, "%send\n", indent)
+		case n.Kind == "branch":
+			fmt.Fprintf(b, "%s%s{\"%s\"}\n", indent, id, label)
+		case n.Kind == "map":
+			fmt.Fprintf(b, "%s%s[[\"%s\"]]\n", indent, id, label)
+		case n.Kind == "subflow":
+			fmt.Fprintf(b, "%s%s[/\"%s\"/]\n", indent, id, label)
+		default:
+			fmt.Fprintf(b, "%s%s[\"%s\"]\n", indent, id, label)
+		}
+		if _, ok := statusColors[n.Status]; ok {
+			classes[n.Status] = append(classes[n.Status], id)
+		}
+	}
+}
+
+func (g *graphExport) mermaidEdges(b *strings.Builder, ids *graphIDs, prefix, indent string) {
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "%s%s --> %s\n", indent, ids.id(prefix, e.From), ids.id(prefix, e.To))
+	}
+}
+
+func mermaidLabel(lines []string) string {
+	r := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+	for i, line := range lines {
+		lines[i] = r.Replace(line)
+	}
+	return strings.Join(lines, "<br/>")
+}
+
+// dot renders the snapshot as a Graphviz digraph. Subflows become clusters;
+// edges to and from them are clipped at the cluster's border.
+func (g *graphExport) dot() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(g.Name))
+	b.WriteString("    rankdir=LR;\n")
+	b.WriteString("    compound=true;\n")
+	b.WriteString("    node [shape=box, style=rounded, fontname=\"Helvetica\"];\n")
+	b.WriteString("    edge [fontname=\"Helvetica\"];\n")
+	g.dotBody(&b, newGraphIDs(), "", "    ")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (g *graphExport) dotBody(b *strings.Builder, ids *graphIDs, prefix, indent string) {
+	nodes := make(map[string]*graphNode, len(g.Nodes))
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		nodes[n.Name] = n
+		id := ids.id(prefix, n.Name)
+		attrs := []string{"label=" + dotLabel(n.label())}
+		colors, colored := statusColors[n.Status]
+		if colored {
+			attrs = append(attrs, "style=\"rounded,filled\"", "fillcolor="+dotQuote(colors[0]), "color="+dotQuote(colors[1]))
+		}
+
+		if n.grouped() {
+			fmt.Fprintf(b, "%ssubgraph %s {\n", indent, dotQuote("cluster_"+id))
+			for _, attr := range attrs {
+				fmt.Fprintf(b, "%s    %s;\n", indent, attr)
+			}
+			n.Subflow.dotBody(b, ids, strings.TrimPrefix(id, "n_"), indent+"    ")
+			fmt.Fprintf(b, "%s}\n", indent)
+			continue
+		}
+		switch n.Kind {
+		case "branch":
+			attrs = append(attrs, "shape=diamond")
+		case "map":
+			attrs = append(attrs, "peripheries=2")
+		case "subflow":
+			attrs = append(attrs, "shape=component")
+		}
+		fmt.Fprintf(b, "%s%s [%s];\n", indent, id, strings.Join(attrs, ", "))
+	}
+
+	// An edge touching a subflow is drawn to its first node and clipped
+	// at the cluster with lhead/ltail
+	endpoint := func(name string) (string, string) {
+		id := ids.id(prefix, name)
+		if n := nodes[name]; n != nil && n.grouped() {
+			child := n.Subflow.Nodes[0].Name
+			return ids.id(strings.TrimPrefix(id, "n_"), child), dotQuote("cluster_" + id)
+		}
+		return id, ""
+	}
+	for _, e := range g.Edges {
+		from, ltail := endpoint(e.From)
+		to, lhead := endpoint(e.To)
+		var attrs []string
+		if ltail != "" {
+			attrs = append(attrs, "ltail="+ltail)
+		}
+		if lhead != "" {
+			attrs = append(attrs, "lhead="+lhead)
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(b, "%s%s -> %s [%s];\n", indent, from, to, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(b, "%s%s -> %s;\n", indent, from, to)
+		}
+	}
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func dotLabel(lines []string) string {
+	return dotQuote(strings.Join(lines, "\n"))
+}
@@ -1,6 +1,8 @@
 package workflow
 
 import (
+	"fmt"
+
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules/util"
 )
@@ -40,7 +42,39 @@ func (wf *workflowHandle) computeOutputsLocked() {
 
 // Usage: local err = workflow.node(wf, "node_name", function(ctx) return result end)
 // Usage: local err = workflow.node(wf, "node_name", function(ctx) return result end, {depends_on = {"node1", "node2"}})
+// Usage: local err = workflow.node(wf, "node_name", fn, {isolated = true})
+// Usage: local err = workflow.node(wf, "node_name", fn, {when = function(ctx) return ctx.approved end})
+// Usage: local err = workflow.node(wf, "node_name", fn, {timeout = "30s", retries = 3, backoff = "exponential", retry_on = function(err) return err:find("503") end})
+// Nodes run on the main state, one at a time, and share its upvalues and
+// globals. isolated = true lets a node run concurrently with others in its
+// own Lua state, with copies of its upvalues and the context: changes to
+// shared variables are not seen by the main script, so it must return a
+// table to pass data on. Isolated nodes that capture userdata (clients,
+// connections) still run on the main state.
+// A node whose when predicate returns false is skipped, and so are the nodes
+// after it unless their trigger is "all_done" or "one_success" (the default
+// "all_success" needs every dependency to complete).
+// A failed node is retried up to retries times (default: the workflow's),
+// waiting delay (default 1s) between attempts, growing by backoff
+// ("constant", "linear" or "exponential") up to max_delay (default 30s).
+// retry_on gets the error and returns whether to retry. timeout (a duration
+// or milliseconds) limits each attempt. A node that still fails fails the
+// run, unless an "all_done" node depends on it: that node runs after the
+// failure, and the nodes that needed the failed one are skipped.
 func luaNode(L *lua.LState) int {
+	return addNode(L, &workflowNode{fn: L.CheckFunction(3)}, 4)
+}
+
+// Usage: local err = workflow.branch(wf, "node_name", function(ctx) return "next_node" end, {depends_on = {"node1"}})
+// A branch node returns the name, or a list of names, of the nodes after it
+// to take; the others are skipped. Its result is not merged into the context.
+func luaBranch(L *lua.LState) int {
+	return addNode(L, &workflowNode{fn: L.CheckFunction(3), branch: true}, 4)
+}
+
+// addNode adds node to the workflow under the name in argument 2, with the
+// options in argument optsIdx. The caller sets the node's functions.
+func addNode(L *lua.LState, node *workflowNode, optsIdx int) int {
 	if L.Get(1) == lua.LNil {
 		return util.PushError(L, "workflow is required")
 	}
@@ -51,12 +85,17 @@ func luaNode(L *lua.LState) int {
 	}
 
 	name := L.CheckString(2)
-	fn := L.CheckFunction(3)
-	opts := L.OptTable(4, nil)
+	opts := L.OptTable(optsIdx, nil)
 
 	// Extract dependencies from options
 	var dependencies []string
+	var when *lua.LFunction
+	isolated := node.mapper != nil
+	trigger := TriggerAllSuccess
 	if opts != nil {
+		if v := L.GetField(opts, "isolated"); v != lua.LNil {
+			isolated = lua.LVAsBool(v)
+		}
 		if depsTable := L.GetField(opts, "depends_on"); depsTable != lua.LNil {
 			if deps, ok := depsTable.(*lua.LTable); ok {
 				deps.ForEach(func(_, v lua.LValue) {
@@ -66,11 +105,49 @@ func luaNode(L *lua.LState) int {
 				})
 			}
 		}
+		if v := L.GetField(opts, "when"); v != lua.LNil {
+			pred, ok := v.(*lua.LFunction)
+			if !ok {
+				return util.PushError(L, "when must be a function for node '%s'", name)
+			}
+			when = pred
+		}
+		if v := L.GetField(opts, "trigger"); v != lua.LNil {
+			switch rule := TriggerRule(lua.LVAsString(v)); rule {
+			case TriggerAllSuccess, TriggerAllDone, TriggerOneSuccess:
+				trigger = rule
+			default:
+				return util.PushError(L, "unknown trigger '%s' for node '%s' (all_success, all_done, one_success)", v.String(), name)
+			}
+		}
 	}
 
 	wf.mu.Lock()
 	defer wf.mu.Unlock()
 
+	policy := nodePolicy{
+		timeout:  wf.timeout,
+		retries:  wf.retries,
+		backoff:  BackoffConstant,
+		delay:    defaultRetryDelay,
+		maxDelay: defaultMaxRetryDelay,
+	}
+	if opts != nil {
+		if err := parsePolicy(L, opts, &policy); err != nil {
+			return util.PushError(L, "node '%s': %v", name, err)
+		}
+	}
+	if node.mapper != nil {
+		if opts != nil {
+			if err := node.mapper.parse(L, opts); err != nil {
+				return util.PushError(L, "node '%s': %v", name, err)
+			}
+		}
+		if node.mapper.output == "" {
+			node.mapper.output = name
+		}
+	}
+
 	if _, exists := wf.nodes[name]; exists {
 		return util.PushError(L, "node '%s' already exists", name)
 	}
@@ -82,14 +159,16 @@ func luaNode(L *lua.LState) int {
 		}
 	}
 
-	// Create and add node
-	node := &workflowNode{
-		name:         name,
-		fn:           fn,
-		dependencies: dependencies,
-		outputs:      []string{},
-		status:       NodeStatusPending,
-	}
+	// Add the node
+	node.name = name
+	node.dependencies = dependencies
+	node.outputs = []string{}
+	node.when = when
+	node.trigger = trigger
+	node.policy = policy
+	node.status = NodeStatusPending
+	// A subflow's graph schedules its own nodes from the main state
+	node.isolated = isolated && node.sub == nil
 
 	if wf.nodes == nil {
 		wf.nodes = make(map[string]*workflowNode)
@@ -148,3 +227,42 @@ func luaEdge(L *lua.LState) int {
 	L.Push(lua.LNil)
 	return 1
 }
+
+// branchTargets returns the outputs a completed branch node takes, from the
+// name or list of names it returned
+func (node *workflowNode) branchTargets() (map[string]bool, error) {
+	taken := make(map[string]bool)
+	add := func(v lua.LValue) error {
+		name, ok := v.(lua.LString)
+		if !ok {
+			return fmt.Errorf("branch '%s' must return node names, got %s", node.name, v.Type())
+		}
+		for _, out := range node.outputs {
+			if out == string(name) {
+				taken[out] = true
+				return nil
+			}
+		}
+		return fmt.Errorf("branch '%s' chose '%s', which does not depend on it", node.name, name)
+	}
+
+	switch result := node.result.(type) {
+	case nil, *lua.LNilType:
+		// Nothing taken: every output is skipped
+	case *lua.LTable:
+		var err error
+		result.ForEach(func(_, v lua.LValue) {
+			if err == nil {
+				err = add(v)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if err := add(result); err != nil {
+			return nil, err
+		}
+	}
+	return taken, nil
+}
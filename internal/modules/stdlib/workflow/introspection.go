@@ -6,7 +6,9 @@ import (
 
 // luaGetNodes returns a table with all node information for TUI inspection
 // Usage: local nodes = workflow.get_nodes(wf)
-// Returns: { {name="node1", status="pending", dependencies={"dep1"}, result=...}, ... }
+// Returns: { {name="node1", status="pending", trigger="all_success", branch=false, dependencies={"dep1"}, result=...}, ... }
+// Map nodes also have map=true and items={ {status="completed", attempts=1, error=...}, ... }
+// Subflow nodes have subflow="child name" and nodes={...}, the child's nodes
 func luaGetNodes(L *lua.LState) int {
 	if L.Get(1) == lua.LNil {
 		L.Push(L.NewTable())
@@ -19,6 +21,12 @@ func luaGetNodes(L *lua.LState) int {
 		return 1
 	}
 
+	L.Push(wf.nodesTable(L))
+	return 1
+}
+
+// nodesTable lists the nodes of the workflow for luaGetNodes
+func (wf *workflowHandle) nodesTable(L *lua.LState) *lua.LTable {
 	wf.mu.Lock()
 	defer wf.mu.Unlock()
 
@@ -29,6 +37,16 @@ func luaGetNodes(L *lua.LState) int {
 		nodeTable := L.NewTable()
 		nodeTable.RawSetString("name", lua.LString(name))
 		nodeTable.RawSetString("status", lua.LString(node.status))
+		nodeTable.RawSetString("trigger", lua.LString(node.trigger))
+		nodeTable.RawSetString("branch", lua.LBool(node.branch))
+		if node.mapper != nil {
+			nodeTable.RawSetString("map", lua.LTrue)
+			nodeTable.RawSetString("items", node.itemsTable(L))
+		}
+		if node.sub != nil {
+			nodeTable.RawSetString("subflow", lua.LString(node.sub.wf.name))
+			nodeTable.RawSetString("nodes", node.sub.wf.nodesTable(L))
+		}
 
 		// Add dependencies
 		depsTable := L.NewTable()
@@ -55,8 +73,25 @@ func luaGetNodes(L *lua.LState) int {
 		idx++
 	}
 
-	L.Push(result)
-	return 1
+	return result
+}
+
+// itemsTable lists the state of each item of a map node
+func (node *workflowNode) itemsTable(L *lua.LState) *lua.LTable {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	items := L.NewTable()
+	for i, item := range node.items {
+		itemTable := L.NewTable()
+		itemTable.RawSetString("status", lua.LString(item.status))
+		itemTable.RawSetString("attempts", lua.LNumber(item.attempts))
+		if item.lastError != "" {
+			itemTable.RawSetString("error", lua.LString(item.lastError))
+		}
+		items.RawSetInt(i+1, itemTable)
+	}
+	return items
 }
 
 // luaGetEdges returns a table with all edges (connections) for TUI visualization
@@ -209,6 +244,10 @@ func luaReset(L *lua.LState) int {
 		node.mu.Lock()
 		node.status = NodeStatusPending
 		node.result = nil
+		node.attempts = 0
+		node.lastError = ""
+		node.items = nil
+		node.duration = 0
 		node.mu.Unlock()
 	}
 
@@ -221,6 +260,7 @@ func luaReset(L *lua.LState) int {
 
 // luaGetNodeStatus returns the status of a specific node
 // Usage: local status = workflow.get_node_status(wf, "node_name")
+// Returns: {name="node1", status="completed", attempts=2, result=..., error="last failed attempt"}
 func luaGetNodeStatus(L *lua.LState) int {
 	if L.Get(1) == lua.LNil {
 		L.Push(lua.LNil)
@@ -247,14 +287,20 @@ func luaGetNodeStatus(L *lua.LState) int {
 	node.mu.Lock()
 	status := node.status
 	result := node.result
+	attempts := node.attempts
+	lastError := node.lastError
 	node.mu.Unlock()
 
 	statusTable := L.NewTable()
 	statusTable.RawSetString("name", lua.LString(nodeName))
 	statusTable.RawSetString("status", lua.LString(status))
+	statusTable.RawSetString("attempts", lua.LNumber(attempts))
 	if result != nil {
 		statusTable.RawSetString("result", result)
 	}
+	if lastError != "" {
+		statusTable.RawSetString("error", lua.LString(lastError))
+	}
 
 	L.Push(statusTable)
 	return 1
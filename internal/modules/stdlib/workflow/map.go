@@ -0,0 +1,254 @@
+package workflow
+
+import (
+	"fmt"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// mapSpec is how a map node fans out over its items
+type mapSpec struct {
+	items       *lua.LFunction // Returns the list of items
+	maxParallel int            // Items processed at once (0: the workflow's max_parallel)
+	output      string         // Context key of the results (default: the node's name)
+}
+
+// mapItem is the state of one item of a map node
+type mapItem struct {
+	status    NodeStatus
+	attempts  int
+	lastError string
+}
+
+// Usage: local err = workflow.map_node(wf, "node_name", function(ctx) return ctx.repos end, function(item, ctx) return result end)
+// Usage: local err = workflow.map_node(wf, "node_name", items_fn, per_item_fn, {depends_on = {"list"}, max_parallel = 4, output = "reports"})
+// A map node calls items_fn with the context on the main state, then
+// per_item_fn for every item it returned, up to max_parallel (default: the
+// workflow's) at a time in isolated states. The results, in item order, are
+// stored in the context under output (default: the node's name). Items must
+// be plain data. The node's timeout and retries apply to each item; the
+// first item that fails fails the node.
+func luaMapNode(L *lua.LState) int {
+	node := &workflowNode{
+		fn:     L.CheckFunction(4),
+		mapper: &mapSpec{items: L.CheckFunction(3)},
+	}
+	return addNode(L, node, 5)
+}
+
+// parse reads the max_parallel and output options of a map node
+func (m *mapSpec) parse(L *lua.LState, opts *lua.LTable) error {
+	if v := L.GetField(opts, "max_parallel"); v != lua.LNil {
+		n, ok := v.(lua.LNumber)
+		if !ok || n < 1 {
+			return fmt.Errorf("max_parallel must be at least 1")
+		}
+		m.maxParallel = int(n)
+	}
+	if v := L.GetField(opts, "output"); v != lua.LNil {
+		m.output = lua.LVAsString(v)
+	}
+	return nil
+}
+
+// listItems calls the items function of a map node with the context
+func (wf *workflowHandle) listItems(L *lua.LState, node *workflowNode) ([]lua.LValue, error) {
+	L.Push(node.mapper.items)
+	L.Push(wf.context)
+	if err := util.PCall(L, 1, 1); err != nil {
+		return nil, fmt.Errorf("node '%s' failed: items: %w", node.name, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	var items []lua.LValue
+	switch list := ret.(type) {
+	case *lua.LNilType:
+	case *lua.LTable:
+		for i := 1; i <= list.Len(); i++ {
+			items = append(items, list.RawGetInt(i))
+		}
+	default:
+		return nil, fmt.Errorf("node '%s' failed: items must return a list, got %s", node.name, ret.Type())
+	}
+	return items, nil
+}
+
+// startMap lists the items of a map node and processes them. With workers
+// above zero the items are handed to that many worker states on a goroutine,
+// which sends the node's result to results, and startMap returns true.
+// Otherwise, or when the per-item function cannot leave the main state,
+// they are processed here one by one.
+func (wf *workflowHandle) startMap(L *lua.LState, pool *statePool, node *workflowNode, workers int, results chan<- nodeExecutionResult) (bool, error) {
+	node.start()
+
+	items, err := wf.listItems(L, node)
+	if err != nil {
+		return false, wf.failNode(L, node, err)
+	}
+
+	node.mu.Lock()
+	node.items = make([]mapItem, len(items))
+	for i := range node.items {
+		node.items[i].status = NodeStatusPending
+	}
+	node.mu.Unlock()
+
+	if workers > len(items) {
+		workers = len(items)
+	}
+	if workers > 0 {
+		if tasks := wf.prepareMapWorkers(L, pool, node, workers); tasks != nil {
+			data := make([]interface{}, len(items))
+			for i, item := range items {
+				data[i] = util.LuaToGo(item)
+			}
+			go wf.runMap(pool, node, tasks, data, results)
+			return true, nil
+		}
+	}
+
+	var retryOn lua.LValue
+	if node.policy.retryOn != nil {
+		retryOn = node.policy.retryOn
+	}
+	out := L.NewTable()
+	for i, item := range items {
+		if wf.isCancelled() {
+			return false, wf.failNode(L, node, fmt.Errorf("node '%s' cancelled after %d of %d items", node.name, i, len(items)))
+		}
+		result, err := wf.callItem(L, node, i, node.fn, retryOn, item, wf.context)
+		if err != nil {
+			return false, wf.failNode(L, node, err)
+		}
+		out.RawSetInt(i+1, result)
+	}
+
+	result := L.NewTable()
+	result.RawSetString(node.mapper.output, out)
+	return false, wf.completeNode(L, node, result)
+}
+
+// prepareMapWorkers copies the per-item function of a map node into
+// workers worker states, or returns nil when it cannot leave the main state
+func (wf *workflowHandle) prepareMapWorkers(L *lua.LState, pool *statePool, node *workflowNode, workers int) []*workerTask {
+	tasks := make([]*workerTask, 0, workers)
+	for i := 0; i < workers; i++ {
+		task, err := wf.prepareWorker(L, pool, node)
+		if err != nil {
+			for _, task := range tasks {
+				pool.release(task.ws)
+			}
+			return nil
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// runMap processes the items of a map node on the prepared worker states,
+// stopping at the first item that fails, and sends the node's result. Runs
+// on its own goroutine.
+func (wf *workflowHandle) runMap(pool *statePool, node *workflowNode, tasks []*workerTask, items []interface{}, results chan<- nodeExecutionResult) {
+	out := make([]interface{}, len(items))
+	var firstErr error
+	var mu sync.Mutex
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task *workerTask) {
+			defer wg.Done()
+			for i := range next {
+				var item lua.LValue = lua.LNil
+				if items[i] != nil {
+					item = util.GoToLua(task.ws.L, items[i])
+				}
+				result, err := wf.callItem(task.ws.L, node, i, task.fn, task.retryOn, item, task.ctx)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					out[i] = util.LuaToGo(result)
+				}
+				mu.Unlock()
+			}
+		}(task)
+	}
+
+	dispatched := 0
+	for i := range items {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed || wf.isCancelled() {
+			break
+		}
+		next <- i
+		dispatched++
+	}
+	close(next)
+	wg.Wait()
+
+	for _, task := range tasks {
+		pool.release(task.ws)
+	}
+
+	res := nodeExecutionResult{node: node}
+	switch {
+	case firstErr != nil:
+		res.err = firstErr
+	case dispatched < len(items):
+		res.err = fmt.Errorf("node '%s' cancelled after %d of %d items", node.name, dispatched, len(items))
+	default:
+		res.data = map[string]interface{}{node.mapper.output: out}
+	}
+	results <- res
+}
+
+// callItem processes item i of a map node on L, recording its status and
+// attempts
+func (wf *workflowHandle) callItem(L *lua.LState, node *workflowNode, i int, fn, retryOn, item, ctx lua.LValue) (lua.LValue, error) {
+	node.mu.Lock()
+	node.items[i].status = NodeStatusRunning
+	node.mu.Unlock()
+
+	record := func(attempt int, err error) {
+		node.mu.Lock()
+		node.items[i].attempts = attempt
+		if err != nil {
+			node.items[i].lastError = err.Error()
+		}
+		node.mu.Unlock()
+	}
+	result, attempts, err := wf.callWithPolicy(L, &node.policy, retryOn, record, fn, item, ctx)
+
+	node.mu.Lock()
+	if err != nil {
+		node.items[i].status = NodeStatusFailed
+	} else {
+		node.items[i].status = NodeStatusCompleted
+	}
+	node.mu.Unlock()
+
+	if err != nil {
+		if attempts > 1 {
+			return nil, fmt.Errorf("node '%s' failed on item %d after %d attempts: %w", node.name, i+1, attempts, err)
+		}
+		return nil, fmt.Errorf("node '%s' failed on item %d: %w", node.name, i+1, err)
+	}
+	return result, nil
+}
+
+// failNode marks a node failed and reports it, returning err
+func (wf *workflowHandle) failNode(L *lua.LState, node *workflowNode, err error) error {
+	node.mu.Lock()
+	node.status = NodeStatusFailed
+	node.mu.Unlock()
+	wf.report(L, node, err)
+	return err
+}
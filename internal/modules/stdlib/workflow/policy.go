@@ -0,0 +1,246 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// Backoff is how the delay between the attempts of a node grows
+type Backoff string
+
+const (
+	BackoffConstant    Backoff = "constant"
+	BackoffLinear      Backoff = "linear"
+	BackoffExponential Backoff = "exponential"
+)
+
+const (
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = 30 * time.Second
+)
+
+// nodePolicy is how long each attempt of a node may take and how failed
+// attempts are retried
+type nodePolicy struct {
+	timeout  time.Duration // Per attempt; 0 means no timeout
+	retries  int           // Attempts after the first
+	backoff  Backoff
+	delay    time.Duration // Delay before the first retry
+	maxDelay time.Duration
+	retryOn  *lua.LFunction // Decides from the error whether to retry (nil retries every error)
+}
+
+// parseDuration reads a duration string like "30s" or a number of
+// milliseconds
+func parseDuration(v lua.LValue) (time.Duration, error) {
+	switch val := v.(type) {
+	case lua.LNumber:
+		return time.Duration(val) * time.Millisecond, nil
+	case lua.LString:
+		return time.ParseDuration(string(val))
+	}
+	return 0, fmt.Errorf("expected a duration like \"30s\" or milliseconds, got %s", v.Type())
+}
+
+// parsePolicy reads the timeout, retries, backoff, delay, max_delay and
+// retry_on options of a node over the workflow's defaults
+func parsePolicy(L *lua.LState, opts *lua.LTable, policy *nodePolicy) error {
+	for field, dst := range map[string]*time.Duration{
+		"timeout":   &policy.timeout,
+		"delay":     &policy.delay,
+		"max_delay": &policy.maxDelay,
+	} {
+		if v := L.GetField(opts, field); v != lua.LNil {
+			d, err := parseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+			*dst = d
+		}
+	}
+	if v := L.GetField(opts, "retries"); v != lua.LNil {
+		n, ok := v.(lua.LNumber)
+		if !ok || n < 0 {
+			return fmt.Errorf("retries must be a number of retries")
+		}
+		policy.retries = int(n)
+	}
+	if v := L.GetField(opts, "backoff"); v != lua.LNil {
+		switch backoff := Backoff(lua.LVAsString(v)); backoff {
+		case BackoffConstant, BackoffLinear, BackoffExponential:
+			policy.backoff = backoff
+		default:
+			return fmt.Errorf("unknown backoff '%s' (constant, linear, exponential)", v.String())
+		}
+	}
+	if v := L.GetField(opts, "retry_on"); v != lua.LNil {
+		fn, ok := v.(*lua.LFunction)
+		if !ok {
+			return fmt.Errorf("retry_on must be a function")
+		}
+		policy.retryOn = fn
+	}
+	return nil
+}
+
+// retryDelay is the wait before retry n (1 for the first retry)
+func (p *nodePolicy) retryDelay(n int) time.Duration {
+	delay := p.delay
+	switch p.backoff {
+	case BackoffLinear:
+		delay *= time.Duration(n)
+	case BackoffExponential:
+		for i := 1; i < n && (p.maxDelay == 0 || delay < p.maxDelay); i++ {
+			delay *= 2
+		}
+	}
+	if p.maxDelay > 0 && delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay
+}
+
+// callNode calls a node's function on L until an attempt succeeds or the
+// node's policy gives up, recording the attempts on the node. fn, ctx and
+// retryOn are values of L (retryOn is nil without a retry_on predicate).
+// The result is left to the caller; the error names the node.
+func (wf *workflowHandle) callNode(L *lua.LState, node *workflowNode, fn, ctx, retryOn lua.LValue) (lua.LValue, error) {
+	record := func(attempt int, err error) {
+		node.mu.Lock()
+		node.attempts = attempt
+		if err != nil {
+			node.lastError = err.Error()
+		}
+		node.mu.Unlock()
+	}
+	result, attempts, err := wf.callWithPolicy(L, &node.policy, retryOn, record, fn, ctx)
+	if err != nil {
+		return nil, node.failure(err, attempts)
+	}
+	return result, nil
+}
+
+// callWithPolicy calls fn with args on L until an attempt succeeds or the
+// policy gives up, telling record about every attempt. It returns the
+// number of attempts made.
+func (wf *workflowHandle) callWithPolicy(L *lua.LState, policy *nodePolicy, retryOn lua.LValue, record func(attempt int, err error), fn lua.LValue, args ...lua.LValue) (lua.LValue, int, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := policy.attempt(L, fn, args...)
+		record(attempt, err)
+
+		if err == nil {
+			return result, attempt, nil
+		}
+		if attempt > policy.retries || wf.isCancelled() {
+			return nil, attempt, err
+		}
+
+		if retryOn != nil {
+			L.Push(retryOn)
+			L.Push(lua.LString(err.Error()))
+			if callErr := util.PCall(L, 1, 1); callErr != nil {
+				return nil, attempt, fmt.Errorf("retry_on: %w", callErr)
+			}
+			retry := lua.LVAsBool(L.Get(-1))
+			L.Pop(1)
+			if !retry {
+				return nil, attempt, err
+			}
+		}
+
+		if !sleep(L, policy.retryDelay(attempt)) {
+			return nil, attempt, err
+		}
+	}
+}
+
+// attempt calls fn once, interrupting it when the timeout passes
+func (p *nodePolicy) attempt(L *lua.LState, fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
+	if p.timeout > 0 {
+		parent := L.Context()
+		base := parent
+		if base == nil {
+			base = context.Background()
+		}
+		timeoutCtx, cancel := context.WithTimeout(base, p.timeout)
+		L.SetContext(&attemptContext{Context: timeoutCtx, parent: base})
+		defer func() {
+			cancel()
+			if parent != nil {
+				L.SetContext(parent)
+			} else {
+				L.RemoveContext()
+			}
+		}()
+
+		result, err := call(L, fn, args...)
+		if err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && base.Err() == nil {
+			return nil, fmt.Errorf("timed out after %s", p.timeout)
+		}
+		return result, err
+	}
+	return call(L, fn, args...)
+}
+
+// attemptContext adds the timeout of an attempt to a state's context. The
+// parent's Done and Err are still consulted, since the engine counts
+// instructions in Done and reports the limit that was hit through Err.
+type attemptContext struct {
+	context.Context
+	parent context.Context
+}
+
+func (c *attemptContext) Done() <-chan struct{} {
+	c.parent.Done()
+	return c.Context.Done()
+}
+
+func (c *attemptContext) Err() error {
+	if err := c.parent.Err(); err != nil {
+		return err
+	}
+	return c.Context.Err()
+}
+
+func call(L *lua.LState, fn lua.LValue, args ...lua.LValue) (lua.LValue, error) {
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := util.PCall(L, len(args), 1); err != nil {
+		return nil, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return result, nil
+}
+
+// sleep waits for d, returning false when L's context ends first
+func sleep(L *lua.LState, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	var done <-chan struct{}
+	if ctx := L.Context(); ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// failure is the error of a node whose last attempt failed with err
+func (node *workflowNode) failure(err error, attempts int) error {
+	if attempts > 1 {
+		return fmt.Errorf("node '%s' failed after %d attempts: %w", node.name, attempts, err)
+	}
+	return fmt.Errorf("node '%s' failed: %w", node.name, err)
+}
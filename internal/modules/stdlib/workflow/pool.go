@@ -0,0 +1,275 @@
+package workflow
+
+import (
+	"fmt"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// statePool hands out isolated Lua states to node workers.
+// States are created lazily through the engine's state factory (so they get
+// the same module loaders and sandbox policy as the main state), reused
+// across nodes and closed when the run finishes.
+type statePool struct {
+	main *lua.LState
+	idle []*workerState
+	all  []*workerState
+	mu   sync.Mutex
+}
+
+// workerState is a pooled Lua state plus the global names it started with
+type workerState struct {
+	L        *lua.LState
+	baseline map[string]bool
+}
+
+func newStatePool(main *lua.LState) *statePool {
+	return &statePool{main: main}
+}
+
+// acquire returns an idle state or creates a new one
+func (p *statePool) acquire() *workerState {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		ws := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return ws
+	}
+	p.mu.Unlock()
+
+	L := util.NewIsolatedState(p.main)
+	ws := &workerState{L: L, baseline: make(map[string]bool)}
+	L.G.Global.ForEach(func(k, _ lua.LValue) {
+		if name, ok := k.(lua.LString); ok {
+			ws.baseline[string(name)] = true
+		}
+	})
+
+	p.mu.Lock()
+	p.all = append(p.all, ws)
+	p.mu.Unlock()
+	return ws
+}
+
+// release returns a state to the pool. Safe to call from worker goroutines.
+func (p *statePool) release(ws *workerState) {
+	ws.L.SetTop(0)
+	p.mu.Lock()
+	p.idle = append(p.idle, ws)
+	p.mu.Unlock()
+}
+
+// close closes every state created by the pool
+func (p *statePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ws := range p.all {
+		ws.L.Close()
+	}
+	p.all = nil
+	p.idle = nil
+}
+
+// transferer copies values from one Lua state into another.
+// It must run on the goroutine that owns src, while dst is not in use.
+//
+// Primitives are shared as-is. Lua functions are rebuilt from their
+// prototype with copied upvalues and the destination's globals. Module
+// tables are re-required and builtin tables (string, math, ...) are mapped
+// to the destination's own. Userdata and coroutines cannot be moved between
+// states, so they produce an error and the caller falls back to running the
+// node on the main state.
+type transferer struct {
+	src  *lua.LState
+	dst  *workerState
+	seen map[lua.LValue]lua.LValue
+	// modules maps module tables in src to their require() name
+	modules map[*lua.LTable]string
+	// protos collects the prototypes of every copied Lua function
+	protos []*lua.FunctionProto
+}
+
+func newTransferer(src *lua.LState, dst *workerState) *transferer {
+	t := &transferer{
+		src:     src,
+		dst:     dst,
+		seen:    make(map[lua.LValue]lua.LValue),
+		modules: make(map[*lua.LTable]string),
+	}
+
+	// Builtin globals map onto the destination's own copies
+	dstGlobals := dst.L.G.Global
+	t.seen[src.G.Global] = dstGlobals
+	dstGlobals.ForEach(func(k, v lua.LValue) {
+		srcValue := src.G.Global.RawGet(k)
+		if _, ok := srcValue.(*lua.LTable); ok {
+			if _, ok := v.(*lua.LTable); ok {
+				t.seen[srcValue] = v
+			}
+		}
+	})
+
+	if loaded, ok := src.GetField(src.Get(lua.RegistryIndex), "_LOADED").(*lua.LTable); ok {
+		loaded.ForEach(func(k, v lua.LValue) {
+			if tbl, ok := v.(*lua.LTable); ok {
+				if name, ok := k.(lua.LString); ok {
+					t.modules[tbl] = string(name)
+				}
+			}
+		})
+	}
+
+	return t
+}
+
+func (t *transferer) copy(v lua.LValue) (lua.LValue, error) {
+	switch val := v.(type) {
+	case lua.LBool, lua.LNumber, lua.LString, *lua.LNilType, lua.LChannel:
+		return v, nil
+	case *lua.LTable:
+		return t.copyTable(val)
+	case *lua.LFunction:
+		return t.copyFunction(val)
+	case *lua.LUserData:
+		return nil, fmt.Errorf("userdata cannot be shared between Lua states")
+	case *lua.LState:
+		return nil, fmt.Errorf("coroutines cannot be shared between Lua states")
+	}
+	return nil, fmt.Errorf("unsupported value type %s", v.Type())
+}
+
+func (t *transferer) copyTable(tbl *lua.LTable) (lua.LValue, error) {
+	if existing, ok := t.seen[tbl]; ok {
+		return existing, nil
+	}
+
+	if name, ok := t.modules[tbl]; ok {
+		if err := t.dst.L.CallByParam(lua.P{
+			Fn:      t.dst.L.GetGlobal("require"),
+			NRet:    1,
+			Protect: true,
+		}, lua.LString(name)); err != nil {
+			return nil, fmt.Errorf("failed to load module '%s': %w", name, err)
+		}
+		mod := t.dst.L.Get(-1)
+		t.dst.L.Pop(1)
+		t.seen[tbl] = mod
+		return mod, nil
+	}
+
+	result := t.dst.L.NewTable()
+	t.seen[tbl] = result
+
+	var copyErr error
+	tbl.ForEach(func(k, v lua.LValue) {
+		if copyErr != nil {
+			return
+		}
+		key, err := t.copy(k)
+		if err != nil {
+			copyErr = err
+			return
+		}
+		value, err := t.copy(v)
+		if err != nil {
+			copyErr = err
+			return
+		}
+		result.RawSet(key, value)
+	})
+	if copyErr != nil {
+		return nil, copyErr
+	}
+
+	if mt, ok := t.src.GetMetatable(tbl).(*lua.LTable); ok {
+		copied, err := t.copy(mt)
+		if err != nil {
+			return nil, err
+		}
+		t.dst.L.SetMetatable(result, copied)
+	}
+
+	return result, nil
+}
+
+func (t *transferer) copyFunction(fn *lua.LFunction) (lua.LValue, error) {
+	if existing, ok := t.seen[fn]; ok {
+		return existing, nil
+	}
+
+	result := &lua.LFunction{
+		IsG:       fn.IsG,
+		Env:       t.dst.L.G.Global,
+		Proto:     fn.Proto,
+		GFunction: fn.GFunction,
+		Upvalues:  make([]*lua.Upvalue, len(fn.Upvalues)),
+	}
+	t.seen[fn] = result
+	if fn.Proto != nil {
+		t.protos = append(t.protos, fn.Proto)
+	}
+
+	for i, uv := range fn.Upvalues {
+		value, err := t.copy(uv.Value())
+		if err != nil {
+			name := fmt.Sprintf("#%d", i+1)
+			if fn.Proto != nil && i < len(fn.Proto.DbgUpvalues) {
+				name = fn.Proto.DbgUpvalues[i]
+			}
+			return nil, fmt.Errorf("upvalue '%s': %w", name, err)
+		}
+		// A zero Upvalue is closed and holds its value directly
+		result.Upvalues[i] = &lua.Upvalue{}
+		result.Upvalues[i].SetValue(value)
+	}
+
+	return result, nil
+}
+
+// copyGlobals copies the user-defined globals the copied functions may
+// reference. Every string constant in a function is a potential global name;
+// names that exist in src but not in the destination's baseline are copied
+// across. Copying a global function can pull in more names, so this repeats
+// until no new functions were copied.
+func (t *transferer) copyGlobals() error {
+	done := make(map[string]bool)
+	for processed := 0; processed < len(t.protos); {
+		names := make(map[string]bool)
+		for _, proto := range t.protos[processed:] {
+			collectConstants(proto, names)
+		}
+		processed = len(t.protos)
+
+		for name := range names {
+			if done[name] || t.dst.baseline[name] {
+				continue
+			}
+			done[name] = true
+
+			value := t.src.G.Global.RawGetString(name)
+			if value == lua.LNil {
+				continue
+			}
+			copied, err := t.copy(value)
+			if err != nil {
+				return fmt.Errorf("global '%s': %w", name, err)
+			}
+			t.dst.L.G.Global.RawSetString(name, copied)
+		}
+	}
+	return nil
+}
+
+func collectConstants(proto *lua.FunctionProto, names map[string]bool) {
+	for _, c := range proto.Constants {
+		if s, ok := c.(lua.LString); ok {
+			names[string(s)] = true
+		}
+	}
+	for _, nested := range proto.FunctionPrototypes {
+		collectConstants(nested, names)
+	}
+}
@@ -0,0 +1,193 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+// subflowSpec is the workflow a subflow node runs
+type subflowSpec struct {
+	wf     *workflowHandle
+	ud     *lua.LUserData // Keeps the child's userdata alive
+	input  *lua.LFunction // Builds the child's input from the context (nil copies it)
+	output string         // Context key of the child's output (empty merges it)
+}
+
+// Usage: local err = workflow.subflow(parent, "node_name", child_wf)
+// Usage: local err = workflow.subflow(parent, "node_name", child_wf, {depends_on = {"node1"}, input = function(ctx) return {id = ctx.id} end, output = "child"})
+// A subflow node runs another workflow on the main state. The child starts
+// from a copy of the context, or from what input returns, so its nodes
+// cannot change the parent's context directly; its final context is merged
+// back, or stored under output. Options like depends_on, when and trigger
+// work as for workflow.node; timeouts and retries belong on the child's
+// nodes. workflow.get_nodes shows the child's nodes under the node.
+func luaSubflow(L *lua.LState) int {
+	if L.Get(1) == lua.LNil {
+		return util.PushError(L, "workflow is required")
+	}
+	parent := checkWorkflow(L, 1)
+	if parent == nil {
+		return util.PushError(L, "invalid workflow")
+	}
+
+	ud := L.CheckUserData(3)
+	child, ok := ud.Value.(*workflowHandle)
+	if !ok {
+		L.ArgError(3, "workflow expected")
+		return 0
+	}
+	if child == parent || child.embeds(parent) {
+		return util.PushError(L, "workflow '%s' cannot contain itself", parent.name)
+	}
+
+	sub := &subflowSpec{wf: child, ud: ud}
+	if opts := L.OptTable(4, nil); opts != nil {
+		if v := L.GetField(opts, "input"); v != lua.LNil {
+			fn, ok := v.(*lua.LFunction)
+			if !ok {
+				return util.PushError(L, "input must be a function")
+			}
+			sub.input = fn
+		}
+		if v := L.GetField(opts, "output"); v != lua.LNil {
+			sub.output = lua.LVAsString(v)
+		}
+	}
+
+	return addNode(L, &workflowNode{sub: sub}, 4)
+}
+
+// embeds reports whether other is a subflow of wf, directly or further down
+func (wf *workflowHandle) embeds(other *workflowHandle) bool {
+	wf.mu.Lock()
+	var children []*workflowHandle
+	for _, node := range wf.nodes {
+		if node.sub != nil {
+			children = append(children, node.sub.wf)
+		}
+	}
+	wf.mu.Unlock()
+
+	for _, child := range children {
+		if child == other || child.embeds(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// runSubflow runs the child workflow of a subflow node with a scoped copy
+// of the context and stores its output as the node's result
+func (wf *workflowHandle) runSubflow(L *lua.LState, node *workflowNode) error {
+	node.start()
+	sub := node.sub
+
+	input := L.NewTable()
+	if sub.input != nil {
+		L.Push(sub.input)
+		L.Push(wf.context)
+		if err := util.PCall(L, 1, 1); err != nil {
+			return wf.failNode(L, node, fmt.Errorf("node '%s' failed: input: %w", node.name, err))
+		}
+		ret := L.Get(-1)
+		L.Pop(1)
+		switch tbl := ret.(type) {
+		case *lua.LTable:
+			input = tbl
+		case *lua.LNilType:
+		default:
+			return wf.failNode(L, node, fmt.Errorf("node '%s' failed: input must return a table, got %s", node.name, ret.Type()))
+		}
+	} else {
+		wf.mu.Lock()
+		wf.context.ForEach(func(k, v lua.LValue) {
+			input.RawSet(k, v)
+		})
+		wf.mu.Unlock()
+	}
+
+	// The child checkpoints under the parent's run and the node's path, so
+	// resuming the parent resumes an interrupted child too, and the same
+	// child in two nodes keeps separate progress
+	wf.mu.Lock()
+	runID, key := wf.runID, wf.key+"/"+node.name
+	wf.mu.Unlock()
+	output, err := sub.wf.execute(L, input, runID, key, util.GetRunInfo(L).Resume, false)
+	if err != nil {
+		return wf.failNode(L, node, fmt.Errorf("node '%s' failed: subflow '%s': %w", node.name, sub.wf.name, err))
+	}
+
+	var result lua.LValue = output
+	if sub.output != "" {
+		tbl := L.NewTable()
+		tbl.RawSetString(sub.output, output)
+		result = tbl
+	}
+	return wf.completeNode(L, node, result)
+}
+
+// luaLoad runs a Lua file that builds a workflow and returns it, for use as
+// a reusable component (e.g. with workflow.subflow). Extra arguments are
+// passed to the file as ...; a relative path is resolved from the directory
+// of the calling script.
+// Usage: local wf, err = workflow.load("flows/notify.lua")
+// Usage: local wf, err = workflow.load("flows/deploy.lua", {env = "prod"})
+func luaLoad(L *lua.LState) int {
+	path := resolveScriptPath(L, L.CheckString(1))
+	if err := sandbox.Check(L, sandbox.FS, path); err != nil {
+		return util.PushError(L, "%v", err)
+	}
+
+	fn, err := L.LoadFile(path)
+	if err != nil {
+		return util.PushError(L, "failed to load workflow %s: %v", path, err)
+	}
+
+	nargs := L.GetTop() - 1
+	L.Push(fn)
+	for i := 2; i <= nargs+1; i++ {
+		L.Push(L.Get(i))
+	}
+	if err := util.PCall(L, nargs, 1); err != nil {
+		util.RecordError(L, err.Error(), err)
+		return util.PushError(L, "failed to load workflow %s: %v", path, err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	if ud, ok := ret.(*lua.LUserData); ok {
+		if _, ok := ud.Value.(*workflowHandle); ok {
+			return util.PushSuccess(L, ud)
+		}
+	}
+	return util.PushError(L, "%s must return a workflow, got %s", path, ret.Type())
+}
+
+// resolveScriptPath resolves a relative path from the directory of the Lua
+// file calling the current function, when that is a file
+func resolveScriptPath(L *lua.LState, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	dbg, ok := L.GetStack(1)
+	if !ok {
+		return path
+	}
+	if _, err := L.GetInfo("S", dbg, lua.LNil); err != nil {
+		return path
+	}
+	source := strings.TrimPrefix(dbg.Source, "@")
+	if source == "" || strings.HasPrefix(source, "<") {
+		return path
+	}
+	if _, err := os.Stat(source); err != nil {
+		return path
+	}
+	return filepath.Join(filepath.Dir(source), path)
+}
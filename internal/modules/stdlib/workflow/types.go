@@ -17,6 +17,22 @@ const (
 	NodeStatusSkipped   NodeStatus = "skipped"
 )
 
+// TriggerRule decides, from the outcome of its dependencies, whether a node
+// runs or is skipped
+type TriggerRule string
+
+const (
+	// TriggerAllSuccess runs a node once every dependency completed and
+	// skips it as soon as one is skipped
+	TriggerAllSuccess TriggerRule = "all_success"
+	// TriggerAllDone runs a node once every dependency completed, failed or
+	// was skipped. A failure it runs after does not fail the workflow.
+	TriggerAllDone TriggerRule = "all_done"
+	// TriggerOneSuccess runs a node as soon as one dependency completed and
+	// skips it when none of them did
+	TriggerOneSuccess TriggerRule = "one_success"
+)
+
 type WorkflowStatus string
 
 const (
@@ -27,25 +43,51 @@ const (
 	WorkflowStatusCancelled WorkflowStatus = "cancelled"
 )
 
+// defaultMaxParallel is the number of nodes a workflow runs concurrently
+// unless max_parallel is given
+const defaultMaxParallel = 8
+
 type workflowNode struct {
 	name         string
 	fn           *lua.LFunction
-	dependencies []string // Names of nodes that must complete before this one
-	outputs      []string // Names of nodes that depend on this one (computed from graph)
+	dependencies []string       // Names of nodes that must complete before this one
+	outputs      []string       // Names of nodes that depend on this one (computed from graph)
+	when         *lua.LFunction // Predicate deciding whether the node runs (nil always runs)
+	trigger      TriggerRule    // How the outcome of dependencies decides whether the node runs
+	branch       bool           // The result names the outputs to take (workflow.branch)
+	policy       nodePolicy     // Timeout and retries of each attempt
+	mapper       *mapSpec       // Fan-out of a map node (workflow.map_node)
+	sub          *subflowSpec   // Workflow run by a subflow node (workflow.subflow)
 	status       NodeStatus
-	result       lua.LValue // Result from execution
+	started      time.Time     // When the node last started running
+	duration     time.Duration // How long the node took in the last run
+	result       lua.LValue    // Result from execution
+	attempts     int           // Times the node was called in the last run
+	lastError    string        // Error of the last failed attempt
+	items        []mapItem     // Items of a map node in the last run
+	isolated     bool          // May run in a worker state (opt-in, except for map nodes)
 	mu           sync.Mutex
 }
 
 type workflowHandle struct {
 	name         string
 	nodes        map[string]*workflowNode // Graph: node name -> node
-	timeout      time.Duration
-	retries      int
+	timeout      time.Duration            // Default timeout of node attempts
+	retries      int                      // Default retries of failed nodes
+	maxParallel  int                      // Maximum number of nodes running at once (1 runs everything on the main state)
 	status       WorkflowStatus
 	errorHandler *lua.LFunction
 	context      *lua.LTable // Shared context (merged from all nodes)
 	output       lua.LValue  // Final output (not a pointer - lua.LValue is an interface)
 	mu           sync.Mutex
 	cancelled    bool
+
+	// Checkpointing (see checkpoint.go). store and progress are set while
+	// a run with a checkpoint store is executing; key names its checkpoints
+	// within the run.
+	checkpoint *checkpointConfig
+	runID      string
+	key        string
+	store      checkpointStore
+	progress   *checkpoint
 }
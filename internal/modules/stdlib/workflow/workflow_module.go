@@ -1,8 +1,6 @@
 package workflow
 
 import (
-	"time"
-
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
@@ -40,9 +38,13 @@ func workflowGC(L *lua.LState) int {
 
 var workflowMethods = map[string]lua.LGFunction{
 	"node":            luaWorkflowNode,
+	"branch":          luaBranch,
+	"map_node":        luaMapNode,
+	"subflow":         luaSubflow,
 	"edge":            luaWorkflowEdge,
 	"on_error":        luaWorkflowOnError,
 	"run":             luaWorkflowRun,
+	"resume":          luaWorkflowResume,
 	"status":          luaWorkflowStatus,
 	"cancel":          luaWorkflowCancel,
 	"get_nodes":       luaGetNodes,
@@ -50,32 +52,58 @@ var workflowMethods = map[string]lua.LGFunction{
 	"run_node":        luaRunNode,
 	"reset":           luaReset,
 	"get_node_status": luaGetNodeStatus,
-}
-
+	"export":          luaExport,
+}
+
+// Usage: local wf, err = workflow.new("name", {timeout = 5000, retries = 2, max_parallel = 4})
+// timeout (a duration like "30s" or milliseconds) and retries are the
+// defaults for the nodes added to the workflow afterwards.
+// Independent nodes marked isolated, and the items of map nodes, run
+// concurrently in their own Lua states, at most max_parallel (default 8) at a
+// time. max_parallel = 1 runs every node on the main state, one after another.
+//
+// checkpoint = {file = "dir"} or {sqlite = "path.db"} saves the result of
+// every completed node and the context, so an interrupted run can be picked
+// up with workflow.resume or vulgar --resume. Results must be plain data.
 func luaNew(L *lua.LState) int {
 	name := L.CheckString(1)
 	opts := L.OptTable(2, nil)
 
 	wf := &workflowHandle{
-		name:    name,
-		nodes:   make(map[string]*workflowNode), // Initialize nodes map
-		timeout: 0,                              // 0 means no timeout
-		retries: 0,
-		status:  WorkflowStatusPending, // Use new WorkflowStatus type
-		context: L.NewTable(),
+		name:        name,
+		nodes:       make(map[string]*workflowNode), // Initialize nodes map
+		timeout:     0,                              // 0 means no timeout
+		retries:     0,
+		maxParallel: defaultMaxParallel,
+		status:      WorkflowStatusPending, // Use new WorkflowStatus type
+		context:     L.NewTable(),
 	}
 
 	if opts != nil {
 		if v := L.GetField(opts, "timeout"); v != lua.LNil {
-			if n, ok := v.(lua.LNumber); ok {
-				wf.timeout = time.Duration(n) * time.Millisecond
+			d, err := parseDuration(v)
+			if err != nil {
+				return util.PushError(L, "timeout: %v", err)
 			}
+			wf.timeout = d
 		}
 		if v := L.GetField(opts, "retries"); v != lua.LNil {
 			if n, ok := v.(lua.LNumber); ok {
 				wf.retries = int(n)
 			}
 		}
+		if v := L.GetField(opts, "max_parallel"); v != lua.LNil {
+			if n, ok := v.(lua.LNumber); ok && n >= 1 {
+				wf.maxParallel = int(n)
+			}
+		}
+		if v := L.GetField(opts, "checkpoint"); v != lua.LNil {
+			cfg, err := parseCheckpointConfig(L, v)
+			if err != nil {
+				return util.PushError(L, "%v", err)
+			}
+			wf.checkpoint = cfg
+		}
 	}
 
 	ud := L.NewUserData()
@@ -128,12 +156,16 @@ func luaWorkflowStatus(L *lua.LState) int {
 	status := wf.status
 	nodeCount := len(wf.nodes)
 	context := wf.context
+	runID := wf.runID
 	wf.mu.Unlock()
 
 	tbl := L.NewTable()
 	tbl.RawSetString("status", lua.LString(status))
 	tbl.RawSetString("name", lua.LString(wf.name))
 	tbl.RawSetString("nodes", lua.LNumber(nodeCount))
+	if runID != "" {
+		tbl.RawSetString("run_id", lua.LString(runID))
+	}
 	if context != nil {
 		tbl.RawSetString("context", context)
 	}
@@ -154,12 +186,7 @@ func luaWorkflowCancel(L *lua.LState) int {
 		return 1
 	}
 
-	wf.mu.Lock()
-	wf.cancelled = true
-	if wf.status == WorkflowStatusPending {
-		wf.status = WorkflowStatusCancelled
-	}
-	wf.mu.Unlock()
+	wf.cancel()
 
 	L.Push(lua.LNil)
 	return 1
@@ -167,10 +194,15 @@ func luaWorkflowCancel(L *lua.LState) int {
 
 var exports = map[string]lua.LGFunction{
 	"new":             luaNew,
+	"load":            luaLoad,
 	"node":            luaNode,
+	"branch":          luaBranch,
+	"map_node":        luaMapNode,
+	"subflow":         luaSubflow,
 	"edge":            luaEdge,
 	"on_error":        luaOnError,
 	"run":             luaRun,
+	"resume":          luaResume,
 	"status":          luaStatus,
 	"cancel":          luaCancel,
 	"get_nodes":       luaGetNodes,
@@ -178,6 +210,7 @@ var exports = map[string]lua.LGFunction{
 	"run_node":        luaRunNode,
 	"reset":           luaReset,
 	"get_node_status": luaGetNodeStatus,
+	"export":          luaExport,
 }
 
 func luaOnError(L *lua.LState) int {
@@ -188,6 +221,10 @@ func luaRun(L *lua.LState) int {
 	return luaWorkflowRun(L)
 }
 
+func luaResume(L *lua.LState) int {
+	return luaWorkflowResume(L)
+}
+
 func luaStatus(L *lua.LState) int {
 	return luaWorkflowStatus(L)
 }
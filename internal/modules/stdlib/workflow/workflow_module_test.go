@@ -0,0 +1,697 @@
+package workflow
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/core/http"
+	"github.com/zepzeper/vulgar/internal/modules/stdlib/shell"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+func newTestState() *lua.LState {
+	L := lua.NewState()
+	L.PreloadModule(ModuleName, Loader)
+	L.SetGlobal("sleep", L.NewFunction(func(L *lua.LState) int {
+		time.Sleep(time.Duration(L.CheckInt(1)) * time.Millisecond)
+		return 0
+	}))
+	return L
+}
+
+func TestRunPassesContextBetweenNodes(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("pipeline")
+
+		local function double(n) return n * 2 end
+
+		workflow.node(wf, "fetch", function(ctx)
+			return { value = ctx.input + 1 }
+		end)
+		workflow.node(wf, "transform", function(ctx)
+			return { doubled = double(ctx.value) }
+		end, { depends_on = { "fetch" } })
+
+		local result, err = workflow.run(wf, { input = 4 })
+		assert(err == nil, "run should not error: " .. tostring(err))
+		assert(result.value == 5, "expected value 5, got " .. tostring(result.value))
+		assert(result.doubled == 10, "expected doubled 10, got " .. tostring(result.doubled))
+		assert(workflow.status(wf).status == "completed")
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestRunExecutesIndependentNodesConcurrently(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	start := time.Now()
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("fanout")
+
+		for i = 1, 4 do
+			workflow.node(wf, "call_" .. i, function(ctx)
+				sleep(200)
+				return { ["result_" .. i] = i }
+			end, { isolated = true })
+		end
+
+		local result, err = workflow.run(wf)
+		assert(err == nil, "run should not error: " .. tostring(err))
+		for i = 1, 4 do
+			assert(result["result_" .. i] == i, "missing result " .. i)
+		end
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("expected nodes to overlap, took %v", elapsed)
+	}
+}
+
+func TestRunRespectsMaxParallel(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	start := time.Now()
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("limited", { max_parallel = 2 })
+
+		for i = 1, 4 do
+			workflow.node(wf, "call_" .. i, function(ctx)
+				sleep(150)
+				return {}
+			end, { isolated = true })
+		end
+
+		local _, err = workflow.run(wf)
+		assert(err == nil, "run should not error: " .. tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expected two batches of two nodes, took %v", elapsed)
+	}
+}
+
+func TestRunMainStateNodes(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+
+		-- Nodes run on the main state unless isolated, so upvalues and
+		-- globals are shared
+		local shared = workflow.new("shared")
+		local count, seen = 0, {}
+		workflow.node(shared, "a", function() count = count + 1; seen.a = true end)
+		workflow.node(shared, "b", function() count = count + 1; total = count end)
+		local _, err = workflow.run(shared)
+		assert(err == nil, tostring(err))
+		assert(count == 2, "expected shared counter 2, got " .. count)
+		assert(seen.a == true, "expected the upvalue table to be updated")
+		assert(total == 2, "expected the global to be set")
+
+		-- max_parallel = 1 keeps isolated nodes on the main state too
+		local sequential = workflow.new("sequential", { max_parallel = 1 })
+		count = 0
+		workflow.node(sequential, "a", function() count = count + 1 end, { isolated = true })
+		workflow.node(sequential, "b", function() count = count + 1 end, { isolated = true })
+		_, err = workflow.run(sequential)
+		assert(err == nil, tostring(err))
+		assert(count == 2, "expected shared counter 2, got " .. count)
+
+		-- Isolated nodes capturing userdata fall back to the main state
+		local fallback = workflow.new("fallback")
+		workflow.node(fallback, "a", function()
+			return { name = workflow.status(fallback).name }
+		end, { isolated = true })
+		local result
+		result, err = workflow.run(fallback)
+		assert(err == nil, tostring(err))
+		assert(result.name == "fallback", "expected name from userdata")
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestRunStopsOnNodeFailure(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("failing")
+
+		workflow.node(wf, "bad", function() error("boom") end)
+		workflow.node(wf, "after", function() return { ran = true } end, { depends_on = { "bad" } })
+
+		local result, err = workflow.run(wf)
+		assert(result == nil, "result should be nil on failure")
+		assert(err ~= nil and err:find("node 'bad' failed"), "unexpected error: " .. tostring(err))
+		assert(workflow.get_node_status(wf, "bad").status == "failed")
+		assert(workflow.get_node_status(wf, "after").status == "pending")
+		assert(workflow.status(wf).status == "failed")
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	for _, store := range []string{"file", "sqlite"} {
+		t.Run(store, func(t *testing.T) {
+			L := newTestState()
+			defer L.Close()
+			path := filepath.Join(t.TempDir(), "checkpoints")
+			if store == "sqlite" {
+				path += ".db"
+			}
+			L.SetGlobal("store", lua.LString(store))
+			L.SetGlobal("path", lua.LString(path))
+
+			err := L.DoString(`
+				local workflow = require("stdlib.workflow")
+				calls = { extract = 0, load = 0 }
+				function build(fail)
+					local wf = workflow.new("migrate", { max_parallel = 1, checkpoint = { [store] = path } })
+					workflow.node(wf, "extract", function(ctx)
+						calls.extract = calls.extract + 1
+						return { rows = ctx.batch * 3 }
+					end)
+					workflow.node(wf, "load", function(ctx)
+						calls.load = calls.load + 1
+						if fail then error("database down") end
+						return { loaded = ctx.rows }
+					end, { depends_on = { "extract" } })
+					return wf
+				end
+
+				local wf = build(true)
+				local _, err = workflow.run(wf, { batch = 1 })
+				assert(err and err:find("database down"), tostring(err))
+				run_id = workflow.status(wf).run_id
+
+				local result, err = workflow.resume(build(false), run_id)
+				assert(err == nil, tostring(err))
+				assert(calls.extract == 1 and calls.load == 2, "extract ran again")
+				assert(result.batch == 1 and result.loaded == 3, "context not restored")
+
+				local _, err = workflow.resume(build(false), "unknown")
+				assert(err and err:find("no checkpoint"), tostring(err))
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A run the engine resumes (vulgar --resume) picks up where the
+			// checkpoint left off without calling workflow.resume
+			util.SetRunInfo(L, &util.RunInfo{ID: L.GetGlobal("run_id").String(), Resume: true})
+			err = L.DoString(`
+				local workflow = require("stdlib.workflow")
+				local result, err = workflow.run(build(false), { batch = 1 })
+				assert(err == nil, tostring(err))
+				assert(calls.extract == 1 and calls.load == 2, "completed nodes ran again")
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSubflowCheckpointsPerNode(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+	L.SetGlobal("path", lua.LString(filepath.Join(t.TempDir(), "checkpoints")))
+
+	// The same child runs in two nodes; the first fails after sending, so
+	// resuming must finish it without mistaking its progress for the
+	// second node's
+	util.SetRunInfo(L, &util.RunInfo{ID: "run-1"})
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		sent, fail = {}, true
+		function build()
+			local notify = workflow.new("notify", { max_parallel = 1, checkpoint = { file = path } })
+			workflow.node(notify, "send", function(ctx)
+				sent[#sent + 1] = ctx.to
+				return { sent = ctx.to }
+			end)
+			workflow.node(notify, "confirm", function(ctx)
+				if fail then error("no receipt") end
+				return { confirmed = ctx.sent }
+			end, { depends_on = { "send" } })
+
+			local wf = workflow.new("alerts", { max_parallel = 1, checkpoint = { file = path } })
+			workflow.subflow(wf, "email", notify, { input = function() return { to = "email" } end, output = "email" })
+			workflow.subflow(wf, "sms", notify, { depends_on = { "email" }, input = function() return { to = "sms" } end, output = "sms" })
+			return wf
+		end
+
+		local _, err = workflow.run(build())
+		assert(err and err:find("no receipt"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.SetRunInfo(L, &util.RunInfo{ID: "run-1", Resume: true})
+	err = L.DoString(`
+		local workflow = require("stdlib.workflow")
+		fail = false
+		local result, err = workflow.run(build())
+		assert(err == nil, tostring(err))
+		assert(table.concat(sent, ",") == "email,sms", "sent to " .. table.concat(sent, ","))
+		assert(result.email.confirmed == "email", "email confirmed " .. tostring(result.email.confirmed))
+		assert(result.sms.confirmed == "sms", "sms confirmed " .. tostring(result.sms.confirmed))
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBranchSkipsNodesNotTaken(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("approval")
+
+		workflow.node(wf, "review", function(ctx)
+			return { approved = ctx.score > 5 }
+		end)
+		workflow.branch(wf, "decide", function(ctx)
+			if ctx.approved then return "publish" end
+			return "reject"
+		end, { depends_on = { "review" } })
+		workflow.node(wf, "publish", function(ctx) return { published = true } end, { depends_on = { "decide" } })
+		workflow.node(wf, "announce", function(ctx) return { announced = true } end, { depends_on = { "publish" } })
+		workflow.node(wf, "reject", function(ctx) return { rejected = true } end, { depends_on = { "decide" } })
+		workflow.node(wf, "notify", function(ctx) return { notified = true } end, {
+			depends_on = { "announce", "reject" },
+			trigger = "one_success",
+		})
+		workflow.node(wf, "archive", function(ctx) return { archived = true } end, {
+			depends_on = { "announce", "reject" },
+		})
+
+		local result, err = workflow.run(wf, { score = 2 })
+		assert(err == nil, "run should not error: " .. tostring(err))
+		assert(result.rejected and result.notified, "rejected path did not run")
+		assert(not result.published and not result.announced, "publish path ran")
+		assert(result[1] == nil, "branch result merged into context")
+		assert(workflow.get_node_status(wf, "publish").status == "skipped")
+		assert(workflow.get_node_status(wf, "announce").status == "skipped", "skip did not cascade")
+		assert(workflow.get_node_status(wf, "archive").status == "skipped", "all_success join ran")
+		assert(workflow.status(wf).status == "completed")
+
+		local wf2 = workflow.new("bad_branch")
+		workflow.branch(wf2, "decide", function(ctx) return "nowhere" end)
+		local _, err = workflow.run(wf2)
+		assert(err and err:find("does not depend on it"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestWhenSkipsNode(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("conditional")
+
+		workflow.node(wf, "load", function(ctx) return { rows = 0 } end)
+		workflow.node(wf, "transform", function(ctx) return { transformed = true } end, {
+			depends_on = { "load" },
+			when = function(ctx) return ctx.rows > 0 end,
+		})
+		workflow.node(wf, "report", function(ctx) return { reported = true } end, {
+			depends_on = { "transform" },
+			trigger = "all_done",
+		})
+
+		local result, err = workflow.run(wf)
+		assert(err == nil, "run should not error: " .. tostring(err))
+		assert(not result.transformed, "transform ran")
+		assert(result.reported, "all_done join did not run")
+		assert(workflow.get_node_status(wf, "transform").status == "skipped")
+
+		local _, err = workflow.node(wf, "bad", function() end, { trigger = "sometimes" })
+		assert(err and err:find("unknown trigger"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestAllDoneRunsAfterFailure(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+
+		for _, isolated in ipairs({ false, true }) do
+			local wf = workflow.new("cleanup", { max_parallel = 2 })
+			workflow.node(wf, "deploy", function(ctx)
+				error("deploy failed")
+			end, { isolated = isolated })
+			workflow.node(wf, "verify", function(ctx) return { verified = true } end, {
+				depends_on = { "deploy" },
+			})
+			workflow.node(wf, "cleanup", function(ctx) return { cleaned = true } end, {
+				depends_on = { "deploy" },
+				trigger = "all_done",
+			})
+
+			local result, err = workflow.run(wf)
+			assert(err == nil, "handled failure failed the run: " .. tostring(err))
+			assert(result.cleaned, "all_done node did not run after the failure")
+			assert(not result.verified, "node needing the failed one ran")
+			assert(workflow.get_node_status(wf, "deploy").status == "failed")
+			assert(workflow.get_node_status(wf, "verify").status == "skipped")
+			assert(workflow.status(wf).status == "completed")
+		end
+
+		-- Without an all_done node the failure still fails the run
+		local wf2 = workflow.new("unhandled")
+		workflow.node(wf2, "deploy", function(ctx) error("deploy failed") end)
+		workflow.node(wf2, "verify", function(ctx) return {} end, { depends_on = { "deploy" } })
+		local _, err = workflow.run(wf2)
+		assert(err and err:find("deploy failed"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestNodeRetriesAndTimeout(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+		local wf = workflow.new("flaky", { max_parallel = 1 })
+
+		local calls = 0
+		workflow.node(wf, "api", function(ctx)
+			calls = calls + 1
+			if calls < 3 then error("503 unavailable") end
+			return { fetched = true }
+		end, { retries = 3, delay = 1, backoff = "exponential" })
+
+		local result, err = workflow.run(wf)
+		assert(err == nil, "run should not error: " .. tostring(err))
+		assert(result.fetched)
+		local status = workflow.get_node_status(wf, "api")
+		assert(status.attempts == 3, "expected 3 attempts, got " .. tostring(status.attempts))
+		assert(status.error:find("503"), tostring(status.error))
+
+		-- retry_on is evaluated in the worker state running the node
+		local wf2 = workflow.new("fatal")
+		workflow.node(wf2, "api", function(ctx)
+			error("401 unauthorized")
+		end, { retries = 5, delay = 1, retry_on = function(err) return err:find("503") ~= nil end })
+		local _, err = workflow.run(wf2)
+		assert(err and err:find("401"), tostring(err))
+		assert(workflow.get_node_status(wf2, "api").attempts == 1, "retried an error retry_on rejected")
+
+		local wf3 = workflow.new("slow", { timeout = "50ms", retries = 1 })
+		workflow.node(wf3, "spin", function(ctx)
+			while true do end
+		end, { delay = 1 })
+		local _, err = workflow.run(wf3)
+		assert(err and err:find("failed after 2 attempts: timed out after 50ms"), tostring(err))
+
+		local _, err = workflow.node(wf3, "bad", function() end, { backoff = "random" })
+		assert(err and err:find("unknown backoff"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestTimeoutCutsOffBlockingCalls(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	for name, body := range map[string]string{
+		"shell": `require("stdlib.shell").exec("sleep 3")`,
+		"http":  `require("http").get(url)`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			L := newTestState()
+			defer L.Close()
+			L.PreloadModule(shell.ModuleName, shell.Loader)
+			L.PreloadModule(http.ModuleName, http.Loader)
+			L.SetGlobal("url", lua.LString(server.URL))
+
+			start := time.Now()
+			err := L.DoString(`
+				local workflow = require("stdlib.workflow")
+				local wf = workflow.new("blocking", { timeout = "300ms" })
+				workflow.node(wf, "call", function() ` + body + ` end)
+				local _, err = workflow.run(wf)
+				assert(err and err:find("timed out after 300ms"), tostring(err))
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("the call was not cut off, took %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestMapNodeFansOutOverItems(t *testing.T) {
+	for _, maxParallel := range []int{1, 4} {
+		L := newTestState()
+		L.SetGlobal("max_parallel", lua.LNumber(maxParallel))
+
+		err := L.DoString(`
+			local workflow = require("stdlib.workflow")
+			local wf = workflow.new("reports", { max_parallel = max_parallel })
+
+			workflow.node(wf, "list", function(ctx)
+				return { repos = { "api", "web", "cli", "docs" } }
+			end)
+			workflow.map_node(wf, "report", function(ctx) return ctx.repos end, function(repo, ctx)
+				sleep(20)
+				return { repo = repo, prefix = ctx.prefix }
+			end, { depends_on = { "list" }, max_parallel = 2, output = "reports" })
+			workflow.node(wf, "summary", function(ctx)
+				return { count = #ctx.reports }
+			end, { depends_on = { "report" } })
+
+			local result, err = workflow.run(wf, { prefix = "nightly" })
+			assert(err == nil, "run should not error: " .. tostring(err))
+			assert(result.count == 4, "expected 4 reports, got " .. tostring(result.count))
+			assert(result.reports[1].repo == "api" and result.reports[4].repo == "docs", "results out of order")
+			assert(result.reports[2].prefix == "nightly", "context not passed to items")
+
+			for _, node in ipairs(workflow.get_nodes(wf)) do
+				if node.name == "report" then
+					assert(node.map and #node.items == 4)
+					for _, item in ipairs(node.items) do
+						assert(item.status == "completed" and item.attempts == 1)
+					end
+				end
+			end
+
+			local wf2 = workflow.new("failing", { max_parallel = max_parallel })
+			workflow.map_node(wf2, "process", function(ctx) return { 1, 2, 3 } end, function(n, ctx)
+				if n == 2 then error("bad row") end
+				return n
+			end)
+			local _, err = workflow.run(wf2)
+			assert(err and err:find("failed on item 2") and err:find("bad row"), tostring(err))
+		`)
+		L.Close()
+		if err != nil {
+			t.Fatalf("max_parallel %d: %v", maxParallel, err)
+		}
+	}
+}
+
+func TestSubflowRunsChildWorkflow(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+
+		local child = workflow.new("notify")
+		workflow.node(child, "format", function(ctx)
+			return { message = "deployed " .. ctx.app }
+		end)
+		workflow.node(child, "send", function(ctx)
+			return { sent = ctx.message }
+		end, { depends_on = { "format" } })
+
+		local parent = workflow.new("deploy")
+		workflow.node(parent, "build", function(ctx) return { app = "api", secret = "s3cr3t" } end)
+		workflow.subflow(parent, "notify", child, {
+			depends_on = { "build" },
+			input = function(ctx) return { app = ctx.app } end,
+			output = "notification",
+		})
+
+		local result, err = workflow.run(parent)
+		assert(err == nil, "run should not error: " .. tostring(err))
+		assert(result.notification.sent == "deployed api", "child output not stored")
+		assert(result.notification.secret == nil, "child saw more than its input")
+		assert(result.message == nil, "child context leaked into the parent")
+
+		local found = false
+		for _, node in ipairs(workflow.get_nodes(parent)) do
+			if node.name == "notify" then
+				found = node.subflow == "notify" and #node.nodes == 2
+				for _, child_node in ipairs(node.nodes) do
+					assert(child_node.status == "completed", child_node.name .. " is " .. child_node.status)
+				end
+			end
+		end
+		assert(found, "subflow nodes not listed")
+
+		local _, err = workflow.subflow(child, "loop", parent)
+		assert(err and err:find("cannot contain itself"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestLoadWorkflowFromFile(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	dir := t.TempDir()
+	component := `
+		local workflow = require("stdlib.workflow")
+		local opts = ...
+		local wf = workflow.new("greet")
+		workflow.node(wf, "greet", function(ctx)
+			return { greeting = opts.greeting .. ", " .. ctx.name }
+		end)
+		return wf
+	`
+	if err := os.WriteFile(filepath.Join(dir, "greet.lua"), []byte(component), 0644); err != nil {
+		t.Fatal(err)
+	}
+	main := `
+		local workflow = require("stdlib.workflow")
+		local greet, err = workflow.load("greet.lua", { greeting = "hello" })
+		assert(err == nil, tostring(err))
+
+		local wf = workflow.new("main")
+		workflow.subflow(wf, "greet", greet)
+		local result, err = workflow.run(wf, { name = "world" })
+		assert(err == nil, tostring(err))
+		assert(result.greeting == "hello, world", tostring(result.greeting))
+
+		local _, err = workflow.load("missing.lua")
+		assert(err and err:find("failed to load workflow"), tostring(err))
+	`
+	if err := os.WriteFile(filepath.Join(dir, "main.lua"), []byte(main), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := L.DoFile(filepath.Join(dir, "main.lua")); err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+}
+
+func TestExportGraph(t *testing.T) {
+	L := newTestState()
+	defer L.Close()
+
+	err := L.DoString(`
+		local workflow = require("stdlib.workflow")
+
+		local child = workflow.new("notify")
+		workflow.node(child, "send", function(ctx) return { sent = true } end)
+
+		local wf = workflow.new("release")
+		workflow.branch(wf, "check", function(ctx) return "build" end)
+		workflow.node(wf, "build", function(ctx) return { built = true } end, { depends_on = { "check" } })
+		workflow.node(wf, "rollback", function(ctx) return {} end, { depends_on = { "check" } })
+		workflow.map_node(wf, "test", function(ctx) return { 1, 2 } end, function(n) return n end, { depends_on = { "build" } })
+		workflow.subflow(wf, "notify", child, { depends_on = { "test", "rollback" }, trigger = "one_success" })
+
+		mermaid = workflow.export(wf, "mermaid")
+		local _, err = workflow.run(wf)
+		assert(err == nil, tostring(err))
+		dot = workflow.export(wf, "dot", { status = true })
+		json = workflow.export(wf, "json", { status = true })
+		recorded = workflow.export(wf, "mermaid", { runs = {
+			{ workflow = "release", name = "check", status = "failed", duration_ns = 1500000000 },
+		} })
+
+		local _, err = workflow.export(wf, "svg")
+		assert(err and err:find("unknown format"), tostring(err))
+	`)
+	if err != nil {
+		t.Fatalf("test failed: %v", err)
+	}
+
+	contains := func(name string, want ...string) {
+		t.Helper()
+		text := L.GetGlobal(name).String()
+		for _, s := range want {
+			if !strings.Contains(text, s) {
+				t.Errorf("%s export is missing %q:\n%s", name, s, text)
+			}
+		}
+	}
+	contains("mermaid",
+		"flowchart LR",
+		`n_check{"check"}`,
+		`n_test[["test"]]`,
+		`subgraph n_notify ["notify<br/>(one_success)"]`,
+		`n_notify__send["send"]`,
+		"n_check --> n_build",
+		"n_test --> n_notify",
+	)
+	contains("dot",
+		`digraph "release" {`,
+		`n_check [label="check\ncompleted", style="rounded,filled"`,
+		`n_rollback [label="rollback\nskipped"`,
+		`label="test\ncompleted, 2/2 items`,
+		`subgraph "cluster_n_notify" {`,
+		`n_test -> n_notify__send [lhead="cluster_n_notify"];`,
+	)
+	contains("json", `"kind": "subflow"`, `"status": "completed"`, `"items_done": 2`)
+	contains("recorded", `n_check{"check<br/>failed, 1.5s"}`, `n_build["build<br/>pending"]`, "class n_check failed")
+}
@@ -7,6 +7,7 @@ import (
 	lua "github.com/yuin/gopher-lua"
 	"github.com/zepzeper/vulgar/internal/modules"
 	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
 )
 
 const ModuleName = "stdlib.yaml"
@@ -47,6 +48,9 @@ func luaEncode(L *lua.LState) int {
 // Usage: local data, err = yaml.decode_file(path)
 func luaDecodeFile(L *lua.LState) int {
 	filePath := L.CheckString(1)
+	if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	// Read file
 	content, err := os.ReadFile(filePath)
@@ -70,6 +74,9 @@ func luaDecodeFile(L *lua.LState) int {
 func luaEncodeFile(L *lua.LState) int {
 	filePath := L.CheckString(1)
 	dataTable := L.CheckTable(2)
+	if err := sandbox.Check(L, sandbox.FS, filePath); err != nil {
+		return util.PushError(L, "%v", err)
+	}
 
 	// Convert Lua table to Go value
 	dataGo := util.LuaToGo(dataTable)
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// CancellationRegistryKey is the registry key of a state's Cancellation
+const CancellationRegistryKey = "vulgar_cancellation"
+
+// Context returns the context of L for blocking Go calls, so they are cut
+// off when the run is stopped or the workflow node attempt making them times
+// out. States without one get context.Background().
+func Context(L *lua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
+}
+
+// Cancellation lets a run be cancelled gracefully. Operations that can stop
+// early, such as stdlib.workflow graphs, register with OnCancel while they
+// run. It is shared by the main state and its worker states.
+type Cancellation struct {
+	mu        sync.Mutex
+	cancelled bool
+	next      int
+	active    map[int]func()
+	idle      chan struct{}
+	idleDone  bool // idle was closed
+}
+
+// NewCancellation creates a Cancellation nothing is registered with
+func NewCancellation() *Cancellation {
+	return &Cancellation{active: make(map[int]func())}
+}
+
+// Cancel calls every registered function, and functions registered later
+// as soon as they are. The returned channel is closed once no registered
+// operation is running any more.
+func (c *Cancellation) Cancel() <-chan struct{} {
+	c.mu.Lock()
+	c.cancelled = true
+	fns := make([]func(), 0, len(c.active))
+	for _, fn := range c.active {
+		fns = append(fns, fn)
+	}
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+	c.closeIdle()
+	idle := c.idle
+	c.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+	return idle
+}
+
+func (c *Cancellation) add(fn func()) func() {
+	c.mu.Lock()
+	id := c.next
+	c.next++
+	c.active[id] = fn
+	cancelled := c.cancelled
+	c.mu.Unlock()
+
+	if cancelled {
+		fn()
+	}
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.active[id]; !ok {
+			return
+		}
+		delete(c.active, id)
+		c.closeIdle()
+	}
+}
+
+// closeIdle closes the idle channel once nothing runs after Cancel.
+// Callers hold c.mu.
+func (c *Cancellation) closeIdle() {
+	if c.idle != nil && !c.idleDone && len(c.active) == 0 {
+		c.idleDone = true
+		close(c.idle)
+	}
+}
+
+// SetCancellation stores the Cancellation operations on L register with
+func SetCancellation(L *lua.LState, c *Cancellation) {
+	ud := L.NewUserData()
+	ud.Value = c
+	L.SetField(L.Get(lua.RegistryIndex), CancellationRegistryKey, ud)
+}
+
+// OnCancel registers fn to be called when the run L belongs to is
+// cancelled, at once if it already was. Call the returned function when the
+// operation finishes.
+func OnCancel(L *lua.LState, fn func()) (done func()) {
+	if ud, ok := L.GetField(L.Get(lua.RegistryIndex), CancellationRegistryKey).(*lua.LUserData); ok {
+		if c, ok := ud.Value.(*Cancellation); ok {
+			return c.add(fn)
+		}
+	}
+	return func() {}
+}
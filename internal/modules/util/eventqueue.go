@@ -1,6 +1,7 @@
 package util
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -50,27 +51,61 @@ type EventData struct {
 //		// From main Lua thread:
 //		queue.WaitForEvents() // Block until events arrive or timeout
 //
+//		// Let the engine stop the source on shutdown:
+//		queue.RegisterStopper(handle, handle.stop)
+//		queue.UnregisterStopper(handle) // once the source stopped on its own
+//
+// Sources that can produce many events (timers, cron jobs, watchers) should
+// queue through an EventSource, which applies an OverflowPolicy when the
+// queue is full and is counted in Stats:
+//
+//	src := queue.NewSource("filewatch ./inbox", util.OverflowBlock)
+//	src.QueueTask(func(L *lua.LState) { ... })
+//	src.Close() // when the watcher stops
+//
 // Thread Safety:
-//   - Queue(), AddSource(), RemoveSource() can be called from any goroutine
+//   - Queue(), QueueTask(), AddSource(), RemoveSource() can be called from any goroutine
+//   - NewSource() and the EventSource methods can be called from any goroutine
+//   - QueueTaskWait() can be called from any goroutine except the main Lua thread
+//   - RegisterStopper(), UnregisterStopper(), StopSources() can be called from any goroutine
 //   - WaitForEvents(), Process() MUST be called from the main Lua thread only
 //   - Close() can be called from any goroutine
 type EventQueue struct {
-	events        chan EventData
 	L             *lua.LState
 	done          chan struct{}
 	activeSources int32
+	stoppers      map[interface{}]func()
+	stoppersMu    sync.Mutex
+	onError       func(error)
+
+	mu       sync.Mutex
+	pending  []*queuedEvent
+	capacity int
+	notify   chan struct{} // signalled when an event is added
+	space    chan struct{} // closed and replaced when an event is removed
+	stats    queueCounters
+	sources  map[sourceKey]*sourceCounters
+	overflow func(OverflowWarning)
 }
 
-// NewEventQueue creates a new event queue for the given Lua state
-// bufferSize controls how many events can be queued before dropping (default: 100)
+// DefaultQueueSize is the number of events a queue holds before its
+// overflow policies apply
+const DefaultQueueSize = 100
+
+// NewEventQueue creates a new event queue for the given Lua state.
+// bufferSize controls how many events can be pending before the overflow
+// policy of the source applies (default: DefaultQueueSize).
 func NewEventQueue(L *lua.LState, bufferSize int) *EventQueue {
 	if bufferSize <= 0 {
-		bufferSize = 100 // Default buffer size
+		bufferSize = DefaultQueueSize
 	}
 	return &EventQueue{
-		events: make(chan EventData, bufferSize),
-		L:      L,
-		done:   make(chan struct{}),
+		L:        L,
+		done:     make(chan struct{}),
+		capacity: bufferSize,
+		notify:   make(chan struct{}, 1),
+		space:    make(chan struct{}),
+		sources:  make(map[sourceKey]*sourceCounters),
 	}
 }
 
@@ -89,42 +124,102 @@ func (q *EventQueue) HasActiveSources() bool {
 	return atomic.LoadInt32(&q.activeSources) > 0
 }
 
+// RegisterStopper registers a function that stops an async source (timer,
+// cron job, watcher) when the engine shuts down. key identifies the source,
+// usually its handle; registering the same key again replaces the function.
+func (q *EventQueue) RegisterStopper(key interface{}, stop func()) {
+	q.stoppersMu.Lock()
+	defer q.stoppersMu.Unlock()
+	if q.stoppers == nil {
+		q.stoppers = make(map[interface{}]func())
+	}
+	q.stoppers[key] = stop
+}
+
+// UnregisterStopper removes a stopper, typically because the source stopped
+func (q *EventQueue) UnregisterStopper(key interface{}) {
+	q.stoppersMu.Lock()
+	delete(q.stoppers, key)
+	q.stoppersMu.Unlock()
+}
+
+// StopSources calls every registered stopper. Stoppers run without the lock
+// held, so they may unregister themselves.
+func (q *EventQueue) StopSources() {
+	q.stoppersMu.Lock()
+	stoppers := make([]func(), 0, len(q.stoppers))
+	for _, stop := range q.stoppers {
+		stoppers = append(stoppers, stop)
+	}
+	q.stoppersMu.Unlock()
+
+	for _, stop := range stoppers {
+		stop()
+	}
+}
+
+// SetErrorHandler sets the function that receives errors raised by callbacks
+// run from the queue. Without one, callback errors are dropped.
+func (q *EventQueue) SetErrorHandler(fn func(error)) {
+	q.onError = fn
+}
+
+// ReportError hands an error raised by a callback to the error handler.
+// Must be called from the main Lua thread.
+func (q *EventQueue) ReportError(err error) {
+	if q.onError != nil {
+		q.onError(err)
+	}
+}
+
+// ReportCallbackError reports an error raised by a Lua callback a module ran
+// on behalf of the event loop, such as an event listener. The script keeps
+// running; the engine shows the error with its traceback.
+func ReportCallbackError(L *lua.LState, err error) {
+	if q := GetEventQueue(L); q != nil && q.L == L {
+		q.ReportError(err)
+	}
+}
+
 // Queue safely adds an event to the queue (can be called from any goroutine)
 // If the queue is full, the event is dropped (non-blocking)
 // If the queue is closed, the event is ignored
 func (q *EventQueue) Queue(callback *lua.LFunction, data lua.LValue) {
-	q.send(EventData{Callback: callback, Data: data})
+	q.push(nil, EventData{Callback: callback, Data: data})
 }
 
 // QueueTask safely adds a generic task to be executed on the main thread
 func (q *EventQueue) QueueTask(task func(*lua.LState)) {
-	q.send(EventData{Task: task})
+	q.push(nil, EventData{Task: task})
 }
 
-func (q *EventQueue) send(event EventData) {
-	select {
-	case q.events <- event:
-		// Successfully queued
-	case <-q.done:
-		// Queue closed, ignore event
-	default:
-		// Channel full - drop event (non-blocking)
-		// In production, you might want to log this
-	}
+// QueueTaskWait is like QueueTask but waits for room instead of dropping the
+// task when the queue is full. Use it for results that must not be lost, such
+// as the completion of a future. It must not be called from the main Lua
+// thread, which is the one draining the queue.
+func (q *EventQueue) QueueTaskWait(task func(*lua.LState)) {
+	q.pushWait(nil, EventData{Task: task})
 }
 
 // WaitForEvents blocks until an event is available or the queue is closed.
 // It returns true if an event was processed, false if the queue is closed or no sources remain.
 func (q *EventQueue) WaitForEvents() bool {
 	// If no active sources and no pending events, we're done
-	if !q.HasActiveSources() && len(q.events) == 0 {
+	if !q.HasActiveSources() && q.Pending() == 0 {
 		return false
 	}
 
-	select {
-	case event := <-q.events:
+	if event, ok := q.pop(); ok {
 		q.processEvent(event)
 		return true
+	}
+
+	select {
+	case <-q.notify:
+		if event, ok := q.pop(); ok {
+			q.processEvent(event)
+		}
+		return true
 	case <-q.done:
 		return false
 	// Add a small timeout to allow checking for active sources periodically
@@ -144,16 +239,14 @@ func (q *EventQueue) processEvent(event EventData) {
 	}
 
 	q.L.Push(event.Callback)
+	nargs := 0
 	if event.Data != nil {
 		q.L.Push(event.Data)
-		if err := q.L.PCall(1, 0, nil); err != nil {
-			// Error in callback - log but don't crash
-			_ = err
-		}
-	} else {
-		if err := q.L.PCall(0, 0, nil); err != nil {
-			_ = err
-		}
+		nargs = 1
+	}
+	// An error in a callback is reported but does not stop the loop
+	if err := PCall(q.L, nargs, 0); err != nil {
+		q.ReportError(err)
 	}
 }
 
@@ -164,16 +257,19 @@ func (q *EventQueue) Process() int {
 	count := 0
 	for {
 		select {
-		case event := <-q.events:
-			q.processEvent(event)
-			count++
 		case <-q.done:
 			// Queue closed, stop processing
 			return count
 		default:
+		}
+
+		event, ok := q.pop()
+		if !ok {
 			// No more events available, return immediately (non-blocking)
 			return count
 		}
+		q.processEvent(event)
+		count++
 	}
 }
 
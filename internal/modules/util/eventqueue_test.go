@@ -0,0 +1,165 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// queueRecorder queues tasks that record their label when processed
+type queueRecorder struct {
+	queue *EventQueue
+	seen  []string
+}
+
+func newQueueRecorder(t *testing.T, size int) *queueRecorder {
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	return &queueRecorder{queue: NewEventQueue(L, size)}
+}
+
+func (r *queueRecorder) task(label string) func(*lua.LState) {
+	return func(*lua.LState) { r.seen = append(r.seen, label) }
+}
+
+func (r *queueRecorder) drain() []string {
+	r.seen = nil
+	r.queue.Process()
+	return r.seen
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	r := newQueueRecorder(t, 2)
+	src := r.queue.NewSource("watch", OverflowDropNewest)
+	for _, label := range []string{"a", "b", "c"} {
+		src.QueueTask(r.task(label))
+	}
+
+	if got := r.drain(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("processed %v, want [a b]", got)
+	}
+	stats := r.queue.Stats()
+	if stats.Dropped != 1 || stats.Sources[0].Dropped != 1 {
+		t.Errorf("dropped = %d (source %d), want 1", stats.Dropped, stats.Sources[0].Dropped)
+	}
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	r := newQueueRecorder(t, 2)
+	other := r.queue.NewSource("other", OverflowDropNewest)
+	src := r.queue.NewSource("watch", OverflowDropOldest)
+
+	other.QueueTask(r.task("other"))
+	src.QueueTask(r.task("a"))
+	src.QueueTask(r.task("b"))
+
+	// Only the source's own events make room
+	if got := r.drain(); len(got) != 2 || got[0] != "other" || got[1] != "b" {
+		t.Errorf("processed %v, want [other b]", got)
+	}
+}
+
+func TestOverflowCoalesce(t *testing.T) {
+	r := newQueueRecorder(t, 10)
+	src := r.queue.NewSource("tick", OverflowCoalesce)
+	for _, label := range []string{"a", "b", "c"} {
+		src.QueueTask(r.task(label))
+	}
+
+	if got := r.drain(); len(got) != 1 || got[0] != "c" {
+		t.Errorf("processed %v, want [c]", got)
+	}
+	if stats := r.queue.Stats(); stats.Coalesced != 2 {
+		t.Errorf("coalesced = %d, want 2", stats.Coalesced)
+	}
+
+	// After the pending event ran, the next one is queued again
+	src.QueueTask(r.task("d"))
+	if got := r.drain(); len(got) != 1 || got[0] != "d" {
+		t.Errorf("processed %v, want [d]", got)
+	}
+}
+
+func TestOverflowBlockWaitsForRoom(t *testing.T) {
+	r := newQueueRecorder(t, 1)
+	src := r.queue.NewSource("watch", OverflowBlock)
+	src.QueueTask(r.task("a"))
+
+	queued := make(chan struct{})
+	go func() {
+		src.QueueTask(r.task("b"))
+		close(queued)
+	}()
+
+	select {
+	case <-queued:
+		t.Fatal("blocking source should wait while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := r.drain(); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("processed %v, want [a]", got)
+	}
+	<-queued
+	if got := r.drain(); len(got) != 1 || got[0] != "b" {
+		t.Errorf("processed %v, want [b]", got)
+	}
+	if stats := r.queue.Stats(); stats.Dropped != 0 {
+		t.Errorf("dropped = %d, want 0", stats.Dropped)
+	}
+}
+
+func TestOverflowBlockStopsWhenSourceCloses(t *testing.T) {
+	r := newQueueRecorder(t, 1)
+	src := r.queue.NewSource("watch", OverflowBlock)
+	src.QueueTask(r.task("a"))
+
+	done := make(chan struct{})
+	go func() {
+		src.QueueTask(r.task("b"))
+		close(done)
+	}()
+	src.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("closing the source should release a blocked producer")
+	}
+}
+
+func TestOverflowWarningsAreRateLimited(t *testing.T) {
+	r := newQueueRecorder(t, 1)
+	var warnings []OverflowWarning
+	r.queue.SetOverflowHandler(func(w OverflowWarning) { warnings = append(warnings, w) })
+
+	src := r.queue.NewSource("watch", OverflowDropNewest)
+	for i := 0; i < 5; i++ {
+		src.QueueTask(r.task("x"))
+	}
+
+	if len(warnings) != 1 || warnings[0].Source != "watch" || warnings[0].Dropped != 1 {
+		t.Errorf("warnings = %+v, want one for the first drop", warnings)
+	}
+	if stats := r.queue.Stats(); stats.Dropped != 4 {
+		t.Errorf("dropped = %d, want 4", stats.Dropped)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for name, want := range map[string]OverflowPolicy{
+		"block":       OverflowBlock,
+		"drop-oldest": OverflowDropOldest,
+		"drop_newest": OverflowDropNewest,
+		"COALESCE":    OverflowCoalesce,
+	} {
+		got, err := ParseOverflowPolicy(name)
+		if err != nil || got != want {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseOverflowPolicy("latest"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
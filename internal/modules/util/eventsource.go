@@ -0,0 +1,417 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// OverflowPolicy decides what happens to an event that arrives while the
+// event queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the event that does not fit (the default)
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the source's oldest pending event to make room
+	OverflowDropOldest
+	// OverflowBlock makes the source wait for room, so it falls behind
+	// instead of losing events
+	OverflowBlock
+	// OverflowCoalesce keeps at most one pending event per source; a newer
+	// event replaces the pending one
+	OverflowCoalesce
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowDropNewest: "drop_newest",
+	OverflowDropOldest: "drop_oldest",
+	OverflowBlock:      "block",
+	OverflowCoalesce:   "coalesce",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy parses a policy name: block, drop_oldest, drop_newest
+// or coalesce. Hyphens may be used instead of underscores.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	normalized := strings.ReplaceAll(strings.ToLower(name), "-", "_")
+	for p, n := range overflowPolicyNames {
+		if n == normalized {
+			return p, nil
+		}
+	}
+	return OverflowDropNewest, fmt.Errorf("unknown overflow policy %q (use block, drop_oldest, drop_newest or coalesce)", name)
+}
+
+// OptOverflowPolicy reads the overflow field of the options table at
+// argument n, e.g. timer.every(1000, fn, { overflow = "coalesce" }). It
+// raises an argument error for unknown policies and returns def when the
+// table or field is absent.
+func OptOverflowPolicy(L *lua.LState, n int, def OverflowPolicy) OverflowPolicy {
+	opts := L.OptTable(n, nil)
+	if opts == nil {
+		return def
+	}
+	value := opts.RawGetString("overflow")
+	if value == lua.LNil {
+		return def
+	}
+	policy, err := ParseOverflowPolicy(lua.LVAsString(value))
+	if err != nil {
+		L.ArgError(n, err.Error())
+	}
+	return policy
+}
+
+// overflowWarnInterval bounds how often dropped events of one source are
+// reported
+const overflowWarnInterval = time.Second
+
+// OverflowWarning reports events a source lost since its last warning
+type OverflowWarning struct {
+	Source  string
+	Policy  OverflowPolicy
+	Dropped int64
+}
+
+// EventSource is a producer of events, such as a timer, cron job or file
+// watcher. Its overflow policy decides what happens when the queue is full,
+// and its counters are part of the queue's Stats. Sources with the same name
+// and policy share counters.
+type EventSource struct {
+	queue     *EventQueue
+	name      string
+	policy    OverflowPolicy
+	counters  *sourceCounters
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// Guarded by queue.mu
+	pending   int
+	coalesced *queuedEvent
+}
+
+// NewSource creates an event source
+func (q *EventQueue) NewSource(name string, policy OverflowPolicy) *EventSource {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := sourceKey{name: name, policy: policy}
+	counters, ok := q.sources[key]
+	if !ok {
+		counters = &sourceCounters{}
+		q.sources[key] = counters
+	}
+
+	return &EventSource{
+		queue:    q,
+		name:     name,
+		policy:   policy,
+		counters: counters,
+		closed:   make(chan struct{}),
+	}
+}
+
+// Name returns the name the source was created with
+func (s *EventSource) Name() string {
+	return s.name
+}
+
+// Policy returns the source's overflow policy
+func (s *EventSource) Policy() OverflowPolicy {
+	return s.policy
+}
+
+// Queue adds a callback invocation, applying the source's overflow policy
+func (s *EventSource) Queue(callback *lua.LFunction, data lua.LValue) {
+	s.queue.push(s, EventData{Callback: callback, Data: data})
+}
+
+// QueueTask adds a task, applying the source's overflow policy
+func (s *EventSource) QueueTask(task func(*lua.LState)) {
+	s.queue.push(s, EventData{Task: task})
+}
+
+// Close stops the source from waiting for room in the queue. Events it
+// already queued still run.
+func (s *EventSource) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// queuedEvent is a pending event and the source that queued it
+type queuedEvent struct {
+	EventData
+	source *EventSource
+}
+
+// push adds an event, applying the overflow policy of src (drop newest if
+// src is nil)
+func (q *EventQueue) push(src *EventSource, event EventData) {
+	if src != nil && src.policy == OverflowBlock {
+		q.pushWait(src, event)
+		return
+	}
+
+	select {
+	case <-q.done:
+		// Queue closed, ignore event
+		return
+	default:
+	}
+
+	q.mu.Lock()
+	if src != nil && src.policy == OverflowCoalesce && src.coalesced != nil {
+		// Replace the pending event instead of adding another
+		src.coalesced.EventData = event
+		q.mu.Unlock()
+		q.stats.coalesced.Add(1)
+		src.counters.coalesced.Add(1)
+		return
+	}
+
+	if len(q.pending) >= q.capacity {
+		dropped := q.makeRoom(src)
+		if !dropped {
+			q.mu.Unlock()
+			q.recordDrop(src)
+			return
+		}
+		// The source's oldest event made room for this one
+		defer q.recordDrop(src)
+	}
+	q.append(src, event)
+	q.mu.Unlock()
+}
+
+// makeRoom removes the oldest pending event of a drop_oldest source.
+// It reports whether there is room now. Called with q.mu held.
+func (q *EventQueue) makeRoom(src *EventSource) bool {
+	if src == nil || src.policy != OverflowDropOldest || src.pending == 0 {
+		return false
+	}
+	for i, e := range q.pending {
+		if e.source == src {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			src.pending--
+			return true
+		}
+	}
+	return false
+}
+
+// pushWait adds an event once there is room, unless the queue or the source
+// is closed first
+func (q *EventQueue) pushWait(src *EventSource, event EventData) {
+	var closed chan struct{}
+	if src != nil {
+		closed = src.closed
+	}
+
+	q.mu.Lock()
+	for len(q.pending) >= q.capacity {
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-space:
+		case <-q.done:
+			return
+		case <-closed:
+			return
+		}
+		q.mu.Lock()
+	}
+	q.append(src, event)
+	q.mu.Unlock()
+}
+
+// append adds an event to the queue. Called with q.mu held.
+func (q *EventQueue) append(src *EventSource, event EventData) {
+	e := &queuedEvent{EventData: event, source: src}
+	q.pending = append(q.pending, e)
+	q.stats.queued.Add(1)
+	if src != nil {
+		src.pending++
+		src.counters.queued.Add(1)
+		if src.policy == OverflowCoalesce {
+			src.coalesced = e
+		}
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the oldest pending event
+func (q *EventQueue) pop() (EventData, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) == 0 {
+		return EventData{}, false
+	}
+	e := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+
+	if src := e.source; src != nil {
+		src.pending--
+		if src.coalesced == e {
+			src.coalesced = nil
+		}
+	}
+	q.stats.processed.Add(1)
+
+	// Wake sources waiting for room
+	close(q.space)
+	q.space = make(chan struct{})
+	return e.EventData, true
+}
+
+// recordDrop counts a dropped event and warns about it, at most once per
+// overflowWarnInterval per source
+func (q *EventQueue) recordDrop(src *EventSource) {
+	q.stats.dropped.Add(1)
+
+	name, policy, counters := "", OverflowDropNewest, &q.stats.unnamed
+	if src != nil {
+		name, policy, counters = src.name, src.policy, src.counters
+	}
+	counters.dropped.Add(1)
+	unreported := counters.unreported.Add(1)
+
+	q.mu.Lock()
+	handler := q.overflow
+	q.mu.Unlock()
+	if handler == nil {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := counters.lastWarning.Load()
+	if now-last < int64(overflowWarnInterval) || !counters.lastWarning.CompareAndSwap(last, now) {
+		return
+	}
+	counters.unreported.Add(-unreported)
+	handler(OverflowWarning{Source: name, Policy: policy, Dropped: unreported})
+}
+
+// FlushOverflowWarnings reports drops that were held back by the warning
+// rate limit, e.g. before the engine exits
+func (q *EventQueue) FlushOverflowWarnings() {
+	q.mu.Lock()
+	handler := q.overflow
+	warnings := []OverflowWarning{}
+	if n := q.stats.unnamed.unreported.Swap(0); n > 0 {
+		warnings = append(warnings, OverflowWarning{Policy: OverflowDropNewest, Dropped: n})
+	}
+	for key, c := range q.sources {
+		if n := c.unreported.Swap(0); n > 0 {
+			warnings = append(warnings, OverflowWarning{Source: key.name, Policy: key.policy, Dropped: n})
+		}
+	}
+	q.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Source < warnings[j].Source })
+	for _, w := range warnings {
+		handler(w)
+	}
+}
+
+// SetOverflowHandler sets the function told about dropped events. It is
+// called from the goroutine of the source, at most once per second per
+// source, with the number of events dropped since the previous call.
+func (q *EventQueue) SetOverflowHandler(fn func(OverflowWarning)) {
+	q.mu.Lock()
+	q.overflow = fn
+	q.mu.Unlock()
+}
+
+// Pending returns the number of events waiting to be processed
+func (q *EventQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+type queueCounters struct {
+	queued, processed, dropped, coalesced atomic.Int64
+	unnamed                               sourceCounters
+}
+
+// sourceKey identifies the counters shared by sources
+type sourceKey struct {
+	name   string
+	policy OverflowPolicy
+}
+
+type sourceCounters struct {
+	queued, dropped, coalesced atomic.Int64
+	unreported                 atomic.Int64
+	lastWarning                atomic.Int64
+}
+
+// QueueStats are the counters of an event queue
+type QueueStats struct {
+	Capacity  int
+	Pending   int
+	Queued    int64
+	Processed int64
+	Dropped   int64
+	Coalesced int64
+	// Sources lists event sources, sorted by name
+	Sources []SourceStats
+}
+
+// SourceStats are the counters of the event sources sharing a name and policy
+type SourceStats struct {
+	Name      string
+	Policy    OverflowPolicy
+	Queued    int64
+	Dropped   int64
+	Coalesced int64
+}
+
+// Stats returns the queue's counters
+func (q *EventQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Capacity:  q.capacity,
+		Pending:   len(q.pending),
+		Queued:    q.stats.queued.Load(),
+		Processed: q.stats.processed.Load(),
+		Dropped:   q.stats.dropped.Load(),
+		Coalesced: q.stats.coalesced.Load(),
+	}
+	for key, c := range q.sources {
+		stats.Sources = append(stats.Sources, SourceStats{
+			Name:      key.name,
+			Policy:    key.policy,
+			Queued:    c.queued.Load(),
+			Dropped:   c.dropped.Load(),
+			Coalesced: c.coalesced.Load(),
+		})
+	}
+	sort.Slice(stats.Sources, func(i, j int) bool {
+		a, b := stats.Sources[i], stats.Sources[j]
+		return a.Name < b.Name || (a.Name == b.Name && a.Policy < b.Policy)
+	})
+	return stats
+}
@@ -0,0 +1,326 @@
+package util
+
+import (
+	"context"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// FutureTypeName is the userdata type name of futures
+const FutureTypeName = "async_future"
+
+// Registry key for storing the async scheduler in the Lua state
+const AsyncSchedulerRegistryKey = "vulgar_async_scheduler"
+
+// FinishFunc pushes the results of asynchronous work onto the Lua stack and
+// returns how many values it pushed, like an LGFunction. It runs on the main
+// Lua thread, so it may use L freely.
+type FinishFunc func(L *lua.LState) int
+
+// Future is the eventual result of asynchronous work: the values a FinishFunc
+// pushed, or the return values of an async.run task. Futures are only touched
+// on the main Lua thread; goroutines hand their results over through the
+// EventQueue.
+//
+// Usage (in a module function):
+//
+//	L.Push(util.Go(L, func(ctx context.Context) util.FinishFunc {
+//		data, err := fetch(ctx)
+//		return func(L *lua.LState) int {
+//			if err != nil {
+//				return util.PushError(L, "fetch failed: %v", err)
+//			}
+//			return util.PushSuccess(L, lua.LString(data))
+//		}
+//	}))
+//	return 1
+type Future struct {
+	done    bool
+	values  []lua.LValue
+	waiters []*lua.LState                     // coroutines suspended in Await
+	onDone  []func(*lua.LState, []lua.LValue) // Go callbacks, e.g. for gather
+}
+
+// NewFuture creates a pending future
+func NewFuture() *Future {
+	return &Future{}
+}
+
+// Done reports whether the future has been resolved
+func (f *Future) Done() bool {
+	return f.done
+}
+
+// Values returns the values the future resolved to
+func (f *Future) Values() []lua.LValue {
+	return f.values
+}
+
+// Resolve completes the future and schedules the coroutines waiting for it.
+// Resolving twice has no effect.
+func (f *Future) Resolve(L *lua.LState, values ...lua.LValue) {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.values = values
+
+	s := getScheduler(L)
+	for _, co := range f.waiters {
+		s.ready = append(s.ready, readyTask{co: co, values: values})
+	}
+	f.waiters = nil
+
+	callbacks := f.onDone
+	f.onDone = nil
+	for _, fn := range callbacks {
+		fn(L, values)
+	}
+}
+
+// OnDone calls fn with the future's values once it resolves, immediately if
+// it already has. fn runs on the main Lua thread.
+func (f *Future) OnDone(L *lua.LState, fn func(*lua.LState, []lua.LValue)) {
+	if f.done {
+		fn(L, f.values)
+		return
+	}
+	f.onDone = append(f.onDone, fn)
+}
+
+// NewFutureValue wraps a future in a Lua userdata with done() and await() methods
+func NewFutureValue(L *lua.LState, f *Future) *lua.LUserData {
+	if L.GetTypeMetatable(FutureTypeName) == lua.LNil {
+		RegisterUserDataType(L, FutureTypeName, map[string]lua.LGFunction{
+			"done":  futureDone,
+			"await": futureAwait,
+		})
+	}
+	return NewUserData(L, f, FutureTypeName)
+}
+
+// ToFuture returns the future held by v, or nil
+func ToFuture(v lua.LValue) *Future {
+	if ud, ok := v.(*lua.LUserData); ok {
+		if f, ok := ud.Value.(*Future); ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// Usage: if fut:done() then ... end
+func futureDone(L *lua.LState) int {
+	f := ToFuture(L.CheckUserData(1))
+	if f == nil {
+		L.ArgError(1, "future expected")
+		return 0
+	}
+	L.Push(lua.LBool(f.done))
+	return 1
+}
+
+// Usage: local result, err = fut:await()
+func futureAwait(L *lua.LState) int {
+	f := ToFuture(L.CheckUserData(1))
+	if f == nil {
+		L.ArgError(1, "future expected")
+		return 0
+	}
+	return Await(L, f)
+}
+
+// Go runs work on a new goroutine and returns a future for its results.
+// work must not use the Lua state; it returns a FinishFunc that converts its
+// results on the main thread. work receives the state's context, so engine
+// shutdown and timeouts cancel it. Without an event loop (worker states,
+// tests) work runs synchronously and the future is already resolved.
+func Go(L *lua.LState, work func(ctx context.Context) FinishFunc) *lua.LUserData {
+	f := NewFuture()
+	ud := NewFutureValue(L, f)
+
+	ctx := L.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	queue := GetEventQueue(L)
+	if queue == nil {
+		f.Resolve(L, callFinish(L, work(ctx))...)
+		return ud
+	}
+
+	// A pending future keeps the event loop running
+	queue.AddSource()
+	go func() {
+		finish := work(ctx)
+		queue.QueueTaskWait(func(L *lua.LState) {
+			queue.RemoveSource()
+			f.Resolve(L, callFinish(L, finish)...)
+			getScheduler(L).drain(L)
+		})
+	}()
+	return ud
+}
+
+// callFinish runs finish and collects the values it pushed
+func callFinish(L *lua.LState, finish FinishFunc) []lua.LValue {
+	top := L.GetTop()
+	n := finish(L)
+	values := make([]lua.LValue, 0, n)
+	for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+		values = append(values, L.Get(i))
+	}
+	L.SetTop(top)
+	return values
+}
+
+// Await returns the future's values, pushing them onto the stack. Inside an
+// async.run task the task is suspended until the future resolves; on the main
+// thread the event loop runs until it does.
+func Await(L *lua.LState, f *Future) int {
+	if !f.done {
+		s := getScheduler(L)
+		switch {
+		case s.tasks[L] != nil:
+			if insideProtectedCall(L) {
+				L.RaiseError("await cannot suspend a task inside pcall; await first, then handle the (result, err) pair")
+				return 0
+			}
+			f.waiters = append(f.waiters, L)
+			// The values the task is resumed with become our return values
+			return L.Yield()
+		case s.isMain(L):
+			s.wait(L, f)
+		default:
+			L.RaiseError("await can only be used in the main script or inside async.run")
+			return 0
+		}
+	}
+
+	for _, v := range f.values {
+		L.Push(v)
+	}
+	return len(f.values)
+}
+
+// insideProtectedCall reports whether L is running inside pcall or xpcall.
+// gopher-lua cannot yield across them.
+func insideProtectedCall(L *lua.LState) bool {
+	for level := 0; ; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			return false
+		}
+		if _, err := L.GetInfo("n", dbg, lua.LNil); err != nil {
+			continue
+		}
+		if dbg.Name == "pcall" || dbg.Name == "xpcall" {
+			return true
+		}
+	}
+}
+
+// RunTask runs fn as a coroutine task and returns a future for its return
+// values. The task runs until it first awaits a pending future. If fn raises
+// an error the future resolves to nil and the error message.
+func RunTask(L *lua.LState, fn *lua.LFunction, args ...lua.LValue) *Future {
+	s := getScheduler(L)
+	co, _ := L.NewThread()
+	f := NewFuture()
+	s.tasks[co] = f
+
+	s.resume(L, co, fn, args)
+	if s.isMain(L) {
+		s.drain(L)
+	} else if len(s.ready) > 0 && s.queue != nil {
+		s.queue.QueueTask(s.drain)
+	}
+	return f
+}
+
+// readyTask is a suspended task whose future has resolved
+type readyTask struct {
+	co     *lua.LState
+	values []lua.LValue
+}
+
+// scheduler tracks async.run tasks for one engine. Like futures it is only
+// used on the main Lua thread.
+type scheduler struct {
+	queue    *EventQueue
+	tasks    map[*lua.LState]*Future
+	ready    []readyTask
+	draining bool
+}
+
+func getScheduler(L *lua.LState) *scheduler {
+	registry := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := L.GetField(registry, AsyncSchedulerRegistryKey).(*lua.LUserData); ok {
+		if s, ok := ud.Value.(*scheduler); ok {
+			return s
+		}
+	}
+
+	s := &scheduler{queue: GetEventQueue(L), tasks: make(map[*lua.LState]*Future)}
+	ud := L.NewUserData()
+	ud.Value = s
+	L.SetField(registry, AsyncSchedulerRegistryKey, ud)
+	return s
+}
+
+// isMain reports whether L is the thread that owns the event loop
+func (s *scheduler) isMain(L *lua.LState) bool {
+	return s.queue != nil && s.queue.L == L
+}
+
+// resume runs a task until it finishes or awaits a pending future
+func (s *scheduler) resume(L *lua.LState, co *lua.LState, fn *lua.LFunction, args []lua.LValue) {
+	state, err, values := L.Resume(co, fn, args...)
+	if state == lua.ResumeYield {
+		return
+	}
+
+	f := s.tasks[co]
+	delete(s.tasks, co)
+	if err != nil {
+		f.Resolve(L, lua.LNil, lua.LString(err.Error()))
+		return
+	}
+	f.Resolve(L, values...)
+}
+
+// drain resumes tasks whose futures have resolved. Tasks resolved while
+// draining are picked up by the same loop.
+func (s *scheduler) drain(L *lua.LState) {
+	if s.draining {
+		return
+	}
+	s.draining = true
+	defer func() { s.draining = false }()
+
+	for len(s.ready) > 0 {
+		next := s.ready[0]
+		s.ready = s.ready[1:]
+		if s.tasks[next.co] == nil {
+			continue
+		}
+		s.resume(L, next.co, nil, next.values)
+	}
+}
+
+// wait runs the event loop on the main thread until f resolves
+func (s *scheduler) wait(L *lua.LState, f *Future) {
+	for {
+		s.drain(L)
+		if f.done {
+			return
+		}
+		if ctx := L.Context(); ctx != nil && ctx.Err() != nil {
+			L.RaiseError("await interrupted: %v", ctx.Err())
+		}
+		if !s.queue.WaitForEvents() && !f.done {
+			L.RaiseError("await: future can never complete (nothing left to wait for)")
+		}
+	}
+}
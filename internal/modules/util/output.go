@@ -0,0 +1,64 @@
+package util
+
+import (
+	"io"
+	"os"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Registry keys for where a state's output and workflow node reports go
+const (
+	OutputRegistryKey       = "vulgar_output"
+	NodeObserverRegistryKey = "vulgar_node_observer"
+)
+
+// SetOutput makes w the destination of what scripts print and log on L
+func SetOutput(L *lua.LState, w io.Writer) {
+	ud := L.NewUserData()
+	ud.Value = w
+	L.SetField(L.Get(lua.RegistryIndex), OutputRegistryKey, ud)
+}
+
+// Output returns where print and log output of L goes, os.Stdout unless
+// SetOutput was called
+func Output(L *lua.LState) io.Writer {
+	if ud, ok := L.GetField(L.Get(lua.RegistryIndex), OutputRegistryKey).(*lua.LUserData); ok {
+		if w, ok := ud.Value.(io.Writer); ok {
+			return w
+		}
+	}
+	return os.Stdout
+}
+
+// NodeReport describes a stdlib.workflow node that finished
+type NodeReport struct {
+	Workflow string
+	Node     string
+	Status   string
+	Started  time.Time
+	Duration time.Duration
+	// Result is what the node returned, converted with LuaToGo
+	Result interface{}
+	Error  string
+}
+
+// NodeObserver receives a report for every workflow node that finishes
+type NodeObserver func(NodeReport)
+
+// SetNodeObserver stores the observer workflow nodes on L report to
+func SetNodeObserver(L *lua.LState, observer NodeObserver) {
+	ud := L.NewUserData()
+	ud.Value = observer
+	L.SetField(L.Get(lua.RegistryIndex), NodeObserverRegistryKey, ud)
+}
+
+// ReportNode passes a finished node to the observer registered on L, if any
+func ReportNode(L *lua.LState, report NodeReport) {
+	if ud, ok := L.GetField(L.Get(lua.RegistryIndex), NodeObserverRegistryKey).(*lua.LUserData); ok {
+		if observer, ok := ud.Value.(NodeObserver); ok {
+			observer(report)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// RunRegistryKey is the registry key of the RunInfo of a state
+const RunRegistryKey = "vulgar_run"
+
+// GraphRegistryKey is the registry key of the last workflow a graph-only
+// run passed to workflow.run
+const GraphRegistryKey = "vulgar_workflow_graph"
+
+// RunInfo describes the run a state belongs to
+type RunInfo struct {
+	// ID identifies the run, in the run history and in workflow checkpoints
+	ID string
+	// Resume is set when the run continues the earlier run with the same
+	// ID, so stdlib.workflow graphs skip the nodes checkpointed for it
+	Resume bool
+	// DryRun is set when side effects are stubbed out, so nothing should
+	// be persisted
+	DryRun bool
+	// GraphOnly is set when the script is only loaded for the graphs it
+	// builds (vulgar graph): workflow.run records the workflow under
+	// GraphRegistryKey instead of running its nodes
+	GraphOnly bool
+}
+
+// SetRunInfo stores the run L belongs to. The engine shares one RunInfo
+// between the main state and its worker states.
+func SetRunInfo(L *lua.LState, info *RunInfo) {
+	ud := L.NewUserData()
+	ud.Value = info
+	L.SetField(L.Get(lua.RegistryIndex), RunRegistryKey, ud)
+}
+
+// GetRunInfo returns the run L belongs to, or a zero RunInfo for states
+// created outside an engine
+func GetRunInfo(L *lua.LState) RunInfo {
+	if ud, ok := L.GetField(L.Get(lua.RegistryIndex), RunRegistryKey).(*lua.LUserData); ok {
+		if info, ok := ud.Value.(*RunInfo); ok {
+			return *info
+		}
+	}
+	return RunInfo{}
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules"
+)
+
+// Registry key for storing the StateFactory in the Lua state
+const StateFactoryRegistryKey = "vulgar_state_factory"
+
+// StateFactory creates a fresh Lua state configured like the state it was
+// registered on (same module loaders, sandbox policy and globals).
+// Lua states are not thread-safe, so modules that run Lua code concurrently
+// must give each goroutine its own state.
+type StateFactory func() *lua.LState
+
+// SetStateFactory stores the factory in the Lua registry
+func SetStateFactory(L *lua.LState, factory StateFactory) {
+	ud := L.NewUserData()
+	ud.Value = factory
+	L.SetField(L.Get(lua.RegistryIndex), StateFactoryRegistryKey, ud)
+}
+
+// NewIsolatedState creates a new state using the factory registered on L.
+// Without a factory (e.g. in tests) it falls back to a plain state with all
+// registered modules available through require().
+func NewIsolatedState(L *lua.LState) *lua.LState {
+	if tbl, ok := L.Get(lua.RegistryIndex).(*lua.LTable); ok {
+		if ud, ok := L.GetField(tbl, StateFactoryRegistryKey).(*lua.LUserData); ok {
+			if factory, ok := ud.Value.(StateFactory); ok {
+				return factory()
+			}
+		}
+	}
+
+	state := lua.NewState()
+	for name, loader := range modules.GetRegistry() {
+		state.PreloadModule(name, loader)
+	}
+	for _, opener := range modules.GetPreloadRegistry() {
+		opener(state)
+	}
+	if ctx := L.Context(); ctx != nil {
+		state.SetContext(ctx)
+	}
+	return state
+}
@@ -0,0 +1,217 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Registry key for storing errors that were turned into strings for scripts
+const ErrorCausesRegistryKey = "vulgar_error_causes"
+
+// maxErrorCauses bounds how many recorded errors a state keeps
+const maxErrorCauses = 32
+
+// StackFrame is one level of the Lua call stack
+type StackFrame struct {
+	// Source is the chunk name (usually the script path), or "[G]" for
+	// functions implemented in Go
+	Source string `json:"source"`
+	// Line is the line being executed, 0 if unknown
+	Line int `json:"line,omitempty"`
+	// Function is the name the function was called by, e.g. "get" for http.get
+	Function string `json:"function,omitempty"`
+	// GoFunction names the Go function behind a Go frame, e.g. "http.luaGet"
+	GoFunction string `json:"go_function,omitempty"`
+}
+
+// IsGo reports whether the frame is a function implemented in Go
+func (f StackFrame) IsGo() bool {
+	return f.Source == "[G]"
+}
+
+// String formats the frame like a line of a Lua traceback
+func (f StackFrame) String() string {
+	location := f.Source
+	if f.Line > 0 {
+		location += ":" + strconv.Itoa(f.Line)
+	}
+	switch {
+	case f.Function == "main chunk":
+		return location + " in main chunk"
+	case strings.HasPrefix(f.Function, "<"), strings.HasPrefix(f.Function, "("):
+		return location + " in function " + f.Function
+	case f.Function != "":
+		return location + " in function '" + f.Function + "'"
+	}
+	return location + " in function (anonymous)"
+}
+
+// ScriptError is a Lua runtime error together with the call stack at the
+// point it was raised
+type ScriptError struct {
+	// Message is the error value as a string, including the position Lua
+	// prefixes it with
+	Message string
+	// Value is the error value itself
+	Value lua.LValue
+	// Frames is the call stack, innermost first
+	Frames []StackFrame
+}
+
+func (e *ScriptError) Error() string {
+	return e.Message
+}
+
+// Traceback formats the frames like Lua's debug.traceback
+func (e *ScriptError) Traceback() string {
+	var b strings.Builder
+	b.WriteString("stack traceback:")
+	for _, f := range e.Frames {
+		b.WriteString("\n\t")
+		b.WriteString(f.String())
+	}
+	return b.String()
+}
+
+// RaisedBy returns the frame of the Go module function that raised the
+// error, or nil when the error came from Lua code (error(), a failed index, ...)
+func (e *ScriptError) RaisedBy() *StackFrame {
+	if len(e.Frames) == 0 || !e.Frames[0].IsGo() {
+		return nil
+	}
+	if strings.HasPrefix(e.Frames[0].GoFunction, "gopher-lua.") {
+		// gopher-lua's own library functions, e.g. error() or assert()
+		return nil
+	}
+	return &e.Frames[0]
+}
+
+// PCall is L.PCall with a message handler that records the call stack of
+// runtime errors. Errors raised while running the function are returned as
+// a *ScriptError.
+func PCall(L *lua.LState, nargs, nret int) error {
+	var frames []StackFrame
+	handler := L.NewFunction(func(L *lua.LState) int {
+		// Level 0 is this handler, level 1 raised the error
+		frames = CaptureStack(L, 1)
+		L.SetTop(1)
+		return 1
+	})
+
+	err := L.PCall(nargs, nret, handler)
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) || frames == nil {
+		return err
+	}
+	return &ScriptError{Message: apiErr.Object.String(), Value: apiErr.Object, Frames: frames}
+}
+
+// CaptureStack returns the call stack starting at level, innermost first
+func CaptureStack(L *lua.LState, level int) []StackFrame {
+	frames := []StackFrame{}
+	for ; ; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			return frames
+		}
+		fn, err := L.GetInfo("nSlf", dbg, lua.LNil)
+		if err != nil {
+			continue
+		}
+
+		frame := StackFrame{Source: dbg.Source, Function: dbg.Name}
+		if dbg.CurrentLine > 0 {
+			frame.Line = dbg.CurrentLine
+		}
+		// The outermost frame of a call from Go is reported as the main
+		// chunk even when it is a function, e.g. a workflow node
+		if dbg.What == "main" && dbg.LineDefined > 0 {
+			frame.Function = fmt.Sprintf("<%s:%d>", dbg.Source, dbg.LineDefined)
+		}
+		if lf, ok := fn.(*lua.LFunction); ok && lf.IsG {
+			frame.Source = "[G]"
+			frame.GoFunction = goFunctionName(lf.GFunction)
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// goFunctionName returns the package-qualified name of a Go function, e.g.
+// "http.luaGet" for github.com/zepzeper/vulgar/internal/modules/stdlib/http.luaGet
+func goFunctionName(fn lua.LGFunction) string {
+	if fn == nil {
+		return ""
+	}
+	rf := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if rf == nil {
+		return ""
+	}
+	name := rf.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// RecordError remembers err under the message a module hands to the script,
+// so the engine can attach its stack to the error the script raises in turn.
+// Use it for errors returned as strings that carry a *ScriptError, like a
+// failed workflow node.
+func RecordError(L *lua.LState, message string, err error) {
+	var scriptErr *ScriptError
+	if !errors.As(err, &scriptErr) {
+		return
+	}
+	causes := errorCauses(L)
+	causes.entries = append(causes.entries, recordedError{message: message, err: scriptErr})
+	if len(causes.entries) > maxErrorCauses {
+		causes.entries = causes.entries[len(causes.entries)-maxErrorCauses:]
+	}
+}
+
+// ErrorCause returns the recorded error whose message appears in message,
+// preferring the longest match
+func ErrorCause(L *lua.LState, message string) (string, *ScriptError) {
+	var bestMessage string
+	var best *ScriptError
+	for _, entry := range errorCauses(L).entries {
+		if len(entry.message) > len(bestMessage) && strings.Contains(message, entry.message) {
+			bestMessage, best = entry.message, entry.err
+		}
+	}
+	return bestMessage, best
+}
+
+type recordedError struct {
+	message string
+	err     *ScriptError
+}
+
+type errorCauseList struct {
+	entries []recordedError
+}
+
+func errorCauses(L *lua.LState) *errorCauseList {
+	registry := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if ud, ok := L.GetField(registry, ErrorCausesRegistryKey).(*lua.LUserData); ok {
+		if c, ok := ud.Value.(*errorCauseList); ok {
+			return c
+		}
+	}
+
+	c := &errorCauseList{}
+	ud := L.NewUserData()
+	ud.Value = c
+	L.SetField(registry, ErrorCausesRegistryKey, ud)
+	return c
+}
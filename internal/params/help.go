@@ -0,0 +1,164 @@
+package params
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// DeclarationFunc is the name of the global scripts use to declare parameters
+const DeclarationFunc = "params"
+
+// Extract reads the parameter declaration from a script without running it.
+// Only the table passed to the top-level params{...} call is evaluated, so
+// declarations should use literal values. Returns nil if the script does not
+// declare parameters.
+func Extract(path string) ([]Param, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	chunk, err := parse.Parse(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %w", err)
+	}
+
+	declaration := findDeclaration(chunk)
+	if declaration == nil {
+		return nil, nil
+	}
+
+	// Compile "return <table>" and evaluate it in an empty state
+	proto, err := lua.Compile([]ast.Stmt{&ast.ReturnStmt{Exprs: []ast.Expr{declaration}}}, path)
+	if err != nil {
+		return nil, err
+	}
+
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		return nil, fmt.Errorf("failed to evaluate parameter declaration: %w", err)
+	}
+
+	tbl, ok := L.Get(-1).(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("parameter declaration must be a table")
+	}
+	return ParseSpec(tbl)
+}
+
+// RedactScript is Redact with the parameters the script at path declares.
+// When the declaration cannot be read, as for compiled scripts, no values
+// are kept at all, since any of them may be secret.
+func RedactScript(path string, in Input) Input {
+	specs, err := Extract(path)
+	if err != nil {
+		return Input{Strict: in.Strict}
+	}
+	return Redact(specs, in)
+}
+
+// findDeclaration returns the table argument of the first top-level
+// params{...} call, whether used as a statement or assigned to a variable
+func findDeclaration(chunk []ast.Stmt) *ast.TableExpr {
+	for _, stmt := range chunk {
+		var exprs []ast.Expr
+		switch s := stmt.(type) {
+		case *ast.FuncCallStmt:
+			exprs = []ast.Expr{s.Expr}
+		case *ast.LocalAssignStmt:
+			exprs = s.Exprs
+		case *ast.AssignStmt:
+			exprs = s.Rhs
+		}
+
+		for _, expr := range exprs {
+			call, ok := expr.(*ast.FuncCallExpr)
+			if !ok || call.Receiver != nil || len(call.Args) != 1 {
+				continue
+			}
+			if ident, ok := call.Func.(*ast.IdentExpr); !ok || ident.Value != DeclarationFunc {
+				continue
+			}
+			if tbl, ok := call.Args[0].(*ast.TableExpr); ok {
+				return tbl
+			}
+		}
+	}
+	return nil
+}
+
+// PrintHelp writes usage information for a script's declared parameters
+func PrintHelp(w io.Writer, script string, specs []Param) {
+	name := filepath.Base(script)
+	if len(specs) == 0 {
+		fmt.Fprintf(w, "Usage: vulgar %s [args...]\n\n", name)
+		fmt.Fprintln(w, "This script does not declare any parameters.")
+		return
+	}
+
+	var positional []string
+	for _, p := range specs {
+		if p.Required {
+			positional = append(positional, "<"+p.Name+">")
+		} else {
+			positional = append(positional, "["+p.Name+"]")
+		}
+	}
+
+	fmt.Fprintf(w, "Usage: vulgar %s [--param name=value ...] %s\n\n", name, strings.Join(positional, " "))
+	fmt.Fprintln(w, "Parameters:")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, p := range specs {
+		var notes []string
+		if p.Required {
+			notes = append(notes, "required")
+		}
+		if p.Default != nil {
+			notes = append(notes, fmt.Sprintf("default: %v", formatDefault(p.Default)))
+		}
+		if len(p.Choices) > 0 {
+			notes = append(notes, "one of: "+strings.Join(p.Choices, ", "))
+		}
+		if p.Secret {
+			notes = append(notes, "secret")
+		}
+
+		detail := p.Description
+		if len(notes) > 0 {
+			if detail != "" {
+				detail += " "
+			}
+			detail += "(" + strings.Join(notes, "; ") + ")"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", p.Name, p.Type, detail)
+	}
+	tw.Flush()
+}
+
+func formatDefault(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return fmt.Sprintf("%q", val)
+	case []interface{}:
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = fmt.Sprintf("%v", item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
@@ -0,0 +1,354 @@
+// Package params implements typed script parameters.
+//
+// A script declares its parameters with the global params function:
+//
+//	local p = params {
+//	    { "env",   type = "string", required = true, description = "Target environment" },
+//	    { "count", type = "integer", default = 5 },
+//	}
+//
+// Values come from --param name=value flags or from positional arguments
+// (matched in declaration order) and are validated and coerced before the
+// script sees them. Defaults are checked against the declared type and
+// choices in the same way.
+package params
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Supported parameter types
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeList    = "list"
+)
+
+var validTypes = []string{TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeList}
+
+// Param describes a single declared script parameter
+type Param struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Choices     []string    `json:"choices,omitempty"`
+	// Secret keeps the value out of the run history (e.g. tokens)
+	Secret bool `json:"secret,omitempty"`
+}
+
+// Input holds raw parameter values supplied on the command line
+type Input struct {
+	Named      map[string]string
+	Positional []string
+	// Strict rejects named values for a script that declares no
+	// parameters, which would otherwise ignore them (as with --param)
+	Strict bool
+}
+
+// ParseSpec converts a params{...} declaration into parameter definitions.
+// Both the ordered array form ({ "name", type = ... }) and the keyed form
+// (name = { type = ... }) are accepted; keyed entries are sorted by name.
+func ParseSpec(tbl *lua.LTable) ([]Param, error) {
+	var ordered []Param
+	var keyed []Param
+	var parseErr error
+
+	tbl.ForEach(func(k, v lua.LValue) {
+		if parseErr != nil {
+			return
+		}
+
+		def, ok := v.(*lua.LTable)
+		if !ok {
+			parseErr = fmt.Errorf("parameter %s: definition must be a table", lua.LVAsString(k))
+			return
+		}
+
+		var p Param
+		p, parseErr = parseParam(def)
+		if parseErr != nil {
+			return
+		}
+
+		switch key := k.(type) {
+		case lua.LNumber:
+			ordered = append(ordered, p)
+		case lua.LString:
+			if p.Name == "" {
+				p.Name = string(key)
+			}
+			keyed = append(keyed, p)
+		}
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	sort.Slice(keyed, func(i, j int) bool { return keyed[i].Name < keyed[j].Name })
+	result := append(ordered, keyed...)
+
+	seen := make(map[string]bool)
+	for _, p := range result {
+		if p.Name == "" {
+			return nil, fmt.Errorf("parameter is missing a name")
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("parameter %s is declared twice", p.Name)
+		}
+		seen[p.Name] = true
+	}
+
+	return result, nil
+}
+
+func parseParam(def *lua.LTable) (Param, error) {
+	p := Param{Type: TypeString}
+
+	if name := def.RawGetInt(1); name != lua.LNil {
+		p.Name = lua.LVAsString(name)
+	}
+	if name := def.RawGetString("name"); name != lua.LNil {
+		p.Name = lua.LVAsString(name)
+	}
+	if typ := def.RawGetString("type"); typ != lua.LNil {
+		p.Type = strings.ToLower(lua.LVAsString(typ))
+	}
+	if desc := def.RawGetString("description"); desc != lua.LNil {
+		p.Description = lua.LVAsString(desc)
+	}
+	p.Required = lua.LVAsBool(def.RawGetString("required"))
+	p.Secret = lua.LVAsBool(def.RawGetString("secret"))
+
+	if choices, ok := def.RawGetString("choices").(*lua.LTable); ok {
+		choices.ForEach(func(_, v lua.LValue) {
+			p.Choices = append(p.Choices, lua.LVAsString(v))
+		})
+	}
+
+	valid := false
+	for _, t := range validTypes {
+		if p.Type == t {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return p, fmt.Errorf("parameter %s: unknown type %q (expected one of: %s)", p.Name, p.Type, strings.Join(validTypes, ", "))
+	}
+
+	if v := def.RawGetString("default"); v != lua.LNil {
+		value, err := coerceDefault(p, v)
+		if err != nil {
+			return p, fmt.Errorf("parameter %s: invalid default: %w", p.Name, err)
+		}
+		p.Default = value
+	}
+
+	return p, nil
+}
+
+// coerceDefault checks a declared default against the parameter's type and
+// choices, as if it had been given on the command line. Lists also accept a
+// table of items.
+func coerceDefault(p Param, v lua.LValue) (interface{}, error) {
+	switch val := v.(type) {
+	case lua.LString, lua.LNumber, lua.LBool:
+		return Coerce(p, val.String())
+	case *lua.LTable:
+		if p.Type != TypeList {
+			return nil, fmt.Errorf("expected a %s, got a table", p.Type)
+		}
+		items := []interface{}{}
+		var err error
+		val.ForEach(func(_, item lua.LValue) {
+			if err != nil {
+				return
+			}
+			switch item.(type) {
+			case lua.LString, lua.LNumber:
+			default:
+				err = fmt.Errorf("list items must be strings, got %s", item.Type())
+				return
+			}
+			var s interface{}
+			s, err = Coerce(Param{Name: p.Name, Type: TypeString, Choices: p.Choices}, item.String())
+			items = append(items, s)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("expected a %s, got %s", p.Type, v.Type())
+}
+
+// Resolve validates the supplied input against the declared parameters and
+// returns the coerced values keyed by parameter name
+func Resolve(specs []Param, in Input) (map[string]interface{}, error) {
+	raw, err := assign(specs, in)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(specs))
+	for _, p := range specs {
+		str, ok := raw[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, fmt.Errorf("missing required parameter %q", p.Name)
+			}
+			if p.Default != nil {
+				values[p.Name] = p.Default
+			}
+			continue
+		}
+
+		v, err := Coerce(p, str)
+		if err != nil {
+			return nil, err
+		}
+		values[p.Name] = v
+	}
+
+	return values, nil
+}
+
+// Redact returns in without the values of parameters declared secret, for
+// keeping a record of a run. When a positional argument fills a secret
+// parameter, or the arguments match no parameters, the positional
+// arguments are all left out.
+func Redact(specs []Param, in Input) Input {
+	secret := make(map[string]bool)
+	for _, p := range specs {
+		if p.Secret {
+			secret[p.Name] = true
+		}
+	}
+	if len(secret) == 0 {
+		return in
+	}
+
+	out := Input{Named: make(map[string]string, len(in.Named)), Positional: in.Positional, Strict: in.Strict}
+	for name, value := range in.Named {
+		if !secret[name] {
+			out.Named[name] = value
+		}
+	}
+	raw, err := assign(specs, in)
+	if err != nil {
+		out.Positional = nil
+	}
+	for name := range raw {
+		if _, named := in.Named[name]; secret[name] && !named {
+			out.Positional = nil
+		}
+	}
+	return out
+}
+
+// assign matches the raw values of in to the declared parameters: named
+// values by name, then positional arguments in declaration order
+func assign(specs []Param, in Input) (map[string]string, error) {
+	byName := make(map[string]Param, len(specs))
+	for _, p := range specs {
+		byName[p.Name] = p
+	}
+
+	raw := make(map[string]string)
+	for name, value := range in.Named {
+		if _, ok := byName[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+		raw[name] = value
+	}
+
+	// Positional arguments fill parameters that were not named explicitly,
+	// in declaration order
+	next := 0
+	for _, value := range in.Positional {
+		for next < len(specs) {
+			if _, named := raw[specs[next].Name]; !named {
+				break
+			}
+			next++
+		}
+		if next >= len(specs) {
+			return nil, fmt.Errorf("too many arguments: %q does not match any parameter", value)
+		}
+		raw[specs[next].Name] = value
+		next++
+	}
+	return raw, nil
+}
+
+// Coerce converts a raw string to the parameter's declared type
+func Coerce(p Param, s string) (interface{}, error) {
+	if len(p.Choices) > 0 {
+		allowed := false
+		for _, c := range p.Choices {
+			if c == s {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("invalid value %q for parameter %q (expected one of: %s)", s, p.Name, strings.Join(p.Choices, ", "))
+		}
+	}
+
+	switch p.Type {
+	case TypeNumber:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for parameter %q: expected a number", s, p.Name)
+		}
+		return n, nil
+	case TypeInteger:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for parameter %q: expected an integer", s, p.Name)
+		}
+		return float64(n), nil
+	case TypeBoolean:
+		switch strings.ToLower(s) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0":
+			return false, nil
+		}
+		return nil, fmt.Errorf("invalid value %q for parameter %q: expected true or false", s, p.Name)
+	case TypeList:
+		var items []interface{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items, nil
+	default:
+		return s, nil
+	}
+}
+
+// ParseAssignments parses "name=value" pairs as given to --param
+func ParseAssignments(pairs []string) (map[string]string, error) {
+	result := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid parameter %q (expected name=value)", pair)
+		}
+		result[name] = value
+	}
+	return result, nil
+}
@@ -0,0 +1,273 @@
+package params
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func parseDeclaration(t *testing.T, code string) []Param {
+	t.Helper()
+	L := lua.NewState()
+	defer L.Close()
+
+	if err := L.DoString("return " + code); err != nil {
+		t.Fatalf("failed to evaluate declaration: %v", err)
+	}
+	specs, err := ParseSpec(L.Get(-1).(*lua.LTable))
+	if err != nil {
+		t.Fatalf("failed to parse spec: %v", err)
+	}
+	return specs
+}
+
+func TestParseSpecOrderedForm(t *testing.T) {
+	specs := parseDeclaration(t, `{
+		{ "env", type = "string", required = true, description = "Target" },
+		{ "count", type = "integer", default = 5 },
+	}`)
+
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(specs))
+	}
+	if specs[0].Name != "env" || !specs[0].Required || specs[0].Description != "Target" {
+		t.Errorf("unexpected first param: %+v", specs[0])
+	}
+	if specs[1].Name != "count" || specs[1].Type != TypeInteger || specs[1].Default != float64(5) {
+		t.Errorf("unexpected second param: %+v", specs[1])
+	}
+}
+
+func TestParseSpecKeyedForm(t *testing.T) {
+	specs := parseDeclaration(t, `{
+		zone = { type = "string" },
+		app = { type = "string" },
+	}`)
+
+	if len(specs) != 2 || specs[0].Name != "app" || specs[1].Name != "zone" {
+		t.Errorf("expected keyed params sorted by name, got %+v", specs)
+	}
+}
+
+func TestParseSpecUnknownType(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	L.DoString(`return { { "x", type = "date" } }`)
+	if _, err := ParseSpec(L.Get(-1).(*lua.LTable)); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestResolveNamedAndPositional(t *testing.T) {
+	specs := []Param{
+		{Name: "env", Type: TypeString, Required: true},
+		{Name: "count", Type: TypeInteger, Default: float64(1)},
+		{Name: "verbose", Type: TypeBoolean},
+	}
+
+	values, err := Resolve(specs, Input{
+		Named:      map[string]string{"env": "prod"},
+		Positional: []string{"7", "yes"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if values["env"] != "prod" || values["count"] != float64(7) || values["verbose"] != true {
+		t.Errorf("unexpected values: %v", values)
+	}
+}
+
+func TestResolveDefaults(t *testing.T) {
+	specs := []Param{{Name: "count", Type: TypeNumber, Default: float64(3)}}
+
+	values, err := Resolve(specs, Input{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if values["count"] != float64(3) {
+		t.Errorf("expected default, got %v", values["count"])
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	specs := []Param{
+		{Name: "env", Type: TypeString, Required: true, Choices: []string{"dev", "prod"}},
+		{Name: "count", Type: TypeInteger},
+	}
+
+	cases := map[string]Input{
+		"missing required": {},
+		"unknown param":    {Named: map[string]string{"env": "dev", "nope": "1"}},
+		"bad integer":      {Named: map[string]string{"env": "dev", "count": "1.5"}},
+		"bad choice":       {Named: map[string]string{"env": "staging"}},
+		"too many args":    {Positional: []string{"dev", "1", "extra"}},
+	}
+
+	for name, in := range cases {
+		if _, err := Resolve(specs, in); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCoerceList(t *testing.T) {
+	v, err := Coerce(Param{Name: "tags", Type: TypeList}, "a, b,,c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	items := v.([]interface{})
+	if len(items) != 3 || items[1] != "b" {
+		t.Errorf("unexpected list: %v", items)
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	got, err := ParseAssignments([]string{"env=prod", "query=a=b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got["env"] != "prod" || got["query"] != "a=b" {
+		t.Errorf("unexpected assignments: %v", got)
+	}
+
+	if _, err := ParseAssignments([]string{"novalue"}); err == nil {
+		t.Error("expected error for missing '='")
+	}
+}
+
+func TestExtractAndPrintHelp(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "job.lua")
+	os.WriteFile(script, []byte(`
+local http = require("http")
+local p = params {
+	{ "env", type = "string", required = true, description = "Target environment" },
+	{ "count", type = "integer", default = 5 },
+}
+http.post("https://example.com", p.env)
+`), 0644)
+
+	specs, err := Extract(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("expected 2 params, got %d", len(specs))
+	}
+
+	var buf bytes.Buffer
+	PrintHelp(&buf, script, specs)
+	out := buf.String()
+	for _, want := range []string{"job.lua", "<env>", "[count]", "Target environment", "default: 5"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("help output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExtractWithoutDeclaration(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "plain.lua")
+	os.WriteFile(script, []byte(`print("hello")`), 0644)
+
+	specs, err := Extract(script)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if specs != nil {
+		t.Errorf("expected no params, got %v", specs)
+	}
+}
+
+func TestParseSpecDefaults(t *testing.T) {
+	specs := parseDeclaration(t, `{
+		{ "count", type = "integer", default = "5" },
+		{ "dry", type = "boolean", default = "yes" },
+		{ "env", choices = { "dev", "prod" }, default = "dev" },
+		{ "tags", type = "list", default = { "a", "b" } },
+	}`)
+
+	if specs[0].Default != float64(5) {
+		t.Errorf("expected integer default to be coerced, got %#v", specs[0].Default)
+	}
+	if specs[1].Default != true {
+		t.Errorf("expected boolean default to be coerced, got %#v", specs[1].Default)
+	}
+	if specs[2].Default != "dev" {
+		t.Errorf("unexpected default %#v", specs[2].Default)
+	}
+	if tags, ok := specs[3].Default.([]interface{}); !ok || len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("unexpected list default %#v", specs[3].Default)
+	}
+}
+
+func TestParseSpecInvalidDefaults(t *testing.T) {
+	cases := map[string]string{
+		"bad integer":  `{ { "count", type = "integer", default = 1.5 } }`,
+		"bad number":   `{ { "ratio", type = "number", default = "half" } }`,
+		"bad boolean":  `{ { "dry", type = "boolean", default = "maybe" } }`,
+		"bad choice":   `{ { "env", choices = { "dev", "prod" }, default = "staging" } }`,
+		"table scalar": `{ { "env", default = { "dev" } } }`,
+		"bad item":     `{ { "tags", type = "list", choices = { "a" }, default = { "a", "z" } } }`,
+	}
+
+	for name, code := range cases {
+		L := lua.NewState()
+		if err := L.DoString("return " + code); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := ParseSpec(L.Get(-1).(*lua.LTable)); err == nil || !strings.Contains(err.Error(), "invalid default") {
+			t.Errorf("%s: expected invalid default error, got %v", name, err)
+		}
+		L.Close()
+	}
+}
+
+func TestRedact(t *testing.T) {
+	specs := parseDeclaration(t, `{
+		{ "env" },
+		{ "token", secret = true },
+	}`)
+	if !specs[1].Secret {
+		t.Fatal("expected token to be secret")
+	}
+
+	got := Redact(specs, Input{Named: map[string]string{"env": "prod", "token": "ghp_x"}})
+	if got.Named["env"] != "prod" {
+		t.Errorf("expected env to be kept, got %v", got.Named)
+	}
+	if _, ok := got.Named["token"]; ok {
+		t.Errorf("expected token to be left out, got %v", got.Named)
+	}
+
+	got = Redact(specs, Input{Positional: []string{"prod", "ghp_x"}})
+	if len(got.Positional) != 0 {
+		t.Errorf("expected positional secret to be left out, got %v", got.Positional)
+	}
+
+	got = Redact(specs, Input{Named: map[string]string{"token": "ghp_x"}, Positional: []string{"prod"}})
+	if len(got.Positional) != 1 || len(got.Named) != 0 {
+		t.Errorf("expected only the named secret to be left out, got %+v", got)
+	}
+}
+
+func TestRedactScript(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "deploy.lua")
+	os.WriteFile(script, []byte(`local p = params { { "token", secret = true }, { "env" } }`), 0644)
+
+	got := RedactScript(script, Input{Named: map[string]string{"token": "ghp_x", "env": "prod"}})
+	if len(got.Named) != 1 || got.Named["env"] != "prod" {
+		t.Errorf("unexpected values %v", got.Named)
+	}
+
+	// A declaration that cannot be read keeps nothing
+	got = RedactScript(filepath.Join(dir, "missing.lua"), Input{Named: map[string]string{"env": "prod"}, Positional: []string{"x"}})
+	if len(got.Named) != 0 || len(got.Positional) != 0 {
+		t.Errorf("expected nothing to be kept, got %+v", got)
+	}
+}
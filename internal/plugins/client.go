@@ -0,0 +1,192 @@
+package plugins
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/zepzeper/vulgar/pkg/plugin"
+)
+
+// Timeouts for talking to plugin processes
+const (
+	handshakeTimeout = 10 * time.Second
+	stopTimeout      = 2 * time.Second
+)
+
+// client is a running plugin process
+type client struct {
+	plugin Plugin
+	info   plugin.HandshakeResult
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan plugin.Response
+	done    chan struct{} // closed when the process exits
+	err     error         // why the process exited
+}
+
+// startClient starts the plugin and performs the handshake
+func startClient(p Plugin) (*client, error) {
+	if p.Path == "" {
+		return nil, fmt.Errorf("plugin not found")
+	}
+
+	cmd := exec.Command(p.Path)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", p.Name, err)
+	}
+
+	c := &client{
+		plugin:  p,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan plugin.Response),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop(stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+
+	result, err := c.call(ctx, plugin.MethodHandshake, plugin.HandshakeParams{ProtocolVersion: plugin.ProtocolVersion})
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("plugin %s handshake failed: %w", p.Name, err)
+	}
+	if err := json.Unmarshal(result, &c.info); err != nil {
+		c.close()
+		return nil, fmt.Errorf("plugin %s handshake failed: %w", p.Name, err)
+	}
+	if c.info.ProtocolVersion != plugin.ProtocolVersion {
+		c.close()
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, expected %d",
+			p.Name, c.info.ProtocolVersion, plugin.ProtocolVersion)
+	}
+	return c, nil
+}
+
+// readLoop delivers responses to the waiting callers until stdout closes
+func (c *client) readLoop(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var resp plugin.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || resp.ID == nil {
+			// Not a response; plugins should log to stderr
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[*resp.ID]
+		delete(c.pending, *resp.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	err := c.cmd.Wait()
+	c.mu.Lock()
+	if err != nil {
+		c.err = fmt.Errorf("plugin %s exited: %w", c.plugin.Name, err)
+	} else {
+		c.err = fmt.Errorf("plugin %s exited", c.plugin.Name)
+	}
+	c.mu.Unlock()
+	close(c.done)
+}
+
+// exited reports whether the plugin process has stopped
+func (c *client) exited() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// call sends a request and waits for its response or for ctx to end.
+// Requests may be in flight concurrently.
+func (c *client) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode arguments: %w", err)
+	}
+
+	ch := make(chan plugin.Response, 1)
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(plugin.Request{ID: &id, Method: method, Params: data}); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return nil, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *client) send(req plugin.Request) error {
+	req.JSONRPC = "2.0"
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("plugin %s is not running: %w", c.plugin.Name, err)
+	}
+	return nil
+}
+
+// close asks the plugin to exit and kills it if it does not within stopTimeout
+func (c *client) close() {
+	_ = c.send(plugin.Request{Method: plugin.MethodShutdown})
+	c.stdin.Close()
+
+	select {
+	case <-c.done:
+	case <-time.After(stopTimeout):
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+}
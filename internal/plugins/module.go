@@ -0,0 +1,74 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+// Loader returns the require() loader for a plugin. The plugin process is
+// started by the first require and needs the process capability for its
+// executable name when a sandbox policy is active.
+//
+// Usage:
+//
+//	local jira = require("plugins.jira")
+//	local issue, err = jira.create_issue({title = "Disk full"})
+func (m *Manager) Loader(name string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		p := m.plugins[name]
+		if err := sandbox.Check(L, sandbox.Process, filepath.Base(p.Path)); err != nil {
+			L.RaiseError("cannot load module %s: %v", p.Module(), err)
+			return 0
+		}
+
+		c, err := m.client(name)
+		if err != nil {
+			L.RaiseError("cannot load module %s: %v", p.Module(), err)
+			return 0
+		}
+
+		mod := L.NewTable()
+		for _, fn := range c.info.Functions {
+			L.SetField(mod, fn.Name, L.NewFunction(m.function(name, fn.Name)))
+		}
+		L.Push(mod)
+		return 1
+	}
+}
+
+// function returns a Lua function forwarding its arguments to the plugin.
+// It returns the result, or nil and an error message.
+func (m *Manager) function(name, function string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		args := make([]interface{}, L.GetTop())
+		for i := range args {
+			args[i] = util.LuaToGo(L.Get(i + 1))
+		}
+
+		// Restart the plugin if it died since the module was loaded
+		c, err := m.client(name)
+		if err != nil {
+			return util.PushError(L, "%v", err)
+		}
+
+		ctx := L.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		data, err := c.call(ctx, function, args)
+		if err != nil {
+			return util.PushError(L, "%s.%s: %v", ModulePrefix+name, function, err)
+		}
+
+		var result interface{}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return util.PushError(L, "%s.%s: invalid result: %v", ModulePrefix+name, function, err)
+		}
+		return util.PushSuccess(L, util.GoToLua(L, result))
+	}
+}
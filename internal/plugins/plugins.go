@@ -0,0 +1,174 @@
+// Package plugins runs out-of-process modules. Executables found in the
+// plugin directories are exposed as require("plugins.<name>"), started on
+// first use and spoken to over the JSON-RPC protocol in pkg/plugin.
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zepzeper/vulgar/internal/config"
+)
+
+// ModulePrefix is the require() prefix for plugin modules
+const ModulePrefix = "plugins."
+
+// PathEnv lists extra plugin directories, separated like PATH
+const PathEnv = "VULGAR_PLUGIN_PATH"
+
+// Plugin is an executable discovered in a plugin directory
+type Plugin struct {
+	Name string // module name without ModulePrefix
+	Path string
+}
+
+// Module returns the name scripts require the plugin by
+func (p Plugin) Module() string {
+	return ModulePrefix + p.Name
+}
+
+// DefaultDirs returns the directories searched when none are given on the
+// command line: $VULGAR_PLUGIN_PATH, then the plugins directory next to the
+// config file (~/.config/vulgar/plugins)
+func DefaultDirs() []string {
+	var dirs []string
+	if env := os.Getenv(PathEnv); env != "" {
+		for _, dir := range filepath.SplitList(env) {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return append(dirs, filepath.Join(config.ConfigDir(), "plugins"))
+}
+
+// Discover finds the plugins in dirs. Missing directories are skipped. When
+// two directories contain a plugin of the same name, the first one wins.
+func Discover(dirs []string) []Plugin {
+	seen := make(map[string]bool)
+	var found []Plugin
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name, ok := pluginName(dir, entry)
+			if !ok || seen[name] {
+				continue
+			}
+			path, err := filepath.Abs(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			seen[name] = true
+			found = append(found, Plugin{Name: name, Path: path})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+	return found
+}
+
+// pluginName returns the module name for an executable directory entry.
+// "jira", "jira.exe" and "jira.py" all become "jira".
+func pluginName(dir string, entry os.DirEntry) (string, bool) {
+	if strings.HasPrefix(entry.Name(), ".") {
+		return "", false
+	}
+	// Stat rather than entry.Info() so symlinked plugins are followed
+	info, err := os.Stat(filepath.Join(dir, entry.Name()))
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		return "", false
+	}
+
+	name := entry.Name()
+	if ext := filepath.Ext(name); ext != "" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name, name != ""
+}
+
+// Manager starts plugins on demand and stops them when the engine closes.
+// A plugin process is shared by the main state and all worker states.
+type Manager struct {
+	plugins map[string]Plugin
+	clients map[string]*client
+	mu      sync.Mutex
+}
+
+// NewManager creates a manager for the plugins found in dirs
+func NewManager(dirs []string) *Manager {
+	m := &Manager{
+		plugins: make(map[string]Plugin),
+		clients: make(map[string]*client),
+	}
+	for _, p := range Discover(dirs) {
+		m.plugins[p.Name] = p
+	}
+	return m
+}
+
+// Plugins lists the discovered plugins sorted by name
+func (m *Manager) Plugins() []Plugin {
+	list := make([]Plugin, 0, len(m.plugins))
+	for _, p := range m.plugins {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// client returns the running client for a plugin, starting it if needed
+func (m *Manager) client(name string) (*client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.clients[name]; ok && !c.exited() {
+		return c, nil
+	}
+	c, err := startClient(m.plugins[name])
+	if err != nil {
+		return nil, err
+	}
+	m.clients[name] = c
+	return c, nil
+}
+
+// SideEffects returns the functions a started plugin marked as side-effecting
+func (m *Manager) SideEffects(name string) []string {
+	m.mu.Lock()
+	c, ok := m.clients[name]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for _, fn := range c.info.Functions {
+		if fn.SideEffects {
+			names = append(names, fn.Name)
+		}
+	}
+	return names
+}
+
+// Close stops every running plugin
+func (m *Manager) Close() {
+	m.mu.Lock()
+	clients := m.clients
+	m.clients = make(map[string]*client)
+	m.mu.Unlock()
+
+	for _, c := range clients {
+		c.close()
+	}
+}
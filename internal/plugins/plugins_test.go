@@ -0,0 +1,150 @@
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+	"github.com/zepzeper/vulgar/pkg/plugin"
+)
+
+// The test binary doubles as a plugin when this variable is set
+const helperEnv = "VULGAR_TEST_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(helperEnv) == "1" {
+		plugin.Serve(plugin.Plugin{
+			Name: "greeter",
+			Functions: map[string]plugin.Function{
+				"greet": {Func: func(args []interface{}) (interface{}, error) {
+					return fmt.Sprintf("hello, %v", args[0]), nil
+				}},
+				"sum": {Func: func(args []interface{}) (interface{}, error) {
+					total := 0.0
+					for _, n := range args[0].([]interface{}) {
+						total += n.(float64)
+					}
+					return map[string]interface{}{"total": total}, nil
+				}},
+				"fail": {Func: func(args []interface{}) (interface{}, error) {
+					return nil, errors.New("something broke")
+				}, SideEffects: true},
+			},
+		})
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// writeHelperPlugin installs the test binary as a plugin named greeter
+func writeHelperPlugin(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("helper plugin uses a shell script")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\n%s=1 exec %q\n", helperEnv, exe)
+	if err := os.WriteFile(filepath.Join(dir, "greeter"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newPluginState(t *testing.T, m *Manager) *lua.LState {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	t.Cleanup(m.Close)
+	for _, p := range m.Plugins() {
+		L.PreloadModule(p.Module(), m.Loader(p.Name))
+	}
+	return L
+}
+
+func TestDiscover(t *testing.T) {
+	first := t.TempDir()
+	second := t.TempDir()
+	write := func(dir, name string, mode os.FileMode) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(first, "jira.py", 0755)
+	write(first, "notes.txt", 0644)
+	write(first, ".hidden", 0755)
+	write(second, "jira", 0755)
+	write(second, "pagerduty", 0755)
+
+	found := Discover([]string{first, second, filepath.Join(first, "missing")})
+	var names []string
+	for _, p := range found {
+		names = append(names, p.Name)
+	}
+	if got := strings.Join(names, ","); got != "jira,pagerduty" && runtime.GOOS != "windows" {
+		t.Fatalf("discovered %s, want jira,pagerduty", got)
+	}
+	if filepath.Dir(found[0].Path) != first {
+		t.Errorf("jira should come from the first directory, got %s", found[0].Path)
+	}
+}
+
+func TestPluginCalls(t *testing.T) {
+	L := newPluginState(t, NewManager([]string{writeHelperPlugin(t)}))
+
+	err := L.DoString(`
+		local greeter = require("plugins.greeter")
+		greeting = greeter.greet("vulgar")
+		local result = greeter.sum({1, 2, 3})
+		total = result.total
+		failed, fail_err = greeter.fail()
+	`)
+	if err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+
+	if got := L.GetGlobal("greeting").String(); got != "hello, vulgar" {
+		t.Errorf("greeting = %q", got)
+	}
+	if got := L.GetGlobal("total"); got != lua.LNumber(6) {
+		t.Errorf("total = %v", got)
+	}
+	if L.GetGlobal("failed") != lua.LNil || !strings.Contains(L.GetGlobal("fail_err").String(), "something broke") {
+		t.Errorf("expected nil and the plugin error, got %v, %v", L.GetGlobal("failed"), L.GetGlobal("fail_err"))
+	}
+}
+
+func TestSideEffects(t *testing.T) {
+	m := NewManager([]string{writeHelperPlugin(t)})
+	L := newPluginState(t, m)
+	if err := L.DoString(`require("plugins.greeter")`); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.SideEffects("greeter"); len(got) != 1 || got[0] != "fail" {
+		t.Errorf("SideEffects = %v, want [fail]", got)
+	}
+}
+
+func TestPluginSandbox(t *testing.T) {
+	L := newPluginState(t, NewManager([]string{writeHelperPlugin(t)}))
+	policy, err := sandbox.NewPolicy([]string{"net"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandbox.Attach(L, policy)
+
+	err = L.DoString(`require("plugins.greeter")`)
+	if err == nil || !strings.Contains(err.Error(), "--allow process=greeter") {
+		t.Errorf("expected a sandbox violation, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package project
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Install vendors every dependency into .vulgar/deps and writes vulgar.lock.
+// Git dependencies already in the lockfile are installed at their locked
+// commit and verified against the locked checksum; packages whose vendored
+// copy is intact are left alone. Progress is written to out.
+func (p *Project) Install(out io.Writer) (*Lock, error) {
+	previous, err := ReadLock(p.Root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(p.DepsPath(), 0755); err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{}
+	for _, name := range p.DependencyNames() {
+		dep := p.Manifest.Dependencies[name]
+
+		var (
+			pkg    *LockedPackage
+			status string
+		)
+		if dep.Git != "" {
+			pkg, status, err = p.installGit(name, dep, previous.Find(name))
+		} else {
+			pkg, status, err = p.installPath(name, dep)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s: %w", name, err)
+		}
+		lock.Packages = append(lock.Packages, *pkg)
+		fmt.Fprintf(out, "  %-20s %s\n", name, status)
+	}
+
+	if err := p.removeStale(); err != nil {
+		return nil, err
+	}
+	if err := lock.Write(p.Root); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// installPath links a local package into the deps directory, so edits to it
+// are picked up without reinstalling. Where symlinks are unavailable it is copied.
+func (p *Project) installPath(name string, dep Dependency) (*LockedPackage, string, error) {
+	src := dep.Path
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(p.Root, src)
+	}
+	src = filepath.Join(src, dep.Subdir)
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		return nil, "", fmt.Errorf("path %s is not a directory", src)
+	}
+
+	target := filepath.Join(p.DepsPath(), name)
+	if err := os.RemoveAll(target); err != nil {
+		return nil, "", err
+	}
+	status := "linked " + dep.Path
+	if err := os.Symlink(src, target); err != nil {
+		if err := copyTree(src, target); err != nil {
+			return nil, "", err
+		}
+		status = "copied " + dep.Path
+	}
+	return &LockedPackage{Name: name, Source: dep.Source()}, status, nil
+}
+
+// installGit vendors a package from a git repository at a pinned commit
+func (p *Project) installGit(name string, dep Dependency, locked *LockedPackage) (*LockedPackage, string, error) {
+	target := filepath.Join(p.DepsPath(), name)
+
+	// Only trust the lock if the manifest still asks for the same thing
+	if locked != nil && (locked.Source != dep.Source() || locked.Rev != dep.Rev) {
+		locked = nil
+	}
+	if locked != nil {
+		if sum, err := checksumTree(target); err == nil && sum == locked.Checksum {
+			return locked, "up to date at " + shortCommit(locked.Commit), nil
+		}
+	}
+
+	checkout := dep.Rev
+	if locked != nil {
+		checkout = locked.Commit
+	}
+	// The lockfile is not validated with the manifest, and git would take
+	// anything starting with a dash for an option
+	if strings.HasPrefix(checkout, "-") {
+		return nil, "", fmt.Errorf("invalid rev %q", checkout)
+	}
+
+	tmp, err := os.MkdirTemp(p.DepsPath(), ".fetch-")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	repo := filepath.Join(tmp, "repo")
+	if _, err := git("", "clone", "--quiet", "--no-checkout", "--", dep.Git, repo); err != nil {
+		return nil, "", err
+	}
+	if _, err := git(repo, "checkout", "--quiet", "--detach", checkout, "--"); err != nil {
+		return nil, "", fmt.Errorf("rev %s: %w", checkout, err)
+	}
+	commit, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+
+	staged := filepath.Join(tmp, "package")
+	if err := copyTree(filepath.Join(repo, dep.Subdir), staged); err != nil {
+		return nil, "", err
+	}
+	sum, err := checksumTree(staged)
+	if err != nil {
+		return nil, "", err
+	}
+	if locked != nil && sum != locked.Checksum {
+		return nil, "", fmt.Errorf("checksum mismatch for commit %s (locked %s, got %s)", commit, locked.Checksum, sum)
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		return nil, "", err
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return nil, "", err
+	}
+
+	pkg := &LockedPackage{Name: name, Source: dep.Source(), Rev: dep.Rev, Commit: commit, Checksum: sum}
+	return pkg, "installed at " + shortCommit(commit), nil
+}
+
+// removeStale deletes vendored packages that are no longer in the manifest
+func (p *Project) removeStale() error {
+	entries, err := os.ReadDir(p.DepsPath())
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if _, ok := p.Manifest.Dependencies[name]; !ok {
+			if err := os.RemoveAll(filepath.Join(p.DepsPath(), name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// git runs a git command and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// copyTree copies the regular files below src to dst, skipping .git
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), data, 0644)
+	})
+}
+
+// checksumTree hashes the paths and contents of the files below dir
+func checksumTree(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, path := range files {
+		rel, _ := filepath.Rel(dir, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		h.Write(data)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
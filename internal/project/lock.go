@@ -0,0 +1,64 @@
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Lock is the content of vulgar.lock
+type Lock struct {
+	Packages []LockedPackage `toml:"package"`
+}
+
+// LockedPackage records exactly what was installed for a dependency
+type LockedPackage struct {
+	Name     string `toml:"name"`
+	Source   string `toml:"source"`
+	Rev      string `toml:"rev,omitempty"`      // rev requested in the manifest
+	Commit   string `toml:"commit,omitempty"`   // commit rev resolved to
+	Checksum string `toml:"checksum,omitempty"` // hash of the vendored files
+}
+
+const lockHeader = "# This file is generated by `vulgar deps install`. Do not edit.\n\n"
+
+// ReadLock reads the lockfile in dir. A missing lockfile is an empty lock.
+func ReadLock(dir string) (*Lock, error) {
+	path := filepath.Join(dir, LockFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Lock{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var lock Lock
+	if err := toml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &lock, nil
+}
+
+// Write saves the lockfile in dir
+func (l *Lock) Write(dir string) error {
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	if err := toml.NewEncoder(&buf).Encode(l); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, LockFile), buf.Bytes(), 0644)
+}
+
+// Find returns the locked package with the given name, or nil
+func (l *Lock) Find(name string) *LockedPackage {
+	for i := range l.Packages {
+		if l.Packages[i].Name == name {
+			return &l.Packages[i]
+		}
+	}
+	return nil
+}
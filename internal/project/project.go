@@ -0,0 +1,188 @@
+// Package project reads vulgar.toml project manifests and installs the Lua
+// packages they depend on.
+//
+// A manifest marks the root of a project. Scripts anywhere below it can
+// require modules from the project's lib directories and from its
+// dependencies, which `vulgar deps install` vendors into .vulgar/deps:
+//
+//	[project]
+//	name = "ops-automations"
+//	lib = ["lib"]                     # default
+//
+//	[dependencies]
+//	slackfmt = { path = "../shared/slackfmt" }
+//	inspect = { git = "https://github.com/kikito/inspect.lua", rev = "a3b9b9e", subdir = "" }
+//
+// Git dependencies are pinned to a commit in vulgar.lock.
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// File and directory names used by projects
+const (
+	ManifestFile = "vulgar.toml"
+	LockFile     = "vulgar.lock"
+	DepsDir      = ".vulgar/deps"
+)
+
+// Manifest is the content of vulgar.toml
+type Manifest struct {
+	Project      Settings              `toml:"project"`
+	Dependencies map[string]Dependency `toml:"dependencies"`
+}
+
+// Settings is the [project] section
+type Settings struct {
+	Name string `toml:"name"`
+	// Lib lists directories, relative to the project root, that scripts can
+	// require modules from. Defaults to ["lib"].
+	Lib []string `toml:"lib"`
+}
+
+// Dependency is a Lua package from a local path or a git repository
+type Dependency struct {
+	Path   string `toml:"path"`
+	Git    string `toml:"git"`
+	Rev    string `toml:"rev"`
+	Subdir string `toml:"subdir"` // directory inside the package holding its modules
+}
+
+// Source describes where the dependency comes from, as recorded in the lockfile
+func (d Dependency) Source() string {
+	if d.Git != "" {
+		return "git+" + d.Git
+	}
+	return "path+" + d.Path
+}
+
+// Project is a directory with a vulgar.toml
+type Project struct {
+	Root     string
+	Manifest Manifest
+}
+
+// dependencyName matches names usable as the first part of a require() path
+var dependencyName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Find looks for vulgar.toml in dir and its parents. It returns nil without an
+// error when there is none.
+func Find(dir string) (*Project, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		path := filepath.Join(dir, ManifestFile)
+		if _, err := os.Stat(path); err == nil {
+			return Load(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// Load reads the manifest of the project rooted at dir
+func Load(dir string) (*Project, error) {
+	path := filepath.Join(dir, ManifestFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var m Manifest
+	if err := toml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if m.Project.Lib == nil {
+		m.Project.Lib = []string{"lib"}
+	}
+
+	p := &Project{Root: dir, Manifest: m}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *Project) validate() error {
+	var errs []error
+	for _, name := range p.DependencyNames() {
+		dep := p.Manifest.Dependencies[name]
+		switch {
+		case !dependencyName.MatchString(name):
+			errs = append(errs, fmt.Errorf("dependency %q: name must be a valid module name", name))
+		case dep.Path != "" && dep.Git != "":
+			errs = append(errs, fmt.Errorf("dependency %q: set either path or git, not both", name))
+		case dep.Path == "" && dep.Git == "":
+			errs = append(errs, fmt.Errorf("dependency %q: needs a path or git source", name))
+		case dep.Git != "" && dep.Rev == "":
+			errs = append(errs, fmt.Errorf("dependency %q: git dependencies must be pinned with rev", name))
+		case strings.HasPrefix(dep.Git, "-"), strings.HasPrefix(dep.Rev, "-"):
+			errs = append(errs, fmt.Errorf("dependency %q: git and rev must not start with -", name))
+		case dep.Subdir != "" && !filepath.IsLocal(dep.Subdir):
+			errs = append(errs, fmt.Errorf("dependency %q: subdir must be a relative path inside the package", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DependencyNames returns the dependency names sorted
+func (p *Project) DependencyNames() []string {
+	names := make([]string, 0, len(p.Manifest.Dependencies))
+	for name := range p.Manifest.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DepsPath returns the directory dependencies are vendored into
+func (p *Project) DepsPath() string {
+	return filepath.Join(p.Root, filepath.FromSlash(DepsDir))
+}
+
+// PackagePath returns the package.path entries for the project: its lib
+// directories, then the vendored dependencies. A dependency is required by
+// its name in the manifest, which loads its init.lua or, for single-file
+// packages like inspect.lua, the file named after it.
+func (p *Project) PackagePath() string {
+	var dirs []string
+	for _, lib := range p.Manifest.Project.Lib {
+		dirs = append(dirs, filepath.Join(p.Root, lib))
+	}
+	dirs = append(dirs, p.DepsPath())
+
+	var entries []string
+	for _, dir := range dirs {
+		entries = append(entries,
+			filepath.Join(dir, "?.lua"),
+			filepath.Join(dir, "?", "init.lua"))
+	}
+	entries = append(entries, filepath.Join(p.DepsPath(), "?", "?.lua"))
+	return strings.Join(entries, ";")
+}
+
+// Missing lists the dependencies that have not been installed
+func (p *Project) Missing() []string {
+	var missing []string
+	for _, name := range p.DependencyNames() {
+		if _, err := os.Stat(filepath.Join(p.DepsPath(), name)); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
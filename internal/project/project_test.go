@@ -0,0 +1,150 @@
+package project
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindWalksUp(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ManifestFile), "[project]\nname = \"ops\"\n")
+	nested := filepath.Join(root, "scripts", "daily")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Find(nested)
+	if err != nil || p == nil {
+		t.Fatalf("Find: %v, %v", p, err)
+	}
+	if p.Root != root || p.Manifest.Project.Name != "ops" {
+		t.Errorf("unexpected project %+v", p)
+	}
+	if !strings.HasPrefix(p.PackagePath(), filepath.Join(root, "lib", "?.lua")) {
+		t.Errorf("lib should come first in %s", p.PackagePath())
+	}
+
+	if p, err := Find(t.TempDir()); p != nil || err != nil {
+		t.Errorf("expected no project, got %v, %v", p, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ManifestFile), `
+[dependencies]
+unpinned = { git = "https://example.com/x.git" }
+both = { git = "https://example.com/y.git", rev = "abc", path = "../y" }
+"bad.name" = { path = "../z" }
+escape = { path = "../w", subdir = "../../etc" }
+absolute = { git = "https://example.com/v.git", rev = "abc", subdir = "/etc" }
+option = { git = "--upload-pack=touch /tmp/pwned", rev = "abc" }
+`)
+
+	_, err := Load(root)
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"pinned with rev", "not both", "valid module name", `"escape": subdir`, `"absolute": subdir`, "must not start with -"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestInstallPathDependency(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "project")
+	writeFile(t, filepath.Join(base, "shared", "fmt", "init.lua"), "return {}")
+	writeFile(t, filepath.Join(root, ManifestFile), "[dependencies]\nfmt = { path = \"../shared/fmt\" }\n")
+	writeFile(t, filepath.Join(root, DepsDir, "stale", "init.lua"), "return {}")
+
+	p, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := p.Missing(); len(missing) != 1 || missing[0] != "fmt" {
+		t.Errorf("Missing = %v", missing)
+	}
+	if _, err := p.Install(io.Discard); err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(p.DepsPath(), "fmt", "init.lua")); err != nil {
+		t.Errorf("dependency not installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(p.DepsPath(), "stale")); !os.IsNotExist(err) {
+		t.Error("stale dependency was not removed")
+	}
+	if len(p.Missing()) != 0 {
+		t.Errorf("still missing %v", p.Missing())
+	}
+}
+
+func TestInstallGitDependency(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	repo := t.TempDir()
+	writeFile(t, filepath.Join(repo, "src", "init.lua"), "return 'v1'")
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.email=test@example.com", "-c", "user.name=test", "commit", "--quiet", "-m", "v1"},
+	} {
+		if _, err := git(repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, ManifestFile),
+		"[dependencies]\nlib1 = { git = \""+filepath.ToSlash(repo)+"\", rev = \""+commit[:7]+"\", subdir = \"src\" }\n")
+	p, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := p.Install(io.Discard)
+	if err != nil {
+		t.Fatalf("Install: %v", err)
+	}
+	locked := lock.Find("lib1")
+	if locked == nil || locked.Commit != commit || !strings.HasPrefix(locked.Checksum, "sha256:") {
+		t.Fatalf("unexpected lock entry %+v", locked)
+	}
+
+	// Tampering with the vendored copy is repaired from the locked commit
+	writeFile(t, filepath.Join(p.DepsPath(), "lib1", "init.lua"), "return 'tampered'")
+	if _, err := p.Install(io.Discard); err != nil {
+		t.Fatalf("reinstall: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(p.DepsPath(), "lib1", "init.lua"))
+	if err != nil || string(data) != "return 'v1'" {
+		t.Errorf("vendored file = %q, %v", data, err)
+	}
+
+	reread, err := ReadLock(root)
+	if err != nil || reread.Find("lib1").Commit != commit {
+		t.Errorf("lockfile not written correctly: %v", err)
+	}
+}
@@ -2,10 +2,12 @@ package repl
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/zepzeper/vulgar/internal/cli"
 	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/modules/docs"
 )
 
 // CommandHandler handles built-in REPL commands
@@ -43,6 +45,13 @@ func (c *CommandHandler) Handle(line string) bool {
 	case "reset", "r":
 		fmt.Println(cli.Warning("Reset not implemented. Restart REPL for clean state."))
 
+	case "doc":
+		if len(parts) < 2 {
+			fmt.Println(errorStyle.Render("Usage: :doc <module>[.<function>]"))
+			return true
+		}
+		c.showDoc(parts[1])
+
 	case "load", "l":
 		if len(parts) < 2 {
 			fmt.Println(errorStyle.Render("Usage: :load <filename>"))
@@ -66,6 +75,7 @@ func (c *CommandHandler) printHelp() {
 	fmt.Println("  " + cli.Primary(":clear") + ", " + cli.Primary(":c") + "          Clear the screen")
 	fmt.Println("  " + cli.Primary(":modules") + ", " + cli.Primary(":m") + "        List available modules")
 	fmt.Println("  " + cli.Primary(":load <file>") + ", " + cli.Primary(":l") + "    Load and execute a Lua file")
+	fmt.Println("  " + cli.Primary(":doc <module.fn>") + "     Show a module's or function's documentation")
 	fmt.Println()
 	fmt.Println(cli.Title("Tips"))
 	fmt.Println()
@@ -123,6 +133,21 @@ func (c *CommandHandler) listModules() {
 	}
 }
 
+// showDoc displays the documentation of a module or module function
+func (c *CommandHandler) showDoc(query string) {
+	ref, fn, err := docs.Query(c.repl.Engine().L, query)
+	if err == nil {
+		if fn != nil {
+			err = docs.WriteFunction(os.Stdout, ref, *fn)
+		} else {
+			err = docs.WriteModule(os.Stdout, ref)
+		}
+	}
+	if err != nil {
+		fmt.Println(errorStyle.Render(err.Error()))
+	}
+}
+
 // loadFile loads and executes a Lua file
 func (c *CommandHandler) loadFile(filename string) {
 	if err := c.repl.Engine().RunWorkflow(filename); err != nil {
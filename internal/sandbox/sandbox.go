@@ -0,0 +1,440 @@
+// Package sandbox implements capability-based permissions for workflow scripts.
+//
+// A policy is built from allow/deny rules of the form "capability[=target]":
+//
+//	net=api.github.com   network access to a single host (wildcards: *.github.com)
+//	fs=./data            filesystem access below a directory
+//	shell                running shell commands (stdlib.shell, os.execute, io.popen)
+//	process=git          running executables by name (stdlib.process)
+//	ssh=build.internal   opening SSH connections to a host
+//
+// An empty policy is unrestricted. As soon as any allow rule is present,
+// every capability that is not explicitly allowed is denied; deny rules alone
+// only revoke what they name. Deny rules always win over allow rules.
+//
+// Rules a script declares for itself are kept apart from the operator's and
+// can only narrow them: an action must be permitted by both, so a script
+// cannot grant itself what the operator did not.
+package sandbox
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Capability names a class of side effect a script may perform
+type Capability string
+
+const (
+	Net     Capability = "net"
+	FS      Capability = "fs"
+	Shell   Capability = "shell"
+	Process Capability = "process"
+	SSH     Capability = "ssh"
+)
+
+// Capabilities lists every capability known to the sandbox
+var Capabilities = []Capability{Net, FS, Shell, Process, SSH}
+
+// RegistryKey is the Lua registry key the policy is stored under
+const RegistryKey = "vulgar_sandbox_policy"
+
+// Rule grants or revokes a capability, optionally scoped to a target
+type Rule struct {
+	Capability Capability
+	Target     string // empty matches everything
+}
+
+// String renders the rule in CLI syntax
+func (r Rule) String() string {
+	if r.Target == "" {
+		return string(r.Capability)
+	}
+	return string(r.Capability) + "=" + r.Target
+}
+
+// ParseRule parses "capability[=target]"
+func ParseRule(s string) (Rule, error) {
+	name, target, _ := strings.Cut(strings.TrimSpace(s), "=")
+	capability := Capability(strings.ToLower(strings.TrimSpace(name)))
+
+	known := false
+	for _, c := range Capabilities {
+		if c == capability {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return Rule{}, fmt.Errorf("unknown capability %q (expected one of: net, fs, shell, process, ssh)", name)
+	}
+
+	target = strings.TrimSpace(target)
+	if capability == FS && target != "" {
+		abs, err := filepath.Abs(target)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid fs path %q: %w", target, err)
+		}
+		target = abs
+	}
+
+	return Rule{Capability: capability, Target: target}, nil
+}
+
+// Policy is a set of allow and deny rules
+type Policy struct {
+	allow []Rule
+	deny  []Rule
+	// declared holds the rules the script declared (see Declare)
+	declared *Policy
+	mu       sync.RWMutex
+}
+
+// NewPolicy builds a policy from CLI-style allow and deny rules
+func NewPolicy(allow, deny []string) (*Policy, error) {
+	p := &Policy{}
+	for _, s := range allow {
+		if err := p.Allow(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range deny {
+		if err := p.Deny(s); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Allow adds an allow rule
+func (p *Policy) Allow(s string) error {
+	rule, err := ParseRule(s)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.allow = append(p.allow, rule)
+	p.mu.Unlock()
+	return nil
+}
+
+// Deny adds a deny rule
+func (p *Policy) Deny(s string) error {
+	rule, err := ParseRule(s)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.deny = append(p.deny, rule)
+	p.mu.Unlock()
+	return nil
+}
+
+// Declare adds a rule the script declares in its header, verb being
+// "allow" or "deny". Declared rules narrow the policy: they are checked in
+// addition to the operator's rules, never instead of them.
+func (p *Policy) Declare(verb, s string) error {
+	p.mu.Lock()
+	if p.declared == nil {
+		p.declared = &Policy{}
+	}
+	declared := p.declared
+	p.mu.Unlock()
+
+	switch verb {
+	case "allow":
+		return declared.Allow(s)
+	case "deny":
+		return declared.Deny(s)
+	}
+	return fmt.Errorf("unknown permission verb %q (expected allow or deny)", verb)
+}
+
+// Enabled reports whether the policy restricts anything
+func (p *Policy) Enabled() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.RLock()
+	own, declared := p.ownEnabled(), p.declared
+	p.mu.RUnlock()
+	return own || declared.Enabled()
+}
+
+// ownEnabled reports whether the operator's rules restrict anything.
+// Callers hold p.mu.
+func (p *Policy) ownEnabled() bool {
+	return len(p.allow) > 0 || len(p.deny) > 0
+}
+
+// Check returns an error if the capability is not permitted for target
+func (p *Policy) Check(capability Capability, target string) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	declared := p.declared
+	err := p.checkOwn(capability, target)
+	p.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return markDeclared(declared.Check(capability, target))
+}
+
+// checkOwn checks the operator's rules. Callers hold p.mu.
+func (p *Policy) checkOwn(capability Capability, target string) error {
+	if !p.ownEnabled() {
+		return nil
+	}
+
+	for _, rule := range p.deny {
+		if rule.Capability == capability && rule.matches(target) {
+			return &Violation{Capability: capability, Target: target, Rule: &rule}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, rule := range p.allow {
+		if rule.Capability == capability && rule.matches(target) {
+			return nil
+		}
+	}
+	return &Violation{Capability: capability, Target: target}
+}
+
+// CheckAny returns an error if the capability is not permitted for any target.
+// Used to gate whole modules before they are loaded.
+func (p *Policy) CheckAny(capability Capability) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	declared := p.declared
+	err := p.checkAnyOwn(capability)
+	p.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return markDeclared(declared.CheckAny(capability))
+}
+
+// CheckAll returns an error unless the capability is permitted for every
+// target. Used to gate modules that cannot check each target themselves.
+func (p *Policy) CheckAll(capability Capability) error {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	declared := p.declared
+	err := p.checkAllOwn(capability)
+	p.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return markDeclared(declared.CheckAll(capability))
+}
+
+// markDeclared flags a violation of the script's declared rules
+func markDeclared(err error) error {
+	if v, ok := err.(*Violation); ok {
+		v.Declared = true
+	}
+	return err
+}
+
+// checkAnyOwn checks the operator's rules. Callers hold p.mu.
+func (p *Policy) checkAnyOwn(capability Capability) error {
+	if !p.ownEnabled() {
+		return nil
+	}
+
+	for _, rule := range p.deny {
+		if rule.Capability == capability && rule.Target == "" {
+			return &Violation{Capability: capability, Rule: &rule}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, rule := range p.allow {
+		if rule.Capability == capability {
+			return nil
+		}
+	}
+	return &Violation{Capability: capability}
+}
+
+// checkAllOwn checks the operator's rules. Callers hold p.mu.
+func (p *Policy) checkAllOwn(capability Capability) error {
+	if !p.ownEnabled() {
+		return nil
+	}
+
+	for _, rule := range p.deny {
+		if rule.Capability == capability {
+			return &Violation{Capability: capability, Rule: &rule}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, rule := range p.allow {
+		if rule.Capability == capability && rule.Target == "" {
+			return nil
+		}
+	}
+	return &Violation{Capability: capability}
+}
+
+// Rules returns the allow and deny rules in CLI syntax
+func (p *Policy) Rules() (allow, deny []string) {
+	if p == nil {
+		return nil, nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.allow {
+		allow = append(allow, r.String())
+	}
+	for _, r := range p.deny {
+		deny = append(deny, r.String())
+	}
+	return allow, deny
+}
+
+func (r Rule) matches(target string) bool {
+	if r.Target == "" {
+		return true
+	}
+
+	switch r.Capability {
+	case Net, SSH:
+		return matchHost(r.Target, target)
+	case FS:
+		return matchPath(r.Target, target)
+	default:
+		return r.Target == commandName(target)
+	}
+}
+
+// matchHost compares hostnames, ignoring ports and supporting "*.domain"
+func matchHost(pattern, host string) bool {
+	host = strings.ToLower(stripPort(host))
+	pattern = strings.ToLower(stripPort(pattern))
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == suffix || strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
+
+func stripPort(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.Index(host, "]"); end != -1 {
+			return host[1:end]
+		}
+	}
+	if strings.Count(host, ":") == 1 {
+		host, _, _ = strings.Cut(host, ":")
+	}
+	return host
+}
+
+// matchPath reports whether path is dir itself or lies below it
+func matchPath(dir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)))
+}
+
+// commandName returns the executable name of a command line
+func commandName(cmd string) string {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return ""
+	}
+	return filepath.Base(fields[0])
+}
+
+// Violation is returned when a script attempts something the policy forbids
+type Violation struct {
+	Capability Capability
+	Target     string
+	Rule       *Rule // deny rule that matched, nil if nothing allowed it
+	// Declared is set when the script's declared rules forbid it
+	Declared bool
+}
+
+func (v *Violation) Error() string {
+	subject := string(v.Capability)
+	if v.Target != "" {
+		subject = fmt.Sprintf("%s access to %q", v.Capability, v.Target)
+	}
+
+	if v.Rule != nil {
+		if v.Declared {
+			return fmt.Sprintf("sandbox: %s denied by the script's vulgar:deny %s", subject, v.Rule)
+		}
+		return fmt.Sprintf("sandbox: %s denied by rule --deny %s", subject, v.Rule)
+	}
+
+	hint := Rule{Capability: v.Capability, Target: v.Target}
+	if v.Capability == Shell || v.Capability == Process {
+		hint.Target = commandName(v.Target)
+	}
+	if v.Declared {
+		return fmt.Sprintf("sandbox: %s not declared by the script (add -- vulgar:allow %s)", subject, hint)
+	}
+	return fmt.Sprintf("sandbox: %s not permitted (grant with --allow %s)", subject, hint)
+}
+
+// Attach stores the policy in the Lua registry so modules can enforce it
+func Attach(L *lua.LState, p *Policy) {
+	ud := L.NewUserData()
+	ud.Value = p
+	L.SetField(L.Get(lua.RegistryIndex), RegistryKey, ud)
+}
+
+// FromState retrieves the policy attached to the Lua state, or nil
+func FromState(L *lua.LState) *Policy {
+	registry, ok := L.Get(lua.RegistryIndex).(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	if ud, ok := L.GetField(registry, RegistryKey).(*lua.LUserData); ok {
+		if p, ok := ud.Value.(*Policy); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// Check enforces the policy attached to L. States without a policy are
+// unrestricted, which keeps modules usable in tests and embedded states.
+func Check(L *lua.LState, capability Capability, target string) error {
+	return FromState(L).Check(capability, target)
+}
+
+// CheckURL enforces the network rules of the policy attached to L for the
+// host of rawURL
+func CheckURL(L *lua.LState, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	return Check(L, Net, u.Host)
+}
@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestEmptyPolicyIsUnrestricted(t *testing.T) {
+	p, err := NewPolicy(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if p.Enabled() {
+		t.Error("empty policy should not be enabled")
+	}
+	if err := p.Check(Shell, "rm -rf /"); err != nil {
+		t.Errorf("empty policy should allow everything, got %v", err)
+	}
+}
+
+func TestNilPolicyIsUnrestricted(t *testing.T) {
+	var p *Policy
+	if err := p.Check(Net, "example.com"); err != nil {
+		t.Errorf("nil policy should allow everything, got %v", err)
+	}
+	if err := p.CheckAny(Shell); err != nil {
+		t.Errorf("nil policy should allow everything, got %v", err)
+	}
+}
+
+func TestParseRuleUnknownCapability(t *testing.T) {
+	if _, err := ParseRule("teleport"); err == nil {
+		t.Error("expected error for unknown capability")
+	}
+}
+
+func TestNetRules(t *testing.T) {
+	p, err := NewPolicy([]string{"net=api.github.com", "net=*.example.com"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	allowed := []string{"api.github.com", "api.github.com:443", "example.com", "a.b.example.com"}
+	for _, host := range allowed {
+		if err := p.Check(Net, host); err != nil {
+			t.Errorf("expected %s to be allowed, got %v", host, err)
+		}
+	}
+
+	denied := []string{"github.com", "evil.com", "notexample.com"}
+	for _, host := range denied {
+		if err := p.Check(Net, host); err == nil {
+			t.Errorf("expected %s to be denied", host)
+		}
+	}
+}
+
+func TestFSRules(t *testing.T) {
+	dir := t.TempDir()
+	p, err := NewPolicy([]string{"fs=" + dir}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := p.Check(FS, filepath.Join(dir, "nested", "file.txt")); err != nil {
+		t.Errorf("expected path inside dir to be allowed, got %v", err)
+	}
+	if err := p.Check(FS, dir); err != nil {
+		t.Errorf("expected dir itself to be allowed, got %v", err)
+	}
+	if err := p.Check(FS, filepath.Join(dir, "..", "escape.txt")); err == nil {
+		t.Error("expected path outside dir to be denied")
+	}
+	if err := p.Check(FS, dir+"-sibling"); err == nil {
+		t.Error("expected sibling dir with shared prefix to be denied")
+	}
+}
+
+func TestDenyOverridesAllow(t *testing.T) {
+	p, err := NewPolicy([]string{"shell"}, []string{"shell=rm"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := p.Check(Shell, "ls -la"); err != nil {
+		t.Errorf("expected ls to be allowed, got %v", err)
+	}
+
+	err = p.Check(Shell, "rm -rf /tmp/x")
+	var v *Violation
+	if !errors.As(err, &v) {
+		t.Fatalf("expected violation, got %v", err)
+	}
+	if v.Rule == nil || v.Rule.Target != "rm" {
+		t.Errorf("expected violation to reference deny rule, got %+v", v)
+	}
+}
+
+func TestDenyOnlyPolicyRevokesNamedCapabilities(t *testing.T) {
+	p, err := NewPolicy(nil, []string{"shell", "net=evil.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := p.Check(Shell, "ls"); err == nil {
+		t.Error("expected shell to be denied")
+	}
+	if err := p.CheckAny(Shell); err == nil {
+		t.Error("expected shell modules to be refused")
+	}
+	if err := p.Check(Net, "evil.com"); err == nil {
+		t.Error("expected evil.com to be denied")
+	}
+	if err := p.CheckAll(Net); err == nil {
+		t.Error("expected net with a deny rule to be refused for every target")
+	}
+
+	if err := p.Check(FS, "/etc/hostname"); err != nil {
+		t.Errorf("expected fs to stay allowed, got %v", err)
+	}
+	if err := p.Check(Net, "api.github.com"); err != nil {
+		t.Errorf("expected other hosts to stay allowed, got %v", err)
+	}
+	if err := p.CheckAny(Process); err != nil {
+		t.Errorf("expected process to stay allowed, got %v", err)
+	}
+	if err := p.CheckAll(SSH); err != nil {
+		t.Errorf("expected ssh to stay allowed, got %v", err)
+	}
+}
+
+func TestCheckAny(t *testing.T) {
+	p, err := NewPolicy([]string{"process=git"}, []string{"shell"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := p.CheckAny(Process); err != nil {
+		t.Errorf("expected process to be partially allowed, got %v", err)
+	}
+	if err := p.CheckAny(Shell); err == nil {
+		t.Error("expected shell to be denied")
+	}
+	if err := p.CheckAny(SSH); err == nil {
+		t.Error("expected ssh to be denied when not allowed")
+	}
+}
+
+func TestCheckAll(t *testing.T) {
+	p, err := NewPolicy([]string{"net", "fs=/data"}, []string{"net=internal.example.com"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := p.CheckAll(FS); err == nil {
+		t.Error("expected fs scoped to a directory to be refused")
+	}
+	if err := p.CheckAll(Net); err == nil {
+		t.Error("expected net with a deny rule to be refused")
+	}
+
+	p, _ = NewPolicy([]string{"net"}, nil)
+	if err := p.CheckAll(Net); err != nil {
+		t.Errorf("expected unscoped net to be allowed, got %v", err)
+	}
+}
+
+func TestDeclaredRulesOnlyNarrow(t *testing.T) {
+	p, err := NewPolicy([]string{"net=api.github.com"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, rule := range []string{"shell", "net"} {
+		if err := p.Declare("allow", rule); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := p.Check(Shell, "ls"); err == nil {
+		t.Error("expected a declared allow not to grant shell")
+	}
+	if err := p.Check(Net, "example.com"); err == nil {
+		t.Error("expected a declared allow not to widen net")
+	}
+	if err := p.Check(Net, "api.github.com"); err != nil {
+		t.Errorf("expected host granted by both to be allowed, got %v", err)
+	}
+
+	if err := p.Declare("deny", "net=api.github.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = p.Check(Net, "api.github.com")
+	var v *Violation
+	if !errors.As(err, &v) || !v.Declared {
+		t.Errorf("expected the declared deny to apply, got %v", err)
+	}
+}
+
+func TestDeclaredRulesWithoutOperatorRules(t *testing.T) {
+	p, _ := NewPolicy(nil, nil)
+	if err := p.Declare("allow", "net=api.github.com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !p.Enabled() {
+		t.Error("expected declared rules to enable the policy")
+	}
+	if err := p.Check(Net, "api.github.com"); err != nil {
+		t.Errorf("expected declared host to be allowed, got %v", err)
+	}
+	if err := p.Check(FS, "/etc/passwd"); err == nil {
+		t.Error("expected undeclared capability to be denied")
+	}
+}
+
+func TestAttachAndCheck(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	if err := Check(L, Net, "example.com"); err != nil {
+		t.Errorf("state without policy should be unrestricted, got %v", err)
+	}
+
+	p, _ := NewPolicy([]string{"net=example.com"}, nil)
+	Attach(L, p)
+
+	if FromState(L) != p {
+		t.Fatal("expected attached policy to be returned")
+	}
+	if err := Check(L, Net, "example.com"); err != nil {
+		t.Errorf("expected allowed host, got %v", err)
+	}
+	if err := Check(L, Net, "other.com"); err == nil {
+		t.Error("expected other host to be denied")
+	}
+}
@@ -0,0 +1,87 @@
+// Package plugin implements the vulgar plugin protocol.
+//
+// A plugin is an executable in one of vulgar's plugin directories. The engine
+// starts it on the first require("plugins.<name>") and talks JSON-RPC 2.0 over
+// its stdin and stdout, one message per line:
+//
+//	-> {"jsonrpc":"2.0","id":1,"method":"vulgar.handshake","params":{"protocol_version":1}}
+//	<- {"jsonrpc":"2.0","id":1,"result":{"protocol_version":1,"name":"jira","functions":[{"name":"create_issue","side_effects":true}]}}
+//	-> {"jsonrpc":"2.0","id":2,"method":"create_issue","params":[{"title":"Disk full"}]}
+//	<- {"jsonrpc":"2.0","id":2,"result":{"key":"OPS-12"}}
+//
+// Call parameters are the Lua arguments as a JSON array; the result becomes
+// the function's return value. An error response makes the function return
+// nil plus the error message. Plugins may answer requests out of order.
+// Before stopping a plugin the engine sends a vulgar.shutdown notification
+// and closes stdin. Anything the plugin writes to stderr is passed through.
+//
+// Plugins can be written in any language; Serve implements the protocol for
+// Go plugins.
+package plugin
+
+import "encoding/json"
+
+// ProtocolVersion is the protocol version this package speaks
+const ProtocolVersion = 1
+
+// Methods reserved by the protocol
+const (
+	MethodHandshake = "vulgar.handshake"
+	MethodShutdown  = "vulgar.shutdown"
+)
+
+// JSON-RPC error codes
+const (
+	CodeParseError     = -32700
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeCallFailed     = -32000 // the function itself returned an error
+)
+
+// Request is a JSON-RPC request. Notifications have no ID.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// HandshakeParams are sent by the engine when it starts a plugin
+type HandshakeParams struct {
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// HandshakeResult describes the plugin and the functions it exports
+type HandshakeResult struct {
+	ProtocolVersion int            `json:"protocol_version"`
+	Name            string         `json:"name"`
+	Version         string         `json:"version,omitempty"`
+	Functions       []FunctionInfo `json:"functions"`
+}
+
+// FunctionInfo describes an exported function
+type FunctionInfo struct {
+	Name string `json:"name"`
+	// Doc is a one-line description shown by tooling
+	Doc string `json:"doc,omitempty"`
+	// SideEffects marks functions that are stubbed out in --dry-run
+	SideEffects bool `json:"side_effects,omitempty"`
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Func is an exported plugin function. args are the Lua arguments decoded
+// from JSON; the returned value is encoded back to JSON.
+type Func func(args []interface{}) (interface{}, error)
+
+// Function is an exported function with its metadata
+type Function struct {
+	Func        Func
+	Doc         string
+	SideEffects bool
+}
+
+// Plugin describes a Go plugin for Serve
+type Plugin struct {
+	Name      string
+	Version   string
+	Functions map[string]Function
+}
+
+// Serve runs the plugin protocol on stdin and stdout until the engine shuts
+// the plugin down. Each call runs on its own goroutine.
+//
+// Usage:
+//
+//	func main() {
+//		plugin.Serve(plugin.Plugin{
+//			Name: "jira",
+//			Functions: map[string]plugin.Function{
+//				"create_issue": {Func: createIssue, SideEffects: true},
+//			},
+//		})
+//	}
+func Serve(p Plugin) {
+	if err := ServeConn(p, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "plugin %s: %v\n", p.Name, err)
+		os.Exit(1)
+	}
+}
+
+// ServeConn runs the plugin protocol on r and w until r is closed or a
+// shutdown notification arrives
+func ServeConn(p Plugin, r io.Reader, w io.Writer) error {
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+		enc     = json.NewEncoder(w)
+	)
+	reply := func(resp Response) {
+		resp.JSONRPC = "2.0"
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		_ = enc.Encode(resp)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			reply(Response{Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+
+		switch req.Method {
+		case MethodShutdown:
+			wg.Wait()
+			return nil
+		case MethodHandshake:
+			reply(Response{ID: req.ID, Result: mustMarshal(p.handshake())})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := p.call(req)
+			if req.ID != nil {
+				resp.ID = req.ID
+				reply(resp)
+			}
+		}()
+	}
+	wg.Wait()
+	return scanner.Err()
+}
+
+// maxMessageSize bounds a single protocol message
+const maxMessageSize = 64 * 1024 * 1024
+
+func (p Plugin) handshake() HandshakeResult {
+	result := HandshakeResult{
+		ProtocolVersion: ProtocolVersion,
+		Name:            p.Name,
+		Version:         p.Version,
+		Functions:       make([]FunctionInfo, 0, len(p.Functions)),
+	}
+	for name, fn := range p.Functions {
+		result.Functions = append(result.Functions, FunctionInfo{
+			Name:        name,
+			Doc:         fn.Doc,
+			SideEffects: fn.SideEffects,
+		})
+	}
+	sort.Slice(result.Functions, func(i, j int) bool {
+		return result.Functions[i].Name < result.Functions[j].Name
+	})
+	return result
+}
+
+func (p Plugin) call(req Request) Response {
+	fn, ok := p.Functions[req.Method]
+	if !ok {
+		return Response{Error: &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown function %q", req.Method)}}
+	}
+
+	var args []interface{}
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &args); err != nil {
+			return Response{Error: &Error{Code: CodeInvalidParams, Message: err.Error()}}
+		}
+	}
+
+	result, err := fn.Func(args)
+	if err != nil {
+		return Response{Error: &Error{Code: CodeCallFailed, Message: err.Error()}}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: &Error{Code: CodeCallFailed, Message: fmt.Sprintf("cannot encode result: %v", err)}}
+	}
+	return Response{Result: data}
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestServeConn(t *testing.T) {
+	p := Plugin{
+		Name: "echo",
+		Functions: map[string]Function{
+			"echo": {Func: func(args []interface{}) (interface{}, error) { return args, nil }},
+		},
+	}
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"vulgar.handshake","params":{"protocol_version":1}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"echo","params":["a",1]}`,
+		`{"jsonrpc":"2.0","id":3,"method":"missing"}`,
+		`{"jsonrpc":"2.0","method":"vulgar.shutdown"}`,
+	}, "\n")
+
+	out, w := io.Pipe()
+	go func() {
+		if err := ServeConn(p, strings.NewReader(input), w); err != nil {
+			t.Errorf("ServeConn: %v", err)
+		}
+		w.Close()
+	}()
+
+	responses := make(map[int64]Response)
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid response %s: %v", scanner.Text(), err)
+		}
+		responses[*resp.ID] = resp
+	}
+
+	var hs HandshakeResult
+	if err := json.Unmarshal(responses[1].Result, &hs); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Name != "echo" || len(hs.Functions) != 1 || hs.Functions[0].Name != "echo" {
+		t.Errorf("unexpected handshake %+v", hs)
+	}
+	if got := string(responses[2].Result); got != `["a",1]` {
+		t.Errorf("echo returned %s", got)
+	}
+	if responses[3].Error == nil || responses[3].Error.Code != CodeMethodNotFound {
+		t.Errorf("expected method not found, got %+v", responses[3])
+	}
+}
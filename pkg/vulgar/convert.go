@@ -0,0 +1,47 @@
+package vulgar
+
+import (
+	"encoding/json"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/modules/util"
+)
+
+// toLua converts a Go value for use in Lua. Values util.GoToLua does not
+// handle natively (ints, structs, typed maps and slices) go through
+// encoding/json first, so they convert the way they would serialise.
+func toLua(L *lua.LState, v interface{}) (lua.LValue, error) {
+	switch val := v.(type) {
+	case lua.LValue:
+		return val, nil
+	case lua.LGFunction:
+		return L.NewFunction(val), nil
+	case func(*lua.LState) int:
+		return L.NewFunction(val), nil
+	case nil, bool, float64, string:
+		return util.GoToLua(L, val), nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %T to Lua: %w", v, err)
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("cannot convert %T to Lua: %w", v, err)
+	}
+	return util.GoToLua(L, generic), nil
+}
+
+func toGo(v lua.LValue) interface{} {
+	return util.LuaToGo(v)
+}
+
+func toGoSlice(values []lua.LValue) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = toGo(v)
+	}
+	return result
+}
@@ -0,0 +1,269 @@
+// Package vulgar embeds the vulgar workflow engine in Go programs.
+//
+// An Engine runs Lua scripts with every built-in module available through
+// require(), plus any modules the host registers:
+//
+//	eng, err := vulgar.New(vulgar.Options{Allow: []string{"net=api.github.com"}})
+//	if err != nil {
+//		return err
+//	}
+//	defer eng.Close()
+//
+//	eng.Register("myapp.store", storeModule{db})
+//	eng.SetGlobal("config", map[string]interface{}{"team": "infra"})
+//
+//	results, err := eng.RunFile(ctx, "automations/rotate.lua")
+//
+// Values cross the boundary as plain Go data: Lua tables become
+// []interface{} or map[string]interface{}, numbers become float64. An Engine
+// is not safe for concurrent use; create one per goroutine (they are cheap).
+package vulgar
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/zepzeper/vulgar/internal/engine"
+	"github.com/zepzeper/vulgar/internal/modules"
+	"github.com/zepzeper/vulgar/internal/params"
+	"github.com/zepzeper/vulgar/internal/sandbox"
+)
+
+// Module is implemented by Go modules that scripts load with require().
+// Loader pushes the module table and returns 1, as in gopher-lua's
+// PreloadModule. This is the interface the built-in modules implement.
+type Module = modules.LuaModule
+
+// PreloadableModule is a Module that is also installed as a global before
+// the script starts (like log)
+type PreloadableModule = modules.PreloadableModule
+
+// Limits bounds the resources a script may use
+type Limits = engine.Limits
+
+// LimitError is returned when a script exceeds one of its Limits
+type LimitError = engine.LimitError
+
+// ShutdownError is returned when a run was stopped by Engine.Shutdown
+type ShutdownError = engine.ShutdownError
+
+// RuntimeError is returned when a script raises an error. It carries the Lua
+// traceback and the source lines around the error.
+type RuntimeError = engine.RuntimeError
+
+// DryRunCall is a side-effecting call intercepted in DryRun mode
+type DryRunCall = engine.DryRunCall
+
+// Options configures a new Engine. The zero value runs scripts unrestricted.
+type Options struct {
+	// LogLevel for the log module: DEBUG, INFO (default), WARN or ERROR
+	LogLevel string
+	// LogFormat for the log module: text (default) or json
+	LogFormat string
+	// Allow and Deny are sandbox rules in CLI syntax, e.g. "net=api.github.com"
+	// or "fs=/var/lib/app". Any rule switches to least-privilege mode.
+	Allow []string
+	Deny  []string
+	// Limits bounds memory, call depth, stack size and instructions
+	Limits Limits
+	// ShutdownGrace bounds how long on_shutdown handlers may run
+	ShutdownGrace time.Duration
+	// DryRun stubs out side-effecting module calls
+	DryRun bool
+	// PluginDirs are searched for plugin executables (see package plugin).
+	// None are searched by default.
+	PluginDirs []string
+	// EventQueueSize is the number of timer, cron and watcher events
+	// buffered before their overflow policies apply (default 100)
+	EventQueueSize int
+	// BytecodeCache is a directory where compiled project modules are kept
+	// between runs, so require() skips parsing unchanged modules. Empty
+	// disables the cache.
+	BytecodeCache string
+}
+
+// Engine runs Lua scripts in a single Lua state
+type Engine struct {
+	eng *engine.Engine
+	mu  sync.Mutex
+}
+
+// RegisterModule makes a module available to every engine created
+// afterwards, the same way built-in modules register themselves.
+// Call it from an init function.
+func RegisterModule(name string, m Module) {
+	modules.Register(name, m.Loader)
+	if p, ok := m.(PreloadableModule); ok {
+		modules.RegisterPreload(name, p.Open)
+	}
+}
+
+// New creates an engine
+func New(opts Options) (*Engine, error) {
+	policy, err := sandbox.NewPolicy(opts.Allow, opts.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sandbox rule: %w", err)
+	}
+
+	logLevel := opts.LogLevel
+	if logLevel == "" {
+		logLevel = "INFO"
+	}
+	logFormat := opts.LogFormat
+	if logFormat == "" {
+		logFormat = "text"
+	}
+
+	eng := engine.NewEngine(engine.Config{
+		LogLevel:      logLevel,
+		LogFormat:     logFormat,
+		DryRun:        opts.DryRun,
+		Sandbox:       policy,
+		Limits:        opts.Limits,
+		ShutdownGrace: opts.ShutdownGrace,
+		PluginDirs:    opts.PluginDirs,
+
+		EventQueueSize: opts.EventQueueSize,
+		BytecodeCache:  opts.BytecodeCache,
+	})
+	return &Engine{eng: eng}, nil
+}
+
+// Register makes a module available to require() in this engine only
+func (e *Engine) Register(name string, m Module) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.eng.RegisterModule(name, m.Loader)
+	if p, ok := m.(PreloadableModule); ok {
+		e.eng.RegisterPreload(name, p.Open)
+	}
+}
+
+// RegisterFunc makes a Go function available to require() in this engine
+// only. It is a shorthand for Register with a Loader-style function.
+func (e *Engine) RegisterFunc(name string, loader lua.LGFunction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.eng.RegisterModule(name, loader)
+}
+
+// SetGlobal sets a global variable. value may be a Go value (converted like
+// encoding/json would), a lua.LValue, or a func(*lua.LState) int.
+func (e *Engine) SetGlobal(name string, value interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lv, err := toLua(e.eng.L, value)
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+	e.eng.L.SetGlobal(name, lv)
+	return nil
+}
+
+// Global returns a global variable as a Go value
+func (e *Engine) Global(name string) interface{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return toGo(e.eng.L.GetGlobal(name))
+}
+
+// SetParams supplies values for the parameters a script declares with
+// params{...}, as --param name=value does on the command line. Running a
+// script that declares no parameters with named values is an error.
+func (e *Engine) SetParams(named map[string]string, positional ...string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.eng.SetInput(params.Input{Named: named, Positional: positional, Strict: true})
+}
+
+// RunFile runs a script like the vulgar command does: permissions declared
+// in its header narrow the sandbox rules of Options, the event loop runs
+// until timers, cron jobs and watchers are done, and on_shutdown handlers
+// run at the end. Cancelling ctx stops the script. Returns the values the
+// script's main chunk returned. path may also be a script compiled with
+// 'vulgar compile'.
+func (e *Engine) RunFile(ctx context.Context, path string) ([]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.eng.SetContext(ctx)
+	if err := e.eng.RunWorkflow(path); err != nil {
+		return nil, err
+	}
+	return toGoSlice(e.eng.Results()), nil
+}
+
+// RunString runs a chunk of Lua code and returns its results. Unlike RunFile
+// it does not run the event loop.
+func (e *Engine) RunString(ctx context.Context, code string) ([]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.eng.SetContext(ctx)
+	L := e.eng.L
+
+	fn, err := L.LoadString(code)
+	if err != nil {
+		return nil, err
+	}
+	results, err := e.eng.Call("<string>", fn)
+	if err != nil {
+		return nil, err
+	}
+	return toGoSlice(results), nil
+}
+
+// Call calls a global Lua function with Go arguments and returns its results
+func (e *Engine) Call(ctx context.Context, name string, args ...interface{}) ([]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.eng.SetContext(ctx)
+	L := e.eng.L
+
+	fn, ok := L.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a function", name)
+	}
+
+	luaArgs := make([]lua.LValue, len(args))
+	for i, arg := range args {
+		lv, err := toLua(L, arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		luaArgs[i] = lv
+	}
+	results, err := e.eng.Call(name, fn, luaArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return toGoSlice(results), nil
+}
+
+// Shutdown stops a running RunFile from another goroutine, running the
+// script's on_shutdown handlers with reason. RunFile returns a *ShutdownError.
+func (e *Engine) Shutdown(reason string) {
+	e.eng.Shutdown(reason)
+}
+
+// DryRunCalls lists the side-effecting calls intercepted in DryRun mode
+func (e *Engine) DryRunCalls() []DryRunCall {
+	return e.eng.DryRunCalls()
+}
+
+// LState exposes the underlying Lua state for advanced use. It must not be
+// used while a script is running.
+func (e *Engine) LState() *lua.LState {
+	return e.eng.L
+}
+
+// Close releases the engine. Use Shutdown to stop a running script first.
+func (e *Engine) Close() {
+	e.eng.Close()
+}
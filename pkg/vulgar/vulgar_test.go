@@ -0,0 +1,128 @@
+package vulgar
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+type greeterModule struct{ greeting string }
+
+func (m greeterModule) Loader(L *lua.LState) int {
+	mod := L.NewTable()
+	L.SetField(mod, "greet", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(m.greeting + ", " + L.CheckString(1)))
+		return 1
+	}))
+	L.Push(mod)
+	return 1
+}
+
+func newTestEngine(t *testing.T, opts Options) *Engine {
+	t.Helper()
+	eng, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	return eng
+}
+
+func TestRegisterAndGlobals(t *testing.T) {
+	eng := newTestEngine(t, Options{})
+	eng.Register("app.greeter", greeterModule{greeting: "hello"})
+
+	type config struct {
+		Team  string `json:"team"`
+		Limit int    `json:"limit"`
+	}
+	if err := eng.SetGlobal("config", config{Team: "infra", Limit: 3}); err != nil {
+		t.Fatalf("SetGlobal: %v", err)
+	}
+
+	results, err := eng.RunString(context.Background(), `
+		local greeter = require("app.greeter")
+		answer = config.limit * 2
+		return greeter.greet(config.team), {1, 2}
+	`)
+	if err != nil {
+		t.Fatalf("RunString: %v", err)
+	}
+	if len(results) != 2 || results[0] != "hello, infra" {
+		t.Fatalf("unexpected results %#v", results)
+	}
+	if list, ok := results[1].([]interface{}); !ok || len(list) != 2 {
+		t.Errorf("expected a list as second result, got %#v", results[1])
+	}
+	if got := eng.Global("answer"); got != float64(6) {
+		t.Errorf("answer = %#v, want 6", got)
+	}
+}
+
+func TestRunFileAndCall(t *testing.T) {
+	eng := newTestEngine(t, Options{})
+	path := filepath.Join(t.TempDir(), "script.lua")
+	script := `
+		function add(a, b) return a + b end
+		return "done"
+	`
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := eng.RunFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("RunFile: %v", err)
+	}
+	if len(results) != 1 || results[0] != "done" {
+		t.Fatalf("unexpected results %#v", results)
+	}
+
+	sum, err := eng.Call(context.Background(), "add", 2, 3)
+	if err != nil {
+		t.Fatalf("Call: %v", err)
+	}
+	if len(sum) != 1 || sum[0] != float64(5) {
+		t.Errorf("add(2, 3) = %#v", sum)
+	}
+
+	if _, err := eng.Call(context.Background(), "missing"); err == nil {
+		t.Error("expected an error calling an undefined function")
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	eng := newTestEngine(t, Options{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := eng.RunString(ctx, `while true do end`); err == nil {
+		t.Fatal("expected the loop to be interrupted")
+	}
+
+	// The engine stays usable with a new context
+	if _, err := eng.RunString(context.Background(), `return 1`); err != nil {
+		t.Errorf("engine unusable after cancellation: %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	eng := newTestEngine(t, Options{Limits: Limits{MaxInstructions: 1000}})
+	_, err := eng.RunString(context.Background(), `while true do end`)
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected a LimitError, got %v", err)
+	}
+}
+
+func TestInvalidSandboxRule(t *testing.T) {
+	if _, err := New(Options{Allow: []string{"bogus"}}); err == nil {
+		t.Error("expected an invalid rule to be rejected")
+	}
+}